@@ -0,0 +1,112 @@
+package gostage
+
+// fate is what finally happened to an event
+type fate int
+
+const (
+	// handled by the terminal stage
+	completed fate = iota
+	// discarded by the framework and reported with a StageError
+	// e.g. ErrExpired, ErrDropped or ErrPoisonEvent
+	deadLettered
+	// discarded by a disabled terminal stage
+	discarded
+	// held by an instance which stopped or crashed before passing it on
+	lostInFlight
+	// consumed by a stage which returned ErrNoData, or by a Join
+	skipped
+	// cancelled by CancelEvent
+	cancelled
+	// left in the inbox of an instance which stopped, see LeastBusy
+	lostInChannel
+	fates
+)
+
+// Accounting tells what happened to the events produced in a run
+// DroppedInChannel is only known once the pipeline has stopped
+type Accounting struct {
+	// the number of events emitted by the producers
+	Produced int64 `json:"produced"`
+	// the extra copies of the events made by Broadcast stages, one less than
+	// the instances per event, each copy has a fate of its own
+	Copies int64 `json:"copies"`
+	// handled by the terminal stage, successfully or not
+	Completed int64 `json:"completed"`
+	// discarded by the framework and reported with a StageError
+	DeadLettered int64 `json:"dead_lettered"`
+	// discarded by a disabled terminal stage
+	Discarded int64 `json:"discarded"`
+	// left in the buffers between stages when the workers stopped
+	DroppedInChannel int64 `json:"dropped_in_channel"`
+	// held by an instance which stopped or crashed before passing it on
+	DroppedInFlight int64 `json:"dropped_in_flight"`
+	// consumed by a stage which returned ErrNoData for them,
+	// or by a Join which merged them into another event or dropped them unmatched
+	Skipped int64 `json:"skipped"`
+	// cancelled by CancelEvent
+	Cancelled int64 `json:"cancelled"`
+	// the part of DroppedInChannel and DroppedInFlight dropped by BestEffort stages
+	DroppedBestEffort int64 `json:"dropped_best_effort"`
+}
+
+// DroppedCritical returns the number of events dropped on shutdown by the stages which aren't BestEffort
+func (a Accounting) DroppedCritical() int64 {
+	return a.DroppedInChannel + a.DroppedInFlight - a.DroppedBestEffort
+}
+
+// Accounted returns the number of produced events and copies whose fate is known,
+// it's Produced + Copies once every event is accounted for
+func (a Accounting) Accounted() int64 {
+	return a.Completed + a.DeadLettered + a.Discarded + a.DroppedInChannel + a.DroppedInFlight + a.Skipped + a.Cancelled
+}
+
+func (s *GoStage) account() Accounting {
+	return Accounting{
+		Produced:          s.produced.Load(),
+		Copies:            s.copies.Load(),
+		Completed:         s.settled[completed].Load(),
+		DeadLettered:      s.settled[deadLettered].Load(),
+		Discarded:         s.settled[discarded].Load(),
+		DroppedInChannel:  s.settled[lostInChannel].Load(),
+		DroppedInFlight:   s.settled[lostInFlight].Load(),
+		Skipped:           s.settled[skipped].Load(),
+		Cancelled:         s.settled[cancelled].Load(),
+		DroppedBestEffort: s.droppedBestEffort.Load(),
+	}
+}
+
+// reconcile accounts for every produced event once all workers have stopped
+// logs a summary and notifies the observer
+func (s *GoStage) reconcile() {
+	s.finishSequences()
+
+	a := s.account()
+	for i := s.producers; i < len(s.linkedWorkers); i++ {
+		lw := s.linkedWorkers[i]
+		a.DroppedInChannel += int64(lw.queued())
+		if lw.BestEffort {
+			a.DroppedBestEffort += int64(lw.queued())
+		}
+	}
+
+	s.mu.Lock()
+	s.accounting = a
+	s.mu.Unlock()
+	report := s.makeReport()
+	report.Accounting = a
+	s.mu.Lock()
+	s.report = report
+	s.mu.Unlock()
+
+	if a.Accounted() != a.Produced+a.Copies {
+		s.logger.Error("gostage lost track of events: produced %d and %d copies, accounted %d: %+v", a.Produced, a.Copies, a.Accounted(), a)
+	} else {
+		s.logger.Info("gostage stopped: %+v, dropped %d critical and %d best-effort events", a, a.DroppedCritical(), a.DroppedBestEffort)
+	}
+	s.lifecycle(PipelineStopped, "", "pipeline stopped after %v: %s", report.WallTime, s.reason)
+	if s.observer != nil {
+		s.notifications <- func() {
+			s.observer.OnPipelineStopped(a)
+		}
+	}
+}
@@ -0,0 +1,151 @@
+package gostage
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrRestartRequired if Apply changes fields which only take effect when the pipeline starts
+var ErrRestartRequired = errors.New("restart required")
+
+// tunable are the fields of a Config that Apply changes while the pipeline runs
+var tunable = map[string]bool{
+	"RateLimit":            true,
+	"ErrorMode":            true,
+	"Retries":              true,
+	"Unclassified":         true,
+	"DebugSampling":        true,
+	"MaxConsecutiveErrors": true,
+}
+
+// current returns the effective config of the stage, the config it was
+// started with changed by Apply
+func (lw *linkedWorker) current() *Config {
+	return lw.tuned.Load()
+}
+
+// Config returns a copy of the effective config of a stage of the current or last run
+func (s *GoStage) Config(stage string) (*Config, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i, err := s.stage(stage)
+	if err != nil {
+		return nil, err
+	}
+	c := *s.linkedWorkers[i].current()
+	return &c, nil
+}
+
+// Apply changes the config of a running stage with mutate, which is given a copy of its effective config
+// RateLimit, ErrorMode, Retries, DebugSampling and MaxConsecutiveErrors take effect right away,
+// changing any other field fails with ErrRestartRequired and nothing is applied, use Scale to change Size
+// the changes last until the pipeline is stopped
+func (s *GoStage) Apply(stage string, mutate func(*Config)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.requireRunning(); err != nil {
+		return err
+	}
+	i, err := s.stage(stage)
+	if err != nil {
+		return err
+	}
+	lw := s.linkedWorkers[i]
+	cur := lw.current()
+	next := *cur
+	mutate(&next)
+
+	var changes, rejected []string
+	a, b := reflect.ValueOf(cur).Elem(), reflect.ValueOf(&next).Elem()
+	for k := 0; k < a.NumField(); k++ {
+		name := a.Type().Field(k).Name
+		if sameField(a.Field(k), b.Field(k)) {
+			continue
+		}
+		switch {
+		case tunable[name]:
+			changes = append(changes, fmt.Sprintf("%s %v -> %v", name, a.Field(k).Interface(), b.Field(k).Interface()))
+		case name == "Size" || name == "Concurrency":
+			rejected = append(rejected, name+" (use Scale)")
+		default:
+			rejected = append(rejected, name)
+		}
+	}
+	if len(rejected) > 0 {
+		return fmt.Errorf("%w: %s can't change %s at runtime", ErrRestartRequired, stage, strings.Join(rejected, ", "))
+	}
+	if len(changes) == 0 {
+		return nil
+	}
+
+	lw.tuned.Store(&next)
+	if next.RateLimit != cur.RateLimit {
+		lw.limiter.reset()
+	}
+	if lw.debugSampling(s) > 0 {
+		s.sampling.Store(true)
+	}
+	s.logger.Info("%s reconfigured: %s", stage, strings.Join(changes, ", "))
+	return nil
+}
+
+// sameField returns true if a field of a config hasn't been changed
+// funcs can't be compared, the ones with the same code are taken as the same
+func sameField(a, b reflect.Value) bool {
+	switch a.Kind() {
+	case reflect.Interface:
+		if a.IsNil() || b.IsNil() {
+			return a.IsNil() == b.IsNil()
+		}
+		return a.Elem().Type() == b.Elem().Type() && sameField(a.Elem(), b.Elem())
+	case reflect.Func:
+		return a.Pointer() == b.Pointer()
+	}
+	return reflect.DeepEqual(a.Interface(), b.Interface())
+}
+
+// rateLimiter spaces out the events handled by the instances of a stage, see Config.RateLimit
+type rateLimiter struct {
+	mu sync.Mutex
+	// when the next event may be handled
+	next time.Time
+}
+
+// wait blocks until the stage may handle an event at rate events per second
+// returns false if stop is closed meanwhile
+func (r *rateLimiter) wait(clock Clock, rate float64, stop <-chan struct{}) bool {
+	if rate <= 0 {
+		return true
+	}
+	r.mu.Lock()
+	now := clock.Now()
+	at := r.next
+	if at.Before(now) {
+		at = now
+	}
+	r.next = at.Add(time.Duration(float64(time.Second) / rate))
+	r.mu.Unlock()
+
+	if !at.After(now) {
+		return true
+	}
+	select {
+	case <-clock.After(at.Sub(now)):
+		return true
+	case <-stop:
+		return false
+	}
+}
+
+// reset lets the next event through right away, the rate has changed
+func (r *rateLimiter) reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.next = time.Time{}
+}
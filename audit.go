@@ -0,0 +1,106 @@
+package gostage
+
+import (
+	"encoding/json"
+	"io"
+	"sync/atomic"
+	"time"
+)
+
+// AuditOutcome is what a stage did with an event
+type AuditOutcome string
+
+const (
+	// AuditOK the worker handled the event
+	AuditOK AuditOutcome = "ok"
+	// AuditError the worker returned an error for the event
+	AuditError AuditOutcome = "error"
+	// AuditDropped the event left the pipeline without reaching the worker
+	AuditDropped AuditOutcome = "dropped"
+	// AuditFiltered the event was passed on untouched or the worker returned ErrNoData
+	AuditFiltered AuditOutcome = "filtered"
+)
+
+// AuditRecord is the passage of an event through a stage
+type AuditRecord struct {
+	EventID  string        `json:"event_id"`
+	Stage    string        `json:"stage"`
+	Instance int           `json:"instance"`
+	Outcome  AuditOutcome  `json:"outcome"`
+	Duration time.Duration `json:"duration"`
+	Time     time.Time     `json:"time"`
+	// the event was put in the pipeline by Inject
+	Injected bool `json:"injected,omitempty"`
+	// the ID of the event a Broadcast stage copied, EventID is this ID with the index of the copy appended
+	ParentID string `json:"parent_id,omitempty"`
+}
+
+// JSONAuditEncoder encodes a record as a line of JSON
+func JSONAuditEncoder(r AuditRecord) []byte {
+	data, _ := json.Marshal(r)
+	return append(data, '\n')
+}
+
+// the number of records queued before new ones are dropped
+const auditQueueSize = 1024
+
+// auditLog writes the records off the workers' goroutines
+type auditLog struct {
+	w       io.Writer
+	encode  func(AuditRecord) []byte
+	records chan AuditRecord
+	done    chan struct{}
+	dropped atomic.Int64
+}
+
+// WithAuditLog writes a record to w for every event handled by a stage, encoded by encoder
+// default is JSONAuditEncoder, the records are dropped if w can't keep up and counted
+// in Stats.DroppedAuditRecords, w is flushed on shutdown if it has a Flush method
+func WithAuditLog(w io.Writer, encoder func(AuditRecord) []byte) Option {
+	return func(gs *GoStage) {
+		if encoder == nil {
+			encoder = JSONAuditEncoder
+		}
+		gs.audit = &auditLog{w: w, encode: encoder}
+	}
+}
+
+// start starts the goroutine writing the records of a run
+func (a *auditLog) start() {
+	a.records = make(chan AuditRecord, auditQueueSize)
+	a.done = make(chan struct{})
+	go func(records chan AuditRecord, done chan struct{}) {
+		defer close(done)
+		for r := range records {
+			a.w.Write(a.encode(r))
+		}
+		if f, ok := a.w.(interface{ Flush() error }); ok {
+			f.Flush()
+		}
+	}(a.records, a.done)
+}
+
+// stop writes the queued records, it must be called after all workers have stopped
+func (a *auditLog) stop() {
+	close(a.records)
+	<-a.done
+}
+
+// record queues the record of an event handled by instance n of lw, it never blocks
+func (a *auditLog) record(lw *linkedWorker, n int, id, parent string, injected bool, outcome AuditOutcome, start, end time.Time) {
+	r := AuditRecord{
+		EventID:  id,
+		Stage:    lw.Name,
+		Instance: n,
+		Outcome:  outcome,
+		Duration: end.Sub(start),
+		Time:     end,
+		Injected: injected,
+		ParentID: parent,
+	}
+	select {
+	case a.records <- r:
+	default:
+		a.dropped.Add(1)
+	}
+}
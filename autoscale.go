@@ -0,0 +1,122 @@
+package gostage
+
+import (
+	"fmt"
+	"time"
+)
+
+// DefaultAutoScaleInterval is how often the input buffer is sampled if AutoScale.Interval isn't set
+const DefaultAutoScaleInterval = time.Second
+
+// autoScaleSamples is the number of samples in a row needed to scale,
+// so that a stage isn't scaled up and down by every short burst
+const autoScaleSamples = 3
+
+// AutoScale scales the instances of a consumer stage with the length of its input buffer
+// one instance is added once the buffer has held more than TargetQueueLength events
+// for several samples in a row, one is removed once it has been empty for as long
+type AutoScale struct {
+	// the bounds of the number of instances, the stage starts with Size within them
+	Min, Max int
+	// the buffer length above which the stage is scaled up
+	TargetQueueLength int
+	// how often the buffer is sampled, default is DefaultAutoScaleInterval
+	Interval time.Duration
+}
+
+func (a *AutoScale) interval() time.Duration {
+	if a.Interval > 0 {
+		return a.Interval
+	}
+	return DefaultAutoScaleInterval
+}
+
+// validateAutoScale checks the AutoScale of every config
+func (s *GoStage) validateAutoScale() error {
+	for _, config := range s.configs {
+		a := config.AutoScale
+		if a == nil {
+			continue
+		}
+		switch {
+		case a.Min < 1 || a.Max < a.Min:
+			return fmt.Errorf("%w: %s autoscales from %d to %d instances", ErrInvalidScale, config.Name, a.Min, a.Max)
+		case config.Pool:
+			return fmt.Errorf("%w: %s is pooled and autoscaled", ErrInvalidScale, config.Name)
+		case config.Concurrency > 0:
+			return fmt.Errorf("%w: %s sets both Concurrency and AutoScale", ErrInvalidScale, config.Name)
+		case a.Max > 1 && !config.ShareInstance && !canCreate(config.Worker):
+			return fmt.Errorf("%w: %s autoscales to %d instances, add a Create method or set ShareInstance", ErrMissingCreate, config.Name, a.Max)
+		}
+	}
+	return nil
+}
+
+// startAutoScalers starts a controller for every autoscaled consumer stage
+func (s *GoStage) startAutoScalers() {
+	for i := s.producers; i < len(s.linkedWorkers); i++ {
+		if s.linkedWorkers[i].AutoScale == nil {
+			continue
+		}
+		s.bg.Add(1)
+		go func(i int) {
+			defer s.bg.Done()
+			s.autoscale(i, s.scaling)
+		}(i)
+	}
+}
+
+// autoscale samples the input buffer of stage i until stop is closed
+func (s *GoStage) autoscale(i int, stop <-chan struct{}) {
+	lw := s.linkedWorkers[i]
+	a := lw.AutoScale
+	var above, empty int
+	for {
+		select {
+		case <-stop:
+			return
+		case <-s.clock.After(a.interval()):
+		}
+
+		queued := lw.queued()
+		switch {
+		case queued > a.TargetQueueLength:
+			above++
+			empty = 0
+		case queued == 0:
+			empty++
+			above = 0
+		default:
+			above, empty = 0, 0
+		}
+
+		current := lw.instanceCount()
+		n := current
+		if above >= autoScaleSamples && current < a.Max {
+			n = current + 1
+		} else if empty >= autoScaleSamples && current > a.Min {
+			n = current - 1
+		}
+		if n == current {
+			continue
+		}
+		above, empty = 0, 0
+		s.autoscaleTo(i, n, queued)
+	}
+}
+
+// autoscaleTo scales stage i to n instances unless the pipeline is stopping
+func (s *GoStage) autoscaleTo(i, n, queued int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.requireRunning() != nil || s.linkedWorkers[i].stopped.Load() {
+		return
+	}
+	from, err := s.scale(i, n)
+	if err != nil {
+		s.logger.Error("%s autoscale: %+v", s.linkedWorkers[i].Name, err)
+		return
+	}
+	s.logger.Info("%s autoscaled from %d to %d instances, queue length %d", s.linkedWorkers[i].Name, from, n, queued)
+}
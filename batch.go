@@ -0,0 +1,60 @@
+package gostage
+
+// WithReceiveBatch lets a consumer instance take up to k events at once
+// from its input channel when they're already buffered, then handle them one by one
+// it never waits for more events to arrive, Workers still get one event per HandleEvent
+// default is 1, which takes one event at a time
+func WithReceiveBatch(k int) Option {
+	return func(gs *GoStage) {
+		gs.receiveBatch = k
+	}
+}
+
+// pop returns the next event of the instance's batch, nil if it's empty
+func (inst *instance) pop() *envelope {
+	if inst.head == len(inst.batch) {
+		return nil
+	}
+	env := inst.batch[inst.head]
+	inst.batch[inst.head] = nil
+	inst.head++
+	if inst.head == len(inst.batch) {
+		inst.batch, inst.head = inst.batch[:0], 0
+	}
+	return env
+}
+
+// fill moves the events already buffered in in to the instance's batch,
+// leaving room for the event just received
+func (s *GoStage) fill(inst *instance, in chan *envelope) {
+	if s.receiveBatch <= 1 {
+		return
+	}
+	if inst.batch == nil {
+		inst.batch = make([]*envelope, 0, s.receiveBatch-1)
+	}
+	for len(inst.batch) < s.receiveBatch-1 {
+		select {
+		case env, ok := <-in:
+			if !ok {
+				return
+			}
+			inst.batch = append(inst.batch, env)
+		default:
+			return
+		}
+	}
+}
+
+// dropBatch gives up the events left in the instance's batch
+func (s *GoStage) dropBatch(inst *instance) {
+	for env := inst.pop(); env != nil; env = inst.pop() {
+		inst.lw.stats.unqueued.Add(1)
+		inst.lw.bytes.release(env.size)
+		if inst.lw.BestEffort {
+			s.droppedBestEffort.Add(1)
+		}
+		s.leave(lostInFlight)
+		env.free()
+	}
+}
@@ -0,0 +1,214 @@
+package gostage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+)
+
+// ErrInvalidEdge if Edge is given two stages which aren't linked or an edge can't be replaced
+var ErrInvalidEdge = errors.New("invalid edge")
+
+// EdgeHandle gives access to the edge between two linked stages, see Edge
+type EdgeHandle struct {
+	s        *GoStage
+	from, to string
+}
+
+// Edge returns the edge from the stage with the name from to the stage with the name to,
+// which must be the one it sends to, the producers share the edge to the stage after them
+// it can be called before a run, the edge is looked up again on each call of its methods
+func (s *GoStage) Edge(from, to string) (*EdgeHandle, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	src, dst := s.configNamed(from), s.configNamed(to)
+	if src == nil {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownStage, from)
+	}
+	if dst == nil {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownStage, to)
+	}
+	if s.findParent(dst) != src && !(s.isRoot(src) && dst == s.findFirst(s.findRoots())) {
+		return nil, fmt.Errorf("%w: %s doesn't send to %s", ErrInvalidEdge, from, to)
+	}
+	return &EdgeHandle{s: s, from: from, to: to}, nil
+}
+
+// configNamed returns the config of the stage with the given name, nil if there's none
+func (s *GoStage) configNamed(name string) *Config {
+	for _, config := range s.configs {
+		if config.Name == name {
+			return config
+		}
+	}
+	return nil
+}
+
+func (s *GoStage) isRoot(config *Config) bool {
+	return config.SubscribeTo == nil && config.SubscribeToName == ""
+}
+
+// Len returns the number of events waiting in the edge, the ones in the channels of
+// ReplaceWithChannels and in the code between them included, 0 before the first run
+func (e *EdgeHandle) Len() int {
+	e.s.mu.Lock()
+	defer e.s.mu.Unlock()
+	i, err := e.s.stage(e.to)
+	if err != nil {
+		return 0
+	}
+	return e.s.linkedWorkers[i].queued()
+}
+
+// Cap returns the number of events the edge holds before the sends wait, the BufferSize
+// of the stage it feeds, or the capacity of the channels given to ReplaceWithChannels
+func (e *EdgeHandle) Cap() int {
+	e.s.mu.Lock()
+	defer e.s.mu.Unlock()
+	if b := e.s.bridges[e.to]; b != nil {
+		return cap(b.in) + cap(b.out)
+	}
+	i, err := e.s.stage(e.to)
+	if err == nil && e.s.linkedWorkers[i].queue != nil {
+		if b, ok := e.s.linkedWorkers[i].queue.q.(*bridge); ok {
+			return cap(b.in) + cap(b.out)
+		}
+	}
+	return e.s.configNamed(e.to).BufferSize
+}
+
+// Tap returns the events sent into the edge, see GoStage.Tap
+func (e *EdgeHandle) Tap(buffer int) (<-chan TapEvent, func()) {
+	return e.s.Tap(e.from, buffer)
+}
+
+// ReplaceWithChannels makes the next run send the payloads of the edge to in
+// and feed the stage after it from out, so that code of its own can bridge them
+// it must be called while the pipeline is stopped, the following runs use a plain
+// edge again unless it's replaced again with new channels
+// the pipeline closes in once the stage before has stopped, the bridge must then
+// close out after sending what it holds, the stage after stops once out is closed
+// when the pipeline drains; in and out may be the same channel
+// every event taken from in is accounted as in flight until one comes out of out,
+// those coming out beyond the events taken are counted as produced,
+// the payloads are the encoded ones if the stage after has a Codec
+func (e *EdgeHandle) ReplaceWithChannels(in chan interface{}, out chan interface{}) error {
+	s := e.s
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if st := s.State(); st != StateIdle && st != StateStopped {
+		return fmt.Errorf("%w: pipeline is %s", ErrAlreadyRunning, st)
+	}
+	if in == nil || out == nil {
+		return fmt.Errorf("%w: %s to %s: nil channel", ErrInvalidEdge, e.from, e.to)
+	}
+	if s.configNamed(e.to).Queue != nil {
+		return fmt.Errorf("%w: %s has a Queue", ErrInvalidEdge, e.to)
+	}
+	if s.bridges[e.to] != nil {
+		return fmt.Errorf("%w: %s to %s is already replaced", ErrInvalidEdge, e.from, e.to)
+	}
+	if s.bridges == nil {
+		s.bridges = make(map[string]*bridge)
+	}
+	s.bridges[e.to] = &bridge{s: s, in: in, out: out, closed: make(chan struct{})}
+	return nil
+}
+
+// bridge is the Queue of an edge replaced by ReplaceWithChannels
+type bridge struct {
+	s       *GoStage
+	lw      *linkedWorker
+	in, out chan interface{}
+	// the events taken from in which haven't come out of out
+	pending atomic.Int64
+
+	mu     sync.RWMutex
+	closed chan struct{}
+	once   sync.Once
+}
+
+// bridgeTo returns the bridge replacing the edge into lw for this run, nil if there's none
+func (s *GoStage) bridgeTo(lw *linkedWorker) *bridge {
+	b := s.bridges[lw.Name]
+	if b == nil {
+		return nil
+	}
+	delete(s.bridges, lw.Name)
+	b.lw = lw
+	return b
+}
+
+func (b *bridge) Push(ctx context.Context, v interface{}) error {
+	// Close waits for the pushes in progress before closing in
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	select {
+	case <-b.closed:
+		return ErrQueueClosed
+	default:
+	}
+	env := v.(*envelope)
+	b.pending.Add(1)
+	select {
+	case b.in <- env.payload:
+		b.lw.bytes.release(env.size)
+		env.free()
+		return nil
+	case <-b.closed:
+		b.pending.Add(-1)
+		return ErrQueueClosed
+	case <-ctx.Done():
+		b.pending.Add(-1)
+		return ctx.Err()
+	}
+}
+
+func (b *bridge) Pop(ctx context.Context) (interface{}, bool, error) {
+	select {
+	case v, ok := <-b.out:
+		if !ok {
+			return nil, false, nil
+		}
+		return b.wrap(v), true, nil
+	case <-ctx.Done():
+		return nil, true, ctx.Err()
+	}
+}
+
+// wrap makes the envelope of a payload coming out of the bridge
+func (b *bridge) wrap(v interface{}) *envelope {
+	s := b.s
+	env := s.newEnvelope(v)
+	env.root = -1
+	env.encoded = s.codecOf(b.lw) != nil
+	for {
+		n := b.pending.Load()
+		if n == 0 {
+			// not one of the events which went in
+			s.produced.Add(1)
+			s.enter()
+			break
+		}
+		if b.pending.CompareAndSwap(n, n-1) {
+			break
+		}
+	}
+	s.sample(env)
+	return env
+}
+
+func (b *bridge) Len() int {
+	return int(b.pending.Load())
+}
+
+func (b *bridge) Close() {
+	b.once.Do(func() {
+		close(b.closed)
+		b.mu.Lock()
+		close(b.in)
+		b.mu.Unlock()
+	})
+}
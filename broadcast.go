@@ -0,0 +1,96 @@
+package gostage
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// validateBroadcast checks that config acknowledges it subscribes to a Broadcast stage
+func (s *GoStage) validateBroadcast(config *Config) error {
+	parent := s.findParent(config)
+	if parent == nil || parent.Dispatch != Broadcast || config.ExpectBroadcast {
+		return nil
+	}
+	return fmt.Errorf("%w: %s gets the events of the Broadcast stage %s once per instance, it must set ExpectBroadcast",
+		ErrInvalidDispatch, config.Name, parent.Name)
+}
+
+// broadcast hands a copy of env to every instance of lw, waiting for each of them to have room,
+// the copies of the instances gone meanwhile are lost
+func (s *GoStage) broadcast(lw *linkedWorker, env *envelope, drain bool) {
+	lw.mu.Lock()
+	instances := append([]*instance(nil), lw.instances...)
+	lw.mu.Unlock()
+	if len(instances) <= 1 {
+		if !lw.deliver(env, drain) {
+			s.lostInDispatch(lw, env)
+		}
+		return
+	}
+
+	// the copies are made first, env may be freed as soon as it's delivered
+	parent := s.auditID(env)
+	copies := make([]*envelope, len(instances))
+	for k := range copies {
+		c := env
+		if k > 0 {
+			c = envelopes.Get().(*envelope)
+			*c = *env
+			// the bytes of the event are released once, by the first copy
+			c.id, c.events, c.size = 0, nil, 0
+			// a copy isn't produced, it has a fate of its own though
+			s.copies.Add(1)
+			s.enter()
+			lw.stats.enqueued.Add(1)
+		}
+		c.parent, c.copy = parent, k
+		copies[k] = c
+	}
+	lw.stats.broadcasts.Add(1)
+	for k, c := range copies {
+		if lw.deliverTo(c, instances[k], drain) {
+			lw.stats.copies.Add(1)
+		} else {
+			s.lostInDispatch(lw, c)
+		}
+	}
+}
+
+// deliverTo hands env to inst, an instance of lw, waiting for it to have room
+// returns false if inst isn't an instance of lw anymore
+func (lw *linkedWorker) deliverTo(env *envelope, inst *instance, drain bool) bool {
+	for {
+		lw.mu.Lock()
+		if !lw.runs(inst) {
+			lw.mu.Unlock()
+			return false
+		}
+		if len(inst.inbox) < cap(inst.inbox) && inst.accepting(drain) {
+			inst.load.Add(1)
+			inst.inbox <- env
+			lw.mu.Unlock()
+			return true
+		}
+		lw.mu.Unlock()
+		<-lw.kick
+	}
+}
+
+// runs returns true if inst is still one of the instances of lw, lw.mu must be held
+func (lw *linkedWorker) runs(inst *instance) bool {
+	for _, other := range lw.instances {
+		if other == inst {
+			return true
+		}
+	}
+	return false
+}
+
+// auditID returns the ID of env in the audit log, the ID of the event it's a copy of
+// with the index of the copy appended if a Broadcast stage made it
+func (s *GoStage) auditID(env *envelope) string {
+	if env.parent != "" {
+		return env.parent + "/" + strconv.Itoa(env.copy)
+	}
+	return s.eventName(s.eventID(env))
+}
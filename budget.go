@@ -0,0 +1,109 @@
+package gostage
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ErrErrorBudgetExceeded a stage failed too often, see WithErrorBudget and Config.MaxConsecutiveErrors
+var ErrErrorBudgetExceeded = errors.New("error budget exceeded")
+
+// minBudgetEvents is the number of events a stage must handle in the window
+// before its error rate is checked against WithErrorBudget
+const minBudgetEvents = 10
+
+// budgetBuckets is the number of buckets the error budget window is split into
+const budgetBuckets = 10
+
+// WithErrorBudget stops the pipeline with ErrErrorBudgetExceeded when more than
+// maxRatio of the events handled by a stage in the last window have failed
+// the rate is only checked once the stage has handled 10 events in the window
+// zero window means the whole run
+func WithErrorBudget(maxRatio float64, window time.Duration) Option {
+	return func(gs *GoStage) {
+		gs.maxErrorRatio = maxRatio
+		gs.errorWindow = window
+	}
+}
+
+type budgetBucket struct {
+	start  time.Time
+	total  int64
+	failed int64
+}
+
+// errorRate counts the handled and failed events of a stage in a sliding window
+type errorRate struct {
+	mu      sync.Mutex
+	buckets []budgetBucket
+}
+
+// record adds an event handled at now and returns the counts in the window
+func (r *errorRate) record(now time.Time, window time.Duration, failed bool) (total, failures int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if window > 0 {
+		k := 0
+		for k < len(r.buckets) && now.Sub(r.buckets[k].start) > window {
+			k++
+		}
+		r.buckets = r.buckets[k:]
+	}
+
+	last := len(r.buckets) - 1
+	if last < 0 || (window > 0 && now.Sub(r.buckets[last].start) >= window/budgetBuckets) {
+		r.buckets = append(r.buckets, budgetBucket{start: now})
+		last++
+	}
+	r.buckets[last].total++
+	if failed {
+		r.buckets[last].failed++
+	}
+
+	for _, b := range r.buckets {
+		total += b.total
+		failures += b.failed
+	}
+	return total, failures
+}
+
+// recordOutcome counts an event handled by stage lw and takes the fatal path
+// if the stage has exceeded its error budget
+func (s *GoStage) recordOutcome(lw *linkedWorker, err error) {
+	if err == nil {
+		// avoid writing the shared counter on every event
+		if lw.consecutiveErrors.Load() != 0 {
+			lw.consecutiveErrors.Store(0)
+		}
+	} else if n := lw.consecutiveErrors.Add(1); lw.current().MaxConsecutiveErrors > 0 && n > int64(lw.current().MaxConsecutiveErrors) {
+		s.fail(lw, fmt.Errorf("%w: %s failed %d events in a row", ErrErrorBudgetExceeded, lw.Name, n))
+		return
+	}
+
+	if s.maxErrorRatio <= 0 {
+		return
+	}
+	total, failures := lw.errorRate.record(s.clock.Now(), s.errorWindow, err != nil)
+	if total < minBudgetEvents {
+		return
+	}
+	if rate := float64(failures) / float64(total); rate > s.maxErrorRatio {
+		s.fail(lw, fmt.Errorf("%w: %s failed %d of %d events (%.2f > %.2f)", ErrErrorBudgetExceeded, lw.Name, failures, total, rate, s.maxErrorRatio))
+	}
+}
+
+// fail stops the pipeline through the fatal path, only the first error is kept
+// the instances of lw stop taking events right away, whatever the StopMode,
+// the events buffered for them aren't handled
+func (s *GoStage) fail(lw *linkedWorker, err error) {
+	lw.overBudget.Store(true)
+	lw.interrupter.interrupt()
+	lw.haltAll()
+	select {
+	case s.errChan <- err:
+	default:
+	}
+}
@@ -0,0 +1,69 @@
+package gostage
+
+import (
+	"errors"
+	"fmt"
+	"runtime/debug"
+)
+
+// ErrCallbackPanic if a callback given to the pipeline panicked, see Err
+var ErrCallbackPanic = errors.New("callback panicked")
+
+// protect runs a callback given to the pipeline, a panic is logged and
+// recorded in Err instead of unwinding the framework
+func (s *GoStage) protect(callback string, fn func()) {
+	defer func() {
+		if v := recover(); v != nil {
+			p := &PanicError{Value: v, Stack: debug.Stack()}
+			s.logger.Error("gostage %v\n%s", s.callbackPanicked(callback, p), p.Stack)
+		}
+	}()
+	fn()
+}
+
+// callbackPanicked records the panic of a callback for Err
+func (s *GoStage) callbackPanicked(callback string, p *PanicError) error {
+	err := fmt.Errorf("%w: %s: %w", ErrCallbackPanic, callback, p)
+	s.panicsMu.Lock()
+	defer s.panicsMu.Unlock()
+	s.panics = append(s.panics, err)
+	return err
+}
+
+// safeLogger keeps a panicking Logger from unwinding the framework,
+// the panic is only recorded, it can't be logged
+type safeLogger struct {
+	s *GoStage
+	Logger
+}
+
+func (l *safeLogger) recover() {
+	if v := recover(); v != nil {
+		l.s.callbackPanicked("logger", &PanicError{Value: v, Stack: debug.Stack()})
+	}
+}
+
+func (l *safeLogger) Fatal(format string, args ...interface{}) {
+	defer l.recover()
+	l.Logger.Fatal(format, args...)
+}
+
+func (l *safeLogger) Error(format string, args ...interface{}) {
+	defer l.recover()
+	l.Logger.Error(format, args...)
+}
+
+func (l *safeLogger) Warn(format string, args ...interface{}) {
+	defer l.recover()
+	logWarn(l.Logger, format, args...)
+}
+
+func (l *safeLogger) Info(format string, args ...interface{}) {
+	defer l.recover()
+	l.Logger.Info(format, args...)
+}
+
+func (l *safeLogger) Debug(format string, args ...interface{}) {
+	defer l.recover()
+	l.Logger.Debug(format, args...)
+}
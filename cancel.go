@@ -0,0 +1,166 @@
+package gostage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+)
+
+// ErrUnknownEvent if CancelEvent is given the ID of an event which isn't in the pipeline
+var ErrUnknownEvent = errors.New("unknown event")
+
+// eventIndex keeps the events given an ID until they leave the pipeline
+type eventIndex struct {
+	mu     sync.Mutex
+	events map[uint64]*inflightEvent
+	// the events by the ID given by the IDGenerator, if any
+	names map[string]uint64
+}
+
+type inflightEvent struct {
+	// cancels the context of the HandleEventContext running the event, nil if none
+	cancel    context.CancelFunc
+	cancelled bool
+	// the ID given by the IDGenerator, if any
+	name string
+}
+
+func (x *eventIndex) track(id uint64, name string) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if x.events == nil {
+		x.events = make(map[uint64]*inflightEvent)
+	}
+	x.events[id] = &inflightEvent{name: name}
+	if name != "" {
+		if x.names == nil {
+			x.names = make(map[string]uint64)
+		}
+		x.names[name] = id
+	}
+}
+
+// name returns the ID given to the event id by the IDGenerator
+func (x *eventIndex) name(id uint64) string {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if e := x.events[id]; e != nil {
+		return e.name
+	}
+	return ""
+}
+
+// lookup returns the event given the ID name by the IDGenerator
+func (x *eventIndex) lookup(name string) (uint64, bool) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	id, ok := x.names[name]
+	return id, ok
+}
+
+// reset forgets the events left in the buffers by the last run
+func (x *eventIndex) reset() {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.events = nil
+	x.names = nil
+}
+
+func (x *eventIndex) forget(id uint64) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if e := x.events[id]; e != nil && e.name != "" {
+		delete(x.names, e.name)
+	}
+	delete(x.events, id)
+}
+
+// handling records the cancel func of the context the event is handled with
+// it's called at once if the event is already cancelled
+func (x *eventIndex) handling(id uint64, cancel context.CancelFunc) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	e := x.events[id]
+	if e == nil {
+		return
+	}
+	if e.cancelled {
+		cancel()
+		return
+	}
+	e.cancel = cancel
+}
+
+func (x *eventIndex) handled(id uint64) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if e := x.events[id]; e != nil {
+		e.cancel = nil
+	}
+}
+
+func (x *eventIndex) cancelled(id uint64) bool {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	e := x.events[id]
+	return e != nil && e.cancelled
+}
+
+func (x *eventIndex) cancelEvent(id uint64) bool {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	e := x.events[id]
+	if e == nil {
+		return false
+	}
+	e.cancelled = true
+	if e.cancel != nil {
+		e.cancel()
+	}
+	return true
+}
+
+// CancelEvent cancels the event with the given ID, see EventIDFromContext
+// the context of the ContextWorker handling it is cancelled and the event isn't passed
+// to any other stage, it's counted as Cancelled
+func (s *GoStage) CancelEvent(id string) error {
+	var n uint64
+	var err error
+	if s.idGen != nil {
+		var ok bool
+		if n, ok = s.events.lookup(id); !ok {
+			err = ErrUnknownEvent
+		}
+	} else {
+		n, err = strconv.ParseUint(id, 10, 64)
+	}
+	if err != nil || !s.events.cancelEvent(n) {
+		return fmt.Errorf("%w: %s", ErrUnknownEvent, id)
+	}
+	return nil
+}
+
+// newEventID gives env an ID which it keeps until it leaves the pipeline
+func (s *GoStage) newEventID() uint64 {
+	id := s.eventIDs.Add(1)
+	var name string
+	if s.idGen != nil {
+		name = s.idGen()
+	}
+	s.events.track(id, name)
+	return id
+}
+
+// dropCancelled drops env if it was cancelled, returns true if it was
+func (s *GoStage) dropCancelled(lw *linkedWorker, env *envelope) bool {
+	if env.id == 0 || !s.events.cancelled(env.id) {
+		return false
+	}
+	lw.stats.cancelled.Add(1)
+	s.checkSequence(env)
+	s.leave(cancelled)
+	env.free()
+	return true
+}
@@ -0,0 +1,84 @@
+package gostage
+
+import "errors"
+
+// ErrorClass tells whether retrying an event which failed with an error may help
+type ErrorClass int
+
+const (
+	// Unclassified errors are neither wrapped by Retryable or Permanent nor have a Temporary method,
+	// they're treated as Config.Unclassified says
+	Unclassified ErrorClass = iota
+	// RetryableError follows the ErrorMode of the stage
+	RetryableError
+	// PermanentError is never retried nor forwarded, whatever the ErrorMode
+	PermanentError
+)
+
+func (c ErrorClass) String() string {
+	switch c {
+	case RetryableError:
+		return "retryable"
+	case PermanentError:
+		return "permanent"
+	}
+	return "unclassified"
+}
+
+// classifiedError is an error wrapped by Retryable or Permanent
+type classifiedError struct {
+	err   error
+	class ErrorClass
+}
+
+func (e *classifiedError) Error() string {
+	return e.err.Error()
+}
+
+func (e *classifiedError) Unwrap() error {
+	return e.err
+}
+
+// Retryable marks err as worth retrying, e.g. a rate limit or a timeout
+func Retryable(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &classifiedError{err: err, class: RetryableError}
+}
+
+// Permanent marks err as failing again whenever retried, e.g. a validation error
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &classifiedError{err: err, class: PermanentError}
+}
+
+// Classify returns the class of err, the outermost of Retryable or Permanent wins,
+// otherwise an error in the chain with a Temporary() bool method is retryable if it returns true
+func Classify(err error) ErrorClass {
+	var classified *classifiedError
+	if errors.As(err, &classified) {
+		return classified.class
+	}
+	var temporary interface{ Temporary() bool }
+	if errors.As(err, &temporary) {
+		if temporary.Temporary() {
+			return RetryableError
+		}
+		return PermanentError
+	}
+	return Unclassified
+}
+
+// classify returns the class of an error returned by HandleEvent of lw
+func (lw *linkedWorker) classify(err error) ErrorClass {
+	if class := Classify(err); class != Unclassified {
+		return class
+	}
+	if lw.current().Unclassified == PermanentError {
+		return PermanentError
+	}
+	return RetryableError
+}
@@ -0,0 +1,27 @@
+package gostage
+
+import "time"
+
+// Clock is the time source used by the framework
+// replace it with WithClock in tests to control time
+type Clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+// WithClock sets the clock used for timestamps, expiry and sleeps
+func WithClock(c Clock) Option {
+	return func(gs *GoStage) {
+		gs.clock = c
+	}
+}
@@ -0,0 +1,230 @@
+package gostage
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+// ErrUnregisteredType if a codec is given an event whose type wasn't registered with RegisterType
+var ErrUnregisteredType = errors.New("unregistered type")
+
+// ErrEncode if an event couldn't be encoded, reported for the stage sending it
+var ErrEncode = errors.New("encode failed")
+
+// ErrDecode if an event couldn't be decoded, reported for the stage receiving it
+var ErrDecode = errors.New("decode failed")
+
+// Codec serializes events, for the edges which pass them as bytes
+type Codec interface {
+	Encode(interface{}) ([]byte, error)
+	Decode([]byte) (interface{}, error)
+}
+
+// WithCodec makes every edge pass its events as bytes encoded by c
+// it costs an encode and a decode per event and edge, but catches the events which
+// wouldn't survive crossing a process, use Config.Codec for a single edge
+func WithCodec(c Codec) Option {
+	return func(gs *GoStage) {
+		gs.codec = c
+	}
+}
+
+// the concrete types the codecs can decode, by name
+var registry = struct {
+	sync.RWMutex
+	types map[string]reflect.Type
+	// gob registers a type and the pointers to it once
+	gob map[reflect.Type]bool
+}{types: make(map[string]reflect.Type), gob: make(map[reflect.Type]bool)}
+
+func init() {
+	for _, v := range []interface{}{
+		false, 0, int8(0), int16(0), int32(0), int64(0),
+		uint(0), uint8(0), uint16(0), uint32(0), uint64(0), float32(0), float64(0),
+		"", []byte(nil), []interface{}(nil), map[string]interface{}(nil),
+	} {
+		registry.types[typeName(reflect.TypeOf(v))] = reflect.TypeOf(v)
+	}
+}
+
+// RegisterType makes the concrete type of v known to the codecs so that its events round-trip
+// the basic types are registered already
+func RegisterType(v interface{}) {
+	t := reflect.TypeOf(v)
+	name := typeName(t)
+
+	registry.Lock()
+	defer registry.Unlock()
+	if _, ok := registry.types[name]; ok {
+		return
+	}
+	registry.types[name] = t
+
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if !registry.gob[t] {
+		registry.gob[t] = true
+		gob.Register(reflect.Zero(t).Interface())
+	}
+}
+
+func typeName(t reflect.Type) string {
+	if t.Kind() == reflect.Ptr {
+		return "*" + typeName(t.Elem())
+	}
+	if t.Name() != "" && t.PkgPath() != "" {
+		return t.PkgPath() + "." + t.Name()
+	}
+	return t.String()
+}
+
+// lookup returns the registered name of v's type
+func lookup(v interface{}) (string, error) {
+	if v == nil {
+		return "", nil
+	}
+	name := typeName(reflect.TypeOf(v))
+	registry.RLock()
+	defer registry.RUnlock()
+	if _, ok := registry.types[name]; !ok {
+		return "", fmt.Errorf("%w: %s", ErrUnregisteredType, name)
+	}
+	return name, nil
+}
+
+// GobCodec encodes events with encoding/gob
+// a pointer is decoded as the value it points to
+type GobCodec struct{}
+
+// gobEvent carries the event in an interface so that gob sends its type
+type gobEvent struct {
+	V interface{}
+}
+
+// Encode implements Codec
+func (GobCodec) Encode(v interface{}) ([]byte, error) {
+	if _, err := lookup(v); err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(gobEvent{V: v}); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Decode implements Codec
+func (GobCodec) Decode(data []byte) (interface{}, error) {
+	var ev gobEvent
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&ev); err != nil {
+		return nil, err
+	}
+	return ev.V, nil
+}
+
+// JSONCodec encodes events with encoding/json along with the name of their type
+type JSONCodec struct{}
+
+type jsonEvent struct {
+	Type  string          `json:"type,omitempty"`
+	Value json.RawMessage `json:"value"`
+}
+
+// Encode implements Codec
+func (JSONCodec) Encode(v interface{}) ([]byte, error) {
+	name, err := lookup(v)
+	if err != nil {
+		return nil, err
+	}
+	value, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(jsonEvent{Type: name, Value: value})
+}
+
+// Decode implements Codec
+func (JSONCodec) Decode(data []byte) (interface{}, error) {
+	var ev jsonEvent
+	if err := json.Unmarshal(data, &ev); err != nil {
+		return nil, err
+	}
+	if ev.Type == "" {
+		return nil, nil
+	}
+
+	registry.RLock()
+	t, ok := registry.types[ev.Type]
+	registry.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnregisteredType, ev.Type)
+	}
+
+	ptr := t.Kind() == reflect.Ptr
+	if ptr {
+		t = t.Elem()
+	}
+	v := reflect.New(t)
+	if err := json.Unmarshal(ev.Value, v.Interface()); err != nil {
+		return nil, err
+	}
+	if ptr {
+		return v.Interface(), nil
+	}
+	return v.Elem().Interface(), nil
+}
+
+// codecOf returns the codec of the edge into lw, nil if it passes events in memory
+func (s *GoStage) codecOf(lw *linkedWorker) Codec {
+	if lw.Codec != nil {
+		return lw.Codec
+	}
+	return s.codec
+}
+
+// encode replaces the payload of env sent by stage i with its encoding
+// returns false if it failed, the event has left the pipeline then
+func (s *GoStage) encode(c Codec, i int, inst *instance, env *envelope) bool {
+	data, err := c.Encode(env.payload)
+	if err != nil {
+		lw := s.linkedWorkers[i]
+		lw.stats.errors.Add(1)
+		s.logWorkerError(lw, err, "%s_#%d %v: %v", lw.Name, inst.n, ErrEncode, err)
+		s.reportError(lw, inst.n, env.payload, fmt.Errorf("%w: %w", ErrEncode, err))
+		lw.stats.deadLettered.Add(1)
+		s.leave(deadLettered)
+		env.free()
+		return false
+	}
+	env.payload = data
+	env.encoded = true
+	return true
+}
+
+// decode restores the payload of env received by lw
+// returns false if it failed, the event has left the pipeline then
+func (s *GoStage) decode(lw *linkedWorker, n int, env *envelope) bool {
+	if !env.encoded {
+		return true
+	}
+	data := env.payload.([]byte)
+	v, err := s.codecOf(lw).Decode(data)
+	if err != nil {
+		lw.stats.errors.Add(1)
+		s.logWorkerError(lw, err, "%s_#%d %v: %v", lw.Name, n, ErrDecode, err)
+		s.reportError(lw, n, data, fmt.Errorf("%w: %w", ErrDecode, err))
+		lw.stats.deadLettered.Add(1)
+		s.leave(deadLettered)
+		env.free()
+		return false
+	}
+	env.payload = v
+	env.encoded = false
+	return true
+}
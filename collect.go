@@ -0,0 +1,73 @@
+package gostage
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// ErrMaxResults Collect has gathered the number of results set by WithMaxResults
+var ErrMaxResults = errors.New("max results reached")
+
+// WithMaxResults caps the number of results gathered by Collect or with ToCollector
+// once the cap is reached the pipeline is stopped with ErrMaxResults
+func WithMaxResults(n int) Option {
+	return func(gs *GoStage) {
+		gs.maxResults = n
+	}
+}
+
+// collector gathers the outputs of the terminal stage
+type collector struct {
+	mu      sync.Mutex
+	max     int
+	results []interface{}
+}
+
+// add appends v, returns false if the cap has been reached
+func (c *collector) add(v interface{}) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.max > 0 && len(c.results) >= c.max {
+		return false
+	}
+	c.results = append(c.results, v)
+	return true
+}
+
+func (c *collector) all() []interface{} {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.results
+}
+
+// Collect runs the pipeline until it stops and returns the outputs of the
+// terminal stage in the order they were emitted, failed events emit nothing
+// the error is nil if the producer quit and every event was drained,
+// ctx.Err() if ctx was done, otherwise the reason the pipeline stopped
+func (s *GoStage) Collect(ctx context.Context) ([]interface{}, error) {
+	c := &collector{max: s.maxResults}
+	if err := s.run(c); err != nil {
+		return nil, err
+	}
+
+	finished := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			s.setReason(ctx.Err())
+			s.Stop()
+		case <-finished:
+		}
+	}()
+
+	s.wait(nil, nil)
+	close(finished)
+	s.finish(nil)
+
+	reason := s.Reason()
+	if errors.Is(reason, ErrQuit) {
+		reason = nil
+	}
+	return c.all(), reason
+}
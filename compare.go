@@ -0,0 +1,141 @@
+package gostage
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+)
+
+// the number of inputs queued for the candidate before new ones are skipped
+const compareQueueSize = 64
+
+// Comparison is a stage running a candidate worker next to the current one
+// on the same inputs, only the current worker's outputs are passed on
+type Comparison struct {
+	current, candidate Worker
+	diff               func(a, b interface{}) bool
+	onMismatch         func(in, a, b interface{})
+
+	mu      sync.Mutex
+	queue   chan compareJob
+	stopped chan struct{}
+
+	compared   atomic.Int64
+	mismatches atomic.Int64
+	failures   atomic.Int64
+	skipped    atomic.Int64
+}
+
+type compareJob struct {
+	in, out interface{}
+}
+
+// CompareStage creates a stage handling its inputs with current, and with candidate
+// on another goroutine which never blocks the pipeline, diff returns true if the
+// outputs of the two differ, onMismatch is then called with the input and the outputs
+// the errors and panics of the candidate are counted, the inputs for which current
+// failed aren't compared
+// the candidate runs on a single goroutine, so the stage's Size must stay 1
+func CompareStage(name string, current, candidate Worker, diff func(a, b interface{}) bool, onMismatch func(in, a, b interface{})) (*Config, *Comparison) {
+	c := &Comparison{
+		current:    current,
+		candidate:  candidate,
+		diff:       diff,
+		onMismatch: onMismatch,
+	}
+	return &Config{Name: name, Size: 1, Worker: c}, c
+}
+
+// HandleEvent passes in to current and queues it for the candidate
+func (c *Comparison) HandleEvent(in interface{}) (interface{}, error) {
+	out, err := c.current.HandleEvent(in)
+	if err != nil {
+		return out, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.queue == nil {
+		c.start()
+	}
+	select {
+	case c.queue <- compareJob{in: in, out: out}:
+	default:
+		c.skipped.Add(1)
+	}
+	return out, nil
+}
+
+// start starts the candidate's goroutine, c.mu must be held
+func (c *Comparison) start() {
+	c.queue = make(chan compareJob, compareQueueSize)
+	c.stopped = make(chan struct{})
+	go func(queue chan compareJob, stopped chan struct{}) {
+		defer close(stopped)
+		for job := range queue {
+			c.compare(job)
+		}
+	}(c.queue, c.stopped)
+}
+
+func (c *Comparison) compare(job compareJob) {
+	out, err := c.runCandidate(job.in)
+	if err != nil {
+		c.failures.Add(1)
+		return
+	}
+	c.compared.Add(1)
+	if c.diff(job.out, out) {
+		c.mismatches.Add(1)
+		if c.onMismatch != nil {
+			c.onMismatch(job.in, job.out, out)
+		}
+	}
+}
+
+func (c *Comparison) runCandidate(in interface{}) (out interface{}, err error) {
+	defer func() {
+		if v := recover(); v != nil {
+			out, err = nil, fmt.Errorf("candidate panic: %v", v)
+		}
+	}()
+	return c.candidate.HandleEvent(in)
+}
+
+// Close waits for the candidate to compare the queued inputs and closes both workers
+func (c *Comparison) Close() {
+	c.mu.Lock()
+	queue, stopped := c.queue, c.stopped
+	c.queue = nil
+	c.mu.Unlock()
+	if queue != nil {
+		close(queue)
+		<-stopped
+	}
+
+	for _, w := range []Worker{c.current, c.candidate} {
+		if closer, ok := w.(interface{ Close() }); ok {
+			closer.Close()
+		}
+	}
+}
+
+// Compared returns the number of inputs handled by both workers
+func (c *Comparison) Compared() int64 {
+	return c.compared.Load()
+}
+
+// Mismatches returns the number of inputs for which the outputs differed
+func (c *Comparison) Mismatches() int64 {
+	return c.mismatches.Load()
+}
+
+// CandidateFailures returns the number of inputs for which the candidate returned an error or panicked
+func (c *Comparison) CandidateFailures() int64 {
+	return c.failures.Load()
+}
+
+// Skipped returns the number of inputs not passed to the candidate because it couldn't keep up
+func (c *Comparison) Skipped() int64 {
+	return c.skipped.Load()
+}
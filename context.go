@@ -0,0 +1,115 @@
+package gostage
+
+import (
+	"context"
+	"strconv"
+)
+
+// ContextWorker is a Worker whose HandleEventContext is called instead of HandleEvent
+// ctx is done with the pipeline's context and carries the stage, the instance and the event ID
+type ContextWorker interface {
+	Worker
+	HandleEventContext(ctx context.Context, in interface{}) (interface{}, error)
+}
+
+// ContextHandler is a handy function type that implements ContextWorker
+type ContextHandler func(ctx context.Context, in interface{}) (interface{}, error)
+
+// HandleEvent implements the Worker
+func (ch ContextHandler) HandleEvent(in interface{}) (interface{}, error) {
+	return ch(context.Background(), in)
+}
+
+// HandleEventContext implements the ContextWorker
+func (ch ContextHandler) HandleEventContext(ctx context.Context, in interface{}) (interface{}, error) {
+	return ch(ctx, in)
+}
+
+type contextKey int
+
+const (
+	stageKey contextKey = iota
+	instanceKey
+	eventIDKey
+	stopTokenKey
+	metadataKey
+	headersKey
+	clockKey
+)
+
+// StageFromContext returns the name of the stage handling the event
+func StageFromContext(ctx context.Context) (string, bool) {
+	name, ok := ctx.Value(stageKey).(string)
+	return name, ok
+}
+
+// InstanceFromContext returns the index of the instance handling the event, from 0 to the stage's size
+func InstanceFromContext(ctx context.Context) (int, bool) {
+	n, ok := ctx.Value(instanceKey).(int)
+	return n, ok
+}
+
+// EventIDFromContext returns the ID of the event being handled
+// it's unique within the GoStage and kept by the event across stages, see WithIDGenerator
+func EventIDFromContext(ctx context.Context) (string, bool) {
+	switch id := ctx.Value(eventIDKey).(type) {
+	case uint64:
+		return strconv.FormatUint(id, 10), true
+	case string:
+		return id, true
+	}
+	return "", false
+}
+
+// eventContext is a payload carrying the values of its event
+type eventContext struct {
+	values  context.Context
+	payload interface{}
+}
+
+// WithEventContext attaches the values of ctx to the event of payload, a worker returns it
+// instead of the payload and the ContextWorkers downstream see the values in their context,
+// only the values are carried: the event's context is still done with the pipeline's
+// the values of the pipeline's context win over the event's ones with the same key
+func WithEventContext(ctx context.Context, payload interface{}) interface{} {
+	return &eventContext{values: ctx, payload: payload}
+}
+
+// valuesContext is done with its Context and looks up the values it hasn't in values
+type valuesContext struct {
+	context.Context
+	values context.Context
+}
+
+func (c *valuesContext) Value(key interface{}) interface{} {
+	if v := c.Context.Value(key); v != nil {
+		return v
+	}
+	return c.values.Value(key)
+}
+
+// instanceContext returns the context shared by all events of an instance
+func (s *GoStage) instanceContext(lw *linkedWorker, n int) context.Context {
+	ctx := context.WithValue(context.WithValue(s.ctx, stageKey, lw.Name), instanceKey, n)
+	if s.metadata != nil {
+		ctx = context.WithValue(ctx, metadataKey, s.metadata)
+	}
+	ctx = context.WithValue(ctx, clockKey, s.clock)
+	return context.WithValue(ctx, stopTokenKey, lw.interrupter.token())
+}
+
+// clockFromContext returns the Clock of the pipeline running the worker, the real one outside a pipeline
+func clockFromContext(ctx context.Context) Clock {
+	if c, ok := ctx.Value(clockKey).(Clock); ok {
+		return c
+	}
+	return realClock{}
+}
+
+// eventID returns the ID of env, it's given one the first time it's asked for
+func (s *GoStage) eventID(env *envelope) uint64 {
+	if env.id == 0 {
+		env.setID(&s.events, s.newEventID())
+	}
+	return env.id
+}
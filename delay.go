@@ -0,0 +1,313 @@
+package gostage
+
+import (
+	"container/heap"
+	"sync"
+	"time"
+)
+
+// DefaultMaxHeld is the most events a Delay stage holds unless WithMaxHeld is given
+const DefaultMaxHeld = 1024
+
+// DelayStop decides what a Delay stage does with the events it holds
+// when the pipeline stops with StopDrain, with StopImmediate they're dropped
+type DelayStop int
+
+const (
+	// ReleaseNow releases the held events at once, earliest due first
+	ReleaseNow DelayStop = iota
+	// ReleaseWhenDue keeps releasing the held events when they're due,
+	// the stage stops after the last one
+	ReleaseWhenDue
+)
+
+// DelayOption configures a Delay stage
+type DelayOption func(*Delayer)
+
+// WithMaxHeld sets the most events the stage holds, the upstream waits while they're held
+// until the pipeline stops, default is DefaultMaxHeld
+func WithMaxHeld(n int) DelayOption {
+	return func(d *Delayer) {
+		d.maxHeld = n
+	}
+}
+
+// WithDelayStop sets what happens to the held events when the pipeline stops, default is ReleaseNow
+func WithDelayStop(m DelayStop) DelayOption {
+	return func(d *Delayer) {
+		d.onStop = m
+	}
+}
+
+// Delayer holds the events of a Delay stage until they're due
+type Delayer struct {
+	due     func(interface{}) time.Time
+	maxHeld int
+	onStop  DelayStop
+
+	mu   sync.Mutex
+	held dueHeap
+	// orders the events due at the same time by arrival
+	seq uint64
+}
+
+// Delay creates a stage which passes every event downstream once the time returned
+// by due for it has come, the events are released by due time rather than by arrival,
+// those already due are passed at once
+// due is called when the event arrives, the events are held in memory so the stage's Size should stay 1
+// set SubscribeTo on the returned Config to link it to the pipeline
+func Delay(name string, due func(interface{}) time.Time, opts ...DelayOption) (*Config, *Delayer) {
+	d := &Delayer{due: due, maxHeld: DefaultMaxHeld}
+	for _, opt := range opts {
+		opt(d)
+	}
+	if d.maxHeld <= 0 {
+		d.maxHeld = DefaultMaxHeld
+	}
+	return &Config{Name: name, Size: 1, Worker: d}, d
+}
+
+// HandleEvent isn't called, the pipeline holds and releases the events itself
+func (d *Delayer) HandleEvent(in interface{}) (interface{}, error) {
+	return in, nil
+}
+
+// Held returns the number of events the stage holds
+func (d *Delayer) Held() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.held.Len()
+}
+
+type heldEvent struct {
+	env *envelope
+	due time.Time
+	seq uint64
+}
+
+// dueHeap is a min-heap of the held events by due time
+type dueHeap []heldEvent
+
+func (h dueHeap) Len() int { return len(h) }
+
+func (h dueHeap) Less(a, b int) bool {
+	if h[a].due.Equal(h[b].due) {
+		return h[a].seq < h[b].seq
+	}
+	return h[a].due.Before(h[b].due)
+}
+
+func (h dueHeap) Swap(a, b int) { h[a], h[b] = h[b], h[a] }
+
+func (h *dueHeap) Push(x interface{}) { *h = append(*h, x.(heldEvent)) }
+
+func (h *dueHeap) Pop() interface{} {
+	old := *h
+	e := old[len(old)-1]
+	old[len(old)-1] = heldEvent{}
+	*h = old[:len(old)-1]
+	return e
+}
+
+func (d *Delayer) hold(env *envelope, due time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.seq++
+	heap.Push(&d.held, heldEvent{env: env, due: due, seq: d.seq})
+}
+
+// next returns the due time of the earliest held event, false if none is held
+func (d *Delayer) next() (time.Time, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.held.Len() == 0 {
+		return time.Time{}, false
+	}
+	return d.held[0].due, true
+}
+
+// take removes the earliest held event if it's due by now, any if now is zero
+func (d *Delayer) take(now time.Time) *envelope {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.held.Len() == 0 || !now.IsZero() && d.held[0].due.After(now) {
+		return nil
+	}
+	return heap.Pop(&d.held).(heldEvent).env
+}
+
+func (d *Delayer) full() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.held.Len() >= d.maxHeld
+}
+
+// runDelay is the loop of an instance of a Delay stage
+// it takes events while fewer than maxHeld are held and releases them when they're due
+func (s *GoStage) runDelay(inst *instance, d *Delayer, i int) {
+	lw := s.linkedWorkers[i]
+	// restarted after due panicked, the event may be delivered again
+	if env := s.redeliver(inst); env != nil && !s.delay(inst, d, i, env) {
+		s.dropHeld(inst, d)
+		s.exit(inst)
+		return
+	}
+
+	in, stop := s.inputOf(inst), inst.stop
+	// once the pipeline is stopping maxHeld no longer applies, the upstream has to drain
+	stopping := s.scaling
+	var timer <-chan time.Time
+	var timerAt time.Time
+	for {
+		if !s.releaseHeld(inst, d, i, s.clock.Now()) {
+			s.dropHeld(inst, d)
+			s.exit(inst)
+			return
+		}
+		if at, ok := d.next(); !ok {
+			timer = nil
+		} else if timer == nil || !at.Equal(timerAt) {
+			timer, timerAt = s.clock.After(at.Sub(s.clock.Now())), at
+		}
+		receiving := in
+		if stopping != nil && d.full() {
+			receiving = nil
+		}
+
+		select {
+		case env, ok := <-receiving:
+			if !ok {
+				// the upstream has stopped and everything it sent has been taken
+				s.stopDelay(inst, d, i)
+				return
+			}
+			lw.bytes.release(env.size)
+			lw.stats.in.Add(1)
+			if !s.delay(inst, d, i, env) {
+				s.dropHeld(inst, d)
+				s.exit(inst)
+				return
+			}
+		case <-timer:
+			timer = nil
+		case <-stopping:
+			stopping = nil
+		case <-stop:
+			if inst.retiring.Load() {
+				// the other instances release the held events
+				s.exit(inst)
+				return
+			}
+			if s.stopModeOf(lw) == StopImmediate {
+				s.dropHeld(inst, d)
+				s.exit(inst)
+				return
+			}
+			// take what is left in the buffer whatever maxHeld
+		drain:
+			for {
+				select {
+				case env, ok := <-in:
+					if !ok {
+						break drain
+					}
+					lw.bytes.release(env.size)
+					lw.stats.in.Add(1)
+					if !s.delay(inst, d, i, env) {
+						s.dropHeld(inst, d)
+						s.exit(inst)
+						return
+					}
+				default:
+					break drain
+				}
+			}
+			s.stopDelay(inst, d, i)
+			return
+		}
+	}
+}
+
+// delay holds env until it's due, or passes it on at once if the stage is disabled
+// or When returns false for it
+// returns false if the instance was aborted while passing it on
+func (s *GoStage) delay(inst *instance, d *Delayer, i int, env *envelope) bool {
+	lw := s.linkedWorkers[i]
+	if !s.decode(lw, inst.n, env) {
+		return true
+	}
+	if lw.disabled.Load() || lw.When != nil && !lw.When(env.payload) {
+		lw.stats.bypassed.Add(1)
+		return s.releaseEvent(inst, i, env)
+	}
+	inst.current = env
+	due := d.due(env.payload)
+	inst.current = nil
+	inst.processed.Add(1)
+	d.hold(env, due)
+	return true
+}
+
+// releaseHeld passes on the held events due by now, all of them if now is zero
+// returns false if the instance was aborted while passing one on
+func (s *GoStage) releaseHeld(inst *instance, d *Delayer, i int, now time.Time) bool {
+	for env := d.take(now); env != nil; env = d.take(now) {
+		if !s.releaseEvent(inst, i, env) {
+			return false
+		}
+	}
+	return true
+}
+
+// releaseEvent passes env downstream, or out of the pipeline if the stage is terminal
+func (s *GoStage) releaseEvent(inst *instance, i int, env *envelope) bool {
+	lw := s.linkedWorkers[i]
+	lw.stats.out.Add(1)
+	lw.tapped(env.payload)
+	if lw.role == Sink {
+		s.emit(inst, env.payload)
+		s.checkSequence(env)
+		s.leave(completed)
+		env.free()
+		return true
+	}
+	return s.send(i, inst, env)
+}
+
+// stopDelay releases the held events as the DelayStop says, then exits the instance
+func (s *GoStage) stopDelay(inst *instance, d *Delayer, i int) {
+	defer s.exit(inst)
+	if d.onStop == ReleaseNow {
+		if !s.releaseHeld(inst, d, i, time.Time{}) {
+			s.dropHeld(inst, d)
+		}
+		return
+	}
+	for {
+		if !s.releaseHeld(inst, d, i, s.clock.Now()) {
+			s.dropHeld(inst, d)
+			return
+		}
+		at, ok := d.next()
+		if !ok {
+			return
+		}
+		select {
+		case <-s.clock.After(at.Sub(s.clock.Now())):
+		case <-inst.abort:
+			s.dropHeld(inst, d)
+			return
+		}
+	}
+}
+
+// dropHeld gives up the held events
+func (s *GoStage) dropHeld(inst *instance, d *Delayer) {
+	for env := d.take(time.Time{}); env != nil; env = d.take(time.Time{}) {
+		if inst.lw.BestEffort {
+			s.droppedBestEffort.Add(1)
+		}
+		s.leave(lostInFlight)
+		env.free()
+	}
+}
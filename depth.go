@@ -0,0 +1,53 @@
+package gostage
+
+import "time"
+
+// WithQueueSampling samples the number of events waiting in front of every consumer stage
+// each interval, see StageStats.QueueDepth and MaxQueueDepth
+// the depth is the difference of two counters the data path adds to when it puts an event
+// in front of a stage and when the stage takes it, so the sampler never calls Queue.Len
+// nor takes a lock of the data path, the counters are kept whether it samples or not
+func WithQueueSampling(interval time.Duration) Option {
+	return func(gs *GoStage) {
+		gs.queueSampling = interval
+	}
+}
+
+// queueDepth returns the events put in front of lw and not taken yet, the ones
+// in its buffer or Queue and the ones dispatched to the inboxes of its instances
+func (lw *linkedWorker) queueDepth() int64 {
+	st := lw.stats
+	// the takes are read first, an event put and taken meanwhile can't make it negative
+	taken := st.in.Load() + st.unqueued.Load()
+	return st.enqueued.Load() - taken
+}
+
+// startQueueSampler samples the depths of the stages until the pipeline starts stopping
+func (s *GoStage) startQueueSampler() {
+	if s.queueSampling <= 0 {
+		return
+	}
+	s.bg.Add(1)
+	go func(stop <-chan struct{}) {
+		defer s.bg.Done()
+		for {
+			s.sampleQueues()
+			select {
+			case <-stop:
+				return
+			case <-s.clock.After(s.queueSampling):
+			}
+		}
+	}(s.scaling)
+}
+
+func (s *GoStage) sampleQueues() {
+	for i := s.producers; i < len(s.linkedWorkers); i++ {
+		st := s.linkedWorkers[i].stats
+		depth := s.linkedWorkers[i].queueDepth()
+		st.depth.Store(depth)
+		if depth > st.maxDepth.Load() {
+			st.maxDepth.Store(depth)
+		}
+	}
+}
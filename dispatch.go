@@ -0,0 +1,318 @@
+package gostage
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrInvalidDispatch if a stage's Dispatch can't be used with the rest of its config
+var ErrInvalidDispatch = errors.New("invalid dispatch")
+
+// Dispatch decides how the events of a consumer stage reach its instances
+type Dispatch int
+
+const (
+	// Compete lets the instances take the events from the stage's buffer as they're free
+	Compete Dispatch = iota
+	// LeastBusy routes every event to the instance expected to be done with it first,
+	// judging by the events it has been given and how long its last events took,
+	// so that a slow instance gets fewer events, every instance queues one event at most
+	LeastBusy
+	// Partitioned routes every event to the instance of its partition, in order: the one
+	// attached by WithPartition, or the hash of the key returned by Config.PartitionBy,
+	// the events without either go to the least busy instance, every instance queues
+	// BufferSize events at most, see StageStats.Partitions
+	// when the stage is scaled, the events of a partition which moves to another instance
+	// wait until the instance it was on has handled the events it had been given before,
+	// see Config.Partitioner
+	Partitioned
+	// Broadcast hands a copy of every event to each instance, e.g. to refresh the cache
+	// each of them holds, the copies share the payload, every instance queues BufferSize
+	// events at most, the stages subscribing to a Broadcast stage get the output of every
+	// copy and must set Config.ExpectBroadcast, see StageStats.Copies
+	Broadcast
+)
+
+// latencyWeight is the weight of the last event in an instance's average latency, out of 8
+const latencyWeight = 2
+
+// validateDispatch checks the stages which don't Compete
+func (s *GoStage) validateDispatch() error {
+	for _, config := range s.configs {
+		if config.Dispatch != Compete && config.Pool {
+			return fmt.Errorf("%w: %s is pooled, its instances can't be picked", ErrInvalidDispatch, config.Name)
+		}
+		if config.Partitioner != nil && config.Dispatch != Partitioned {
+			return fmt.Errorf("%w: %s has a Partitioner but isn't Partitioned", ErrInvalidDispatch, config.Name)
+		}
+		if err := s.validateBroadcast(config); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// dispatched returns true if the events of lw are routed by a dispatcher
+func (lw *linkedWorker) dispatched() bool {
+	return lw.Dispatch != Compete && lw.role != Source
+}
+
+// inboxSize returns the number of events an instance of a dispatched stage queues
+func (lw *linkedWorker) inboxSize() int {
+	if (lw.Dispatch == Partitioned || lw.Dispatch == Broadcast) && lw.BufferSize > 1 {
+		return lw.BufferSize
+	}
+	return 1
+}
+
+// inputOf returns the channel an instance takes its events from
+func (s *GoStage) inputOf(inst *instance) chan *envelope {
+	if inst.inbox != nil {
+		return inst.inbox
+	}
+	return inst.lw.in
+}
+
+// startDispatcher routes the events of lw to its instances unless a dispatcher already does
+func (s *GoStage) startDispatcher(lw *linkedWorker) {
+	lw.mu.Lock()
+	defer lw.mu.Unlock()
+	if lw.dispatching {
+		return
+	}
+	lw.dispatching = true
+	s.bg.Add(1)
+	go s.dispatch(lw)
+}
+
+// dispatch hands the events of lw to its least busy instances until its input is closed
+// or it has no instance left to hand them to, the events are left in the input then
+func (s *GoStage) dispatch(lw *linkedWorker) {
+	defer s.bg.Done()
+	drain := s.stopModeOf(lw) == StopDrain
+	for {
+		if !lw.awaitInstance(drain) {
+			return
+		}
+		select {
+		case env, ok := <-lw.in:
+			if !ok {
+				// the instances stop once they have taken their last event
+				lw.closeInboxes()
+				return
+			}
+			if lw.Dispatch == Broadcast {
+				s.broadcast(lw, env, drain)
+			} else if !lw.deliver(env, drain) {
+				s.lostInDispatch(lw, env)
+			}
+		case <-lw.kick:
+		}
+	}
+}
+
+// lostInDispatch gives up an event the dispatcher of lw had no instance for
+func (s *GoStage) lostInDispatch(lw *linkedWorker, env *envelope) {
+	lw.stats.unqueued.Add(1)
+	lw.bytes.release(env.size)
+	s.leave(lostInChannel)
+	if lw.BestEffort {
+		s.droppedBestEffort.Add(1)
+	}
+	env.free()
+}
+
+// awaitInstance waits until an instance can be given an event
+// returns false if the stage has no instance left, the dispatcher is done then
+func (lw *linkedWorker) awaitInstance(drain bool) bool {
+	for {
+		lw.mu.Lock()
+		if len(lw.instances) == 0 {
+			lw.dispatching = false
+			lw.mu.Unlock()
+			return false
+		}
+		inst := lw.leastBusy(drain)
+		lw.mu.Unlock()
+		if inst != nil {
+			return true
+		}
+		<-lw.kick
+	}
+}
+
+// deliver hands env to the instance of its partition or the least busy one, waiting for it to have room
+// returns false if the stage has no instance left
+func (lw *linkedWorker) deliver(env *envelope, drain bool) bool {
+	for {
+		lw.mu.Lock()
+		if len(lw.instances) == 0 {
+			lw.dispatching = false
+			lw.mu.Unlock()
+			return false
+		}
+		// only the dispatcher sends to the inboxes, an instance with room keeps it
+		if inst := lw.pick(env, drain); inst != nil {
+			inst.load.Add(1)
+			inst.inbox <- env
+			lw.mu.Unlock()
+			return true
+		}
+		lw.mu.Unlock()
+		<-lw.kick
+	}
+}
+
+// pick returns the instance env goes to, nil if it has no room for it, lw.mu must be held
+func (lw *linkedWorker) pick(env *envelope, drain bool) *instance {
+	if lw.Dispatch != Partitioned {
+		return lw.leastBusy(drain)
+	}
+	inst, k, settled := lw.partitioned(env)
+	if inst == nil {
+		if inst = lw.leastBusy(drain); inst == nil {
+			return nil
+		}
+		for k = range lw.instances {
+			if lw.instances[k] == inst {
+				break
+			}
+		}
+	} else if !settled || len(inst.inbox) == cap(inst.inbox) || !inst.accepting(drain) {
+		return nil
+	}
+	lw.countPartition(k)
+	return inst
+}
+
+// leastBusy returns the instance which should be done first with one more event,
+// nil if none has room for it, lw.mu must be held
+func (lw *linkedWorker) leastBusy(drain bool) *instance {
+	var best *instance
+	var bestScore int64
+	for _, inst := range lw.instances {
+		if len(inst.inbox) == cap(inst.inbox) || !inst.accepting(drain) {
+			continue
+		}
+		latency := inst.latency.Load()
+		if latency < 1 {
+			latency = 1
+		}
+		if score := (inst.load.Load() + 1) * latency; best == nil || score < bestScore {
+			best, bestScore = inst, score
+		}
+	}
+	return best
+}
+
+// accepting returns true if the instance takes events from its inbox, the halted
+// instances only do if they drain what's left of a stopped upstream
+func (inst *instance) accepting(drain bool) bool {
+	if !inst.halted.Load() {
+		return true
+	}
+	return drain && inst.lw.upstreamDone.Load() && !inst.retiring.Load() && !inst.killed()
+}
+
+// killed returns true if the instance has been asked to stop as soon as possible
+func (inst *instance) killed() bool {
+	select {
+	case <-inst.abort:
+		return true
+	default:
+		return false
+	}
+}
+
+// closeInboxes tells the instances that no more event will come, lw.mu must not be held
+func (lw *linkedWorker) closeInboxes() {
+	lw.mu.Lock()
+	defer lw.mu.Unlock()
+	for _, inst := range lw.instances {
+		if !inst.inboxClosed {
+			inst.inboxClosed = true
+			close(inst.inbox)
+		}
+	}
+	lw.dispatching = false
+}
+
+// wake lets the dispatcher of lw look at its instances again
+func (lw *linkedWorker) wake() {
+	select {
+	case lw.kick <- struct{}{}:
+	default:
+	}
+}
+
+// finished records that inst is done with an event which it started handling at since
+func (s *GoStage) finished(inst *instance, since time.Time) {
+	if inst.inbox == nil {
+		return
+	}
+	inst.load.Add(-1)
+	inst.finished.Add(1)
+	last := int64(s.clock.Now().Sub(since))
+	if avg := inst.latency.Load(); avg != 0 {
+		last = (avg*(8-latencyWeight) + last*latencyWeight) / 8
+	}
+	inst.latency.Store(last)
+	inst.lw.wake()
+}
+
+// tryTake returns the event queued in the inbox of inst, if any
+func (s *GoStage) tryTake(inst *instance) (*envelope, bool) {
+	if inst.inbox == nil {
+		return nil, false
+	}
+	select {
+	case env, ok := <-inst.inbox:
+		return env, ok
+	default:
+		return nil, false
+	}
+}
+
+// drainInbox is receive for a halted instance of a dispatched stage, it takes the event
+// queued for it and, if it drains a stopped upstream, the ones the dispatcher still gives it
+func (s *GoStage) drainInbox(inst *instance) (env *envelope, stopped, parked bool) {
+	if env, ok := s.tryTake(inst); ok {
+		return env, false, false
+	}
+	if !inst.accepting(s.stopModeOf(inst.lw) == StopDrain) {
+		return nil, true, false
+	}
+	select {
+	case env, ok := <-inst.inbox:
+		if ok {
+			return env, false, false
+		}
+	case <-inst.abort:
+	}
+	return nil, true, false
+}
+
+// strand counts the events left in the inbox of an exiting instance, lw.mu must be held
+func (s *GoStage) strand(inst *instance) {
+	if inst.inbox == nil {
+		return
+	}
+	for {
+		select {
+		case env, ok := <-inst.inbox:
+			if !ok {
+				return
+			}
+			inst.lw.stats.unqueued.Add(1)
+			inst.lw.bytes.release(env.size)
+			s.leave(lostInChannel)
+			if inst.lw.BestEffort {
+				s.droppedBestEffort.Add(1)
+			}
+			env.free()
+		default:
+			return
+		}
+	}
+}
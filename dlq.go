@@ -0,0 +1,237 @@
+package gostage
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// ErrCorruptDeadLetter if a record of a dead letter file can't be read back
+var ErrCorruptDeadLetter = errors.New("corrupt dead letter")
+
+// maxDeadLetterSize bounds the records read back, a larger length is a corrupt header
+const maxDeadLetterSize = 64 << 20
+
+// DeadLetterEvent is an event which failed in a stage, written by WithDeadLetterFile
+type DeadLetterEvent struct {
+	// the stage's name, prefixed by the pipeline's name and a slash if it has one
+	Stage    string
+	Instance int
+	// the error returned by HandleEvent, as text
+	Err   string
+	Class ErrorClass
+	// the ID of the event, see EventIDFromContext
+	EventID string
+	// when the event failed
+	Time time.Time
+	// the number of times the event had been replayed by ReplayDLQ before it failed
+	Replays int
+	// the payload was too large to be kept, Payload is a string describing it,
+	// see Config.MaxPayloadBytes
+	Truncated bool
+	Payload   interface{}
+}
+
+// deadLetter is how a DeadLetterEvent is stored, the payload is encoded by the pipeline's codec
+type deadLetter struct {
+	Stage     string
+	Instance  int
+	Err       string
+	Class     ErrorClass
+	EventID   string
+	Time      time.Time
+	Replays   int
+	Truncated bool
+	Payload   []byte
+}
+
+// WithDeadLetterFile appends the events a stage gave up on to the file at path: the events
+// whose HandleEvent failed once retries were exhausted or whose error is permanent, and the
+// poison events, the payloads are encoded by the codec set with WithCodec, default is GobCodec
+// the file is kept across runs and restarts, see ReplayDLQ
+func WithDeadLetterFile(path string) Option {
+	return func(gs *GoStage) {
+		gs.deadLetterPath = path
+	}
+}
+
+// deadLetterFile writes the dead letters of a run, a record is a frame of its length,
+// its CRC-32 and its gob encoding so that a corrupt one can be skipped
+type deadLetterFile struct {
+	mu    sync.Mutex
+	codec Codec
+	file  *os.File
+}
+
+// openDeadLetters opens the dead letter file of a run, s.mu must be held
+func (s *GoStage) openDeadLetters() error {
+	if s.deadLetterPath == "" {
+		return nil
+	}
+	f, err := os.OpenFile(s.deadLetterPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("dead letters: %w", err)
+	}
+	codec := s.codec
+	if codec == nil {
+		codec = GobCodec{}
+	}
+	s.deadLetters = &deadLetterFile{codec: codec, file: f}
+	return nil
+}
+
+// closeDeadLetters closes the dead letter file, once all workers have stopped
+func (s *GoStage) closeDeadLetters() {
+	d := s.deadLetters
+	if d == nil {
+		return
+	}
+	s.deadLetters = nil
+	if err := d.file.Close(); err != nil {
+		s.logger.Error("dead letters: %v", err)
+	}
+}
+
+// deadLetter appends env which failed in instance n of lw with err
+// a record is written at once so that it survives a crash of the process
+func (s *GoStage) deadLetter(lw *linkedWorker, n int, env *envelope, err error, class ErrorClass) {
+	s.writeDeadLetter(lw, n, env, env.payload, false, err, class)
+}
+
+// writeDeadLetter appends env to the dead letter file with payload in place of its own
+func (s *GoStage) writeDeadLetter(lw *linkedWorker, n int, env *envelope, payload interface{}, truncated bool, err error, class ErrorClass) {
+	d := s.deadLetters
+	if d == nil {
+		return
+	}
+	data, cerr := d.codec.Encode(payload)
+	if cerr != nil {
+		s.logger.Error("%s dead letters: %v", lw.Name, cerr)
+		return
+	}
+	rec := deadLetter{
+		Stage:     s.qualify(lw.Name),
+		Instance:  n,
+		Err:       err.Error(),
+		Class:     class,
+		EventID:   s.eventName(s.eventID(env)),
+		Time:      s.clock.Now(),
+		Replays:   env.replays,
+		Truncated: truncated,
+		Payload:   data,
+	}
+	var body bytes.Buffer
+	if cerr := gob.NewEncoder(&body).Encode(rec); cerr != nil {
+		s.logger.Error("%s dead letters: %v", lw.Name, cerr)
+		return
+	}
+	frame := make([]byte, 8, 8+body.Len())
+	binary.BigEndian.PutUint32(frame, uint32(body.Len()))
+	binary.BigEndian.PutUint32(frame[4:], crc32.ChecksumIEEE(body.Bytes()))
+	frame = append(frame, body.Bytes()...)
+
+	d.mu.Lock()
+	_, werr := d.file.Write(frame)
+	d.mu.Unlock()
+	if werr != nil {
+		s.logger.Error("%s dead letters: %v", lw.Name, werr)
+	}
+}
+
+// ReplayDLQ injects the events of the dead letter file at path selected by filter into the
+// stage of into with the given name, or the stage after its producers if it's empty,
+// into must be running and use the codec the file was written with
+// the events are marked as replayed: if they fail again they're written with one more
+// Replays, a nil filter selects the events which were never replayed so that they don't
+// loop forever and weren't truncated, the corrupt records are skipped and logged by into
+func ReplayDLQ(path string, into *GoStage, stage string, filter func(DeadLetterEvent) bool) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if filter == nil {
+		filter = func(ev DeadLetterEvent) bool { return ev.Replays == 0 && !ev.Truncated }
+	}
+	codec := into.codec
+	if codec == nil {
+		codec = GobCodec{}
+	}
+
+	r := bufio.NewReader(f)
+	for offset := int64(0); ; {
+		ev, size, err := readDeadLetter(r, codec)
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if errors.Is(err, ErrCorruptDeadLetter) && size > 0 {
+			into.logger.Error("dead letters %s: skipped the record at %d: %v", path, offset, err)
+			offset += size
+			continue
+		}
+		if err != nil {
+			// the frames can't be told apart anymore
+			into.logger.Error("dead letters %s: stopped at %d: %v", path, offset, err)
+			return nil
+		}
+		offset += size
+		if !filter(ev) {
+			continue
+		}
+		if err := into.enqueue(context.Background(), stage, ev.Payload, ev.Replays+1); err != nil {
+			return err
+		}
+	}
+}
+
+// readDeadLetter reads the next record and returns its size, a corrupt record
+// whose frame could be read has a size so that it can be skipped
+func readDeadLetter(r io.Reader, codec Codec) (DeadLetterEvent, int64, error) {
+	var header [8]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			return DeadLetterEvent{}, 0, fmt.Errorf("%w: truncated header", ErrCorruptDeadLetter)
+		}
+		return DeadLetterEvent{}, 0, err
+	}
+	n := binary.BigEndian.Uint32(header[:])
+	if n > maxDeadLetterSize {
+		return DeadLetterEvent{}, 0, fmt.Errorf("%w: length %d", ErrCorruptDeadLetter, n)
+	}
+	body := make([]byte, n)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return DeadLetterEvent{}, 0, fmt.Errorf("%w: truncated record", ErrCorruptDeadLetter)
+	}
+	size := int64(len(header)) + int64(n)
+	if crc32.ChecksumIEEE(body) != binary.BigEndian.Uint32(header[4:]) {
+		return DeadLetterEvent{}, size, fmt.Errorf("%w: checksum mismatch", ErrCorruptDeadLetter)
+	}
+	var rec deadLetter
+	if err := gob.NewDecoder(bytes.NewReader(body)).Decode(&rec); err != nil {
+		return DeadLetterEvent{}, size, fmt.Errorf("%w: %w", ErrCorruptDeadLetter, err)
+	}
+	v, err := codec.Decode(rec.Payload)
+	if err != nil {
+		return DeadLetterEvent{}, size, fmt.Errorf("%w: %w", ErrCorruptDeadLetter, err)
+	}
+	return DeadLetterEvent{
+		Stage:     rec.Stage,
+		Instance:  rec.Instance,
+		Err:       rec.Err,
+		Class:     rec.Class,
+		EventID:   rec.EventID,
+		Time:      rec.Time,
+		Replays:   rec.Replays,
+		Truncated: rec.Truncated,
+		Payload:   v,
+	}, size, nil
+}
@@ -0,0 +1,173 @@
+package gostage
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrDropped if an event was discarded because the downstream buffer was full
+var ErrDropped = errors.New("event dropped")
+
+// ErrInvalidOverflow if a stage sets DropOldest without a buffer to evict from
+var ErrInvalidOverflow = errors.New("invalid overflow policy")
+
+// DefaultNoConsumerTimeout is how long a send waits for a stage stopped by StopStage to be started again
+const DefaultNoConsumerTimeout = time.Minute
+
+// WithNoConsumerTimeout sets how long a send blocked on a stage stopped by StopStage
+// waits for StartStage before the pipeline fails with ErrNoConsumer,
+// default is DefaultNoConsumerTimeout, zero or less waits however long it takes
+// a send to a stage which has lost its instances otherwise fails right away
+func WithNoConsumerTimeout(d time.Duration) Option {
+	return func(gs *GoStage) {
+		gs.noConsumerTimeout = d
+	}
+}
+
+// OverflowPolicy decides what happens when a stage's input buffer is full
+type OverflowPolicy int
+
+const (
+	// Block makes the upstream wait until there is room in the buffer
+	Block OverflowPolicy = iota
+	// DropNewest discards the incoming event
+	DropNewest
+	// DropOldest evicts the oldest buffered event to make room for the incoming one,
+	// the stage needs a BufferSize of at least 1
+	DropOldest
+)
+
+// validateOverflow rejects DropOldest on an unbuffered edge, there's nothing to evict
+// and the sender would spin on it
+func (s *GoStage) validateOverflow() error {
+	for _, config := range s.configs {
+		if config.OverflowPolicy == DropOldest && config.Queue == nil && config.BufferSize < 1 {
+			return fmt.Errorf("%w: %s sets DropOldest with BufferSize %d, it needs at least 1", ErrInvalidOverflow, config.Name, config.BufferSize)
+		}
+	}
+	return nil
+}
+
+// send passes env from stage i to the input of the next stage
+// honoring the downstream stage's OverflowPolicy
+// returns false if the instance was aborted while waiting
+func (s *GoStage) send(i int, inst *instance, env *envelope) bool {
+	next := s.linkedWorkers[s.next(i)]
+	out := s.linkedWorkers[i].out
+	env.size = sizeOf(env.payload)
+	if next.oversized(env) {
+		s.rejectOversized(next, env)
+		return true
+	}
+	if c := s.codecOf(next); c != nil && !s.encode(c, i, inst, env) {
+		return true
+	}
+	if next.queue != nil {
+		return s.sendQueued(next, inst, env)
+	}
+
+	switch next.OverflowPolicy {
+	case DropNewest:
+		if !next.bytes.tryAcquire(env.size) {
+			s.drop(next, env)
+			break
+		}
+		next.stats.enqueued.Add(1)
+		select {
+		case out <- env:
+		default:
+			next.stats.enqueued.Add(-1)
+			next.bytes.release(env.size)
+			s.drop(next, env)
+		}
+	case DropOldest:
+		for {
+			if next.bytes.tryAcquire(env.size) {
+				next.stats.enqueued.Add(1)
+				select {
+				case out <- env:
+					return true
+				default:
+					next.stats.enqueued.Add(-1)
+					next.bytes.release(env.size)
+				}
+			}
+			select {
+			case old := <-out:
+				next.stats.unqueued.Add(1)
+				next.bytes.release(old.size)
+				s.drop(next, old)
+			default:
+			}
+		}
+	default:
+		if !next.bytes.acquire(env.size, inst.abort) {
+			s.leave(lostInFlight)
+			env.free()
+			return false
+		}
+		// counted before it's sent so that the depth is never negative, see queueDepth
+		next.stats.enqueued.Add(1)
+		// don't pay for a select unless the buffer is full
+		select {
+		case out <- env:
+			return true
+		default:
+		}
+		gone := next.gone()
+		var restart <-chan time.Time
+		for {
+			select {
+			case out <- env:
+				return true
+			case <-inst.abort:
+			case <-gone:
+				gone = nil
+				// the stages stop one after the other when the pipeline does
+				if s.State() != StateRunning || !next.vacant() {
+					continue
+				}
+				if next.stopped.Load() && s.noConsumerTimeout <= 0 {
+					continue
+				}
+				if next.stopped.Load() {
+					restart = s.clock.After(s.noConsumerTimeout)
+					continue
+				}
+				s.noConsumer(i, next, "it has no instance left")
+			case <-restart:
+				restart = nil
+				if s.State() != StateRunning || !next.vacant() {
+					// started again, watch its new instances
+					gone = next.gone()
+					continue
+				}
+				s.noConsumer(i, next, fmt.Sprintf("it has been stopped for %v", s.noConsumerTimeout))
+			}
+			next.stats.enqueued.Add(-1)
+			next.bytes.release(env.size)
+			s.leave(lostInFlight)
+			env.free()
+			return false
+		}
+	}
+	return true
+}
+
+// noConsumer fails the pipeline with ErrNoConsumer naming the edge from stage i to next
+func (s *GoStage) noConsumer(i int, next *linkedWorker, why string) {
+	err := fmt.Errorf("%w: %s -> %s, %s", ErrNoConsumer, s.linkedWorkers[i].Name, next.Name, why)
+	select {
+	case s.errChan <- err:
+	default:
+	}
+}
+
+func (s *GoStage) drop(lw *linkedWorker, env *envelope) {
+	lw.stats.dropped.Add(1)
+	lw.stats.deadLettered.Add(1)
+	s.reportError(lw, -1, env.payload, ErrDropped)
+	s.leave(deadLettered)
+	env.free()
+}
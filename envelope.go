@@ -0,0 +1,81 @@
+package gostage
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// envelope wraps every event travelling between stages
+// so that the framework can attach metadata without touching the payload
+type envelope struct {
+	payload   interface{}
+	createdAt time.Time
+	// the number of times the event was delivered again after a panic
+	redelivered int
+	// the index of the producer which emitted the event and its sequence number
+	root int
+	seq  uint64
+	// the size of the payload in bytes if it's a Sizer, set when sent
+	size int64
+	// the payload is encoded by the codec of the edge it's travelling
+	encoded bool
+	// the ID passed to ContextWorkers, 0 until one handles the event
+	id uint64
+	// the index the ID is tracked by
+	events *eventIndex
+	// uniform in [0, 1), drawn by the producer if debug sampling is on
+	sample float64
+	drawn  bool
+	// the values attached by WithEventContext, nil if none
+	values context.Context
+	// the partition attached by WithPartition, if partitioned
+	partition   int
+	partitioned bool
+	// the headers of the pipeline which produced the event, see WithMetadata
+	headers map[string]string
+	// the event was put in the middle of the pipeline by Inject
+	injected bool
+	// the number of times the event was replayed by ReplayDLQ
+	replays int
+	// the ID of the event copied by a Broadcast stage and the index of the copy, if it's one
+	parent string
+	copy   int
+}
+
+// envelopes are reused once their events have left the pipeline
+var envelopes = sync.Pool{
+	New: func() interface{} {
+		return &envelope{}
+	},
+}
+
+func (s *GoStage) newEnvelope(payload interface{}) *envelope {
+	env := envelopes.Get().(*envelope)
+	env.payload = payload
+	env.headers = s.headers
+	// reading the clock is costly, only do it if some stage needs the age
+	if s.timestamps {
+		env.createdAt = s.clock.Now()
+	}
+	return env
+}
+
+// setID gives the envelope an ID tracked by events
+func (e *envelope) setID(events *eventIndex, id uint64) {
+	e.id, e.events = id, events
+}
+
+// free returns env to the pool, it must not be used afterwards
+func (e *envelope) free() {
+	if e.events != nil {
+		e.events.forget(e.id)
+	}
+	*e = envelope{}
+	envelopes.Put(e)
+}
+
+// expired reports whether the envelope has waited longer than maxAge
+func (e *envelope) expired(clock Clock, maxAge time.Duration) bool {
+	return maxAge > 0 && clock.Now().Sub(e.createdAt) > maxAge
+}
@@ -0,0 +1,130 @@
+package gostage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime/debug"
+)
+
+// ErrExpired if an event waited longer than MaxEventAge before reaching a stage
+var ErrExpired = errors.New("event expired")
+
+// ErrPayloadTooLarge if a Sizer event was bigger than the MaxPayloadBytes of a stage
+var ErrPayloadTooLarge = errors.New("payload too large")
+
+// ErrPoisonEvent if an event kept crashing the worker after Config.Redeliver redeliveries
+var ErrPoisonEvent = errors.New("poison event")
+
+// StageError describes an error happened while a stage handled an event
+type StageError struct {
+	// the stage's name, prefixed by the pipeline's name and a slash if it has one
+	Stage string
+	// the index of the worker instance in the stage
+	// -1 if the event never reached an instance
+	Instance int
+	// the event the stage was handling
+	Input interface{}
+	Err   error
+	// the class of Err, errors returned by HandleEvent are never Unclassified,
+	// Config.Unclassified decided their class
+	Class ErrorClass
+}
+
+func (e *StageError) Error() string {
+	return fmt.Sprintf("%s_#%d: %v", e.Stage, e.Instance, e.Err)
+}
+
+// Unwrap returns the underlying error
+func (e *StageError) Unwrap() error {
+	return e.Err
+}
+
+// PanicError is a panic recovered from HandleEvent by the Recover PanicPolicy
+type PanicError struct {
+	Value interface{}
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("panic: %v", e.Value)
+}
+
+// PanicPolicy decides what happens when HandleEvent panics
+type PanicPolicy int
+
+const (
+	// Restart lets the panic crash the instance, the supervisor restarts it
+	Restart PanicPolicy = iota
+	// Recover turns the panic into a *PanicError and keeps the instance running
+	Recover
+)
+
+// callHandleEvent calls the HandleEvent of an instance honoring the stage's PanicPolicy
+// id is the ID of the event if the instance is a ContextWorker
+// values are the ones attached to the event by WithEventContext, nil if none
+func (s *GoStage) callHandleEvent(inst *instance, id uint64, values context.Context, headers map[string]string, in interface{}) (out interface{}, err error) {
+	lw := inst.lw
+	lw.stats.busy.Add(1)
+	defer lw.stats.busy.Add(-1)
+	if s.timing {
+		defer s.time(lw, s.clock.Now(), &err)
+	}
+	if lw.PanicPolicy == Recover {
+		defer func() {
+			if v := recover(); v != nil {
+				out, err = nil, &PanicError{Value: v, Stack: debug.Stack()}
+			}
+		}()
+	}
+	if inst.cw != nil {
+		var key interface{} = id
+		if s.idGen != nil {
+			key = s.events.name(id)
+		}
+		ctx := context.WithValue(inst.ctx, eventIDKey, key)
+		if headers != nil {
+			ctx = context.WithValue(ctx, headersKey, headers)
+		}
+		if values != nil {
+			ctx = &valuesContext{Context: ctx, values: values}
+		}
+		ctx, cancel := context.WithCancel(ctx)
+		s.events.handling(id, cancel)
+		defer func() {
+			s.events.handled(id)
+			cancel()
+		}()
+		return inst.cw.HandleEventContext(ctx, in)
+	}
+	if inst.sw != nil {
+		return inst.sw.HandleEventSide(in, inst.emitSide)
+	}
+	return inst.w.HandleEvent(in)
+}
+
+// WithOnError registers a callback that receives every StageError
+func WithOnError(fn func(*StageError)) Option {
+	return func(gs *GoStage) {
+		gs.onError = fn
+	}
+}
+
+func (s *GoStage) reportError(lw *linkedWorker, n int, input interface{}, err error) {
+	s.reportClassified(lw, n, input, err, Classify(err))
+}
+
+func (s *GoStage) reportClassified(lw *linkedWorker, n int, input interface{}, err error, class ErrorClass) {
+	if s.onError == nil && !s.errorSubs.active.Load() {
+		return
+	}
+	se := StageError{Stage: s.qualify(lw.Name), Instance: n, Input: input, Err: err, Class: class}
+	if s.errorSubs.active.Load() {
+		s.errorSubs.publish(lw.Name, se)
+	}
+	if s.onError != nil {
+		s.protect("OnError", func() {
+			s.onError(&se)
+		})
+	}
+}
@@ -0,0 +1,66 @@
+package examples
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/qgymje/gostage"
+)
+
+type stopRecorder struct {
+	gostage.BaseObserver
+	stopped chan gostage.Accounting
+}
+
+func (r *stopRecorder) OnPipelineStopped(a gostage.Accounting) {
+	r.stopped <- a
+}
+
+func Test_AccountingAfterAbruptStop(t *testing.T) {
+	next := 0
+	producer := gostage.WorkHandler(func(_ interface{}) (interface{}, error) {
+		next++
+		return next, nil
+	})
+	gate := make(chan struct{})
+	consumer := gostage.WorkHandler(func(in interface{}) (interface{}, error) {
+		<-gate
+		return nil, nil
+	})
+	configs := []*gostage.Config{
+		{Name: "producer", Worker: producer},
+		{Name: "consumer", Worker: consumer, SubscribeTo: producer, BufferSize: 5},
+	}
+
+	recorder := &stopRecorder{stopped: make(chan gostage.Accounting, 1)}
+	logger := &recordingLogger{}
+	gs := gostage.New(context.Background(), configs, logger, gostage.WithObserver(recorder))
+	done := make(chan struct{})
+	if err := gs.RunAsync(func() { close(done) }); err != nil {
+		t.Fatal(err)
+	}
+	// one event in the consumer, five in the buffer and one blocked in the producer
+	waitFor(t, "the buffer to be full", func() bool {
+		return gs.Stats().Stages[0].Processed == 7
+	})
+
+	gs.Stop()
+	waitFor(t, "the pipeline to be stopping", func() bool {
+		return gs.State() == gostage.StateStopping
+	})
+	time.Sleep(20 * time.Millisecond)
+	close(gate)
+	<-done
+
+	want := gostage.Accounting{Produced: 7, Completed: 1, DroppedInChannel: 5, DroppedInFlight: 1}
+	if got := <-recorder.stopped; got != want {
+		t.Fatalf("observer got %+v, want %+v", got, want)
+	}
+	if got := gs.Stats().Accounting; got != want {
+		t.Fatalf("Stats got %+v, want %+v", got, want)
+	}
+	if len(logger.find("[Info]gostage stopped")) != 1 {
+		t.Fatal("missing the summary log line")
+	}
+}
@@ -0,0 +1,72 @@
+package examples
+
+import (
+	"context"
+	"testing"
+
+	"github.com/qgymje/gostage"
+)
+
+// forwardAllocs returns the allocations of an event going from the producer to the terminal stage
+func forwardAllocs(t *testing.T, opts ...gostage.Option) float64 {
+	feed, done := make(chan int), make(chan struct{})
+	producer := gostage.WorkHandler(func(interface{}) (interface{}, error) {
+		v, ok := <-feed
+		if !ok {
+			return nil, gostage.ErrQuit
+		}
+		return v, nil
+	})
+	sink := gostage.WorkHandler(func(in interface{}) (interface{}, error) {
+		done <- struct{}{}
+		return in, nil
+	})
+	configs := []*gostage.Config{
+		{Name: "producer", Worker: producer},
+		{Name: "middle", Worker: passThrough{}, SubscribeToName: "producer"},
+		{Name: "sink", Worker: sink, SubscribeToName: "middle"},
+	}
+	gs := gostage.New(context.Background(), configs, &recordingLogger{}, opts...)
+	stopped := make(chan struct{})
+	if err := gs.RunAsync(func() { close(stopped) }); err != nil {
+		t.Fatal(err)
+	}
+	allocs := testing.AllocsPerRun(1000, func() {
+		feed <- 1
+		<-done
+	})
+	close(feed)
+	<-stopped
+	return allocs
+}
+
+func Test_ForwardPathAllocs(t *testing.T) {
+	if testing.Short() {
+		t.Skip("counts allocations")
+	}
+	for name, opts := range map[string][]gostage.Option{
+		"default":  nil,
+		"observer": {gostage.WithObserver(gostage.BaseObserver{})},
+		"timing":   {gostage.WithTiming()},
+	} {
+		if allocs := forwardAllocs(t, opts...); allocs != 0 {
+			t.Errorf("%s: %v allocations per event, want 0", name, allocs)
+		}
+	}
+}
+
+// Test_BenchmarkAllocs fails if the benchmarks of the forward path start allocating per event
+func Test_BenchmarkAllocs(t *testing.T) {
+	if testing.Short() {
+		t.Skip("runs benchmarks")
+	}
+	for name, bench := range map[string]func(*testing.B){
+		"Linear3Stage":      BenchmarkLinear3Stage,
+		"Linear3StageBatch": BenchmarkLinear3StageBatch,
+		"FanOut":            BenchmarkFanOut,
+	} {
+		if r := testing.Benchmark(bench); r.AllocsPerOp() != 0 {
+			t.Errorf("%s: %d allocations per event, want 0", name, r.AllocsPerOp())
+		}
+	}
+}
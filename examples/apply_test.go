@@ -0,0 +1,77 @@
+package examples
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/qgymje/gostage"
+)
+
+func Test_ApplyRateLimit(t *testing.T) {
+	n := 0
+	producer := gostage.WorkHandler(func(_ interface{}) (interface{}, error) {
+		n++
+		return n, nil
+	})
+	var handled atomic.Int64
+	sink := gostage.WorkHandler(func(in interface{}) (interface{}, error) {
+		handled.Add(1)
+		return nil, nil
+	})
+	configs := []*gostage.Config{
+		{Name: "producer", Worker: producer},
+		{Name: "sink", Worker: sink, SubscribeToName: "producer", RateLimit: 1000},
+	}
+	logger := &recordingLogger{}
+	gs := gostage.New(context.Background(), configs, logger)
+	done := make(chan struct{})
+	if err := gs.RunAsync(func() { close(done) }); err != nil {
+		t.Fatal(err)
+	}
+	rate := func(d time.Duration) float64 {
+		from := handled.Load()
+		time.Sleep(d)
+		return float64(handled.Load()-from) / d.Seconds()
+	}
+
+	if r := rate(200 * time.Millisecond); r < 300 || r > 1200 {
+		t.Fatalf("%.0f events per second, limited to 1000", r)
+	}
+	if err := gs.Apply("sink", func(c *gostage.Config) { c.RateLimit = 20 }); err != nil {
+		t.Fatal(err)
+	}
+	if r := rate(500 * time.Millisecond); r > 40 {
+		t.Fatalf("%.0f events per second, limited to 20", r)
+	}
+	if c, err := gs.Config("sink"); err != nil || c.RateLimit != 20 {
+		t.Fatalf("config %+v, %v", c, err)
+	}
+	if len(logger.find("[Info]sink reconfigured: RateLimit 1000 -> 20")) != 1 {
+		t.Fatal("the change wasn't logged")
+	}
+
+	err := gs.Apply("sink", func(c *gostage.Config) {
+		c.RateLimit = 0
+		c.Size = 4
+		c.BufferSize = 16
+	})
+	if !errors.Is(err, gostage.ErrRestartRequired) || !strings.Contains(err.Error(), "Size (use Scale), BufferSize") {
+		t.Fatalf("apply a new Size: %v", err)
+	}
+	if c, _ := gs.Config("sink"); c.RateLimit != 20 || c.Size != 0 {
+		t.Fatalf("rejected changes applied: %+v", c)
+	}
+	if err := gs.Apply("nope", func(*gostage.Config) {}); !errors.Is(err, gostage.ErrUnknownStage) {
+		t.Fatalf("apply to an unknown stage: %v", err)
+	}
+
+	gs.Stop()
+	<-done
+	if err := gs.Apply("sink", func(c *gostage.Config) { c.RateLimit = 0 }); !errors.Is(err, gostage.ErrNotRunning) {
+		t.Fatalf("apply to a stopped pipeline: %v", err)
+	}
+}
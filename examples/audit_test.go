@@ -0,0 +1,68 @@
+package examples
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/qgymje/gostage"
+)
+
+func Test_AuditLog(t *testing.T) {
+	producer := countdown(10, func(n int) interface{} { return n })
+	evens := gostage.WorkHandler(func(in interface{}) (interface{}, error) {
+		if in.(int)%2 == 1 {
+			return nil, gostage.ErrNoData
+		}
+		return in, nil
+	})
+	configs := []*gostage.Config{
+		{Name: "producer", Worker: producer},
+		{Name: "double", Worker: passThrough{}, SubscribeToName: "producer"},
+		{Name: "evens", Worker: evens, SubscribeToName: "double"},
+	}
+
+	var buf bytes.Buffer
+	// the records are only written out if the writer is flushed
+	w := bufio.NewWriter(&buf)
+	gs := gostage.New(context.Background(), configs, &recordingLogger{}, gostage.WithAuditLog(w, nil))
+	if err := gs.Run(func() {}); err != nil {
+		t.Fatal(err)
+	}
+
+	stages := make(map[string]int)
+	outcomes := make(map[gostage.AuditOutcome]int)
+	journeys := make(map[string][]string)
+	scanner := bufio.NewScanner(&buf)
+	for scanner.Scan() {
+		var r gostage.AuditRecord
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			t.Fatalf("%v: %s", err, scanner.Text())
+		}
+		if r.EventID == "" || r.EventID == "0" || r.Time.IsZero() || r.Duration < 0 || r.Instance != 0 {
+			t.Fatalf("malformed record %+v", r)
+		}
+		stages[r.Stage]++
+		outcomes[r.Outcome]++
+		journeys[r.EventID] = append(journeys[r.EventID], r.Stage)
+	}
+	if stages["producer"] != 10 || stages["double"] != 10 || stages["evens"] != 10 {
+		t.Fatalf("records per stage %v, want 10 each", stages)
+	}
+	if outcomes[gostage.AuditOK] != 25 || outcomes[gostage.AuditFiltered] != 5 {
+		t.Fatalf("outcomes %v", outcomes)
+	}
+	if len(journeys) != 10 {
+		t.Fatalf("%d event IDs, want 10", len(journeys))
+	}
+	for id, journey := range journeys {
+		if len(journey) != 3 {
+			t.Fatalf("event %s went through %v", id, journey)
+		}
+	}
+	if dropped := gs.Stats().DroppedAuditRecords; dropped != 0 {
+		t.Fatalf("%d records dropped", dropped)
+	}
+}
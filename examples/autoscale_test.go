@@ -0,0 +1,190 @@
+package examples
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/qgymje/gostage"
+)
+
+// fakeClock only moves when Advance is called
+type fakeClock struct {
+	mu      sync.Mutex
+	now     time.Time
+	waiters []fakeWaiter
+}
+
+type fakeWaiter struct {
+	at time.Time
+	c  chan time.Time
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Unix(0, 0)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	w := fakeWaiter{at: c.now.Add(d), c: make(chan time.Time, 1)}
+	c.waiters = append(c.waiters, w)
+	return w.c
+}
+
+// Advance moves the clock and fires the waiters which are due
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+	waiting := c.waiters[:0]
+	for _, w := range c.waiters {
+		if w.at.After(c.now) {
+			waiting = append(waiting, w)
+			continue
+		}
+		w.c <- c.now
+	}
+	c.waiters = waiting
+}
+
+// pending tells whether a waiter fires at at
+func (c *fakeClock) pending(at time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, w := range c.waiters {
+		if w.at.Equal(at) {
+			return true
+		}
+	}
+	return false
+}
+
+// gated blocks every event until its gate is closed
+type gated struct {
+	gate chan struct{}
+}
+
+func (g gated) Create() gostage.Worker {
+	return g
+}
+
+func (g gated) HandleEvent(in interface{}) (interface{}, error) {
+	<-g.gate
+	return in, nil
+}
+
+func Test_AutoScale(t *testing.T) {
+	var left int64 = 20
+	producer := gostage.WorkHandler(func(_ interface{}) (interface{}, error) {
+		if atomic.AddInt64(&left, -1) < 0 {
+			return nil, gostage.ErrNoData
+		}
+		return 1, nil
+	})
+	consumer := gated{gate: make(chan struct{})}
+	interval := time.Second
+	configs := []*gostage.Config{
+		{Name: "producer", Worker: producer},
+		{Name: "consumer", Worker: consumer, SubscribeToName: "producer", BufferSize: 32,
+			AutoScale: &gostage.AutoScale{Min: 1, Max: 3, TargetQueueLength: 4, Interval: interval}},
+	}
+
+	clock := newFakeClock()
+	logger := &recordingLogger{}
+	gs := gostage.New(context.Background(), configs, logger,
+		gostage.WithClock(clock), gostage.WithNoDataCountSleep(time.Millisecond))
+	done := make(chan struct{})
+	if err := gs.RunAsync(func() { close(done) }); err != nil {
+		t.Fatal(err)
+	}
+	concurrency := func() int { return gs.Stats().Stages[1].Concurrency }
+	tick := func() {
+		// the idle producer sleeps on the clock too
+		waitFor(t, "the autoscaler to sample", func() bool { return clock.pending(clock.Now().Add(interval)) })
+		clock.Advance(interval)
+	}
+
+	// the burst fills the buffer while the first instance is blocked
+	waitFor(t, "the burst", func() bool { return atomic.LoadInt64(&left) < 0 })
+	for concurrency() < 3 {
+		tick()
+	}
+	// a sample doesn't scale beyond Max
+	for k := 0; k < 5; k++ {
+		tick()
+	}
+	if got := concurrency(); got != 3 {
+		t.Fatalf("concurrency %d after the burst, want 3", got)
+	}
+
+	// idle, the stage is scaled back down one instance at a time
+	close(consumer.gate)
+	waitFor(t, "the buffer to drain", func() bool { return gs.Stats().Accounting.Completed == 20 })
+	for concurrency() > 1 {
+		tick()
+	}
+	for k := 0; k < 5; k++ {
+		tick()
+	}
+	if got := concurrency(); got != 1 {
+		t.Fatalf("concurrency %d when idle, want 1", got)
+	}
+
+	if err := gs.Stop(); err != nil {
+		t.Fatal(err)
+	}
+	<-done
+	if up := logger.find("[Info]consumer autoscaled from 1 to 2"); len(up) != 1 {
+		t.Fatalf("scale up logs: %v", up)
+	}
+	if down := logger.find("[Info]consumer autoscaled from 2 to 1"); len(down) != 1 {
+		t.Fatalf("scale down logs: %v", down)
+	}
+	if a := gs.Stats().Accounting; a.Completed != 20 || a.DroppedInFlight != 0 {
+		t.Fatalf("accounting %+v", a)
+	}
+}
+
+func Test_Scale(t *testing.T) {
+	producer := idleProducer()
+	configs := []*gostage.Config{
+		{Name: "producer", Worker: producer},
+		{Name: "consumer", Worker: passThrough{}, SubscribeToName: "producer"},
+		{Name: "single", Worker: gostage.WorkHandler(func(in interface{}) (interface{}, error) { return in, nil }), SubscribeToName: "consumer"},
+	}
+	gs := gostage.New(context.Background(), configs, &recordingLogger{}, gostage.WithNoDataCountSleep(time.Millisecond))
+	if err := gs.Scale("consumer", 2); err == nil {
+		t.Fatal("scaled an idle pipeline")
+	}
+	done := make(chan struct{})
+	if err := gs.RunAsync(func() { close(done) }); err != nil {
+		t.Fatal(err)
+	}
+	for _, n := range []int{4, 2, 5} {
+		if err := gs.Scale("consumer", n); err != nil {
+			t.Fatal(err)
+		}
+		if got := gs.Stats().Stages[1].Concurrency; got != n {
+			t.Fatalf("concurrency %d, want %d", got, n)
+		}
+	}
+	if err := gs.Scale("consumer", 0); err == nil {
+		t.Fatal("scaled to 0 instances")
+	}
+	if err := gs.Scale("single", 2); err == nil {
+		t.Fatal("scaled a worker without Create")
+	}
+	if err := gs.Stop(); err != nil {
+		t.Fatal(err)
+	}
+	<-done
+}
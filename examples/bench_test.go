@@ -0,0 +1,109 @@
+package examples
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/qgymje/gostage"
+)
+
+// benchProducer emits n events then quits, it can be created for Size > 1
+type benchProducer struct {
+	left *int64
+}
+
+func (p benchProducer) Create() gostage.Worker {
+	return p
+}
+
+func (p benchProducer) HandleEvent(interface{}) (interface{}, error) {
+	if atomic.AddInt64(p.left, -1) < 0 {
+		return nil, gostage.ErrQuit
+	}
+	return 1, nil
+}
+
+// benchPipeline runs n events through a producer and the given stages
+func benchPipeline(b *testing.B, producerSize int, stages ...*gostage.Config) {
+	benchPipelineWith(b, producerSize, nil, stages...)
+}
+
+func benchPipelineWith(b *testing.B, producerSize int, opts []gostage.Option, stages ...*gostage.Config) {
+	left := int64(b.N)
+	producer := benchProducer{left: &left}
+	configs := []*gostage.Config{{Name: "producer", Worker: producer, Size: producerSize}}
+	for k, c := range stages {
+		if k == 0 {
+			c.SubscribeToName = "producer"
+		} else {
+			c.SubscribeToName = stages[k-1].Name
+		}
+		configs = append(configs, c)
+	}
+
+	gs := gostage.New(context.Background(), configs, &recordingLogger{}, opts...)
+	b.ReportAllocs()
+	b.ResetTimer()
+	if err := gs.Run(func() {}); err != nil {
+		b.Fatal(err)
+	}
+	b.StopTimer()
+	if completed := gs.Stats().Accounting.Completed; completed != int64(b.N) {
+		b.Fatalf("completed %d events, want %d", completed, b.N)
+	}
+}
+
+func BenchmarkLinear3Stage(b *testing.B) {
+	benchPipeline(b, 1,
+		&gostage.Config{Name: "middle", Worker: passThrough{}, BufferSize: 64},
+		&gostage.Config{Name: "sink", Worker: passThrough{}, BufferSize: 64},
+	)
+}
+
+// the events fan out to the 4 instances of the middle stage, which share its input
+func BenchmarkFanOut(b *testing.B) {
+	benchPipeline(b, 1,
+		&gostage.Config{Name: "middle", Worker: passThrough{}, BufferSize: 64, Size: 4},
+		&gostage.Config{Name: "sink", Worker: passThrough{}, BufferSize: 64},
+	)
+}
+
+func BenchmarkSize8(b *testing.B) {
+	benchPipeline(b, 8,
+		&gostage.Config{Name: "middle", Worker: passThrough{}, BufferSize: 64, Size: 8},
+		&gostage.Config{Name: "sink", Worker: passThrough{}, BufferSize: 64, Size: 8},
+	)
+}
+
+func BenchmarkLinear3StageBatch(b *testing.B) {
+	benchPipelineWith(b, 1, []gostage.Option{gostage.WithReceiveBatch(16)},
+		&gostage.Config{Name: "middle", Worker: passThrough{}, BufferSize: 64},
+		&gostage.Config{Name: "sink", Worker: passThrough{}, BufferSize: 64},
+	)
+}
+
+func BenchmarkFanOutPool(b *testing.B) {
+	benchPipeline(b, 1,
+		&gostage.Config{Name: "middle", Worker: passThrough{}, BufferSize: 64, Size: 4, Pool: true},
+		&gostage.Config{Name: "sink", Worker: passThrough{}, BufferSize: 64},
+	)
+}
+
+// the cost of a Queue against the plain channels of BenchmarkLinear3Stage
+func BenchmarkLinear3StageQueue(b *testing.B) {
+	benchPipeline(b, 1,
+		&gostage.Config{Name: "middle", Worker: passThrough{}, BufferSize: 64, Queue: gostage.ChannelQueue},
+		&gostage.Config{Name: "sink", Worker: passThrough{}, BufferSize: 64, Queue: gostage.ChannelQueue},
+	)
+}
+
+// the cost of WithQueueSampling against BenchmarkLinear3Stage, the counters
+// the depths come from are kept either way, the sampler only reads them
+func BenchmarkLinear3StageSampled(b *testing.B) {
+	benchPipelineWith(b, 1, []gostage.Option{gostage.WithQueueSampling(time.Millisecond)},
+		&gostage.Config{Name: "middle", Worker: passThrough{}, BufferSize: 64},
+		&gostage.Config{Name: "sink", Worker: passThrough{}, BufferSize: 64},
+	)
+}
@@ -0,0 +1,67 @@
+package examples
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/qgymje/gostage"
+)
+
+func Test_BestEffort(t *testing.T) {
+	left := 20
+	producer := gostage.WorkHandler(func(_ interface{}) (interface{}, error) {
+		if left == 0 {
+			return nil, gostage.ErrNoData
+		}
+		left--
+		return left, nil
+	})
+	// billing still has a backlog when the pipeline is stopped, it's drained
+	billing := gostage.WorkHandler(func(in interface{}) (interface{}, error) {
+		time.Sleep(2 * time.Millisecond)
+		return in, nil
+	})
+	// draining analytics would take 200ms
+	analytics := gostage.WorkHandler(func(in interface{}) (interface{}, error) {
+		time.Sleep(10 * time.Millisecond)
+		return nil, nil
+	})
+	configs := []*gostage.Config{
+		{Name: "producer", Worker: producer},
+		{Name: "billing", Worker: billing, SubscribeToName: "producer", BufferSize: 32},
+		{Name: "analytics", Worker: analytics, SubscribeToName: "billing", BufferSize: 32, BestEffort: true},
+	}
+
+	logger := &recordingLogger{}
+	gs := gostage.New(context.Background(), configs, logger,
+		gostage.WithStopMode(gostage.StopDrain), gostage.WithNoDataCountSleep(time.Millisecond))
+	done := make(chan struct{})
+	if err := gs.RunAsync(func() { close(done) }); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "the events", func() bool { return gs.Stats().Stages[0].Processed == 20 })
+	if err := gs.Stop(); err != nil {
+		t.Fatal(err)
+	}
+	<-done
+
+	stats := gs.Stats()
+	if got := stats.Stages[1].Processed; got != 20 {
+		t.Fatalf("billing handled %d events, want 20", got)
+	}
+	handled := stats.Stages[2].Processed
+	if handled == 20 {
+		t.Fatal("analytics was drained")
+	}
+	a := stats.Accounting
+	if a.DroppedBestEffort != 20-handled || a.DroppedCritical() != 0 {
+		t.Fatalf("analytics handled %d events, accounting %+v", handled, a)
+	}
+	if a.Accounted() != a.Produced {
+		t.Fatalf("accounting %+v", a)
+	}
+	if len(logger.find("[Info]gostage stopped", "dropped 0 critical")) != 1 {
+		t.Fatal("no shutdown summary")
+	}
+}
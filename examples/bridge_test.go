@@ -0,0 +1,125 @@
+package examples
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"testing"
+
+	"github.com/qgymje/gostage"
+)
+
+func bridged() ([]*gostage.Config, *gostage.GoStage) {
+	producer := countdown(20, func(n int) interface{} { return n })
+	double := gostage.WorkHandler(func(in interface{}) (interface{}, error) {
+		return in.(int) * 2, nil
+	})
+	sink := gostage.WorkHandler(func(in interface{}) (interface{}, error) {
+		return in, nil
+	})
+	configs := []*gostage.Config{
+		{Name: "producer", Worker: producer},
+		{Name: "double", Worker: double, SubscribeToName: "producer"},
+		{Name: "sink", Worker: sink, SubscribeToName: "double", BufferSize: 4},
+	}
+	return configs, gostage.New(context.Background(), configs, &recordingLogger{})
+}
+
+func Test_EdgeReplaceWithChannels(t *testing.T) {
+	_, gs := bridged()
+	edge, err := gs.Edge("double", "sink")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if edge.Cap() != 4 {
+		t.Errorf("cap %d, want the BufferSize of sink", edge.Cap())
+	}
+	in, out := make(chan interface{}, 2), make(chan interface{}, 3)
+	if err := edge.ReplaceWithChannels(in, out); err != nil {
+		t.Fatal(err)
+	}
+	if edge.Cap() != 5 {
+		t.Errorf("cap %d, want the capacity of the channels", edge.Cap())
+	}
+	// the code of our own between the stages
+	go func() {
+		defer close(out)
+		for v := range in {
+			out <- v.(int) + 1000
+		}
+	}()
+	taps, cancel := edge.Tap(32)
+	defer cancel()
+
+	results, err := gs.Collect(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []int
+	for _, v := range results {
+		got = append(got, v.(int))
+	}
+	sort.Ints(got)
+	if len(got) != 20 || got[0] != 1002 || got[19] != 1040 {
+		t.Errorf("got %v, want 1002 to 1040 by 2", got)
+	}
+	if len(taps) != 20 {
+		t.Errorf("tapped %d events sent into the edge, want 20", len(taps))
+	}
+	if a := gs.Stats().Accounting; a.Produced != 20 || a.Completed != 20 {
+		t.Errorf("accounting %+v", a)
+	}
+	if edge.Len() != 0 {
+		t.Errorf("%d events left in the edge", edge.Len())
+	}
+
+	// the next run has a plain edge
+	results, err = gs.Collect(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 0 {
+		// countdown has quit for good
+		t.Errorf("got %v", results)
+	}
+}
+
+func Test_EdgeMisuse(t *testing.T) {
+	_, gs := bridged()
+	if _, err := gs.Edge("producer", "sink"); !errors.Is(err, gostage.ErrInvalidEdge) {
+		t.Errorf("edge between stages not linked: %v", err)
+	}
+	if _, err := gs.Edge("producer", "nope"); !errors.Is(err, gostage.ErrUnknownStage) {
+		t.Errorf("edge to an unknown stage: %v", err)
+	}
+	edge, err := gs.Edge("producer", "double")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := edge.ReplaceWithChannels(nil, make(chan interface{})); !errors.Is(err, gostage.ErrInvalidEdge) {
+		t.Errorf("nil channel: %v", err)
+	}
+	c := make(chan interface{}, 1)
+	if err := edge.ReplaceWithChannels(c, c); err != nil {
+		t.Fatal(err)
+	}
+	if err := edge.ReplaceWithChannels(c, c); !errors.Is(err, gostage.ErrInvalidEdge) {
+		t.Errorf("replaced twice: %v", err)
+	}
+
+	done := make(chan struct{})
+	if err := gs.RunAsync(func() { close(done) }); err != nil {
+		t.Fatal(err)
+	}
+	if err := edge.ReplaceWithChannels(make(chan interface{}), make(chan interface{})); !errors.Is(err, gostage.ErrAlreadyRunning) {
+		t.Errorf("replaced while running: %v", err)
+	}
+	<-done
+	// in and out being the same channel, the pipeline closed it
+	if _, ok := <-c; ok {
+		t.Error("the channel of the edge is still open")
+	}
+	if a := gs.Stats().Accounting; a.Produced != 20 || a.Accounted() != 20 {
+		t.Errorf("accounting %+v", a)
+	}
+}
@@ -0,0 +1,101 @@
+package examples
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"sort"
+	"testing"
+
+	"github.com/qgymje/gostage"
+)
+
+func Test_BroadcastNeedsAcknowledgement(t *testing.T) {
+	configs := []*gostage.Config{
+		{Name: "producer", Worker: countdown(3, func(n int) interface{} { return n })},
+		{Name: "refresh", Worker: passThrough{}, SubscribeToName: "producer", Size: 3, ShareInstance: true,
+			Dispatch: gostage.Broadcast},
+		{Name: "sink", Worker: &collecting{}, SubscribeToName: "refresh"},
+	}
+	gs := gostage.New(context.Background(), configs, &recordingLogger{})
+	if err := gs.Run(func() {}); !errors.Is(err, gostage.ErrInvalidDispatch) {
+		t.Fatalf("err %v, want ErrInvalidDispatch", err)
+	}
+
+	configs[2].ExpectBroadcast = true
+	gs = gostage.New(context.Background(), configs, &recordingLogger{})
+	if err := gs.Run(func() {}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_BroadcastCopies(t *testing.T) {
+	sink := &collecting{}
+	configs := []*gostage.Config{
+		{Name: "producer", Worker: countdown(5, func(n int) interface{} { return n })},
+		{Name: "refresh", Worker: passThrough{}, SubscribeToName: "producer", Size: 3, ShareInstance: true,
+			Dispatch: gostage.Broadcast, BufferSize: 2},
+		{Name: "sink", Worker: sink, SubscribeToName: "refresh", ExpectBroadcast: true},
+	}
+	var buf bytes.Buffer
+	w := bufio.NewWriter(&buf)
+	gs := gostage.New(context.Background(), configs, &recordingLogger{}, gostage.WithAuditLog(w, nil))
+	if err := gs.Run(func() {}); err != nil {
+		t.Fatal(err)
+	}
+
+	// every event is handled by the 3 instances, then 3 times by the sink
+	if got := sink.sorted(); len(got) != 15 || got[0] != 1 || got[2] != 1 || got[14] != 5 {
+		t.Errorf("sink got %v", got)
+	}
+	st := gs.Stats().Stages[1]
+	if st.Broadcasts != 5 || st.Copies != 15 {
+		t.Errorf("%d broadcasts, %d copies", st.Broadcasts, st.Copies)
+	}
+	// the copies are counted apart from the produced events
+	if a := gs.Stats().Accounting; a.Produced != 5 || a.Copies != 10 || a.Completed != 15 || a.Accounted() != a.Produced+a.Copies {
+		t.Errorf("accounting %+v", a)
+	}
+
+	// the copies of an event share its ID with their index appended
+	copies := map[string][]string{}
+	instances := map[string]map[int]bool{}
+	scanner := bufio.NewScanner(&buf)
+	for scanner.Scan() {
+		var r gostage.AuditRecord
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			t.Fatalf("%v: %s", err, scanner.Text())
+		}
+		if r.Stage == "producer" {
+			if r.ParentID != "" {
+				t.Errorf("producer record %+v", r)
+			}
+			continue
+		}
+		if r.EventID[:len(r.ParentID)+1] != r.ParentID+"/" {
+			t.Fatalf("record %+v", r)
+		}
+		if r.Stage == "refresh" {
+			copies[r.ParentID] = append(copies[r.ParentID], r.EventID)
+			if instances[r.ParentID] == nil {
+				instances[r.ParentID] = map[int]bool{}
+			}
+			instances[r.ParentID][r.Instance] = true
+		}
+	}
+	if len(copies) != 5 {
+		t.Fatalf("%d broadcast events, want 5", len(copies))
+	}
+	for parent, ids := range copies {
+		sort.Strings(ids)
+		want := []string{parent + "/0", parent + "/1", parent + "/2"}
+		if len(ids) != 3 || ids[0] != want[0] || ids[1] != want[1] || ids[2] != want[2] {
+			t.Errorf("copies of %s: %v", parent, ids)
+		}
+		if len(instances[parent]) != 3 {
+			t.Errorf("copies of %s handled by instances %v", parent, instances[parent])
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package examples
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/qgymje/gostage"
+)
+
+// budgetPipeline runs 100 events through a consumer which fails the events fail returns true for
+func budgetPipeline(consumer gostage.Config, fail func(n int) bool, opts ...gostage.Option) *gostage.GoStage {
+	next := 0
+	producer := gostage.WorkHandler(func(_ interface{}) (interface{}, error) {
+		if next == 100 {
+			return nil, gostage.ErrQuit
+		}
+		next++
+		return next, nil
+	})
+	consumer.Name = "consumer"
+	consumer.SubscribeTo = producer
+	consumer.Worker = gostage.WorkHandler(func(in interface{}) (interface{}, error) {
+		if fail(in.(int)) {
+			return nil, fmt.Errorf("event %d failed", in)
+		}
+		return nil, nil
+	})
+	configs := []*gostage.Config{
+		{Name: "producer", Worker: producer},
+		&consumer,
+	}
+	return gostage.New(context.Background(), configs, &recordingLogger{}, opts...)
+}
+
+func Test_MaxConsecutiveErrors(t *testing.T) {
+	// every third event succeeds, two errors in a row are tolerated
+	gs := budgetPipeline(gostage.Config{MaxConsecutiveErrors: 2}, func(n int) bool { return n%3 != 0 })
+	gs.Run(func() {})
+	if reason := gs.Reason(); !errors.Is(reason, gostage.ErrQuit) {
+		t.Fatalf("reason = %v, want ErrQuit", reason)
+	}
+
+	for _, c := range []struct {
+		name string
+		opts []gostage.Option
+	}{
+		{"default", nil},
+		{"drain", []gostage.Option{gostage.WithStopMode(gostage.StopDrain)}},
+	} {
+		gs = budgetPipeline(gostage.Config{MaxConsecutiveErrors: 5, BufferSize: 100}, func(int) bool { return true }, c.opts...)
+		gs.Run(func() {})
+		reason := gs.Reason()
+		if !errors.Is(reason, gostage.ErrErrorBudgetExceeded) || !strings.Contains(reason.Error(), "consumer failed 6 events in a row") {
+			t.Fatalf("%s: reason = %v, want ErrErrorBudgetExceeded after 6 errors", c.name, reason)
+		}
+		if errs := gs.Stats().Stages[1].Errors; errs != 6 {
+			t.Fatalf("%s: errors = %d, want the stage to stop after the 6th", c.name, errs)
+		}
+		if a := gs.Stats().Accounting; a.Accounted() != a.Produced {
+			t.Fatalf("%s: accounting %+v", c.name, a)
+		}
+	}
+}
+
+func Test_ErrorBudget(t *testing.T) {
+	// half of the events fail, which is within the budget
+	gs := budgetPipeline(gostage.Config{}, func(n int) bool { return n%2 == 0 }, gostage.WithErrorBudget(0.5, time.Minute))
+	gs.Run(func() {})
+	if reason := gs.Reason(); !errors.Is(reason, gostage.ErrQuit) {
+		t.Fatalf("reason = %v, want ErrQuit", reason)
+	}
+
+	gs = budgetPipeline(gostage.Config{}, func(int) bool { return true }, gostage.WithErrorBudget(0.5, time.Minute))
+	gs.Run(func() {})
+	reason := gs.Reason()
+	if !errors.Is(reason, gostage.ErrErrorBudgetExceeded) || !strings.Contains(reason.Error(), "consumer failed 10 of 10 events") {
+		t.Fatalf("reason = %v, want ErrErrorBudgetExceeded after 10 events", reason)
+	}
+}
@@ -0,0 +1,82 @@
+package examples
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/qgymje/gostage"
+)
+
+// panickyObserver panics when the pipeline stops
+type panickyObserver struct {
+	gostage.BaseObserver
+}
+
+func (panickyObserver) OnPipelineStopped(gostage.Accounting) {
+	panic("observer torn down")
+}
+
+// panickyLogger panics on errors
+type panickyLogger struct {
+	recordingLogger
+}
+
+func (l *panickyLogger) Error(format string, args ...interface{}) {
+	panic("logger torn down")
+}
+
+func Test_CallbackPanic(t *testing.T) {
+	cases := []struct {
+		callback string
+		logger   gostage.Logger
+		opts     []gostage.Option
+		done     func()
+	}{
+		{callback: "done callback", done: func() { panic("done torn down") }},
+		{callback: "OnError", opts: []gostage.Option{gostage.WithOnError(func(*gostage.StageError) { panic("OnError torn down") })}},
+		{callback: "OnIdle", opts: []gostage.Option{gostage.WithOnIdle(func() { panic("OnIdle torn down") })}},
+		{callback: "hook", opts: []gostage.Option{gostage.WithObserver(panickyObserver{})}},
+		{callback: "logger", logger: &panickyLogger{}},
+	}
+	for _, c := range cases {
+		t.Run(c.callback, func(t *testing.T) {
+			logger := &recordingLogger{}
+			if c.logger == nil {
+				c.logger = logger
+			}
+			if c.done == nil {
+				c.done = func() {}
+			}
+			failing := gostage.WorkHandler(func(in interface{}) (interface{}, error) {
+				return nil, errors.New("failed")
+			})
+			configs := []*gostage.Config{
+				{Name: "producer", Worker: countdown(5, func(n int) interface{} { return n })},
+				{Name: "consumer", Worker: failing, SubscribeToName: "producer"},
+			}
+			gs := gostage.New(context.Background(), configs, c.logger, c.opts...)
+			if err := gs.Run(c.done); err != nil {
+				t.Fatal(err)
+			}
+
+			if gs.State() != gostage.StateStopped {
+				t.Fatalf("pipeline is %s", gs.State())
+			}
+			if a := gs.Stats().Accounting; a.Produced != 5 || a.Accounted() != a.Produced {
+				t.Fatalf("accounting %+v", a)
+			}
+			err := gs.Err()
+			if !errors.Is(err, gostage.ErrCallbackPanic) {
+				t.Fatalf("Err() = %v", err)
+			}
+			var p *gostage.PanicError
+			if !errors.As(err, &p) || len(p.Stack) == 0 {
+				t.Fatalf("no panic in %v", err)
+			}
+			if c.callback != "logger" && len(logger.find("[Error]gostage callback panicked: "+c.callback)) == 0 {
+				t.Fatalf("the panic of %s isn't logged", c.callback)
+			}
+		})
+	}
+}
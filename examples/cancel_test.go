@@ -0,0 +1,76 @@
+package examples
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/qgymje/gostage"
+)
+
+func Test_CancelEvent(t *testing.T) {
+	n := 0
+	producer := gostage.WorkHandler(func(_ interface{}) (interface{}, error) {
+		if n == 10 {
+			return nil, gostage.ErrQuit
+		}
+		n++
+		return n, nil
+	})
+	stuck := make(chan string, 1)
+	slow := gostage.ContextHandler(func(ctx context.Context, in interface{}) (interface{}, error) {
+		if in.(int) != 3 {
+			return in, nil
+		}
+		id, _ := gostage.EventIDFromContext(ctx)
+		stuck <- id
+		<-ctx.Done()
+		return nil, ctx.Err()
+	})
+	var mu sync.Mutex
+	var received []int
+	sink := gostage.WorkHandler(func(in interface{}) (interface{}, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		received = append(received, in.(int))
+		return nil, nil
+	})
+	configs := []*gostage.Config{
+		{Name: "producer", Worker: producer},
+		{Name: "slow", Worker: slow, SubscribeToName: "producer", BufferSize: 16},
+		{Name: "sink", Worker: sink, SubscribeToName: "slow", BufferSize: 16},
+	}
+
+	gs := gostage.New(context.Background(), configs, &recordingLogger{}, gostage.WithStopMode(gostage.StopDrain))
+	done := make(chan struct{})
+	if err := gs.RunAsync(func() { close(done) }); err != nil {
+		t.Fatal(err)
+	}
+	if err := gs.CancelEvent("nope"); !errors.Is(err, gostage.ErrUnknownEvent) {
+		t.Fatalf("cancel an unknown event: %v", err)
+	}
+	if err := gs.CancelEvent(<-stuck); err != nil {
+		t.Fatal(err)
+	}
+	<-done
+
+	if len(received) != 9 {
+		t.Fatalf("sink received %v, want every event but 3", received)
+	}
+	for _, v := range received {
+		if v == 3 {
+			t.Fatalf("the cancelled event reached the sink: %v", received)
+		}
+	}
+	stats := gs.Stats()
+	if a := stats.Accounting; a.Cancelled != 1 || a.Completed != 9 || a.Accounted() != a.Produced {
+		t.Fatalf("accounting %+v", a)
+	}
+	if got := stats.Stages[1].Cancelled; got != 1 {
+		t.Fatalf("slow cancelled %d events, want 1", got)
+	}
+	if got := stats.Stages[1].Errors; got != 0 {
+		t.Fatalf("the cancellation was counted as %d errors", got)
+	}
+}
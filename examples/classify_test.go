@@ -0,0 +1,135 @@
+package examples
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/qgymje/gostage"
+)
+
+// timeout is a Temporary error
+type timeout struct{}
+
+func (timeout) Error() string   { return "timeout" }
+func (timeout) Temporary() bool { return true }
+
+// classifiedSink fails event 3 with a permanent error, 5 with a retryable one,
+// 7 with a Temporary one and 9 with an unclassified one, every attempt
+func classifiedSink(attempts map[int]int) gostage.WorkHandler {
+	return func(in interface{}) (interface{}, error) {
+		n := in.(int)
+		attempts[n]++
+		switch n {
+		case 3:
+			return nil, gostage.Permanent(errors.New("invalid order"))
+		case 5:
+			return nil, gostage.Retryable(errors.New("429 too many requests"))
+		case 7:
+			return nil, timeout{}
+		case 9:
+			return nil, errors.New("unknown")
+		}
+		return in, nil
+	}
+}
+
+func Test_ErrorClassification(t *testing.T) {
+	for _, c := range []struct {
+		unclassified gostage.ErrorClass
+		attempts9    int
+	}{
+		{gostage.Unclassified, 3},
+		{gostage.PermanentError, 1},
+	} {
+		var mu sync.Mutex
+		classes := map[int]gostage.ErrorClass{}
+		exhausted := map[int]bool{}
+		onError := gostage.WithOnError(func(err *gostage.StageError) {
+			mu.Lock()
+			defer mu.Unlock()
+			if errors.Is(err, gostage.ErrRetriesExhausted) {
+				exhausted[err.Input.(int)] = true
+				return
+			}
+			classes[err.Input.(int)] = err.Class
+		})
+		attempts := map[int]int{}
+		gs := retryPipeline(gostage.Config{Worker: classifiedSink(attempts), ErrorMode: gostage.RetryN,
+			Retries: 2, Unclassified: c.unclassified}, onError)
+		if err := gs.Run(func() {}); err != nil {
+			t.Fatal(err)
+		}
+
+		// the permanent error is dead-lettered on the first attempt
+		if attempts[3] != 1 || exhausted[3] || classes[3] != gostage.PermanentError {
+			t.Fatalf("event 3: %d attempts, exhausted %v, class %v", attempts[3], exhausted[3], classes[3])
+		}
+		for _, n := range []int{5, 7} {
+			if attempts[n] != 3 || !exhausted[n] || classes[n] != gostage.RetryableError {
+				t.Fatalf("event %d: %d attempts, exhausted %v, class %v", n, attempts[n], exhausted[n], classes[n])
+			}
+		}
+		if attempts[9] != c.attempts9 {
+			t.Fatalf("unclassified %v: event 9 attempted %d times, want %d", c.unclassified, attempts[9], c.attempts9)
+		}
+
+		stats := gs.Stats().Stages[1]
+		permanent := int64(1)
+		if c.unclassified == gostage.PermanentError {
+			permanent = 2
+		}
+		if stats.PermanentErrors != permanent || stats.RetryableErrors+stats.PermanentErrors != stats.Errors {
+			t.Fatalf("unclassified %v: %d permanent and %d retryable of %d errors",
+				c.unclassified, stats.PermanentErrors, stats.RetryableErrors, stats.Errors)
+		}
+	}
+
+	if got := gostage.Classify(errors.New("plain")); got != gostage.Unclassified {
+		t.Fatalf("a plain error is %v", got)
+	}
+	// the outermost wrapper wins
+	if got := gostage.Classify(gostage.Permanent(gostage.Retryable(timeout{}))); got != gostage.PermanentError {
+		t.Fatalf("a permanent wrapper is %v", got)
+	}
+}
+
+func Test_PermanentErrorNotForwarded(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dead.letters")
+	attempts := 0
+	middle := gostage.WorkHandler(func(in interface{}) (interface{}, error) {
+		if in == 2 {
+			attempts++
+			return nil, gostage.Permanent(errors.New("invalid order"))
+		}
+		return in, nil
+	})
+	var got []interface{}
+	sink := gostage.WorkHandler(func(in interface{}) (interface{}, error) {
+		got = append(got, in)
+		return nil, nil
+	})
+	configs := []*gostage.Config{
+		{Name: "producer", Worker: countdown(3, func(n int) interface{} { return n })},
+		{Name: "middle", Worker: middle, SubscribeToName: "producer", ErrorMode: gostage.RetryForever},
+		{Name: "sink", Worker: sink, SubscribeToName: "middle"},
+	}
+	gs := gostage.New(context.Background(), configs, &recordingLogger{}, gostage.WithDeadLetterFile(path))
+	if err := gs.Run(func() {}); err != nil {
+		t.Fatal(err)
+	}
+	if attempts != 1 {
+		t.Fatalf("the permanent error was attempted %d times", attempts)
+	}
+	if len(got) != 2 || got[0] != 1 || got[1] != 3 {
+		t.Fatalf("sink got %v, want [1 3]", got)
+	}
+	if dead := deadLetters(t, path, gs); len(dead) != 1 || dead[0].Payload != 2 || dead[0].Class != gostage.PermanentError {
+		t.Fatalf("dead letters %+v", dead)
+	}
+	if a := gs.Stats().Accounting; a.Completed != 2 || a.DeadLettered != 1 {
+		t.Fatalf("accounting %+v, want 2 completed and 1 dead-lettered", a)
+	}
+}
@@ -0,0 +1,62 @@
+package examples
+
+import (
+	"context"
+	"testing"
+
+	"github.com/qgymje/gostage"
+)
+
+// closeOnce fails the race detector if Close is called concurrently
+type closeOnce struct {
+	closes  int
+	created *[]*closeOnce
+}
+
+func (c *closeOnce) Create() gostage.Worker {
+	w := &closeOnce{created: c.created}
+	*c.created = append(*c.created, w)
+	return w
+}
+
+func (c *closeOnce) Close() {
+	c.closes++
+}
+
+func (c *closeOnce) HandleEvent(in interface{}) (interface{}, error) {
+	return in, nil
+}
+
+func Test_CloseOncePerWorker(t *testing.T) {
+	next := 0
+	producer := gostage.WorkHandler(func(_ interface{}) (interface{}, error) {
+		if next == 20 {
+			return nil, gostage.ErrQuit
+		}
+		next++
+		return next, nil
+	})
+	var created []*closeOnce
+	shared := &closeOnce{created: &created}
+	configs := []*gostage.Config{
+		{Name: "producer", Worker: producer},
+		{Name: "first", Worker: shared, SubscribeToName: "producer", Size: 3},
+		// the same worker referenced by a second config
+		{Name: "second", Worker: shared, SubscribeToName: "first"},
+	}
+
+	gs := gostage.New(context.Background(), configs, &recordingLogger{}, gostage.WithAllowSharedWorker())
+	gs.Run(func() {})
+
+	if shared.closes != 1 {
+		t.Fatalf("shared worker closed %d times, want 1", shared.closes)
+	}
+	if len(created) != 2 {
+		t.Fatalf("created %d workers, want 2", len(created))
+	}
+	for k, w := range created {
+		if w.closes != 1 {
+			t.Fatalf("created worker %d closed %d times, want 1", k, w.closes)
+		}
+	}
+}
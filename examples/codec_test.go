@@ -0,0 +1,117 @@
+package examples
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/qgymje/gostage"
+)
+
+type point struct {
+	X, Y int
+	Tag  string
+}
+
+// secret is never registered
+type secret struct {
+	Value string
+}
+
+func init() {
+	gostage.RegisterType(point{})
+	gostage.RegisterType(&point{})
+}
+
+func Test_CodecRoundTrip(t *testing.T) {
+	codecs := []gostage.Codec{gostage.GobCodec{}, gostage.JSONCodec{}}
+	for _, c := range codecs {
+		for _, v := range []interface{}{point{1, 2, "a"}, "text", 42, nil} {
+			data, err := c.Encode(v)
+			if err != nil {
+				t.Fatalf("%T: encode %v: %v", c, v, err)
+			}
+			got, err := c.Decode(data)
+			if err != nil {
+				t.Fatalf("%T: decode %v: %v", c, v, err)
+			}
+			if !reflect.DeepEqual(got, v) {
+				t.Fatalf("%T: got %#v, want %#v", c, got, v)
+			}
+		}
+
+		if _, err := c.Encode(secret{"x"}); !errors.Is(err, gostage.ErrUnregisteredType) {
+			t.Fatalf("%T: encode unregistered type: %v, want ErrUnregisteredType", c, err)
+		}
+	}
+
+	// JSON keeps pointers
+	c := gostage.JSONCodec{}
+	data, err := c.Encode(&point{3, 4, "b"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := c.Decode(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p, ok := got.(*point); !ok || *p != (point{3, 4, "b"}) {
+		t.Fatalf("got %#v, want &point{3, 4, b}", got)
+	}
+}
+
+func Test_CodecEdges(t *testing.T) {
+	next := 0
+	producer := gostage.WorkHandler(func(_ interface{}) (interface{}, error) {
+		if next == 10 {
+			return nil, gostage.ErrQuit
+		}
+		next++
+		if next == 5 {
+			return secret{"can't cross"}, nil
+		}
+		return point{X: next, Tag: "p"}, nil
+	})
+	move := gostage.WorkHandler(func(in interface{}) (interface{}, error) {
+		p := in.(point)
+		p.Y = p.X * 10
+		return p, nil
+	})
+	configs := []*gostage.Config{
+		{Name: "producer", Worker: producer},
+		{Name: "move", Worker: move, SubscribeTo: producer},
+		{Name: "sink", Worker: passThrough{}, SubscribeTo: move, Codec: gostage.GobCodec{}},
+	}
+
+	var mu sync.Mutex
+	var stageErrs []*gostage.StageError
+	gs := gostage.New(context.Background(), configs, &recordingLogger{},
+		gostage.WithCodec(gostage.JSONCodec{}),
+		gostage.WithOnError(func(e *gostage.StageError) {
+			mu.Lock()
+			defer mu.Unlock()
+			stageErrs = append(stageErrs, e)
+		}))
+	out, err := gs.Collect(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(out) != 9 {
+		t.Fatalf("collected %d events, want 9", len(out))
+	}
+	for _, v := range out {
+		if p := v.(point); p.Y != p.X*10 || p.Tag != "p" {
+			t.Fatalf("collected %+v", p)
+		}
+	}
+	if len(stageErrs) != 1 || stageErrs[0].Stage != "producer" || !errors.Is(stageErrs[0], gostage.ErrEncode) ||
+		!errors.Is(stageErrs[0], gostage.ErrUnregisteredType) {
+		t.Fatalf("got stage errors %v, want one ErrEncode of the producer", stageErrs)
+	}
+	if a := gs.Stats().Accounting; a.DeadLettered != 1 || a.Completed != 9 {
+		t.Fatalf("accounting %+v, want 1 dead lettered and 9 completed", a)
+	}
+}
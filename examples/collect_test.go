@@ -0,0 +1,63 @@
+package examples
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/qgymje/gostage"
+)
+
+// collectPipeline produces 1..n through a doubling stage
+func collectPipeline(n int, opts ...gostage.Option) *gostage.GoStage {
+	next := 0
+	producer := gostage.WorkHandler(func(_ interface{}) (interface{}, error) {
+		if next == n {
+			return nil, gostage.ErrQuit
+		}
+		next++
+		return next, nil
+	})
+	double := gostage.WorkHandler(func(in interface{}) (interface{}, error) {
+		return in.(int) * 2, nil
+	})
+	configs := []*gostage.Config{
+		{Name: "producer", Worker: producer},
+		{Name: "double", Worker: double, SubscribeTo: producer},
+	}
+	return gostage.New(context.Background(), configs, &recordingLogger{}, opts...)
+}
+
+func Test_Collect(t *testing.T) {
+	results, err := collectPipeline(10).Collect(context.Background())
+	if err != nil {
+		t.Fatalf("Collect error = %v", err)
+	}
+	if len(results) != 10 {
+		t.Fatalf("got %d results, want 10: %v", len(results), results)
+	}
+	for k, v := range results {
+		if v != 2*(k+1) {
+			t.Fatalf("results[%d] = %v, want %d", k, v, 2*(k+1))
+		}
+	}
+}
+
+func Test_CollectMaxResults(t *testing.T) {
+	results, err := collectPipeline(1000, gostage.WithMaxResults(5)).Collect(context.Background())
+	if !errors.Is(err, gostage.ErrMaxResults) {
+		t.Fatalf("Collect error = %v, want ErrMaxResults", err)
+	}
+	if len(results) != 5 {
+		t.Fatalf("got %d results, want 5", len(results))
+	}
+}
+
+func Test_CollectCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := collectPipeline(1 << 30).Collect(ctx)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("Collect error = %v, want context.Canceled", err)
+	}
+}
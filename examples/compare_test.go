@@ -0,0 +1,77 @@
+package examples
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/qgymje/gostage"
+)
+
+func Test_CompareStage(t *testing.T) {
+	current := gostage.WorkHandler(func(in interface{}) (interface{}, error) {
+		return in.(int) * 2, nil
+	})
+	// wrong on every third input, fails on the last two
+	candidate := gostage.WorkHandler(func(in interface{}) (interface{}, error) {
+		n := in.(int)
+		switch {
+		case n == 31:
+			panic("candidate bug")
+		case n == 32:
+			return nil, errors.New("candidate error")
+		case n%3 == 0:
+			return n*2 + 1, nil
+		}
+		return n * 2, nil
+	})
+
+	var mu sync.Mutex
+	var mismatched []int
+	compare, comparison := gostage.CompareStage("compare", current, candidate,
+		func(a, b interface{}) bool { return a != b },
+		func(in, a, b interface{}) {
+			mu.Lock()
+			defer mu.Unlock()
+			mismatched = append(mismatched, in.(int))
+		})
+	compare.SubscribeToName = "producer"
+
+	var received []int
+	sink := gostage.WorkHandler(func(in interface{}) (interface{}, error) {
+		received = append(received, in.(int))
+		return nil, nil
+	})
+	configs := []*gostage.Config{
+		{Name: "producer", Worker: countdown(32, func(n int) interface{} { return n })},
+		compare,
+		{Name: "sink", Worker: sink, SubscribeToName: "compare"},
+	}
+	gs := gostage.New(context.Background(), configs, &recordingLogger{})
+	if err := gs.Run(func() {}); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(mismatched) != 10 {
+		t.Fatalf("mismatches for %v, want every third input", mismatched)
+	}
+	for _, n := range mismatched {
+		if n%3 != 0 {
+			t.Fatalf("mismatches for %v, want every third input", mismatched)
+		}
+	}
+	if comparison.Mismatches() != 10 || comparison.Compared() != 30 || comparison.CandidateFailures() != 2 {
+		t.Fatalf("compared %d, mismatches %d, failures %d",
+			comparison.Compared(), comparison.Mismatches(), comparison.CandidateFailures())
+	}
+	// only the current worker's outputs are passed on
+	for k, v := range received {
+		if v != 2*(k+1) {
+			t.Fatalf("the sink received %v", received)
+		}
+	}
+	if len(received) != 32 {
+		t.Fatalf("the sink received %d events, want 32", len(received))
+	}
+}
@@ -0,0 +1,105 @@
+package examples
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/qgymje/gostage"
+)
+
+// sharedClient stands for a thread-safe client holding a connection pool
+type sharedClient struct {
+	mu      sync.Mutex
+	calls   int
+	running int
+	max     int
+	closes  int
+}
+
+func (c *sharedClient) HandleEvent(in interface{}) (interface{}, error) {
+	c.mu.Lock()
+	c.calls++
+	c.running++
+	if c.running > c.max {
+		c.max = c.running
+	}
+	c.mu.Unlock()
+
+	time.Sleep(time.Millisecond)
+
+	c.mu.Lock()
+	c.running--
+	c.mu.Unlock()
+	return in, nil
+}
+
+func (c *sharedClient) Close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closes++
+}
+
+func Test_Concurrency(t *testing.T) {
+	producer := countdown(200, func(n int) interface{} { return n })
+	client := &sharedClient{}
+	configs := []*gostage.Config{
+		{Name: "producer", Worker: producer},
+		{Name: "client", Worker: client, SubscribeTo: producer, ShareInstance: true, Concurrency: 8, BufferSize: 16},
+	}
+
+	gs := gostage.New(context.Background(), configs, &recordingLogger{})
+	var busy int64
+	done := make(chan struct{})
+	if err := gs.RunAsync(func() { close(done) }); err != nil {
+		t.Fatal(err)
+	}
+	for {
+		stats := gs.Stats().Stages[1]
+		if stats.Busy > busy {
+			busy = stats.Busy
+		}
+		if stats.Concurrency != 8 {
+			t.Fatalf("concurrency is %d, want 8", stats.Concurrency)
+		}
+		select {
+		case <-done:
+		default:
+			time.Sleep(100 * time.Microsecond)
+			continue
+		}
+		break
+	}
+
+	if client.calls != 200 {
+		t.Fatalf("handled %d events, want 200", client.calls)
+	}
+	if client.max < 2 || client.max > 8 {
+		t.Fatalf("ran %d events at once, want between 2 and 8", client.max)
+	}
+	if busy < 2 {
+		t.Fatalf("stats showed at most %d busy goroutines", busy)
+	}
+	if client.closes != 1 {
+		t.Fatalf("closed %d times, want 1", client.closes)
+	}
+}
+
+func Test_InvalidConcurrency(t *testing.T) {
+	cases := []gostage.Config{
+		{Concurrency: 4},
+		{Concurrency: 4, ShareInstance: true, Size: 2},
+	}
+	for _, c := range cases {
+		producer := countdown(1, func(n int) interface{} { return n })
+		c := c
+		c.Name, c.Worker, c.SubscribeTo = "client", &sharedClient{}, producer
+		configs := []*gostage.Config{{Name: "producer", Worker: producer}, &c}
+		gs := gostage.New(context.Background(), configs, &recordingLogger{})
+		if err := gs.Run(func() {}); !errors.Is(err, gostage.ErrInvalidConcurrency) {
+			t.Fatalf("Run() = %v, want ErrInvalidConcurrency", err)
+		}
+	}
+}
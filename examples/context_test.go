@@ -0,0 +1,66 @@
+package examples
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/qgymje/gostage"
+)
+
+func Test_ContextIdentity(t *testing.T) {
+	left := 50
+	producer := gostage.ContextHandler(func(ctx context.Context, _ interface{}) (interface{}, error) {
+		if left == 0 {
+			return nil, gostage.ErrQuit
+		}
+		left--
+		// the event keeps its ID downstream
+		id, ok := gostage.EventIDFromContext(ctx)
+		if !ok {
+			t.Error("no event ID in the producer")
+		}
+		return id, nil
+	})
+
+	var mu sync.Mutex
+	seen := make(map[string]bool)
+	instances := make(map[int]int)
+	consumer := gostage.ContextHandler(func(ctx context.Context, in interface{}) (interface{}, error) {
+		stage, _ := gostage.StageFromContext(ctx)
+		n, ok := gostage.InstanceFromContext(ctx)
+		id, _ := gostage.EventIDFromContext(ctx)
+		mu.Lock()
+		defer mu.Unlock()
+		if stage != "shards" || !ok {
+			t.Errorf("stage %q, instance %v", stage, ok)
+		}
+		if id != in.(string) || seen[id] {
+			t.Errorf("event ID %q for the event %v", id, in)
+		}
+		seen[id] = true
+		instances[n]++
+		return in, nil
+	})
+
+	configs := []*gostage.Config{
+		{Name: "producer", Worker: producer},
+		{Name: "shards", Worker: consumer, SubscribeToName: "producer", Size: 2, ShareInstance: true},
+	}
+	gs := gostage.New(context.Background(), configs, &recordingLogger{}, gostage.WithStopMode(gostage.StopDrain))
+	if err := gs.Run(func() {}); err != nil {
+		t.Fatal(err)
+	}
+	if len(seen) != 50 {
+		t.Fatalf("%d events seen, want 50", len(seen))
+	}
+	for n := range instances {
+		if n != 0 && n != 1 {
+			t.Fatalf("instance %d of 2", n)
+		}
+	}
+
+	if _, ok := gostage.EventIDFromContext(context.Background()); ok {
+		t.Fatal("event ID outside of a pipeline")
+	}
+}
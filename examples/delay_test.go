@@ -0,0 +1,132 @@
+package examples
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/qgymje/gostage"
+)
+
+// released records the events reaching the sink and when
+type released struct {
+	mu     sync.Mutex
+	clock  gostage.Clock
+	events []int
+	at     []time.Time
+}
+
+func (r *released) HandleEvent(in interface{}) (interface{}, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, in.(int))
+	r.at = append(r.at, r.clock.Now())
+	return nil, nil
+}
+
+func (r *released) count() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.events)
+}
+
+// delayPipeline produces the events then idles, every event is due its value in seconds after the epoch
+func delayPipeline(clock *fakeClock, events []int, opts ...gostage.DelayOption) (*gostage.GoStage, *gostage.Delayer, *released) {
+	next := 0
+	producer := gostage.WorkHandler(func(_ interface{}) (interface{}, error) {
+		if next == len(events) {
+			return nil, gostage.ErrNoData
+		}
+		next++
+		return events[next-1], nil
+	})
+	epoch := clock.Now()
+	delay, delayer := gostage.Delay("delay", func(in interface{}) time.Time {
+		return epoch.Add(time.Duration(in.(int)) * time.Second)
+	}, opts...)
+	delay.SubscribeToName = "producer"
+	sink := &released{clock: clock}
+	configs := []*gostage.Config{
+		{Name: "producer", Worker: producer},
+		delay,
+		{Name: "sink", Worker: sink, SubscribeToName: "delay"},
+	}
+	gs := gostage.New(context.Background(), configs, &recordingLogger{},
+		gostage.WithClock(clock), gostage.WithNoDataCountSleep(time.Millisecond), gostage.WithStopMode(gostage.StopDrain))
+	return gs, delayer, sink
+}
+
+func Test_Delay(t *testing.T) {
+	clock := newFakeClock()
+	epoch := clock.Now()
+	gs, delayer, sink := delayPipeline(clock, []int{30, 10, 50, 20, 40})
+	done := make(chan struct{})
+	if err := gs.RunAsync(func() { close(done) }); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "the events held", func() bool { return delayer.Held() == 5 })
+
+	for k, due := range []int{10, 20, 30, 40, 50} {
+		at := epoch.Add(time.Duration(due) * time.Second)
+		waitFor(t, "the timer", func() bool { return clock.pending(at) })
+		if got := sink.count(); got != k {
+			t.Fatalf("%d events released before %ds, want %d", got, due, k)
+		}
+		clock.Advance(at.Sub(clock.Now()))
+		waitFor(t, "the release", func() bool { return sink.count() == k+1 })
+		if sink.events[k] != due || !sink.at[k].Equal(at) {
+			t.Fatalf("released %d at %v, want %d at %v", sink.events[k], sink.at[k], due, at)
+		}
+	}
+	if err := gs.Stop(); err != nil {
+		t.Fatal(err)
+	}
+	<-done
+	if a := gs.Stats().Accounting; a.Completed != 5 || a.Accounted() != a.Produced {
+		t.Fatalf("accounting %+v", a)
+	}
+}
+
+func Test_DelayMaxHeld(t *testing.T) {
+	for _, stop := range []gostage.DelayStop{gostage.ReleaseNow, gostage.ReleaseWhenDue} {
+		clock := newFakeClock()
+		gs, delayer, sink := delayPipeline(clock, []int{50, 40, 30, 20, 10},
+			gostage.WithMaxHeld(2), gostage.WithDelayStop(stop))
+		done := make(chan struct{})
+		if err := gs.RunAsync(func() { close(done) }); err != nil {
+			t.Fatal(err)
+		}
+		// the producer waits with the third event
+		waitFor(t, "the producer", func() bool { return gs.Stats().Stages[0].Processed == 3 })
+		time.Sleep(20 * time.Millisecond)
+		if held, produced := delayer.Held(), gs.Stats().Stages[0].Processed; held != 2 || produced != 3 {
+			t.Fatalf("%d events held and %d produced, want 2 and 3", held, produced)
+		}
+
+		// the waiting event gets in once the pipeline stops
+		if err := gs.Stop(); err != nil {
+			t.Fatal(err)
+		}
+		if stop == gostage.ReleaseWhenDue {
+			waitFor(t, "the events held", func() bool { return delayer.Held() == 3 })
+			if got := sink.count(); got != 0 {
+				t.Fatalf("%d events released before due", got)
+			}
+			clock.Advance(time.Minute)
+		}
+		<-done
+		want := []int{30, 40, 50}
+		if len(sink.events) != len(want) {
+			t.Fatalf("%v released %v, want %v", stop, sink.events, want)
+		}
+		for k, v := range sink.events {
+			if v != want[k] {
+				t.Fatalf("%v released %v, want %v", stop, sink.events, want)
+			}
+		}
+		if a := gs.Stats().Accounting; a.Completed != 3 || a.Accounted() != a.Produced {
+			t.Fatalf("%v accounting %+v", stop, a)
+		}
+	}
+}
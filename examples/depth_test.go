@@ -0,0 +1,89 @@
+package examples
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/qgymje/gostage"
+)
+
+// lenCounting is a Queue which counts the calls to its Len
+type lenCounting struct {
+	gostage.Queue
+	calls *atomic.Int64
+}
+
+func (q lenCounting) Len() int {
+	q.calls.Add(1)
+	return q.Queue.Len()
+}
+
+func Test_QueueSampling(t *testing.T) {
+	const interval = 10 * time.Millisecond
+	for name, queue := range map[string]bool{"channel": false, "queue": true} {
+		t.Run(name, func(t *testing.T) {
+			var lens atomic.Int64
+			started := make(chan struct{}, 6)
+			release := make(chan struct{})
+			sink := gostage.WorkHandler(func(in interface{}) (interface{}, error) {
+				started <- struct{}{}
+				<-release
+				return nil, nil
+			})
+			configs := []*gostage.Config{
+				{Name: "producer", Worker: idle},
+				{Name: "sink", Worker: sink, SubscribeToName: "producer", BufferSize: 10},
+			}
+			if queue {
+				configs[1].Queue = func(capacity int, evict func(interface{})) gostage.Queue {
+					return lenCounting{Queue: gostage.DropOldestQueue(capacity, evict), calls: &lens}
+				}
+			}
+			gs := gostage.New(context.Background(), configs, &recordingLogger{},
+				gostage.WithStopMode(gostage.StopDrain), gostage.WithQueueSampling(interval))
+			done := make(chan struct{})
+			if err := gs.RunAsync(func() { close(done) }); err != nil {
+				t.Fatal(err)
+			}
+
+			// the sink holds the first event, the 5 others wait
+			for n := 0; n < 6; n++ {
+				if err := gs.Push(context.Background(), n); err != nil {
+					t.Fatal(err)
+				}
+				if n == 0 {
+					<-started
+				}
+			}
+			time.Sleep(2 * interval)
+			if st := gs.Stats().Stages[1]; st.QueueDepth != 5 {
+				t.Errorf("depth %d, want 5", st.QueueDepth)
+			}
+
+			// 2 more are taken
+			release <- struct{}{}
+			<-started
+			release <- struct{}{}
+			<-started
+			time.Sleep(2 * interval)
+			if st := gs.Stats().Stages[1]; st.QueueDepth != 3 {
+				t.Errorf("depth %d, want 3", st.QueueDepth)
+			}
+
+			close(release)
+			waitFor(t, "the events", func() bool { return gs.Stats().Stages[1].Processed == 6 })
+			time.Sleep(2 * interval)
+			if st := gs.Stats().Stages[1]; st.QueueDepth != 0 || st.MaxQueueDepth != 5 {
+				t.Errorf("depth %d, max %d, want 0 and 5", st.QueueDepth, st.MaxQueueDepth)
+			}
+			// the sampler never asked the queue
+			if lens.Load() != 0 {
+				t.Errorf("Len called %d times while running", lens.Load())
+			}
+			gs.Stop()
+			<-done
+		})
+	}
+}
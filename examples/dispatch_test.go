@@ -0,0 +1,115 @@
+package examples
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/qgymje/gostage"
+)
+
+// uneven sleeps slow for its instance 0 and fast for the others
+type uneven struct {
+	slow, fast time.Duration
+	n          int
+}
+
+func (u *uneven) CreateWithInfo(_ string, instance, _ int) gostage.Worker {
+	return &uneven{slow: u.slow, fast: u.fast, n: instance}
+}
+
+func (u *uneven) HandleEvent(in interface{}) (interface{}, error) {
+	if u.n == 0 {
+		time.Sleep(u.slow)
+	} else {
+		time.Sleep(u.fast)
+	}
+	return in, nil
+}
+
+// runUneven sends 150 timestamped events through a stage with a slow instance
+// returns the p95 latency of the events and the events handled by each instance
+func runUneven(t *testing.T, dispatch gostage.Dispatch) (time.Duration, []int64) {
+	left := 150
+	producer := gostage.WorkHandler(func(_ interface{}) (interface{}, error) {
+		if left == 0 {
+			return nil, gostage.ErrQuit
+		}
+		left--
+		time.Sleep(2 * time.Millisecond)
+		return time.Now(), nil
+	})
+	var mu sync.Mutex
+	var latencies []time.Duration
+	sink := gostage.WorkHandler(func(in interface{}) (interface{}, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		latencies = append(latencies, time.Since(in.(time.Time)))
+		return nil, nil
+	})
+	configs := []*gostage.Config{
+		{Name: "producer", Worker: producer},
+		{Name: "work", Worker: &uneven{slow: 30 * time.Millisecond, fast: time.Millisecond}, SubscribeToName: "producer", Size: 3, BufferSize: 16, Dispatch: dispatch},
+		{Name: "sink", Worker: sink, SubscribeToName: "work"},
+	}
+	gs := gostage.New(context.Background(), configs, &recordingLogger{})
+	if err := gs.Run(func() {}); err != nil {
+		t.Fatal(err)
+	}
+	if a := gs.Stats().Accounting; a.Completed != 150 || a.Accounted() != a.Produced {
+		t.Fatalf("accounting %+v", a)
+	}
+	sort.Slice(latencies, func(a, b int) bool { return latencies[a] < latencies[b] })
+	return latencies[len(latencies)*95/100], gs.Stats().Stages[1].InstanceProcessed
+}
+
+func Test_LeastBusy(t *testing.T) {
+	competeP95, competed := runUneven(t, gostage.Compete)
+	leastBusyP95, dispatched := runUneven(t, gostage.LeastBusy)
+	t.Logf("compete: p95 %v, per instance %v; least busy: p95 %v, per instance %v", competeP95, competed, leastBusyP95, dispatched)
+
+	if len(dispatched) != 3 || dispatched[0]+dispatched[1]+dispatched[2] != 150 {
+		t.Fatalf("events per instance %v", dispatched)
+	}
+	// the slow instance is only given events until its latency is known
+	if dispatched[0] > 3 || dispatched[0] >= competed[0] {
+		t.Fatalf("the slow instance handled %d events, %d when competing", dispatched[0], competed[0])
+	}
+	if leastBusyP95 >= competeP95 {
+		t.Fatalf("p95 %v, %v when competing", leastBusyP95, competeP95)
+	}
+}
+
+func Test_LeastBusyStop(t *testing.T) {
+	for _, mode := range []gostage.StopMode{gostage.StopImmediate, gostage.StopDrain} {
+		n := 0
+		producer := gostage.WorkHandler(func(_ interface{}) (interface{}, error) {
+			n++
+			return n, nil
+		})
+		configs := []*gostage.Config{
+			{Name: "producer", Worker: producer},
+			{Name: "work", Worker: &uneven{slow: 3 * time.Millisecond, fast: 100 * time.Microsecond}, SubscribeToName: "producer", Size: 4, BufferSize: 32, Dispatch: gostage.LeastBusy},
+			{Name: "sink", Worker: passThrough{}, SubscribeToName: "work"},
+		}
+		gs := gostage.New(context.Background(), configs, &recordingLogger{}, gostage.WithStopMode(mode))
+		done := make(chan struct{})
+		if err := gs.RunAsync(func() { close(done) }); err != nil {
+			t.Fatal(err)
+		}
+		waitFor(t, "some events", func() bool { return gs.Stats().Stages[2].Processed > 100 })
+		if err := gs.Stop(); err != nil {
+			t.Fatal(err)
+		}
+		<-done
+		a := gs.Stats().Accounting
+		if a.Accounted() != a.Produced {
+			t.Fatalf("stop mode %v, accounting %+v", mode, a)
+		}
+		if mode == gostage.StopDrain && a.DroppedInChannel != 0 {
+			t.Fatalf("drained events dropped: %+v", a)
+		}
+	}
+}
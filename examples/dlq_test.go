@@ -0,0 +1,201 @@
+package examples
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/qgymje/gostage"
+)
+
+var errOdd = errors.New("odd")
+
+// validator fails the odd numbers unless it's fixed
+func validator(fixed bool) gostage.WorkHandler {
+	return func(in interface{}) (interface{}, error) {
+		if in.(int)%2 == 1 && !fixed {
+			return nil, errOdd
+		}
+		return in, nil
+	}
+}
+
+// collecting is a sink keeping the numbers it's given and counting the nil payloads,
+// which a failed event must never turn into
+type collecting struct {
+	mu   sync.Mutex
+	got  []int
+	nils int
+}
+
+func (c *collecting) HandleEvent(in interface{}) (interface{}, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if in == nil {
+		c.nils++
+		return nil, nil
+	}
+	c.got = append(c.got, in.(int))
+	return nil, nil
+}
+
+func (c *collecting) nilCount() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.nils
+}
+
+func (c *collecting) sorted() []int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	got := append([]int(nil), c.got...)
+	sort.Ints(got)
+	return got
+}
+
+// deadLetters reads the events of the dead letter file at path
+func deadLetters(t *testing.T, path string, into *gostage.GoStage) []gostage.DeadLetterEvent {
+	var events []gostage.DeadLetterEvent
+	err := gostage.ReplayDLQ(path, into, "", func(ev gostage.DeadLetterEvent) bool {
+		events = append(events, ev)
+		return false
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return events
+}
+
+// replayTarget runs a pipeline whose validate stage may be fixed, writing to the dead letter file at path
+func replayTarget(t *testing.T, path string, fixed bool) (*gostage.GoStage, *collecting, chan struct{}) {
+	sink := &collecting{}
+	configs := []*gostage.Config{
+		{Name: "producer", Worker: idle},
+		{Name: "validate", Worker: validator(fixed), SubscribeToName: "producer"},
+		{Name: "sink", Worker: sink, SubscribeToName: "validate"},
+	}
+	gs := gostage.New(context.Background(), configs, &recordingLogger{},
+		gostage.WithDeadLetterFile(path), gostage.WithNoDataCountSleep(time.Millisecond),
+		gostage.WithStopMode(gostage.StopDrain))
+	done := make(chan struct{})
+	if err := gs.RunAsync(func() { close(done) }); err != nil {
+		t.Fatal(err)
+	}
+	return gs, sink, done
+}
+
+func Test_DeadLetterFileReplay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dead.letters")
+	failed := &collecting{}
+	configs := []*gostage.Config{
+		{Name: "producer", Worker: countdown(6, func(n int) interface{} { return n })},
+		{Name: "validate", Worker: validator(false), SubscribeToName: "producer"},
+		{Name: "sink", Worker: failed, SubscribeToName: "validate"},
+	}
+	failing := gostage.New(context.Background(), configs, &recordingLogger{}, gostage.WithDeadLetterFile(path))
+	if err := failing.Run(func() {}); err != nil {
+		t.Fatal(err)
+	}
+	if got := failed.sorted(); failed.nilCount() != 0 || len(got) != 3 || got[0] != 2 || got[1] != 4 || got[2] != 6 {
+		t.Fatalf("sink got %v and %d nil payloads", got, failed.nilCount())
+	}
+	if a := failing.Stats().Accounting; a.DeadLettered != 3 || a.Completed != 3 {
+		t.Fatalf("accounting %+v, want 3 dead-lettered and 3 completed", a)
+	}
+
+	// the process restarts with the validation fixed
+	fixed, sink, done := replayTarget(t, path, true)
+	events := deadLetters(t, path, fixed)
+	if len(events) != 3 {
+		t.Fatalf("%d dead letters, want 3", len(events))
+	}
+	for _, ev := range events {
+		if ev.Stage != "validate" || ev.Err != errOdd.Error() || ev.Replays != 0 || ev.EventID == "" {
+			t.Fatalf("dead letter %+v", ev)
+		}
+	}
+	if err := gostage.ReplayDLQ(path, fixed, "validate", nil); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "the replayed events to be stored", func() bool { return len(sink.sorted()) == 3 })
+	fixed.Stop()
+	<-done
+	if got := sink.sorted(); sink.nilCount() != 0 || got[0] != 1 || got[1] != 3 || got[2] != 5 {
+		t.Fatalf("sink got %v and %d nil payloads", got, sink.nilCount())
+	}
+}
+
+func Test_DeadLetterReplayDoesNotLoop(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dead.letters")
+	configs := []*gostage.Config{
+		{Name: "producer", Worker: countdown(4, func(n int) interface{} { return n })},
+		{Name: "validate", Worker: validator(false), SubscribeToName: "producer"},
+		{Name: "sink", Worker: &collecting{}, SubscribeToName: "validate"},
+	}
+	if err := gostage.New(context.Background(), configs, &recordingLogger{}, gostage.WithDeadLetterFile(path)).Run(func() {}); err != nil {
+		t.Fatal(err)
+	}
+
+	// still broken, and writing its failures to the file it replays
+	broken, sink, done := replayTarget(t, path, false)
+	if err := gostage.ReplayDLQ(path, broken, "validate", nil); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "the replayed events to fail again", func() bool { return broken.Stats().Stages[1].Errors == 2 })
+	broken.Stop()
+	<-done
+	if n := sink.nilCount(); n != 0 || broken.Stats().Accounting.DeadLettered != 2 {
+		t.Fatalf("sink got %d nil payloads, accounting %+v", n, broken.Stats().Accounting)
+	}
+
+	replays := map[int]int{}
+	for _, ev := range deadLetters(t, path, broken) {
+		replays[ev.Replays]++
+	}
+	if replays[0] != 2 || replays[1] != 2 || len(replays) != 2 {
+		t.Fatalf("dead letters by replays %v, want 2 never replayed and 2 replayed once", replays)
+	}
+}
+
+func Test_DeadLetterCorruptRecords(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dead.letters")
+	configs := []*gostage.Config{
+		{Name: "producer", Worker: countdown(2, func(n int) interface{} { return n })},
+		{Name: "validate", Worker: validator(false), SubscribeToName: "producer"},
+		{Name: "sink", Worker: &collecting{}, SubscribeToName: "validate"},
+	}
+	for run := 0; run < 2; run++ {
+		if err := gostage.New(context.Background(), configs, &recordingLogger{}, gostage.WithDeadLetterFile(path)).Run(func() {}); err != nil {
+			t.Fatal(err)
+		}
+		configs[0].Worker = countdown(2, func(n int) interface{} { return n })
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// flip a byte of the first record, then cut the file in the middle of a header
+	data[len(data)/2-1] ^= 0xff
+	data = append(data, 0, 0, 1)
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	logger := &recordingLogger{}
+	into := gostage.New(context.Background(), configs, logger)
+	events := deadLetters(t, path, into)
+	if len(events) != 1 || events[0].Payload != 1 {
+		t.Fatalf("dead letters %+v, want the second one", events)
+	}
+	if len(logger.find("[Error]dead letters", "skipped the record at 0")) != 1 {
+		t.Fatal("the corrupt record wasn't logged")
+	}
+	if len(logger.find("[Error]dead letters", "truncated header")) != 1 {
+		t.Fatal("the truncated record wasn't logged")
+	}
+}
@@ -0,0 +1,92 @@
+package examples
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"sync"
+	"testing"
+
+	"github.com/qgymje/gostage"
+)
+
+// connector opens a connection in Init, instance 2 fails to
+type connector struct {
+	mu     *sync.Mutex
+	opened *int
+	closed *int
+	n      int
+}
+
+func (c *connector) CreateWithInfo(_ string, instance, _ int) gostage.Worker {
+	return &connector{mu: c.mu, opened: c.opened, closed: c.closed, n: instance}
+}
+
+func (c *connector) Init() error {
+	if c.n == 2 {
+		return errors.New("connection refused")
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	*c.opened++
+	return nil
+}
+
+func (c *connector) HandleEvent(in interface{}) (interface{}, error) {
+	return in, nil
+}
+
+func (c *connector) Close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	*c.closed++
+}
+
+func Test_DryRun(t *testing.T) {
+	before := runtime.NumGoroutine()
+
+	var opened, closed int
+	sink := &connector{mu: &sync.Mutex{}, opened: &opened, closed: &closed}
+	producer := countdown(10, func(n int) interface{} { return n })
+	configs := []*gostage.Config{
+		{Name: "producer", Worker: producer},
+		{Name: "sink", Worker: sink, SubscribeToName: "producer", Size: 3},
+	}
+	gs := gostage.New(context.Background(), configs, &recordingLogger{})
+	err := gs.DryRun()
+	if !errors.Is(err, gostage.ErrInit) {
+		t.Fatalf("dry run: %v", err)
+	}
+	if opened != 2 || closed != 3 {
+		t.Fatalf("%d workers initialized and %d closed, want 2 and 3", opened, closed)
+	}
+	if gs.State() != gostage.StateIdle {
+		t.Fatalf("dry run left the pipeline %s", gs.State())
+	}
+	// a real run fails the same way, before anything is produced
+	if err := gs.Run(func() {}); !errors.Is(err, gostage.ErrInit) {
+		t.Fatalf("run: %v", err)
+	}
+	if n := gs.Stats().Accounting.Produced; n != 0 {
+		t.Fatalf("%d events produced", n)
+	}
+
+	configs[1].Size = 2
+	gs = gostage.New(context.Background(), configs, &recordingLogger{})
+	if err := gs.DryRun(); err != nil {
+		t.Fatal(err)
+	}
+
+	configs = []*gostage.Config{
+		{Name: "producer", Worker: producer},
+		{Name: "sink", Worker: passThrough{}, SubscribeToName: "nowhere"},
+	}
+	gs = gostage.New(context.Background(), configs, &recordingLogger{})
+	if err := gs.DryRun(); !errors.Is(err, gostage.ErrUnknownStage) {
+		t.Fatalf("dry run of a broken topology: %v", err)
+	}
+
+	if n := runtime.NumGoroutine(); n > before {
+		t.Fatalf("%d goroutines running after the dry runs, %d before", n, before)
+	}
+}
@@ -0,0 +1,95 @@
+package examples
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/qgymje/gostage"
+)
+
+type tenantKey struct{}
+
+func Test_EventContext(t *testing.T) {
+	left := 20
+	producer := gostage.ContextHandler(func(ctx context.Context, _ interface{}) (interface{}, error) {
+		if left == 0 {
+			return nil, gostage.ErrQuit
+		}
+		left--
+		// ctx is done once the producer has returned, only its values go with the event
+		return gostage.WithEventContext(context.WithValue(ctx, tenantKey{}, fmt.Sprint("tenant-", left)), left), nil
+	})
+	var mu sync.Mutex
+	tenants := make(map[int]string)
+	sink := gostage.ContextHandler(func(ctx context.Context, in interface{}) (interface{}, error) {
+		if err := ctx.Err(); err != nil {
+			t.Errorf("event %v: %v", in, err)
+		}
+		if stage, _ := gostage.StageFromContext(ctx); stage != "sink" {
+			t.Errorf("stage %q in the sink", stage)
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		tenants[in.(int)], _ = ctx.Value(tenantKey{}).(string)
+		return nil, nil
+	})
+	configs := []*gostage.Config{
+		{Name: "producer", Worker: producer},
+		{Name: "plain", Worker: passThrough{}, SubscribeToName: "producer"},
+		{Name: "sink", Worker: sink, SubscribeToName: "plain"},
+	}
+	gs := gostage.New(context.Background(), configs, &recordingLogger{}, gostage.WithStopMode(gostage.StopDrain))
+	if err := gs.Run(func() {}); err != nil {
+		t.Fatal(err)
+	}
+	if len(tenants) != 20 {
+		t.Fatalf("sink received %d events, want 20", len(tenants))
+	}
+	for n, tenant := range tenants {
+		if tenant != fmt.Sprint("tenant-", n) {
+			t.Fatalf("event %d of %q", n, tenant)
+		}
+	}
+}
+
+func Test_EventContextCancelled(t *testing.T) {
+	sent := false
+	producer := gostage.WorkHandler(func(_ interface{}) (interface{}, error) {
+		if sent {
+			return nil, gostage.ErrNoData
+		}
+		sent = true
+		// the event's own context is never done
+		return gostage.WithEventContext(context.WithValue(context.Background(), tenantKey{}, "acme"), 1), nil
+	})
+	waiting := make(chan string, 1)
+	cancelled := make(chan error, 1)
+	sink := gostage.ContextHandler(func(ctx context.Context, _ interface{}) (interface{}, error) {
+		tenant, _ := ctx.Value(tenantKey{}).(string)
+		waiting <- tenant
+		<-ctx.Done()
+		cancelled <- ctx.Err()
+		return nil, nil
+	})
+	configs := []*gostage.Config{
+		{Name: "producer", Worker: producer},
+		{Name: "sink", Worker: sink, SubscribeToName: "producer"},
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	gs := gostage.New(ctx, configs, &recordingLogger{}, gostage.WithNoDataCountSleep(time.Millisecond))
+	done := make(chan struct{})
+	if err := gs.RunAsync(func() { close(done) }); err != nil {
+		t.Fatal(err)
+	}
+	if tenant := <-waiting; tenant != "acme" {
+		t.Fatalf("tenant %q", tenant)
+	}
+	cancel()
+	if err := <-cancelled; err != context.Canceled {
+		t.Fatalf("the event's context ended with %v", err)
+	}
+	<-done
+}
@@ -0,0 +1,132 @@
+package examples
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/qgymje/gostage"
+)
+
+// firehose always has an event with the given payload
+func firehose(payload string) gostage.WorkHandler {
+	return func(_ interface{}) (interface{}, error) {
+		return payload, nil
+	}
+}
+
+func Test_RoundRobinAcrossSources(t *testing.T) {
+	var handled atomic.Int64
+	// the data events handled when each control event was produced
+	controls := make(chan int)
+	var mu sync.Mutex
+	sentAt := map[int]int64{}
+	delays := map[int]int64{}
+	control := gostage.WorkHandler(func(_ interface{}) (interface{}, error) {
+		select {
+		case k := <-controls:
+			mu.Lock()
+			sentAt[k] = handled.Load()
+			mu.Unlock()
+			return k, nil
+		default:
+			return nil, gostage.ErrNoData
+		}
+	})
+	consumer := gostage.WorkHandler(func(in interface{}) (interface{}, error) {
+		time.Sleep(100 * time.Microsecond)
+		switch v := in.(type) {
+		case string:
+			handled.Add(1)
+		case int:
+			mu.Lock()
+			delays[v] = handled.Load() - sentAt[v]
+			mu.Unlock()
+		}
+		return nil, nil
+	})
+	configs := []*gostage.Config{
+		{Name: "data", Worker: firehose("data")},
+		{Name: "control", Worker: control},
+		{Name: "consumer", Worker: consumer, SubscribeToName: "data", BufferSize: 16, Queue: gostage.RoundRobinAcrossSources},
+	}
+	gs := gostage.New(context.Background(), configs, &recordingLogger{},
+		gostage.WithNoDataCountSleep(time.Millisecond))
+	done := make(chan struct{})
+	if err := gs.RunAsync(func() { close(done) }); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "the data source to saturate the consumer", func() bool { return handled.Load() > 50 })
+	for k := 1; k <= 5; k++ {
+		controls <- k
+		time.Sleep(5 * time.Millisecond)
+	}
+	waitFor(t, "the control events", func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(delays) == 5
+	})
+	gs.Stop()
+	<-done
+
+	for k, d := range delays {
+		// the data event handled, the one held for the consumer and the one before in turn
+		if d > 4 {
+			t.Errorf("control event %d waited for %d data events", k, d)
+		}
+	}
+	sources := gs.Stats().Stages[2].Sources
+	if sources["control"] != 5 {
+		t.Errorf("control delivered %d, want 5", sources["control"])
+	}
+	if sources["data"] < handled.Load() {
+		t.Errorf("data delivered %d, handled %d", sources["data"], handled.Load())
+	}
+}
+
+func Test_WeightedBySource(t *testing.T) {
+	var mu sync.Mutex
+	var seen []string
+	consumer := gostage.WorkHandler(func(in interface{}) (interface{}, error) {
+		time.Sleep(50 * time.Microsecond)
+		mu.Lock()
+		seen = append(seen, in.(string))
+		mu.Unlock()
+		return nil, nil
+	})
+	configs := []*gostage.Config{
+		{Name: "data", Worker: firehose("data")},
+		{Name: "control", Worker: firehose("control")},
+		{Name: "consumer", Worker: consumer, SubscribeToName: "data", BufferSize: 8,
+			Queue: gostage.WeightedBySource(map[string]int{"data": 3})},
+	}
+	gs := gostage.New(context.Background(), configs, &recordingLogger{})
+	done := make(chan struct{})
+	if err := gs.RunAsync(func() { close(done) }); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "the consumer to see 400 events", func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(seen) >= 400
+	})
+	gs.Stop()
+	<-done
+
+	mu.Lock()
+	defer mu.Unlock()
+	// both lanes are full once the sources have started
+	counts := map[string]int{}
+	for _, v := range seen[100:400] {
+		counts[v]++
+	}
+	if counts["data"] < 3*counts["control"]-6 || counts["data"] > 3*counts["control"]+6 {
+		t.Errorf("got %v, want 3 data events for each control one", counts)
+	}
+	sources := gs.Stats().Stages[2].Sources
+	if sources["data"] == 0 || sources["control"] == 0 || len(sources) != 2 {
+		t.Errorf("sources %v", sources)
+	}
+}
@@ -0,0 +1,95 @@
+package examples
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/qgymje/gostage"
+)
+
+// exitingLogger stands for a logger like logrus whose Fatal exits the process
+type exitingLogger struct {
+	recordingLogger
+	exited int32
+}
+
+func (l *exitingLogger) Fatal(format string, args ...interface{}) {
+	atomic.StoreInt32(&l.exited, 1)
+	l.record("Fatal", format, args...)
+}
+
+// panicCloser always panics and counts its Close calls
+type panicCloser struct {
+	closed *int32
+}
+
+func (c panicCloser) HandleEvent(in interface{}) (interface{}, error) {
+	panic("always")
+}
+
+func (c panicCloser) Close() {
+	atomic.AddInt32(c.closed, 1)
+}
+
+func Test_FatalDoesNotUseLogger(t *testing.T) {
+	producer := gostage.WorkHandler(func(_ interface{}) (interface{}, error) {
+		return 1, nil
+	})
+	var closed int32
+	configs := []*gostage.Config{
+		{Name: "producer", Worker: producer},
+		{Name: "consumer", Worker: panicCloser{closed: &closed}, SubscribeTo: producer, DisableRestart: true},
+	}
+
+	lg := &exitingLogger{}
+	gs := gostage.New(context.Background(), configs, lg)
+	var called int32
+	done := make(chan struct{})
+	if err := gs.RunAsync(func() {
+		atomic.StoreInt32(&called, 1)
+		close(done)
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-done:
+	case <-time.After(3 * time.Second):
+		t.Fatal("the done callback wasn't called")
+	}
+	if atomic.LoadInt32(&lg.exited) != 0 {
+		t.Fatal("the pipeline called Logger.Fatal")
+	}
+	if atomic.LoadInt32(&closed) != 1 {
+		t.Fatalf("worker closed %d times, want 1", closed)
+	}
+	if err := gs.Err(); !errors.Is(err, gostage.ErrSupervision) {
+		t.Fatalf("Err() = %v, want ErrSupervision", err)
+	}
+	if len(lg.find("[Error]gostage fatal error happened")) != 1 {
+		t.Fatal("the fatal error wasn't logged")
+	}
+}
+
+func Test_ErrIsNilWithoutFatal(t *testing.T) {
+	next := 0
+	producer := gostage.WorkHandler(func(_ interface{}) (interface{}, error) {
+		if next == 3 {
+			return nil, gostage.ErrQuit
+		}
+		next++
+		return next, nil
+	})
+	configs := []*gostage.Config{
+		{Name: "producer", Worker: producer},
+		{Name: "consumer", Worker: passThrough{}, SubscribeTo: producer},
+	}
+	gs := gostage.New(context.Background(), configs, &recordingLogger{})
+	gs.Run(func() {})
+	if err := gs.Err(); err != nil {
+		t.Fatalf("Err() = %v, want nil", err)
+	}
+}
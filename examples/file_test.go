@@ -0,0 +1,249 @@
+package examples
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/qgymje/gostage"
+)
+
+type fileRecord struct {
+	Name string `json:"name"`
+	N    int    `json:"n"`
+}
+
+// readLines returns the lines of the file at path
+func readLines(t *testing.T, path string) []string {
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var lines []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return lines
+}
+
+func writeLines(t *testing.T, path string, lines []string) {
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_JSONLinesRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	in, out := filepath.Join(dir, "in.jsonl"), filepath.Join(dir, "out.jsonl")
+	var input []string
+	for n := 1; n <= 5000; n++ {
+		input = append(input, fmt.Sprintf(`{"name":"item-%d","n":%d}`, n, n))
+		if n%1000 == 0 {
+			input = append(input, "")
+		}
+	}
+	writeLines(t, in, input)
+
+	source, err := gostage.FromJSONLines("read", in, func() interface{} { return &fileRecord{} })
+	if err != nil {
+		t.Fatal(err)
+	}
+	upper := gostage.WorkHandler(func(in interface{}) (interface{}, error) {
+		r := in.(*fileRecord)
+		r.Name = strings.ToUpper(r.Name)
+		return r, nil
+	})
+	sink, written, err := gostage.ToJSONLines("write", out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sink.SubscribeToName = "upper"
+	configs := []*gostage.Config{
+		source,
+		{Name: "upper", Worker: upper, SubscribeToName: "read", BufferSize: 64},
+		sink,
+	}
+	gs := gostage.New(context.Background(), configs, &recordingLogger{})
+	if err := gs.Run(func() {}); err != nil {
+		t.Fatal(err)
+	}
+
+	lines := readLines(t, out)
+	if len(lines) != 5000 || written.Written() != 5000 || written.Lost() != 0 || written.Err() != nil {
+		t.Fatalf("%d lines, %d written, %d lost: %v", len(lines), written.Written(), written.Lost(), written.Err())
+	}
+	for k, line := range lines {
+		var r fileRecord
+		if err := json.Unmarshal([]byte(line), &r); err != nil {
+			t.Fatal(err)
+		}
+		if r.N != k+1 || r.Name != fmt.Sprintf("ITEM-%d", k+1) {
+			t.Fatalf("line %d: %s", k+1, line)
+		}
+	}
+}
+
+func Test_CSVRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	in, out := filepath.Join(dir, "in.csv"), filepath.Join(dir, "out.csv")
+	input := []string{"Product Name;Qty"}
+	for n := 1; n <= 100; n++ {
+		input = append(input, fmt.Sprintf("widget %d;%d", n, n))
+	}
+	input = append(input, `"semi;colon";7`)
+	writeLines(t, in, input)
+
+	mapping := gostage.CSVMapping(map[string]string{"Product Name": "name", "Qty": "qty"})
+	source, err := gostage.FromCSV("read", in, mapping, gostage.CSVComma(';'))
+	if err != nil {
+		t.Fatal(err)
+	}
+	upper := gostage.WorkHandler(func(in interface{}) (interface{}, error) {
+		rec := in.(map[string]string)
+		rec["name"] = strings.ToUpper(rec["name"])
+		return rec, nil
+	})
+	sink, written, err := gostage.ToCSV("write", out, mapping, gostage.CSVComma(';'),
+		gostage.CSVColumns("Product Name", "Qty"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sink.SubscribeToName = "upper"
+	configs := []*gostage.Config{
+		source,
+		{Name: "upper", Worker: upper, SubscribeToName: "read"},
+		sink,
+	}
+	gs := gostage.New(context.Background(), configs, &recordingLogger{})
+	if err := gs.Run(func() {}); err != nil {
+		t.Fatal(err)
+	}
+
+	lines := readLines(t, out)
+	if len(lines) != len(input) || written.Written() != 101 {
+		t.Fatalf("%d lines, %d written, want %d", len(lines), written.Written(), len(input))
+	}
+	if lines[0] != input[0] || lines[1] != "WIDGET 1;1" || lines[101] != `"SEMI;COLON";7` {
+		t.Errorf("lines %q ... %q", lines[:2], lines[101])
+	}
+}
+
+func Test_FileSourceBadLines(t *testing.T) {
+	in := filepath.Join(t.TempDir(), "in.jsonl")
+	writeLines(t, in, []string{`{"name":"a","n":1}`, `{"name":`, `{"name":"c","n":3}`})
+	source, err := gostage.FromJSONLines("read", in, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sink := &collecting{}
+	var mu sync.Mutex
+	var errs []error
+	gs := gostage.New(context.Background(), []*gostage.Config{
+		source,
+		{Name: "sink", Worker: gostage.WorkHandler(func(in interface{}) (interface{}, error) {
+			return sink.HandleEvent(int((*in.(*map[string]interface{}))["n"].(float64)))
+		}), SubscribeToName: "read"},
+	}, &recordingLogger{}, gostage.WithOnError(func(se *gostage.StageError) {
+		mu.Lock()
+		errs = append(errs, se.Err)
+		mu.Unlock()
+	}))
+	if err := gs.Run(func() {}); err != nil {
+		t.Fatal(err)
+	}
+	if got := sink.sorted(); len(got) != 2 || got[0] != 1 || got[1] != 3 {
+		t.Errorf("sink got %v", got)
+	}
+	if len(errs) != 1 || !strings.Contains(errs[0].Error(), "in.jsonl:2: ") {
+		t.Errorf("errors %v", errs)
+	}
+}
+
+func Test_FileSourceRerun(t *testing.T) {
+	dir := t.TempDir()
+	jsonl, csvPath := filepath.Join(dir, "in.jsonl"), filepath.Join(dir, "in.csv")
+	writeLines(t, jsonl, []string{`{"name":"a","n":1}`, `{"name":"b","n":2}`, `{"name":"c","n":3}`})
+	writeLines(t, csvPath, []string{"name,n", "a,1", "b,2", "c,3"})
+	fromJSON, err := gostage.FromJSONLines("read", jsonl, func() interface{} { return &fileRecord{} })
+	if err != nil {
+		t.Fatal(err)
+	}
+	fromCSV, err := gostage.FromCSV("read", csvPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	name := func(v interface{}) string {
+		if r, ok := v.(*fileRecord); ok {
+			return r.Name
+		}
+		return v.(map[string]string)["name"]
+	}
+
+	for _, source := range []*gostage.Config{fromJSON, fromCSV} {
+		gs := gostage.New(context.Background(), []*gostage.Config{
+			source,
+			{Name: "sink", Worker: passThrough{}, SubscribeToName: "read"},
+		}, &recordingLogger{})
+		for run := 1; run <= 2; run++ {
+			results, err := gs.Collect(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			var names []string
+			for _, v := range results {
+				names = append(names, name(v))
+			}
+			if strings.Join(names, ",") != "a,b,c" {
+				t.Fatalf("run %d read %v", run, names)
+			}
+			if reason := gs.Reason(); !errors.Is(reason, gostage.ErrQuit) {
+				t.Fatalf("run %d stopped with %v, want ErrQuit", run, reason)
+			}
+			if errs := gs.Stats().Stages[0].Errors; errs != 0 {
+				t.Fatalf("run %d: %d errors", run, errs)
+			}
+		}
+	}
+}
+
+func Test_FileSinkWriteFailure(t *testing.T) {
+	if _, err := os.Stat("/dev/full"); err != nil {
+		t.Skip("no /dev/full")
+	}
+	// every write fails with ENOSPC
+	sink, written, err := gostage.ToJSONLines("write", "/dev/full")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sink.SubscribeToName = "producer"
+	configs := []*gostage.Config{
+		{Name: "producer", Worker: countdown(20000, func(n int) interface{} { return fileRecord{Name: "x", N: n} })},
+		sink,
+	}
+	gs := gostage.New(context.Background(), configs, &recordingLogger{})
+	if err := gs.Run(func() {}); err != nil {
+		t.Fatal(err)
+	}
+	if written.Written() != 0 || written.Lost() != 20000 || written.Err() == nil {
+		t.Errorf("%d written, %d lost: %v", written.Written(), written.Lost(), written.Err())
+	}
+	// the events after the failure are refused by the sink
+	if st := gs.Stats().Stages[1]; st.Errors == 0 || st.PermanentErrors == 0 {
+		t.Errorf("%d errors, %d permanent", st.Errors, st.PermanentErrors)
+	}
+	if written.Err() == nil || !strings.Contains(written.Err().Error(), "no space left") {
+		t.Errorf("err %v", written.Err())
+	}
+}
@@ -0,0 +1,66 @@
+package examples
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/qgymje/gostage"
+)
+
+func Test_SourceAndSinkHandlers(t *testing.T) {
+	next := 0
+	source := gostage.SourceHandler(func() (interface{}, error) {
+		if next == 5 {
+			return nil, gostage.ErrQuit
+		}
+		next++
+		return next, nil
+	})
+	sum := 0
+	sink := gostage.SinkHandler(func(in interface{}) error {
+		sum += in.(int)
+		return nil
+	})
+	configs := []*gostage.Config{
+		{Name: "source", Worker: source},
+		{Name: "sink", Worker: sink, SubscribeTo: source},
+	}
+
+	if err := gostage.New(context.Background(), configs, &recordingLogger{}).Run(func() {}); err != nil {
+		t.Fatal(err)
+	}
+	if sum != 15 {
+		t.Fatalf("sum = %d, want 15", sum)
+	}
+}
+
+func Test_MisplacedHandlers(t *testing.T) {
+	source := gostage.SourceHandler(func() (interface{}, error) {
+		return nil, gostage.ErrQuit
+	})
+	sink := gostage.SinkHandler(func(interface{}) error {
+		return nil
+	})
+	cases := []struct {
+		name    string
+		configs []*gostage.Config
+	}{
+		{"sink in the middle", []*gostage.Config{
+			{Name: "source", Worker: source},
+			{Name: "sink", Worker: sink, SubscribeTo: source},
+			{Name: "after sink", Worker: passThrough{}, SubscribeTo: sink},
+		}},
+		{"subscribed source", []*gostage.Config{
+			{Name: "producer", Worker: passThrough{}},
+			{Name: "source", Worker: source, SubscribeToName: "producer"},
+		}},
+	}
+
+	for _, c := range cases {
+		err := gostage.New(context.Background(), c.configs, &recordingLogger{}).Run(func() {})
+		if !errors.Is(err, gostage.ErrMisplacedStage) {
+			t.Fatalf("%s: Run error = %v, want ErrMisplacedStage", c.name, err)
+		}
+	}
+}
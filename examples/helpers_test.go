@@ -0,0 +1,65 @@
+package examples
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// waitFor polls cond until it returns true or fails the test after a few seconds
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(3 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timeout waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// recordingLogger keeps every log line in memory
+type recordingLogger struct {
+	mu    sync.Mutex
+	lines []string
+}
+
+func (l *recordingLogger) record(level, format string, args ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.lines = append(l.lines, "["+level+"]"+fmt.Sprintf(format, args...))
+}
+
+func (l *recordingLogger) Fatal(format string, args ...interface{}) {
+	l.record("Fatal", format, args...)
+}
+func (l *recordingLogger) Error(format string, args ...interface{}) {
+	l.record("Error", format, args...)
+}
+func (l *recordingLogger) Warn(format string, args ...interface{}) { l.record("Warn", format, args...) }
+func (l *recordingLogger) Info(format string, args ...interface{}) { l.record("Info", format, args...) }
+func (l *recordingLogger) Debug(format string, args ...interface{}) {
+	l.record("Debug", format, args...)
+}
+
+// find returns the lines containing all the given substrings
+func (l *recordingLogger) find(subs ...string) []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var found []string
+	for _, line := range l.lines {
+		ok := true
+		for _, sub := range subs {
+			if !strings.Contains(line, sub) {
+				ok = false
+				break
+			}
+		}
+		if ok {
+			found = append(found, line)
+		}
+	}
+	return found
+}
@@ -0,0 +1,87 @@
+package examples
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/qgymje/gostage"
+)
+
+// flakyStore is a KeyStore which can't be reached
+type flakyStore struct{}
+
+func (flakyStore) Seen(string) (bool, error) { return false, errors.New("unreachable") }
+func (flakyStore) Mark(string) error         { return errors.New("unreachable") }
+
+// idempotentPipeline delivers the orders 1..10 twice into a sink charging them
+func idempotentPipeline(store gostage.KeyStore, failure gostage.KeyStoreFailure) (*gostage.GoStage, map[int]int) {
+	var mu sync.Mutex
+	charged := map[int]int{}
+	sink := gostage.WorkHandler(func(in interface{}) (interface{}, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		charged[in.(int)]++
+		return nil, nil
+	})
+	configs := []*gostage.Config{
+		{Name: "producer", Worker: countdown(20, func(n int) interface{} { return n%10 + 1 })},
+		{Name: "sink", Worker: sink, SubscribeToName: "producer",
+			IdempotencyKey: func(in interface{}) string { return fmt.Sprint("order-", in) },
+			KeyStore:       store, KeyStoreFailure: failure},
+	}
+	return gostage.New(context.Background(), configs, &recordingLogger{}), charged
+}
+
+func Test_IdempotencyKey(t *testing.T) {
+	// a single instance, the redeliveries come once the first deliveries were handled
+	gs, charged := idempotentPipeline(nil, gostage.FailClosed)
+	if err := gs.Run(func() {}); err != nil {
+		t.Fatal(err)
+	}
+	if len(charged) != 10 {
+		t.Fatalf("charged %v", charged)
+	}
+	for order, n := range charged {
+		if n != 1 {
+			t.Fatalf("order %d charged %d times", order, n)
+		}
+	}
+	stats := gs.Stats()
+	if got := stats.Stages[1].Deduplicated; got != 10 {
+		t.Fatalf("%d events deduplicated, want 10", got)
+	}
+	if a := stats.Accounting; a.Skipped != 10 || a.Accounted() != a.Produced {
+		t.Fatalf("accounting %+v", a)
+	}
+}
+
+func Test_KeyStoreFailure(t *testing.T) {
+	gs, charged := idempotentPipeline(flakyStore{}, gostage.FailOpen)
+	if err := gs.Run(func() {}); err != nil {
+		t.Fatal(err)
+	}
+	// every delivery is handled
+	for order, n := range charged {
+		if n != 2 {
+			t.Fatalf("order %d charged %d times with fail-open", order, n)
+		}
+	}
+
+	gs, charged = idempotentPipeline(flakyStore{}, gostage.FailClosed)
+	if err := gs.Run(func() {}); err != nil {
+		t.Fatal(err)
+	}
+	if len(charged) != 0 {
+		t.Fatalf("charged %v with fail-closed", charged)
+	}
+	stats := gs.Stats()
+	if a := stats.Accounting; a.DeadLettered != 20 || a.Accounted() != a.Produced {
+		t.Fatalf("accounting %+v", a)
+	}
+	if got := stats.Stages[1].Errors; got != 20 {
+		t.Fatalf("%d errors, want 20", got)
+	}
+}
@@ -0,0 +1,64 @@
+package examples
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/qgymje/gostage"
+)
+
+func Test_Idle(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	next := 0
+	producer := gostage.WorkHandler(func(_ interface{}) (interface{}, error) {
+		if next == 10 {
+			return nil, gostage.ErrQuit
+		}
+		next++
+		return next, nil
+	})
+
+	var consumed int64
+	consumer := gostage.WorkHandler(func(in interface{}) (interface{}, error) {
+		time.Sleep(5 * time.Millisecond)
+		atomic.AddInt64(&consumed, 1)
+		return nil, nil
+	})
+
+	var onIdle int64
+	configs := []*gostage.Config{
+		{Name: "producer", Worker: producer},
+		{Name: "consumer", Worker: consumer, SubscribeTo: producer, BufferSize: 10},
+	}
+	gs := gostage.New(ctx, configs, gostage.NewStdLogger(), gostage.WithOnIdle(func() {
+		atomic.StoreInt64(&onIdle, atomic.LoadInt64(&consumed))
+	}))
+
+	idle := gs.Idle()
+	var atDone int64
+	done := make(chan struct{})
+	gs.RunAsync(func() {
+		atomic.StoreInt64(&atDone, atomic.LoadInt64(&consumed))
+		close(done)
+	})
+
+	select {
+	case <-idle:
+	case <-ctx.Done():
+		t.Fatal("pipeline never became idle")
+	}
+	if n := atomic.LoadInt64(&consumed); n != 10 {
+		t.Fatalf("idle fired after %d events, want 10", n)
+	}
+	<-done
+	if n := atomic.LoadInt64(&atDone); n != 10 {
+		t.Fatalf("pipeline stopped after %d events, want 10", n)
+	}
+	if n := atomic.LoadInt64(&onIdle); n != 10 {
+		t.Fatalf("OnIdle called after %d events, want 10", n)
+	}
+}
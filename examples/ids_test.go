@@ -0,0 +1,132 @@
+package examples
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/qgymje/gostage"
+)
+
+func Test_TimeOrderedIDs(t *testing.T) {
+	// two processes started within the same millisecond
+	clock := &manualClock{now: time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)}
+	seen := make(map[string]bool)
+	for restart := 0; restart < 2; restart++ {
+		gen := gostage.TimeOrderedIDs(clock)
+		var ids []string
+		for i := 0; i < 1000; i++ {
+			ids = append(ids, gen())
+		}
+		if !sort.StringsAreSorted(ids) {
+			t.Fatalf("the IDs of run %d aren't ordered", restart)
+		}
+		for _, id := range ids {
+			if len(id) != 26 || seen[id] {
+				t.Fatalf("ID %q is repeated or malformed", id)
+			}
+			seen[id] = true
+		}
+	}
+	later := gostage.TimeOrderedIDs(&manualClock{now: clock.now.Add(time.Millisecond)})()
+	for id := range seen {
+		if id >= later {
+			t.Fatalf("%s made earlier sorts after %s", id, later)
+		}
+	}
+}
+
+func Test_IDGeneratorsConcurrent(t *testing.T) {
+	for name, gen := range map[string]gostage.IDGenerator{
+		"counter":      gostage.CounterIDs(),
+		"time ordered": gostage.TimeOrderedIDs(nil),
+		"seeded":       gostage.SeededIDs(1),
+	} {
+		var mu sync.Mutex
+		var wg sync.WaitGroup
+		seen := make(map[string]bool)
+		for p := 0; p < 8; p++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for i := 0; i < 500; i++ {
+					id := gen()
+					mu.Lock()
+					seen[id] = true
+					mu.Unlock()
+				}
+			}()
+		}
+		wg.Wait()
+		if len(seen) != 8*500 {
+			t.Fatalf("%s made %d distinct IDs, want %d", name, len(seen), 8*500)
+		}
+	}
+}
+
+func Test_SeededIDs(t *testing.T) {
+	run := func(seed int64) []string {
+		var ids []string
+		tag := gostage.ContextHandler(func(ctx context.Context, in interface{}) (interface{}, error) {
+			id, _ := gostage.EventIDFromContext(ctx)
+			ids = append(ids, id)
+			return in, nil
+		})
+		configs := []*gostage.Config{
+			{Name: "producer", Worker: countdown(20, func(n int) interface{} { return n })},
+			{Name: "tag", Worker: tag, SubscribeToName: "producer"},
+		}
+		gs := gostage.New(context.Background(), configs, &recordingLogger{}, gostage.WithIDGenerator(gostage.SeededIDs(seed)))
+		if err := gs.Run(func() {}); err != nil {
+			t.Fatal(err)
+		}
+		return ids
+	}
+	first := run(42)
+	if len(first) != 20 {
+		t.Fatalf("%d IDs, want 20", len(first))
+	}
+	if again := run(42); !reflect.DeepEqual(first, again) {
+		t.Fatalf("the same seed gave %v then %v", first, again)
+	}
+	if other := run(43); reflect.DeepEqual(first, other) {
+		t.Fatal("another seed gave the same IDs")
+	}
+}
+
+func Test_CancelEventWithIDGenerator(t *testing.T) {
+	stuck := make(chan string, 1)
+	slow := gostage.ContextHandler(func(ctx context.Context, in interface{}) (interface{}, error) {
+		if in.(int) != 3 {
+			return in, nil
+		}
+		id, _ := gostage.EventIDFromContext(ctx)
+		stuck <- id
+		<-ctx.Done()
+		return nil, ctx.Err()
+	})
+	configs := []*gostage.Config{
+		{Name: "producer", Worker: countdown(5, func(n int) interface{} { return n })},
+		{Name: "slow", Worker: slow, SubscribeToName: "producer", BufferSize: 8},
+	}
+	gs := gostage.New(context.Background(), configs, &recordingLogger{},
+		gostage.WithIDGenerator(gostage.TimeOrderedIDs(nil)), gostage.WithStopMode(gostage.StopDrain))
+	done := make(chan struct{})
+	if err := gs.RunAsync(func() { close(done) }); err != nil {
+		t.Fatal(err)
+	}
+	id := <-stuck
+	if len(id) != 26 {
+		t.Fatalf("event ID %q", id)
+	}
+	if err := gs.CancelEvent(id); err != nil {
+		t.Fatal(err)
+	}
+	<-done
+	if got := gs.Stats().Stages[1].Cancelled; got != 1 {
+		t.Fatalf("%d events cancelled, want 1", got)
+	}
+}
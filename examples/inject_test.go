@@ -0,0 +1,107 @@
+package examples
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/qgymje/gostage"
+)
+
+// seenBy records the events a stage handles
+type seenBy struct {
+	mu   sync.Mutex
+	seen []interface{}
+}
+
+func (r *seenBy) HandleEvent(in interface{}) (interface{}, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.seen = append(r.seen, in)
+	return in, nil
+}
+
+func (r *seenBy) events() []interface{} {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]interface{}(nil), r.seen...)
+}
+
+func Test_Inject(t *testing.T) {
+	parse, enrich, store := &seenBy{}, &seenBy{}, &seenBy{}
+	configs := []*gostage.Config{
+		{Name: "producer", Worker: idle},
+		{Name: "parse", Worker: parse, SubscribeToName: "producer"},
+		{Name: "enrich", Worker: enrich, SubscribeToName: "parse"},
+		{Name: "store", Worker: store, SubscribeToName: "enrich"},
+	}
+	var audit bytes.Buffer
+	gs := gostage.New(context.Background(), configs, &recordingLogger{},
+		gostage.WithNoDataCountSleep(time.Millisecond), gostage.WithStopMode(gostage.StopDrain),
+		gostage.WithAuditLog(&audit, nil))
+	if err := gs.Inject("enrich", "backfill"); !errors.Is(err, gostage.ErrPipelineStopped) {
+		t.Fatalf("inject before the run: %v", err)
+	}
+	done := make(chan struct{})
+	if err := gs.RunAsync(func() { close(done) }); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := gs.Inject("enrich", "backfill"); err != nil {
+		t.Fatal(err)
+	}
+	if err := gs.Inject("producer", "nope"); !errors.Is(err, gostage.ErrInjectIntoProducer) {
+		t.Fatalf("inject into the producer: %v", err)
+	}
+	if err := gs.Inject("missing", "nope"); !errors.Is(err, gostage.ErrUnknownStage) {
+		t.Fatalf("inject into an unknown stage: %v", err)
+	}
+	waitFor(t, "the event to be stored", func() bool { return len(store.events()) == 1 })
+	if err := gs.StopStage("store"); err != nil {
+		t.Fatal(err)
+	}
+	if err := gs.Inject("store", "nope"); !errors.Is(err, gostage.ErrStageStopped) {
+		t.Fatalf("inject into a stopped stage: %v", err)
+	}
+	if err := gs.StartStage("store"); err != nil {
+		t.Fatal(err)
+	}
+	gs.Stop()
+	<-done
+	if err := gs.Inject("enrich", "late"); !errors.Is(err, gostage.ErrPipelineStopped) {
+		t.Fatalf("inject after the run: %v", err)
+	}
+
+	if got := parse.events(); len(got) != 0 {
+		t.Fatalf("parse saw %v upstream of the injection", got)
+	}
+	for _, r := range []*seenBy{enrich, store} {
+		if got := r.events(); len(got) != 1 || got[0] != "backfill" {
+			t.Fatalf("a stage downstream saw %v", got)
+		}
+	}
+	stats := gs.Stats()
+	if st := stats.Stages[2]; st.Processed != 1 || st.Injected != 1 {
+		t.Fatalf("enrich processed %d, %d injected", st.Processed, st.Injected)
+	}
+	if a := stats.Accounting; a.Produced != 1 || a.Completed != 1 {
+		t.Fatalf("accounting %+v", a)
+	}
+
+	var records []gostage.AuditRecord
+	for _, line := range strings.Split(strings.TrimSpace(audit.String()), "\n") {
+		var r gostage.AuditRecord
+		if err := json.Unmarshal([]byte(line), &r); err != nil {
+			t.Fatal(err)
+		}
+		records = append(records, r)
+	}
+	if len(records) != 2 || !records[0].Injected || !records[1].Injected {
+		t.Fatalf("audit records %+v, want two injected ones", records)
+	}
+}
@@ -0,0 +1,75 @@
+package examples
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/qgymje/gostage"
+)
+
+type shard struct {
+	mu      *sync.Mutex
+	seen    map[int]int
+	created []int
+
+	instance int
+	total    int
+}
+
+func (s *shard) CreateWithInfo(stage string, instance, total int) gostage.Worker {
+	s.mu.Lock()
+	s.created = append(s.created, instance)
+	s.mu.Unlock()
+	return &shard{mu: s.mu, seen: s.seen}
+}
+
+func (s *shard) SetInstanceInfo(stage string, instance, total int) {
+	s.instance = instance
+	s.total = total
+}
+
+func (s *shard) HandleEvent(in interface{}) (interface{}, error) {
+	s.mu.Lock()
+	s.seen[s.instance] = s.total
+	s.mu.Unlock()
+	// give the other instances a chance to pick up events
+	time.Sleep(time.Millisecond)
+	return nil, nil
+}
+
+func Test_InstanceInfo(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	producer := gostage.WorkHandler(func(_ interface{}) (interface{}, error) {
+		return 1, nil
+	})
+	sh := &shard{mu: &sync.Mutex{}, seen: map[int]int{}}
+
+	configs := []*gostage.Config{
+		{Name: "producer", Worker: producer},
+		{Name: "shard", Worker: sh, SubscribeTo: producer, Size: 3},
+	}
+	gs := gostage.New(ctx, configs, gostage.NewStdLogger())
+
+	done := make(chan struct{})
+	gs.RunAsync(func() { close(done) })
+	waitFor(t, "all instances", func() bool {
+		sh.mu.Lock()
+		defer sh.mu.Unlock()
+		return len(sh.seen) == 3
+	})
+	cancel()
+	<-done
+
+	for i := 0; i < 3; i++ {
+		if sh.seen[i] != 3 {
+			t.Fatalf("instance %d reported total %d, want 3", i, sh.seen[i])
+		}
+	}
+	if len(sh.created) != 2 || sh.created[0] != 1 || sh.created[1] != 2 {
+		t.Fatalf("CreateWithInfo called for %v, want [1 2]", sh.created)
+	}
+}
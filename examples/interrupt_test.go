@@ -0,0 +1,125 @@
+package examples
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/qgymje/gostage"
+)
+
+// rowBatch loops over a huge batch, polling ShouldStop, the first time only
+type rowBatch struct {
+	token   gostage.StopToken
+	started chan struct{}
+	once    sync.Once
+	rows    atomic.Int64
+	long    atomic.Bool
+}
+
+func (b *rowBatch) SetStopToken(t gostage.StopToken) {
+	b.token = t
+}
+
+func (b *rowBatch) HandleEvent(in interface{}) (interface{}, error) {
+	if !b.long.CompareAndSwap(true, false) {
+		return in, nil
+	}
+	b.once.Do(func() { close(b.started) })
+	for row := 0; row < 1e9; row++ {
+		if gostage.ShouldStop(b.token) {
+			return nil, gostage.ErrInterrupted
+		}
+		b.rows.Add(1)
+		time.Sleep(10 * time.Microsecond)
+	}
+	return in, nil
+}
+
+func Test_ShouldStop(t *testing.T) {
+	batch := &rowBatch{started: make(chan struct{})}
+	batch.long.Store(true)
+	var received atomic.Int64
+	sink := gostage.WorkHandler(func(in interface{}) (interface{}, error) {
+		received.Add(int64(in.(int)))
+		return nil, nil
+	})
+	configs := []*gostage.Config{
+		{Name: "producer", Worker: countdown(1, func(n int) interface{} { return n })},
+		{Name: "batch", Worker: batch, SubscribeToName: "producer", Redeliver: 1},
+		{Name: "sink", Worker: sink, SubscribeToName: "batch"},
+	}
+	logger := &recordingLogger{}
+	gs := gostage.New(context.Background(), configs, logger)
+	done := make(chan struct{})
+	if err := gs.RunAsync(func() { close(done) }); err != nil {
+		t.Fatal(err)
+	}
+	<-batch.started
+	start := time.Now()
+	gs.Stop()
+	<-done
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("stopped after %v", elapsed)
+	}
+	if batch.rows.Load() == 0 || received.Load() != 0 {
+		t.Fatalf("handled %d rows, received %d", batch.rows.Load(), received.Load())
+	}
+	stats := gs.Stats()
+	if stats.Stages[1].Interrupted != 1 || stats.Stages[1].Errors != 0 || stats.Accounting.DroppedInFlight != 1 {
+		t.Fatalf("stats %+v", stats)
+	}
+	// not a failure
+	if lines := logger.find("[Error]batch"); len(lines) != 0 {
+		t.Fatalf("logged %q", lines)
+	}
+
+	// delivered again in the next run, the producer has nothing left
+	if err := gs.Run(func() {}); err != nil {
+		t.Fatal(err)
+	}
+	if received.Load() != 1 {
+		t.Fatalf("received %d after the next run, want 1", received.Load())
+	}
+	if a := gs.Stats().Accounting; a.Produced != 1 || a.Completed != 1 {
+		t.Fatalf("next run accounted %+v", a)
+	}
+}
+
+func Test_ShouldStopContext(t *testing.T) {
+	started := make(chan struct{})
+	var once sync.Once
+	long := gostage.ContextHandler(func(ctx context.Context, in interface{}) (interface{}, error) {
+		token, ok := gostage.StopTokenFromContext(ctx)
+		if !ok {
+			t.Error("no stop token")
+		}
+		once.Do(func() { close(started) })
+		<-token.Done()
+		return nil, gostage.ErrInterrupted
+	})
+	configs := []*gostage.Config{
+		{Name: "producer", Worker: countdown(1, func(n int) interface{} { return n })},
+		{Name: "long", Worker: long, SubscribeToName: "producer"},
+	}
+	gs := gostage.New(context.Background(), configs, &recordingLogger{})
+	done := make(chan struct{})
+	if err := gs.RunAsync(func() { close(done) }); err != nil {
+		t.Fatal(err)
+	}
+	<-started
+	gs.Stop()
+	<-done
+	// without Redeliver the event is dropped
+	if a := gs.Stats().Accounting; a.DroppedInFlight != 1 || a.Accounted() != a.Produced {
+		t.Fatalf("accounted %+v", a)
+	}
+	if err := gs.Run(func() {}); err != nil {
+		t.Fatal(err)
+	}
+	if a := gs.Stats().Accounting; a.Produced != 0 {
+		t.Fatalf("next run accounted %+v", a)
+	}
+}
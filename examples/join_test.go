@@ -0,0 +1,145 @@
+package examples
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/qgymje/gostage"
+)
+
+type purchase struct {
+	ID    string
+	Total int
+}
+
+type payment struct {
+	OrderID string
+	Amount  int
+}
+
+// emit produces the events then idles
+func emit(events ...interface{}) gostage.WorkHandler {
+	next := 0
+	return func(_ interface{}) (interface{}, error) {
+		if next == len(events) {
+			return nil, gostage.ErrNoData
+		}
+		next++
+		return events[next-1], nil
+	}
+}
+
+type joinedSink struct {
+	mu     sync.Mutex
+	joined map[string]gostage.Joined
+}
+
+func (s *joinedSink) HandleEvent(in interface{}) (interface{}, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	j := in.(gostage.Joined)
+	s.joined[j.Key] = j
+	return nil, nil
+}
+
+func (s *joinedSink) count() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.joined)
+}
+
+func joinPipeline(clock *fakeClock, opts ...gostage.JoinOption) (*gostage.GoStage, *gostage.Joiner, *joinedSink) {
+	// o2 and o4 are never paid, o6 is paid but unknown
+	orders := emit(purchase{"o1", 10}, purchase{"o2", 20}, purchase{"o3", 30}, purchase{"o4", 40}, purchase{"o5", 50})
+	payments := emit(payment{"o5", 50}, payment{"o6", 60}, payment{"o1", 10}, payment{"o3", 30})
+	join, joiner := gostage.Join("join", "payments", "orders", func(in interface{}) string {
+		switch v := in.(type) {
+		case purchase:
+			return v.ID
+		case payment:
+			return v.OrderID
+		}
+		return ""
+	}, opts...)
+	sink := &joinedSink{joined: map[string]gostage.Joined{}}
+	configs := []*gostage.Config{
+		{Name: "orders", Worker: orders},
+		{Name: "payments", Worker: payments},
+		join,
+		{Name: "sink", Worker: sink, SubscribeToName: "join"},
+	}
+	gs := gostage.New(context.Background(), configs, &recordingLogger{},
+		gostage.WithClock(clock), gostage.WithNoDataCountSleep(time.Millisecond), gostage.WithStopMode(gostage.StopDrain))
+	return gs, joiner, sink
+}
+
+func Test_Join(t *testing.T) {
+	for _, unmatched := range []gostage.Unmatched{gostage.DropUnmatched, gostage.EmitUnmatched} {
+		clock := newFakeClock()
+		expiry := clock.Now().Add(time.Minute)
+		gs, joiner, sink := joinPipeline(clock, gostage.WithJoinTTL(time.Minute), gostage.WithUnmatched(unmatched))
+		done := make(chan struct{})
+		if err := gs.RunAsync(func() { close(done) }); err != nil {
+			t.Fatal(err)
+		}
+		waitFor(t, "the matches", func() bool { return sink.count() == 3 && joiner.Pending() == 3 })
+		for _, id := range []string{"o1", "o3", "o5"} {
+			j := sink.joined[id]
+			// payments is the left side
+			if p, o := j.Left.(payment), j.Right.(purchase); p.OrderID != id || o.ID != id || p.Amount != o.Total {
+				t.Fatalf("joined %+v", j)
+			}
+		}
+
+		waitFor(t, "the TTL timer", func() bool { return clock.pending(expiry) })
+		clock.Advance(time.Minute)
+		waitFor(t, "the orphans", func() bool { return joiner.Pending() == 0 })
+		if got := joiner.Unmatched(); got != 3 {
+			t.Fatalf("%d unmatched events, want 3", got)
+		}
+		if err := gs.Stop(); err != nil {
+			t.Fatal(err)
+		}
+		<-done
+
+		var keys []string
+		for key := range sink.joined {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		a := gs.Stats().Accounting
+		switch unmatched {
+		case gostage.DropUnmatched:
+			if len(keys) != 3 || a.Completed != 3 || a.Skipped != 6 {
+				t.Fatalf("dropped the orphans: emitted %v, accounting %+v", keys, a)
+			}
+		case gostage.EmitUnmatched:
+			if len(keys) != 6 || a.Completed != 6 || a.Skipped != 3 {
+				t.Fatalf("emitted the orphans: emitted %v, accounting %+v", keys, a)
+			}
+			if sink.joined["o2"].Left != nil || sink.joined["o4"].Right.(purchase).ID != "o4" || sink.joined["o6"].Right != nil {
+				t.Fatalf("orphans %+v, %+v and %+v", sink.joined["o2"], sink.joined["o4"], sink.joined["o6"])
+			}
+		}
+		if a.Accounted() != a.Produced || a.Produced != 9 {
+			t.Fatalf("accounting %+v", a)
+		}
+	}
+}
+
+func Test_JoinValidation(t *testing.T) {
+	join, _ := gostage.Join("join", "orders", "refunds", func(interface{}) string { return "" })
+	configs := []*gostage.Config{
+		{Name: "orders", Worker: emit()},
+		join,
+		{Name: "refunds", Worker: passThrough{}, SubscribeToName: "join"},
+	}
+	gs := gostage.New(context.Background(), configs, &recordingLogger{})
+	if err := gs.Run(func() {}); !errors.Is(err, gostage.ErrInvalidJoin) {
+		t.Fatalf("joined a stage which isn't a producer: %v", err)
+	}
+}
@@ -0,0 +1,95 @@
+package examples
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/qgymje/gostage"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files")
+
+func Test_JSONSchema(t *testing.T) {
+	clock := &manualClock{now: time.Unix(0, 0)}
+	sink := gostage.WorkHandler(func(in interface{}) (interface{}, error) {
+		clock.Add(time.Millisecond)
+		if in.(int) == 3 {
+			return nil, gostage.ErrNoData
+		}
+		return nil, nil
+	})
+	configs := []*gostage.Config{
+		{Name: "producer", Worker: countdown(5, func(n int) interface{} { return n })},
+		{Name: "double", Worker: passThrough{}, SubscribeToName: "producer"},
+		{Name: "sink", Worker: sink, SubscribeToName: "double"},
+	}
+	gs := gostage.New(context.Background(), configs, &recordingLogger{}, gostage.WithClock(clock),
+		gostage.WithMetadata(map[string]string{"version": "1.0.0"}))
+	if err := gs.Run(func() {}); err != nil {
+		t.Fatal(err)
+	}
+
+	rec := httptest.NewRecorder()
+	gs.StatusHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	var doc struct {
+		Stats  json.RawMessage `json:"stats"`
+		Report json.RawMessage `json:"report"`
+		Status json.RawMessage `json:"status"`
+	}
+	var err error
+	if doc.Stats, err = json.Marshal(gs.Stats()); err != nil {
+		t.Fatal(err)
+	}
+	if doc.Report, err = json.Marshal(gs.Report()); err != nil {
+		t.Fatal(err)
+	}
+	doc.Status = bytes.TrimSpace(rec.Body.Bytes())
+
+	// the JSON decodes back into the types
+	var stats gostage.Stats
+	var report gostage.Report
+	var status gostage.Status
+	for _, v := range []struct {
+		data []byte
+		into interface{}
+	}{{doc.Stats, &stats}, {doc.Report, &report}, {doc.Status, &status}} {
+		if err := json.Unmarshal(v.data, v.into); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var names []string
+	for _, st := range stats.Stages {
+		names = append(names, st.Name)
+	}
+	if len(names) != 3 || names[0] != "producer" || names[1] != "double" || names[2] != "sink" {
+		t.Errorf("stages %v, want them in topological order", names)
+	}
+	if report.WallTime != 5*time.Millisecond || report.Stages[2].Out != 4 || status.Stages[1].Processed != 5 {
+		t.Errorf("report %+v, status %+v", report, status)
+	}
+
+	got, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	golden := filepath.Join("testdata", "stats.golden.json")
+	if *update {
+		if err := os.WriteFile(golden, append(got, '\n'), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want, err := os.ReadFile(golden)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(bytes.TrimSpace(want), got) {
+		t.Errorf("the JSON changed, run the test with -update if it's on purpose:\n%s", got)
+	}
+}
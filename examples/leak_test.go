@@ -0,0 +1,61 @@
+package examples
+
+import (
+	"context"
+	"runtime"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/qgymje/gostage"
+)
+
+type passThrough struct{}
+
+func (passThrough) Create() gostage.Worker {
+	return passThrough{}
+}
+
+func (passThrough) HandleEvent(in interface{}) (interface{}, error) {
+	return in, nil
+}
+
+type counting struct {
+	count *int64
+}
+
+func (c counting) Create() gostage.Worker {
+	return c
+}
+
+func (c counting) HandleEvent(in interface{}) (interface{}, error) {
+	atomic.AddInt64(c.count, 1)
+	return nil, nil
+}
+
+func Test_NoGoroutineLeak(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	before := runtime.NumGoroutine()
+
+	var consumed int64
+	producer, middle, consumer := ones{}, passThrough{}, counting{count: &consumed}
+	configs := []*gostage.Config{
+		{Name: "producer", Worker: producer, Size: 5},
+		{Name: "middle", Worker: middle, SubscribeTo: producer, Size: 5},
+		{Name: "consumer", Worker: consumer, SubscribeTo: middle, Size: 5},
+	}
+	gs := gostage.New(ctx, configs, gostage.NewStdLogger(), gostage.WithMaxEvents(500))
+
+	done := make(chan struct{})
+	gs.RunAsync(func() { close(done) })
+	<-done
+
+	if n := atomic.LoadInt64(&consumed); n != 500 {
+		t.Fatalf("consumed %d events, want 500", n)
+	}
+	waitFor(t, "goroutines to exit", func() bool {
+		return runtime.NumGoroutine() <= before
+	})
+}
@@ -0,0 +1,165 @@
+package examples
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/qgymje/gostage"
+)
+
+type lifecycleObserver struct {
+	gostage.BaseObserver
+	mu     sync.Mutex
+	events []gostage.LifecycleEvent
+}
+
+func (o *lifecycleObserver) OnLifecycle(ev gostage.LifecycleEvent) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.events = append(o.events, ev)
+}
+
+func lifecyclePipeline(logger *recordingLogger, observer *lifecycleObserver, opts ...gostage.Option) *gostage.GoStage {
+	configs := []*gostage.Config{
+		{Name: "producer", Worker: countdown(5, func(n int) interface{} { return n })},
+		{Name: "double", Size: 2, Worker: passThrough{}, SubscribeToName: "producer"},
+		{Name: "sink", Worker: passThrough{}, SubscribeToName: "double"},
+	}
+	opts = append(opts, gostage.WithObserver(observer))
+	return gostage.New(context.Background(), configs, logger, opts...)
+}
+
+func Test_LifecycleLogging(t *testing.T) {
+	logger, observer := &recordingLogger{}, &lifecycleObserver{}
+	if err := lifecyclePipeline(logger, observer).Run(func() {}); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{
+		"[Info]gostage starting: 3 stages: producer(1) double(2) sink(1)",
+		// downstream first, so that no stage sends to one not running yet
+		"[Info]gostage stage sink started: 1 instances",
+		"[Info]gostage stage double started: 2 instances",
+		"[Info]gostage stage producer started: 1 instances",
+		"[Info]gostage producers started: producer",
+		"[Info]gostage drain started:",
+		"[Info]gostage drain finished: 0 events in flight",
+		"[Info]gostage stopping:",
+		"[Info]gostage stage producer stopped:",
+		"[Info]gostage stage double stopped: 5 events processed",
+		"[Info]gostage stage sink stopped: 5 events processed",
+		"[Info]gostage pipeline stopped after",
+	}
+	var lines []string
+	for _, line := range logger.find("[Info]gostage ") {
+		if !strings.HasPrefix(line, "[Info]gostage stopped:") {
+			lines = append(lines, line)
+		}
+	}
+	if len(lines) != len(want) {
+		t.Fatalf("logged %q", lines)
+	}
+	for k, line := range lines {
+		if !strings.HasPrefix(line, want[k]) {
+			t.Fatalf("line %d is %q, want %q", k, line, want[k])
+		}
+	}
+
+	// the observer is told about the same steps
+	waitFor(t, "the pipeline stopped event", func() bool {
+		observer.mu.Lock()
+		defer observer.mu.Unlock()
+		return len(observer.events) == len(want)
+	})
+	kinds := []gostage.LifecycleKind{
+		gostage.PipelineStarting, gostage.StageStarted, gostage.StageStarted, gostage.StageStarted,
+		gostage.ProducersStarted, gostage.DrainStarted, gostage.DrainFinished, gostage.PipelineStopping,
+		gostage.StageStopped, gostage.StageStopped, gostage.StageStopped, gostage.PipelineStopped,
+	}
+	observer.mu.Lock()
+	defer observer.mu.Unlock()
+	var got []gostage.LifecycleKind
+	for k, ev := range observer.events {
+		got = append(got, ev.Kind)
+		if "[Info]"+ev.Message != lines[k] {
+			t.Fatalf("event %+v, logged %q", ev, lines[k])
+		}
+	}
+	if !reflect.DeepEqual(got, kinds) {
+		t.Fatalf("lifecycle %v, want %v", got, kinds)
+	}
+	if observer.events[1].Stage != "sink" || observer.events[10].Stage != "sink" || observer.events[0].Stage != "" {
+		t.Fatalf("stages of %+v", observer.events)
+	}
+}
+
+func Test_LifecycleLoggingOff(t *testing.T) {
+	logger, observer := &recordingLogger{}, &lifecycleObserver{}
+	if err := lifecyclePipeline(logger, observer, gostage.WithLifecycleLogging(false)).Run(func() {}); err != nil {
+		t.Fatal(err)
+	}
+	if lines := logger.find("gostage stage "); len(lines) != 0 {
+		t.Fatalf("logged %q", lines)
+	}
+	// the summary is still logged
+	if lines := logger.find("[Info]gostage stopped:"); len(lines) != 1 {
+		t.Fatalf("logged %q", lines)
+	}
+	waitFor(t, "the lifecycle events", func() bool {
+		observer.mu.Lock()
+		defer observer.mu.Unlock()
+		return len(observer.events) == 12
+	})
+}
+
+// slowObserver takes a while for every event and records whether it was ever called concurrently
+type slowObserver struct {
+	lifecycleObserver
+	busy       atomic.Int32
+	concurrent atomic.Bool
+}
+
+func (o *slowObserver) OnLifecycle(ev gostage.LifecycleEvent) {
+	if o.busy.Add(1) > 1 {
+		o.concurrent.Store(true)
+	}
+	defer o.busy.Add(-1)
+	time.Sleep(time.Millisecond)
+	o.lifecycleObserver.OnLifecycle(ev)
+}
+
+func Test_SlowObserverKeepsOrder(t *testing.T) {
+	// far more notifications than the hooks can keep up with
+	configs := []*gostage.Config{{Name: "producer", Worker: countdown(1, func(n int) interface{} { return n })}}
+	for k := 0; k < 40; k++ {
+		configs = append(configs, &gostage.Config{Name: fmt.Sprintf("stage%d", k), Worker: passThrough{}, SubscribeToName: configs[k].Name})
+	}
+	logger, observer := &recordingLogger{}, &slowObserver{}
+	gs := gostage.New(context.Background(), configs, logger, gostage.WithObserver(observer))
+	if err := gs.Run(func() {}); err != nil {
+		t.Fatal(err)
+	}
+	if observer.concurrent.Load() {
+		t.Fatal("the observer was called concurrently")
+	}
+	if gs.Stats().DroppedNotifications == 0 {
+		t.Fatal("no notification was dropped")
+	}
+	// what the observer got is in the order it was logged
+	logged := logger.find("[Info]gostage ")
+	k := 0
+	for _, ev := range observer.events {
+		for k < len(logged) && logged[k] != "[Info]"+ev.Message {
+			k++
+		}
+		if k == len(logged) {
+			t.Fatalf("%q came out of order", ev.Message)
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package examples
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/qgymje/gostage"
+)
+
+type ones struct{}
+
+func (ones) Create() gostage.Worker {
+	return ones{}
+}
+
+func (ones) HandleEvent(_ interface{}) (interface{}, error) {
+	return 1, nil
+}
+
+func countingPipeline(consumed *int64) []*gostage.Config {
+	producer := ones{}
+	consumer := gostage.WorkHandler(func(in interface{}) (interface{}, error) {
+		atomic.AddInt64(consumed, 1)
+		return nil, nil
+	})
+	return []*gostage.Config{
+		{Name: "producer", Worker: producer, Size: 3},
+		{Name: "consumer", Worker: consumer, SubscribeTo: producer, BufferSize: 4},
+	}
+}
+
+func Test_MaxEvents(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var consumed int64
+	gs := gostage.New(ctx, countingPipeline(&consumed), gostage.NewStdLogger(),
+		gostage.WithMaxEvents(1000),
+		gostage.WithMaxRuntime(time.Minute),
+	)
+	gs.Run(func() {})
+
+	if n := atomic.LoadInt64(&consumed); n != 1000 {
+		t.Fatalf("consumed %d events, want 1000", n)
+	}
+	if err := gs.Reason(); !errors.Is(err, gostage.ErrMaxEvents) {
+		t.Fatalf("reason = %v, want ErrMaxEvents", err)
+	}
+}
+
+func Test_MaxRuntime(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var consumed int64
+	gs := gostage.New(ctx, countingPipeline(&consumed), gostage.NewStdLogger(),
+		gostage.WithMaxEvents(1<<62),
+		gostage.WithMaxRuntime(30*time.Millisecond),
+	)
+	start := time.Now()
+	gs.Run(func() {})
+
+	if d := time.Since(start); d > time.Second {
+		t.Fatalf("pipeline ran for %v", d)
+	}
+	if err := gs.Reason(); !errors.Is(err, gostage.ErrMaxRuntime) {
+		t.Fatalf("reason = %v, want ErrMaxRuntime", err)
+	}
+	if consumed == 0 {
+		t.Fatal("no events consumed")
+	}
+}
+
+func Test_ContextDeadlineReason(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Millisecond)
+	defer cancel()
+
+	var consumed int64
+	gs := gostage.New(ctx, countingPipeline(&consumed), gostage.NewStdLogger(),
+		gostage.WithMaxRuntime(time.Minute),
+	)
+	gs.Run(func() {})
+
+	if err := gs.Reason(); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("reason = %v, want context.DeadlineExceeded", err)
+	}
+}
@@ -0,0 +1,88 @@
+package examples
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/qgymje/gostage"
+)
+
+// kinds counts the lifecycle events of the given kind
+func (o *lifecycleObserver) kinds(kind gostage.LifecycleKind) int {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	n := 0
+	for _, ev := range o.events {
+		if ev.Kind == kind {
+			n++
+		}
+	}
+	return n
+}
+
+func Test_MemoryLimit(t *testing.T) {
+	var used atomic.Uint64
+	used.Store(100)
+	var produced atomic.Int64
+	producer := gostage.WorkHandler(func(_ interface{}) (interface{}, error) {
+		return produced.Add(1), nil
+	})
+	configs := []*gostage.Config{
+		{Name: "producer", Worker: producer},
+		{Name: "sink", Worker: passThrough{}, SubscribeToName: "producer"},
+	}
+	observer := &lifecycleObserver{}
+	gs := gostage.New(context.Background(), configs, &recordingLogger{},
+		gostage.WithMemoryLimit(1000, 2*time.Millisecond),
+		gostage.WithMemoryGauge(used.Load),
+		gostage.WithObserver(observer))
+	done := make(chan struct{})
+	if err := gs.RunAsync(func() { close(done) }); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "the producer to run", func() bool { return produced.Load() > 10 })
+
+	used.Store(2000)
+	waitFor(t, "the pushes to be held", func() bool { return gs.Stats().MemoryPressure == 2 })
+	paused := produced.Load()
+	// over the threshold but under the limit, still paused
+	used.Store(950)
+	time.Sleep(20 * time.Millisecond)
+	// the producer may have been in HandleEvent when it was paused
+	if n := produced.Load(); n > paused+1 {
+		t.Errorf("produced %d events while paused", n-paused)
+	}
+	if gs.TryPush(1) {
+		t.Error("TryPush got through while the pushes were held")
+	}
+
+	used.Store(500)
+	waitFor(t, "the producer to resume", func() bool { return produced.Load() > paused+10 })
+	if gs.Stats().MemoryPressure != 0 {
+		t.Errorf("pressure %d once relieved", gs.Stats().MemoryPressure)
+	}
+	if err := gs.PushWithTimeout(1, time.Second); err != nil {
+		t.Errorf("push once relieved: %v", err)
+	}
+
+	// stopping under pressure doesn't wait for the relief
+	used.Store(2000)
+	waitFor(t, "the pushes to be held again", func() bool { return gs.Stats().MemoryPressure == 2 })
+	pushed := make(chan error)
+	go func() { pushed <- gs.Push(context.Background(), 1) }()
+	gs.Stop()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the pipeline didn't stop under memory pressure")
+	}
+	if err := <-pushed; err == nil {
+		t.Error("the held push got through a stopped pipeline")
+	}
+
+	waitFor(t, "the observer", func() bool {
+		return observer.kinds(gostage.MemoryPressure) == 4 && observer.kinds(gostage.MemoryRelieved) == 1
+	})
+}
@@ -0,0 +1,102 @@
+package examples
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/qgymje/gostage"
+)
+
+func Test_Metadata(t *testing.T) {
+	producer := countdown(5, func(n int) interface{} { return n })
+	var mu sync.Mutex
+	var headers []map[string]string
+	var metadata map[string]string
+	sink := gostage.ContextHandler(func(ctx context.Context, in interface{}) (interface{}, error) {
+		h, _ := gostage.HeadersFromContext(ctx)
+		m, _ := gostage.MetadataFromContext(ctx)
+		mu.Lock()
+		headers = append(headers, h)
+		metadata = m
+		mu.Unlock()
+		return in, nil
+	})
+	configs := []*gostage.Config{
+		{Name: "producer", Worker: producer},
+		{Name: "sink", Worker: sink, SubscribeToName: "producer"},
+	}
+	meta := map[string]string{"version": "2.1.0", "sha": "9f8e7d"}
+	gs := gostage.New(context.Background(), configs, &recordingLogger{}, gostage.WithMetadata(meta))
+	// the pipeline keeps its own copy
+	meta["version"] = "changed"
+	if _, err := gs.Collect(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(headers) != 5 {
+		t.Fatalf("the sink saw %d events", len(headers))
+	}
+	for _, h := range headers {
+		if h["pipeline-version"] != "2.1.0" || h["pipeline-sha"] != "9f8e7d" {
+			t.Errorf("headers %v", h)
+		}
+	}
+	if metadata["version"] != "2.1.0" {
+		t.Errorf("metadata in the context %v", metadata)
+	}
+	r := gs.Report()
+	if r.Metadata["sha"] != "9f8e7d" || !strings.Contains(r.String(), "metadata sha=9f8e7d version=2.1.0") {
+		t.Errorf("report %v:\n%s", r.Metadata, r)
+	}
+
+	server := httptest.NewServer(gs.StatusHandler())
+	defer server.Close()
+	resp, err := http.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var status gostage.Status
+	if err := json.NewDecoder(resp.Body).Decode(&status); err != nil {
+		t.Fatal(err)
+	}
+	if status.State != "stopped" || status.Metadata["version"] != "2.1.0" || status.Accounting.Completed != 5 {
+		t.Errorf("status %+v", status)
+	}
+}
+
+func Test_SetMetadataWhileRunning(t *testing.T) {
+	configs := []*gostage.Config{
+		{Name: "producer", Worker: idle},
+		{Name: "sink", Worker: passThrough{}, SubscribeToName: "producer"},
+	}
+	gs := gostage.New(context.Background(), configs, &recordingLogger{},
+		gostage.WithMetadata(map[string]string{"version": "1"}),
+		gostage.WithNoDataCountSleep(time.Millisecond))
+	done := make(chan struct{})
+	if err := gs.RunAsync(func() { close(done) }); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "the pipeline to run", func() bool { return gs.State() == gostage.StateRunning })
+	if err := gs.SetMetadata(map[string]string{"version": "2"}); !errors.Is(err, gostage.ErrAlreadyRunning) {
+		t.Errorf("set while running: %v", err)
+	}
+	gs.Stop()
+	<-done
+	if got := gs.Metadata()["version"]; got != "1" {
+		t.Errorf("version %s, want 1", got)
+	}
+	if err := gs.SetMetadata(map[string]string{"version": "2"}); err != nil {
+		t.Fatal(err)
+	}
+	if got := gs.Metadata()["version"]; got != "2" {
+		t.Errorf("version %s, want 2", got)
+	}
+}
@@ -0,0 +1,154 @@
+package examples
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/qgymje/gostage"
+)
+
+func Test_PipelineName(t *testing.T) {
+	logger := &recordingLogger{}
+	var mu sync.Mutex
+	var stages []string
+	onError := gostage.WithOnError(func(err *gostage.StageError) {
+		mu.Lock()
+		defer mu.Unlock()
+		stages = append(stages, err.Stage)
+	})
+	failing := gostage.WorkHandler(func(in interface{}) (interface{}, error) {
+		return nil, errors.New("failed")
+	})
+	named := func(name string) *gostage.GoStage {
+		configs := []*gostage.Config{
+			{Name: "producer", Worker: countdown(3, func(n int) interface{} { return n })},
+			{Name: "consumer", Worker: failing, SubscribeToName: "producer"},
+		}
+		return gostage.New(context.Background(), configs, logger, gostage.WithName(name), onError)
+	}
+	orders, payments := named("orders"), named("payments")
+
+	var wg sync.WaitGroup
+	for _, gs := range []*gostage.GoStage{orders, payments} {
+		wg.Add(1)
+		go func(gs *gostage.GoStage) {
+			defer wg.Done()
+			if err := gs.Run(func() {}); err != nil {
+				t.Error(err)
+			}
+		}(gs)
+	}
+	wg.Wait()
+
+	// every message of the framework tells its pipeline
+	if len(logger.find()) == 0 {
+		t.Fatal("nothing logged")
+	}
+	for _, line := range logger.find() {
+		if !strings.Contains(line, "["+orders.Name()+"] ") && !strings.Contains(line, "["+payments.Name()+"] ") {
+			t.Fatalf("%q tells no pipeline", line)
+		}
+	}
+	for _, gs := range []*gostage.GoStage{orders, payments} {
+		if got := len(logger.find("[" + gs.Name() + "] consumer_#0 error")); got != 3 {
+			t.Fatalf("%d errors logged for %s, want 3", got, gs.Name())
+		}
+	}
+	counts := map[string]int{}
+	for _, stage := range stages {
+		counts[stage]++
+	}
+	if counts[orders.Name()+"/consumer"] != 3 || counts[payments.Name()+"/consumer"] != 3 {
+		t.Fatalf("errors by stage %v", counts)
+	}
+
+	// the names of the stopped pipelines are free again
+	for _, gs := range []*gostage.GoStage{orders, payments} {
+		reused := named(gs.Name())
+		if err := reused.Run(func() {}); err != nil {
+			t.Fatal(err)
+		}
+		if reused.Name() != gs.Name() {
+			t.Fatalf("%s is still taken after its pipeline stopped, renamed to %s", gs.Name(), reused.Name())
+		}
+	}
+}
+
+func Test_PipelineNameHeldWhileRunning(t *testing.T) {
+	logger := &recordingLogger{}
+	named := func(name string) *gostage.GoStage {
+		configs := []*gostage.Config{
+			{Name: "producer", Worker: idle},
+			{Name: "consumer", Worker: passThrough{}, SubscribeToName: "producer"},
+		}
+		return gostage.New(context.Background(), configs, logger, gostage.WithName(name),
+			gostage.WithNoDataCountSleep(time.Millisecond))
+	}
+
+	// a pipeline which never runs doesn't hold its name
+	for k := 0; k < 3; k++ {
+		if dropped := named("inventory"); dropped.Name() != "inventory" {
+			t.Fatalf("a new pipeline is named %s", dropped.Name())
+		}
+	}
+	if err := named("inventory").DryRun(); err != nil {
+		t.Fatal(err)
+	}
+
+	// a name held by a running pipeline gets a suffix, logged as a warning
+	first := named("inventory")
+	firstDone := make(chan struct{})
+	if err := first.RunAsync(func() { close(firstDone) }); err != nil {
+		t.Fatal(err)
+	}
+	if first.Name() != "inventory" {
+		t.Fatalf("the first running pipeline is named %s", first.Name())
+	}
+	again := named("inventory")
+	againDone := make(chan struct{})
+	if err := again.RunAsync(func() { close(againDone) }); err != nil {
+		t.Fatal(err)
+	}
+	if again.Name() != "inventory-2" {
+		t.Fatalf("renamed inventory to %s", again.Name())
+	}
+	if len(logger.find("[Warn][inventory-2] the pipeline name inventory is already used")) != 1 {
+		t.Fatalf("the rename isn't logged as a warning: %q", logger.find("[Warn]"))
+	}
+	first.Stop()
+	<-firstDone
+	again.Stop()
+	<-againDone
+
+	// and is free again once it has stopped
+	reused := named("inventory")
+	reusedDone := make(chan struct{})
+	if err := reused.RunAsync(func() { close(reusedDone) }); err != nil {
+		t.Fatal(err)
+	}
+	name := reused.Name()
+	reused.Stop()
+	<-reusedDone
+	if name != "inventory" {
+		t.Fatalf("inventory is still taken after its pipelines stopped, renamed to %s", name)
+	}
+}
+
+func Test_NamedPipelineWarns(t *testing.T) {
+	logger := &recordingLogger{}
+	configs := []*gostage.Config{
+		{Name: "producer", Worker: countdown(1, func(int) interface{} { return blob(1 << 20) })},
+		{Name: "parse", Worker: passThrough{}, SubscribeToName: "producer", MaxPayloadBytes: 1024},
+	}
+	gs := gostage.New(context.Background(), configs, logger, gostage.WithName("warnings"))
+	if err := gs.Run(func() {}); err != nil {
+		t.Fatal(err)
+	}
+	if len(logger.find("[Warn]["+gs.Name()+"] parse rejected event")) != 1 {
+		t.Fatalf("the warning of a named pipeline isn't logged at Warn: %v", logger.find("parse rejected"))
+	}
+}
@@ -0,0 +1,65 @@
+package examples
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+
+	"github.com/qgymje/gostage"
+)
+
+// sloppyPipeline emits 30 events, every third of them nil, into a consumer
+// which counts the nils it sees
+func sloppyPipeline(nils *int64, opts ...gostage.Option) *gostage.GoStage {
+	producer := countdown(30, func(n int) interface{} {
+		if n%3 == 0 {
+			return nil
+		}
+		return n
+	})
+	// asserts its input's type like most workers do
+	typed := gostage.WorkHandler(func(in interface{}) (interface{}, error) {
+		if in == nil {
+			atomic.AddInt64(nils, 1)
+			return nil, nil
+		}
+		return in.(int), nil
+	})
+	configs := []*gostage.Config{
+		{Name: "producer", Worker: producer},
+		{Name: "typed", Worker: typed, SubscribeToName: "producer"},
+		{Name: "sink", Worker: passThrough{}, SubscribeToName: "typed"},
+	}
+	return gostage.New(context.Background(), configs, &recordingLogger{}, opts...)
+}
+
+func Test_NilEvents(t *testing.T) {
+	var nils int64
+	gs := sloppyPipeline(&nils)
+	if err := gs.Run(func() {}); err != nil {
+		t.Fatal(err)
+	}
+	if nils != 0 {
+		t.Fatalf("the consumer saw %d nil events", nils)
+	}
+	stats := gs.Stats()
+	if skipped := stats.Stages[0].Skipped; skipped != 10 {
+		t.Fatalf("the producer skipped %d nil events, want 10", skipped)
+	}
+	if a := stats.Accounting; a.Produced != 20 || a.Completed != 20 {
+		t.Fatalf("accounting %+v", a)
+	}
+
+	nils = 0
+	gs = sloppyPipeline(&nils, gostage.WithForwardNil())
+	if err := gs.Run(func() {}); err != nil {
+		t.Fatal(err)
+	}
+	if nils != 10 {
+		t.Fatalf("the consumer saw %d nil events, want 10", nils)
+	}
+	// a middle stage returning nil forwards it too
+	if a := gs.Stats().Accounting; a.Completed != 30 {
+		t.Fatalf("accounting %+v", a)
+	}
+}
@@ -0,0 +1,75 @@
+package examples
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/qgymje/gostage"
+)
+
+func Test_NoDataFor(t *testing.T) {
+	clock := newFakeClock()
+	start := clock.Now()
+	var mu sync.Mutex
+	var calls []time.Duration
+	// the API asks to come back after 5s then 30s, then has an event
+	hints := []error{gostage.NoDataFor(5 * time.Second), gostage.NoDataFor(30 * time.Second), nil, gostage.ErrQuit}
+	producer := gostage.WorkHandler(func(interface{}) (interface{}, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		k := len(calls)
+		calls = append(calls, clock.Now().Sub(start))
+		if hints[k] == nil {
+			return k, nil
+		}
+		return nil, hints[k]
+	})
+	called := func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(calls)
+	}
+	configs := []*gostage.Config{
+		{Name: "producer", Worker: producer},
+		{Name: "sink", Worker: passThrough{}, SubscribeToName: "producer"},
+	}
+	// the counter heuristic would sleep after the first ErrNoData
+	gs := gostage.New(context.Background(), configs, &recordingLogger{}, gostage.WithClock(clock),
+		gostage.WithNoDataCount(1), gostage.WithNoDataCountSleep(time.Hour))
+	done := make(chan struct{})
+	if err := gs.RunAsync(func() { close(done) }); err != nil {
+		t.Fatal(err)
+	}
+
+	waitFor(t, "the first hint", func() bool { return clock.pending(start.Add(5 * time.Second)) })
+	clock.Advance(4 * time.Second)
+	time.Sleep(10 * time.Millisecond)
+	if called() != 1 {
+		t.Fatalf("called %d times before the hint was over", called())
+	}
+	clock.Advance(time.Second)
+	waitFor(t, "the second hint", func() bool { return clock.pending(start.Add(35 * time.Second)) })
+	clock.Advance(30 * time.Second)
+	<-done
+
+	mu.Lock()
+	defer mu.Unlock()
+	want := []time.Duration{0, 5 * time.Second, 35 * time.Second, 35 * time.Second}
+	if len(calls) != len(want) {
+		t.Fatalf("called at %v, want %v", calls, want)
+	}
+	for k := range want {
+		if calls[k] != want[k] {
+			t.Fatalf("called at %v, want %v", calls, want)
+		}
+	}
+	if a := gs.Stats().Accounting; a.Produced != 1 || a.Completed != 1 {
+		t.Fatalf("accounted %+v", a)
+	}
+	if err := gostage.NoDataFor(time.Second); !errors.Is(err, gostage.ErrNoData) {
+		t.Fatalf("%v isn't ErrNoData", err)
+	}
+}
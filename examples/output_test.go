@@ -0,0 +1,109 @@
+package examples
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/qgymje/gostage"
+)
+
+// outputPipeline doubles the numbers 1 to 10, its terminal stage returns them
+func outputPipeline(opts ...gostage.Option) *gostage.GoStage {
+	double := gostage.WorkHandler(func(in interface{}) (interface{}, error) {
+		return in.(int) * 2, nil
+	})
+	configs := []*gostage.Config{
+		{Name: "producer", Worker: countdown(10, func(n int) interface{} { return n })},
+		{Name: "double", Worker: double, SubscribeToName: "producer"},
+	}
+	return gostage.New(context.Background(), configs, &recordingLogger{}, opts...)
+}
+
+var doubled = []interface{}{2, 4, 6, 8, 10, 12, 14, 16, 18, 20}
+
+func Test_OutputDiscard(t *testing.T) {
+	gs := outputPipeline()
+	if err := gs.Run(func() {}); err != nil {
+		t.Fatal(err)
+	}
+	if gs.Output() != nil || gs.Results() != nil {
+		t.Fatalf("kept the outputs %v", gs.Results())
+	}
+	if processed := gs.Stats().Stages[1].Processed; processed != 10 {
+		t.Fatalf("%d events processed, want 10", processed)
+	}
+}
+
+func Test_OutputCollector(t *testing.T) {
+	gs := outputPipeline(gostage.WithOutput(gostage.ToCollector))
+	if err := gs.Run(func() {}); err != nil {
+		t.Fatal(err)
+	}
+	if results := gs.Results(); !reflect.DeepEqual(results, doubled) {
+		t.Fatalf("results %v, want %v", results, doubled)
+	}
+
+	// Collect gathers the outputs whatever the disposition
+	results, err := outputPipeline(gostage.WithOutput(gostage.ToOutputChannel)).Collect(context.Background())
+	if err != nil || !reflect.DeepEqual(results, doubled) {
+		t.Fatalf("collected %v, %v", results, err)
+	}
+}
+
+func Test_OutputChannel(t *testing.T) {
+	gs := outputPipeline(gostage.WithOutput(gostage.ToOutputChannel))
+	done := make(chan struct{})
+	if err := gs.RunAsync(func() { close(done) }); err != nil {
+		t.Fatal(err)
+	}
+	var results []interface{}
+	// closed once the pipeline has stopped
+	for v := range gs.Output() {
+		results = append(results, v)
+	}
+	<-done
+	if !reflect.DeepEqual(results, doubled) {
+		t.Fatalf("read %v, want %v", results, doubled)
+	}
+	if gs.Results() != nil || gs.Stats().LostOutputs != 0 {
+		t.Fatalf("stats %+v", gs.Stats())
+	}
+}
+
+func Test_OutputBridge(t *testing.T) {
+	configs := []*gostage.Config{
+		{Name: "producer", Worker: idle},
+		{Name: "sink", Worker: passThrough{}, SubscribeToName: "producer"},
+	}
+	next := gostage.New(context.Background(), configs, &recordingLogger{},
+		gostage.WithOutput(gostage.ToCollector), gostage.WithStopMode(gostage.StopDrain),
+		gostage.WithNoDataCountSleep(time.Millisecond))
+	nextDone := make(chan struct{})
+	if err := next.RunAsync(func() { close(nextDone) }); err != nil {
+		t.Fatal(err)
+	}
+
+	gs := outputPipeline(gostage.WithBridge(next))
+	if err := gs.Run(func() {}); err != nil {
+		t.Fatal(err)
+	}
+	next.Stop()
+	<-nextDone
+	if results := next.Results(); !reflect.DeepEqual(results, doubled) {
+		t.Fatalf("bridged %v, want %v", results, doubled)
+	}
+	if a := next.Stats().Accounting; a.Produced != 10 || a.Completed != 10 {
+		t.Fatalf("bridged pipeline accounted %+v", a)
+	}
+
+	// the bridged pipeline has stopped, the outputs are lost
+	gs = outputPipeline(gostage.WithBridge(next))
+	if err := gs.Run(func() {}); err != nil {
+		t.Fatal(err)
+	}
+	if lost := gs.Stats().LostOutputs; lost != 10 {
+		t.Fatalf("%d outputs lost, want 10", lost)
+	}
+}
@@ -0,0 +1,125 @@
+package examples
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/qgymje/gostage"
+)
+
+func Test_OverflowPolicy(t *testing.T) {
+	tests := []struct {
+		name        string
+		policy      gostage.OverflowPolicy
+		wantSeen    []int
+		wantDropped int64
+	}{
+		{"block", gostage.Block, []int{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}, 0},
+		{"drop newest", gostage.DropNewest, []int{1, 2, 3, 4}, 6},
+		{"drop oldest", gostage.DropOldest, []int{1, 8, 9, 10}, 6},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			started := make(chan struct{})
+			release := make(chan struct{})
+
+			var next int64
+			producer := gostage.WorkHandler(func(_ interface{}) (interface{}, error) {
+				n := atomic.LoadInt64(&next)
+				if n == 10 {
+					return nil, gostage.ErrNoData
+				}
+				if n == 1 {
+					// make sure the consumer holds the first event
+					<-started
+				}
+				atomic.AddInt64(&next, 1)
+				return int(n + 1), nil
+			})
+
+			var mu sync.Mutex
+			var seen []int
+			consumer := gostage.WorkHandler(func(in interface{}) (interface{}, error) {
+				if in.(int) == 1 {
+					close(started)
+					<-release
+				}
+				mu.Lock()
+				seen = append(seen, in.(int))
+				mu.Unlock()
+				return nil, nil
+			})
+
+			var reported int64
+			onError := func(e *gostage.StageError) {
+				if errors.Is(e, gostage.ErrDropped) {
+					atomic.AddInt64(&reported, 1)
+				}
+			}
+
+			configs := []*gostage.Config{
+				{Name: "producer", Worker: producer},
+				{Name: "consumer", Worker: consumer, SubscribeTo: producer, BufferSize: 3, OverflowPolicy: tt.policy},
+			}
+			gs := gostage.New(ctx, configs, gostage.NewStdLogger(),
+				gostage.WithNoDataCountSleep(time.Millisecond),
+				gostage.WithOnError(onError),
+			)
+
+			done := make(chan struct{})
+			gs.RunAsync(func() { close(done) })
+
+			if tt.policy == gostage.Block {
+				// one event in the handler, three in the buffer, one waiting to be sent
+				waitFor(t, "producer to block", func() bool { return atomic.LoadInt64(&next) == 5 })
+				time.Sleep(20 * time.Millisecond)
+				if n := atomic.LoadInt64(&next); n != 5 {
+					t.Fatalf("producer produced %d events against a stopped consumer, want 5", n)
+				}
+			} else {
+				waitFor(t, "producer to finish", func() bool { return atomic.LoadInt64(&next) == 10 })
+			}
+			close(release)
+
+			waitFor(t, "consumer to drain", func() bool {
+				mu.Lock()
+				defer mu.Unlock()
+				return len(seen) == len(tt.wantSeen)
+			})
+			cancel()
+			<-done
+
+			for i := range tt.wantSeen {
+				if seen[i] != tt.wantSeen[i] {
+					t.Fatalf("consumer saw %v, want %v", seen, tt.wantSeen)
+				}
+			}
+			if got := gs.Stats().Stages[1].Dropped; got != tt.wantDropped {
+				t.Fatalf("dropped = %d, want %d", got, tt.wantDropped)
+			}
+			if got := atomic.LoadInt64(&reported); got != tt.wantDropped {
+				t.Fatalf("reported drops = %d, want %d", got, tt.wantDropped)
+			}
+		})
+	}
+}
+
+func Test_DropOldestUnbuffered(t *testing.T) {
+	producer := countdown(3, func(n int) interface{} { return n })
+	configs := []*gostage.Config{
+		{Name: "producer", Worker: producer},
+		{Name: "consumer", Worker: adder{}, SubscribeTo: producer, OverflowPolicy: gostage.DropOldest},
+	}
+	err := gostage.New(context.Background(), configs, &recordingLogger{}).Run(func() {})
+	if !errors.Is(err, gostage.ErrInvalidOverflow) {
+		t.Fatalf("Run error = %v, want ErrInvalidOverflow", err)
+	}
+}
@@ -0,0 +1,78 @@
+package examples
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/qgymje/gostage"
+)
+
+type fragile struct {
+	lookup  map[int]string
+	handled []int
+}
+
+func (f *fragile) HandleEvent(in interface{}) (interface{}, error) {
+	n := in.(int)
+	if n%2 == 1 && n > 1 {
+		// malformed events blow up on a nil map write
+		var broken map[int]string
+		broken[n] = "boom"
+	}
+	f.lookup[n] = "ok"
+	f.handled = append(f.handled, n)
+	return nil, nil
+}
+
+func Test_PanicPolicyRecover(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	next := 0
+	producer := gostage.WorkHandler(func(_ interface{}) (interface{}, error) {
+		if next == 10 {
+			return nil, gostage.ErrQuit
+		}
+		next++
+		return next, nil
+	})
+	consumer := &fragile{lookup: map[int]string{}}
+
+	var mu sync.Mutex
+	var panics []*gostage.PanicError
+	onError := func(e *gostage.StageError) {
+		var pe *gostage.PanicError
+		if errors.As(e, &pe) {
+			mu.Lock()
+			panics = append(panics, pe)
+			mu.Unlock()
+		}
+	}
+
+	configs := []*gostage.Config{
+		{Name: "producer", Worker: producer},
+		{Name: "consumer", Worker: consumer, SubscribeTo: producer, PanicPolicy: gostage.Recover},
+	}
+	gs := gostage.New(ctx, configs, gostage.NewStdLogger(), gostage.WithOnError(onError))
+	gs.Run(func() {})
+
+	if err := gs.Reason(); err != gostage.ErrQuit {
+		t.Fatalf("reason = %v, want ErrQuit", err)
+	}
+	want := []int{1, 2, 4, 6, 8, 10}
+	if len(consumer.handled) != len(want) {
+		t.Fatalf("consumer handled %v, want %v", consumer.handled, want)
+	}
+	if len(panics) != 4 {
+		t.Fatalf("got %d panic errors, want 4", len(panics))
+	}
+	if len(panics[0].Stack) == 0 {
+		t.Fatal("panic error has no stack")
+	}
+	if got := gs.Stats().Stages[1].Errors; got != 4 {
+		t.Fatalf("errors = %d, want 4", got)
+	}
+}
@@ -0,0 +1,107 @@
+package examples
+
+import (
+	"context"
+	"reflect"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/qgymje/gostage"
+)
+
+// byInstance records the events each instance of a stage handled, in order
+type byInstance struct {
+	mu   sync.Mutex
+	seen map[int][]int
+}
+
+func (b *byInstance) handler() gostage.ContextHandler {
+	b.seen = map[int][]int{}
+	return func(ctx context.Context, in interface{}) (interface{}, error) {
+		n, _ := gostage.InstanceFromContext(ctx)
+		// let the events pile up in the inboxes
+		time.Sleep(100 * time.Microsecond)
+		b.mu.Lock()
+		b.seen[n] = append(b.seen[n], in.(int))
+		b.mu.Unlock()
+		return nil, nil
+	}
+}
+
+func Test_PartitionHints(t *testing.T) {
+	// a source already partitioned in 6 partitions, kept on 3 instances
+	producer := countdown(60, func(n int) interface{} {
+		return gostage.WithPartition(n, n%6)
+	})
+	var consumer byInstance
+	configs := []*gostage.Config{
+		{Name: "producer", Worker: producer},
+		{Name: "consumer", Worker: consumer.handler(), SubscribeToName: "producer", Size: 3, ShareInstance: true, BufferSize: 4,
+			Dispatch: gostage.Partitioned,
+			// not called for the hinted events
+			PartitionBy: func(interface{}) string { panic("PartitionBy called") }},
+	}
+	gs := gostage.New(context.Background(), configs, &recordingLogger{})
+	if _, err := gs.Collect(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	want := map[int][]int{}
+	for n := 1; n <= 60; n++ {
+		want[n%6%3] = append(want[n%6%3], n)
+	}
+	if !reflect.DeepEqual(consumer.seen, want) {
+		t.Errorf("got %v, want %v", consumer.seen, want)
+	}
+	if got := gs.Stats().Stages[1].Partitions; !reflect.DeepEqual(got, []int64{20, 20, 20}) {
+		t.Errorf("partitions %v", got)
+	}
+}
+
+func Test_PartitionByFallback(t *testing.T) {
+	// the even events are hinted to the partition 0, the odd ones are hashed on their value
+	producer := countdown(40, func(n int) interface{} {
+		if n%2 == 0 {
+			return gostage.WithPartition(n, 0)
+		}
+		return n
+	})
+	var consumer byInstance
+	key := func(v interface{}) string { return strconv.Itoa(v.(int) % 5) }
+	configs := []*gostage.Config{
+		{Name: "producer", Worker: producer},
+		{Name: "consumer", Worker: consumer.handler(), SubscribeToName: "producer", Size: 4, ShareInstance: true, BufferSize: 4,
+			Dispatch: gostage.Partitioned, PartitionBy: key},
+	}
+	gs := gostage.New(context.Background(), configs, &recordingLogger{})
+	if _, err := gs.Collect(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	// the events with the same key go to the same instance, in order
+	instanceOf := map[string]int{}
+	var total int64
+	for n, events := range consumer.seen {
+		for k, v := range events {
+			if k > 0 && v < events[k-1] {
+				t.Errorf("instance %d got %v out of order", n, events)
+			}
+			if v%2 == 0 {
+				if n != 0 {
+					t.Errorf("hinted event %d went to instance %d", v, n)
+				}
+				continue
+			}
+			if other, ok := instanceOf[key(v)]; ok && other != n {
+				t.Errorf("key %s went to instances %d and %d", key(v), other, n)
+			}
+			instanceOf[key(v)] = n
+		}
+	}
+	for _, c := range gs.Stats().Stages[1].Partitions {
+		total += c
+	}
+	if total != 40 {
+		t.Errorf("partitions counted %d events, want 40", total)
+	}
+}
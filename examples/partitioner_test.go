@@ -0,0 +1,85 @@
+package examples
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/qgymje/gostage"
+)
+
+func Test_ConsistentHashMovesFewPartitions(t *testing.T) {
+	moved := func(p gostage.Partitioner) (moved int, toNew bool) {
+		toNew = true
+		for k := 0; k < 10000; k++ {
+			if before, after := p.Instance(k, 4), p.Instance(k, 5); before != after {
+				moved++
+				toNew = toNew && after == 4
+			}
+		}
+		return moved, toNew
+	}
+	n, toNew := moved(gostage.ConsistentHash(0))
+	if n > 3000 || !toNew {
+		t.Errorf("consistent hashing moved %d partitions of 10000, only to the new instance: %v", n, toNew)
+	}
+	if n, _ := moved(gostage.Modulo); n < 7000 {
+		t.Errorf("modulo moved %d partitions of 10000", n)
+	}
+}
+
+func Test_PartitionedScaleKeepsOrder(t *testing.T) {
+	for name, partitioner := range map[string]gostage.Partitioner{
+		"modulo":     gostage.Modulo,
+		"consistent": gostage.ConsistentHash(16),
+	} {
+		t.Run(name, func(t *testing.T) {
+			var mu sync.Mutex
+			last := map[int]int{}
+			var handled atomic.Int64
+			var violations []string
+			consumer := gostage.WorkHandler(func(in interface{}) (interface{}, error) {
+				n := in.(int)
+				// let the events pile up in the inboxes
+				time.Sleep(50 * time.Microsecond)
+				mu.Lock()
+				if n < last[n%8] {
+					violations = append(violations, strconv.Itoa(n)+" after "+strconv.Itoa(last[n%8]))
+				}
+				last[n%8] = n
+				mu.Unlock()
+				handled.Add(1)
+				return nil, nil
+			})
+			configs := []*gostage.Config{
+				{Name: "producer", Worker: countdown(3000, func(n int) interface{} { return n })},
+				{Name: "consumer", Worker: consumer, SubscribeToName: "producer", Size: 4, ShareInstance: true,
+					BufferSize: 16, Dispatch: gostage.Partitioned, Partitioner: partitioner,
+					PartitionBy: func(v interface{}) string { return strconv.Itoa(v.(int) % 8) }},
+			}
+			gs := gostage.New(context.Background(), configs, &recordingLogger{})
+			done := make(chan struct{})
+			if err := gs.RunAsync(func() { close(done) }); err != nil {
+				t.Fatal(err)
+			}
+			for k, size := range []int{5, 3, 6} {
+				at := int64(600 * (k + 1))
+				waitFor(t, "the events before scaling", func() bool { return handled.Load() >= at })
+				if err := gs.Scale("consumer", size); err != nil {
+					t.Fatal(err)
+				}
+			}
+			<-done
+
+			if len(violations) > 0 {
+				t.Errorf("out of order: %v", violations)
+			}
+			if handled.Load() != 3000 {
+				t.Errorf("handled %d events, want 3000", handled.Load())
+			}
+		})
+	}
+}
@@ -0,0 +1,124 @@
+package examples
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/qgymje/gostage"
+)
+
+func Test_MaxPayloadBytes(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dead.letters")
+	producer := countdown(20, func(n int) interface{} {
+		if n == 7 {
+			return blob(1 << 20)
+		}
+		return blob(n)
+	})
+	var handled []int
+	var mu sync.Mutex
+	parse := gostage.WorkHandler(func(in interface{}) (interface{}, error) {
+		mu.Lock()
+		handled = append(handled, int(in.(blob)))
+		mu.Unlock()
+		return int(in.(blob)), nil
+	})
+	sink := &collecting{}
+	configs := []*gostage.Config{
+		{Name: "producer", Worker: producer},
+		{Name: "parse", Worker: parse, SubscribeToName: "producer", MaxPayloadBytes: 1024, BufferSize: 4},
+		{Name: "sink", Worker: sink, SubscribeToName: "parse"},
+	}
+	var errs []*gostage.StageError
+	logger := &recordingLogger{}
+	gs := gostage.New(context.Background(), configs, logger,
+		gostage.WithDeadLetterFile(path), gostage.WithClock(&manualClock{now: time.Unix(0, 0)}),
+		gostage.WithOnError(func(se *gostage.StageError) {
+			mu.Lock()
+			errs = append(errs, se)
+			mu.Unlock()
+		}))
+	if err := gs.Run(func() {}); err != nil {
+		t.Fatal(err)
+	}
+
+	var want []int
+	for n := 1; n <= 20; n++ {
+		if n != 7 {
+			want = append(want, n)
+		}
+	}
+	if got := sink.sorted(); !reflect.DeepEqual(got, want) {
+		t.Errorf("sink got %v", got)
+	}
+	if len(handled) != 19 {
+		t.Errorf("parse handled %d events", len(handled))
+	}
+	st := gs.Stats()
+	if st.Stages[1].Oversized != 1 || st.Accounting.DeadLettered != 1 || st.Accounting.Completed != 19 {
+		t.Errorf("%d oversized, accounting %+v", st.Stages[1].Oversized, st.Accounting)
+	}
+	if len(errs) != 1 || !errors.Is(errs[0].Err, gostage.ErrPayloadTooLarge) || errs[0].Stage != "parse" {
+		t.Fatalf("errors %+v", errs)
+	}
+	if lines := logger.find("[Warn]parse rejected event ", "of 1048576 bytes, MaxPayloadBytes is 1024"); len(lines) != 1 {
+		t.Errorf("the rejection wasn't logged at Warn: %v", logger.find("parse"))
+	}
+
+	// only the description of the payload is kept, and it isn't replayed
+	dead := deadLetters(t, path, gs)
+	if len(dead) != 1 || !dead[0].Truncated || dead[0].Class != gostage.PermanentError {
+		t.Fatalf("dead letters %+v", dead)
+	}
+	if desc := dead[0].Payload.(string); desc != "examples.blob of 1048576 bytes" {
+		t.Errorf("dead letter payload %q", desc)
+	}
+}
+
+func Test_MaxPayloadBytesPush(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dead.letters")
+	sink := &collecting{}
+	configs := []*gostage.Config{
+		{Name: "producer", Worker: idle},
+		{Name: "sink", Worker: gostage.WorkHandler(func(in interface{}) (interface{}, error) {
+			return sink.HandleEvent(len(in.(sized)))
+		}), SubscribeToName: "producer", MaxPayloadBytes: 8, BufferSize: 4},
+	}
+	gs := gostage.New(context.Background(), configs, &recordingLogger{},
+		gostage.WithDeadLetterFile(path), gostage.WithStopMode(gostage.StopDrain))
+	done := make(chan struct{})
+	if err := gs.RunAsync(func() { close(done) }); err != nil {
+		t.Fatal(err)
+	}
+	if err := gs.Push(context.Background(), sized("small")); err != nil {
+		t.Fatal(err)
+	}
+	if err := gs.Push(context.Background(), sized(strings.Repeat("x", 300))); !errors.Is(err, gostage.ErrPayloadTooLarge) {
+		t.Errorf("push of an oversized event: %v", err)
+	}
+	gs.Stop()
+	<-done
+
+	if got := sink.sorted(); !reflect.DeepEqual(got, []int{5}) {
+		t.Errorf("sink got %v", got)
+	}
+	dead := deadLetters(t, path, gs)
+	if len(dead) != 1 {
+		t.Fatalf("dead letters %+v", dead)
+	}
+	// the beginning of a string is kept
+	if desc := dead[0].Payload.(string); desc != "examples.sized of 300 bytes: \""+strings.Repeat("x", 256)+"\"..." {
+		t.Errorf("dead letter payload %q", desc)
+	}
+}
+
+// sized is a string payload of its length in bytes
+type sized string
+
+func (s sized) SizeBytes() int { return len(s) }
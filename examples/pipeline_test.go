@@ -2,29 +2,28 @@ package examples
 
 import (
 	"context"
-	"log"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/qgymje/gostage"
 )
 
-var count int
-var mu sync.Mutex
-
 type Producer struct {
+	mu    sync.Mutex
+	count int
 }
 
 func (p *Producer) HandleEvent(_ interface{}) (interface{}, error) {
-	mu.Lock()
-	defer mu.Unlock()
-	if count == 10 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.count == 10 {
 		return nil, gostage.ErrQuit
 	}
 
-	count++
-	return count, nil
+	p.count++
+	return p.count, nil
 }
 
 type ProducerConsumer struct {
@@ -35,42 +34,41 @@ func (c *ProducerConsumer) Create() gostage.Worker {
 }
 
 func (c *ProducerConsumer) HandleEvent(data interface{}) (interface{}, error) {
-	log.Printf("producer consumer: %+v", data)
 	return data, nil
 }
 
 func producerConsumer2(data interface{}) (interface{}, error) {
-	log.Printf("producer consumer2: %+v", data)
 	return data, nil
 }
 
 type Consumer struct {
 	count int
+	// shared by the instances made by Create
+	received *sync.Map
+	closed   *atomic.Int64
 }
 
 func (c *Consumer) Create() gostage.Worker {
-	return &Consumer{}
+	return &Consumer{received: c.received, closed: c.closed}
 }
 
 func (c *Consumer) Close() {
-	log.Printf("consumer clean up: count = %d", c.count)
+	c.closed.Add(1)
 }
 
 func (c *Consumer) HandleEvent(data interface{}) (interface{}, error) {
 	c.count++
-	log.Printf("consumer: receive = %+v, count = %d", data, c.count)
-	time.Sleep(1e9)
-	//panic("I'm blowing")
+	c.received.Store(data, true)
 	return nil, nil
 }
 
 func Test_Pipeline(t *testing.T) {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	lg := gostage.NewStdLogger()
+	defer cancel()
 	p := &Producer{}
 	pc := &ProducerConsumer{}
 	pc2 := gostage.WorkHandler(producerConsumer2)
-	c := &Consumer{}
+	c := &Consumer{received: &sync.Map{}, closed: &atomic.Int64{}}
 
 	configs := []*gostage.Config{
 		{
@@ -94,18 +92,22 @@ func Test_Pipeline(t *testing.T) {
 		},
 	}
 
-	gs := gostage.New(ctx, configs, lg)
-	gs.Run(func() {
-		cancel()
-		log.Println("done!!!")
-	})
-
-	/*
-		gs.RunAsync(func() {
-			cancel()
-			log.Println("done!!!")
-		})
+	gs := gostage.New(ctx, configs, &recordingLogger{})
+	if err := gs.Run(func() {}); err != nil {
+		t.Fatal(err)
+	}
 
-		time.Sleep(6 * time.Second)
-	*/
+	// every item reaches the consumer once
+	for n := 1; n <= 10; n++ {
+		if _, ok := c.received.Load(n); !ok {
+			t.Errorf("item %d never reached the consumer", n)
+		}
+	}
+	a := gs.Stats().Accounting
+	if a.Produced != 10 || a.Completed != 10 {
+		t.Errorf("accounting %+v", a)
+	}
+	if c.closed.Load() != 2 {
+		t.Errorf("closed %d consumers, want 2", c.closed.Load())
+	}
 }
@@ -0,0 +1,181 @@
+package examples
+
+import (
+	"context"
+	"runtime"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/qgymje/gostage"
+)
+
+// idleProducer never has data
+func idleProducer() gostage.Worker {
+	return gostage.WorkHandler(func(_ interface{}) (interface{}, error) {
+		return nil, gostage.ErrNoData
+	})
+}
+
+// goroutinesOfWideStage returns the goroutines started by a pipeline with an idle stage of 200 instances
+func goroutinesOfWideStage(t *testing.T, pool bool) int {
+	configs := []*gostage.Config{
+		{Name: "producer", Worker: idleProducer()},
+		{Name: "wide", Worker: passThrough{}, SubscribeToName: "producer", Size: 200, Pool: pool},
+	}
+	before := runtime.NumGoroutine()
+	gs := gostage.New(context.Background(), configs, &recordingLogger{}, gostage.WithNoDataCountSleep(time.Millisecond))
+	done := make(chan struct{})
+	if err := gs.RunAsync(func() { close(done) }); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(20 * time.Millisecond)
+	n := runtime.NumGoroutine() - before
+	gs.Stop()
+	<-done
+	return n
+}
+
+func Test_PoolGoroutines(t *testing.T) {
+	unpooled := goroutinesOfWideStage(t, false)
+	pooled := goroutinesOfWideStage(t, true)
+	if unpooled < 200 {
+		t.Fatalf("started %d goroutines without a pool, want at least one per instance", unpooled)
+	}
+	if pooled > 20 {
+		t.Fatalf("started %d goroutines with a pool, want a few", pooled)
+	}
+}
+
+// slowCounter sleeps on every event and records how many events are handled at once
+type slowCounter struct {
+	running, max *int64
+}
+
+func (c slowCounter) Create() gostage.Worker {
+	return c
+}
+
+func (c slowCounter) HandleEvent(in interface{}) (interface{}, error) {
+	n := atomic.AddInt64(c.running, 1)
+	for {
+		max := atomic.LoadInt64(c.max)
+		if n <= max || atomic.CompareAndSwapInt64(c.max, max, n) {
+			break
+		}
+	}
+	time.Sleep(2 * time.Millisecond)
+	atomic.AddInt64(c.running, -1)
+	return in, nil
+}
+
+func Test_PoolGrowsAndShrinks(t *testing.T) {
+	next := 0
+	producer := gostage.WorkHandler(func(_ interface{}) (interface{}, error) {
+		if next == 100 {
+			return nil, gostage.ErrNoData
+		}
+		next++
+		return next, nil
+	})
+	var running, max int64
+	configs := []*gostage.Config{
+		{Name: "producer", Worker: producer},
+		{Name: "slow", Worker: slowCounter{running: &running, max: &max}, SubscribeToName: "producer",
+			Size: 16, Pool: true, PoolIdleTimeout: 10 * time.Millisecond, BufferSize: 100},
+	}
+
+	before := runtime.NumGoroutine()
+	gs := gostage.New(context.Background(), configs, &recordingLogger{}, gostage.WithNoDataCountSleep(time.Millisecond))
+	done := make(chan struct{})
+	if err := gs.RunAsync(func() { close(done) }); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "the events to be handled", func() bool {
+		return gs.Stats().Stages[1].Processed == 100
+	})
+	if got := atomic.LoadInt64(&max); got < 4 {
+		t.Fatalf("handled at most %d events at once, want the pool to grow", got)
+	}
+	waitFor(t, "the pool to shrink", func() bool {
+		return runtime.NumGoroutine()-before <= 6
+	})
+	gs.Stop()
+	<-done
+}
+
+// flaky panics the first time it sees 7, it's created for every instance
+type flaky struct {
+	panicked *int32
+	mu       *sync.Mutex
+	closed   *int
+}
+
+func (f flaky) Create() gostage.Worker {
+	return f
+}
+
+func (f flaky) Close() {
+	f.mu.Lock()
+	*f.closed++
+	f.mu.Unlock()
+}
+
+func (f flaky) HandleEvent(in interface{}) (interface{}, error) {
+	if in.(int) == 7 && atomic.CompareAndSwapInt32(f.panicked, 0, 1) {
+		panic("seven")
+	}
+	return in.(int) * 10, nil
+}
+
+// collectFlaky runs 50 events through a flaky stage of 8 instances
+func collectFlaky(t *testing.T, pool bool) (results []int, restarts int64, closed int) {
+	next := 0
+	producer := gostage.WorkHandler(func(_ interface{}) (interface{}, error) {
+		if next == 50 {
+			return nil, gostage.ErrQuit
+		}
+		next++
+		return next, nil
+	})
+	var panicked int32
+	var mu sync.Mutex
+	configs := []*gostage.Config{
+		{Name: "producer", Worker: producer},
+		{Name: "flaky", Worker: flaky{panicked: &panicked, mu: &mu, closed: &closed}, SubscribeToName: "producer",
+			Size: 8, Pool: pool, Redeliver: 1, BufferSize: 10,
+			OnRestart: func(gostage.RestartEvent) { atomic.AddInt64(&restarts, 1) }},
+	}
+
+	gs := gostage.New(context.Background(), configs, &recordingLogger{}, gostage.WithStopMode(gostage.StopDrain))
+	out, err := gs.Collect(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range out {
+		results = append(results, v.(int))
+	}
+	sort.Ints(results)
+	return results, atomic.LoadInt64(&restarts), closed
+}
+
+func Test_PoolSameEvents(t *testing.T) {
+	want, wantRestarts, wantClosed := collectFlaky(t, false)
+	got, restarts, closed := collectFlaky(t, true)
+	if len(want) != 50 || len(got) != len(want) {
+		t.Fatalf("collected %d events with a pool and %d without, want 50", len(got), len(want))
+	}
+	for k := range want {
+		if got[k] != want[k] {
+			t.Fatalf("collected %v with a pool, want %v", got, want)
+		}
+	}
+	if restarts != 1 || wantRestarts != 1 {
+		t.Fatalf("restarted %d times with a pool and %d without, want 1", restarts, wantRestarts)
+	}
+	if closed != 8 || wantClosed != 8 {
+		t.Fatalf("closed %d workers with a pool and %d without, want 8", closed, wantClosed)
+	}
+}
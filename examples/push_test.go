@@ -0,0 +1,144 @@
+package examples
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/qgymje/gostage"
+)
+
+// idle produces nothing, the events are pushed
+var idle = gostage.WorkHandler(func(_ interface{}) (interface{}, error) {
+	return nil, gostage.ErrNoData
+})
+
+func Test_Push(t *testing.T) {
+	var consumed atomic.Int64
+	sink := gostage.WorkHandler(func(in interface{}) (interface{}, error) {
+		consumed.Add(1)
+		time.Sleep(10 * time.Microsecond)
+		return nil, nil
+	})
+	configs := []*gostage.Config{
+		{Name: "producer", Worker: idle},
+		{Name: "sink", Worker: sink, SubscribeToName: "producer", BufferSize: 8},
+	}
+	gs := gostage.New(context.Background(), configs, &recordingLogger{},
+		gostage.WithStopMode(gostage.StopDrain), gostage.WithNoDataCountSleep(time.Millisecond))
+	if gs.TryPush(0) {
+		t.Fatal("pushed to an idle pipeline")
+	}
+	if err := gs.Push(context.Background(), 0); !errors.Is(err, gostage.ErrPipelineStopped) {
+		t.Fatalf("push to an idle pipeline: %v", err)
+	}
+
+	done := make(chan struct{})
+	if err := gs.RunAsync(func() { close(done) }); err != nil {
+		t.Fatal(err)
+	}
+	var accepted, rejected atomic.Int64
+	var wg sync.WaitGroup
+	for g := 0; g < 50; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for k := 0; k < 200; k++ {
+				var err error
+				switch k % 3 {
+				case 0:
+					err = gs.Push(context.Background(), k)
+				case 1:
+					err = gs.PushWithTimeout(k, time.Second)
+				default:
+					if !gs.TryPush(k) {
+						continue
+					}
+				}
+				if errors.Is(err, gostage.ErrPipelineStopped) {
+					rejected.Add(1)
+					continue
+				}
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				accepted.Add(1)
+			}
+		}(g)
+	}
+	waitFor(t, "some events", func() bool { return accepted.Load() > 1000 })
+	if err := gs.Stop(); err != nil {
+		t.Fatal(err)
+	}
+	wg.Wait()
+	<-done
+
+	if rejected.Load() == 0 {
+		t.Fatal("no push was rejected after the pipeline stopped")
+	}
+	a := gs.Stats().Accounting
+	if a.Produced != accepted.Load() || a.Completed != accepted.Load() || consumed.Load() != accepted.Load() {
+		t.Fatalf("%d pushes accepted, %d consumed, accounting %+v", accepted.Load(), consumed.Load(), a)
+	}
+	if err := gs.Push(context.Background(), 0); !errors.Is(err, gostage.ErrPipelineStopped) {
+		t.Fatalf("push to a stopped pipeline: %v", err)
+	}
+}
+
+func Test_PushTimeout(t *testing.T) {
+	release := make(chan struct{})
+	sink := gostage.WorkHandler(func(in interface{}) (interface{}, error) {
+		<-release
+		return nil, nil
+	})
+	configs := []*gostage.Config{
+		{Name: "producer", Worker: idle},
+		{Name: "sink", Worker: sink, SubscribeToName: "producer", BufferSize: 1},
+	}
+	gs := gostage.New(context.Background(), configs, &recordingLogger{},
+		gostage.WithStopMode(gostage.StopDrain), gostage.WithNoDataCountSleep(time.Millisecond))
+	done := make(chan struct{})
+	if err := gs.RunAsync(func() { close(done) }); err != nil {
+		t.Fatal(err)
+	}
+	// one event is handled, one is buffered
+	for k := 0; k < 2; k++ {
+		if err := gs.PushWithTimeout(k, time.Second); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if gs.TryPush(2) {
+		t.Fatal("pushed to a full buffer")
+	}
+	if err := gs.PushWithTimeout(2, 10*time.Millisecond); err != gostage.ErrPushTimeout {
+		t.Fatalf("push to a full buffer: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := gs.Push(ctx, 2); err != context.Canceled {
+		t.Fatalf("push with a cancelled context: %v", err)
+	}
+	close(release)
+	gs.Stop()
+	<-done
+	if a := gs.Stats().Accounting; a.Produced != 2 || a.Completed != 2 {
+		t.Fatalf("accounting %+v", a)
+	}
+}
+
+func Test_PushEmptyPipeline(t *testing.T) {
+	gs := gostage.New(context.Background(), nil, &recordingLogger{})
+	if err := gs.RunAsync(func() {}); !errors.Is(err, gostage.ErrEmptyPipeline) {
+		t.Fatalf("RunAsync error %v, want ErrEmptyPipeline", err)
+	}
+	if gs.TryPush(1) {
+		t.Fatal("pushed to an empty pipeline")
+	}
+	if err := gs.Push(context.Background(), 1); !errors.Is(err, gostage.ErrPipelineStopped) {
+		t.Fatalf("push to an empty pipeline: %v", err)
+	}
+}
@@ -0,0 +1,164 @@
+package examples
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/qgymje/gostage"
+)
+
+// runQueued sends 1 to n to a consumer with queue which holds the first event until the
+// producer is done, returns the events in the order the consumer saw them
+func runQueued(t *testing.T, n, bufferSize int, queue gostage.QueueFactory) ([]int, *gostage.GoStage, int64) {
+	var next atomic.Int64
+	started := make(chan struct{})
+	producer := gostage.WorkHandler(func(_ interface{}) (interface{}, error) {
+		v := next.Load()
+		if v == int64(n) {
+			return nil, gostage.ErrNoData
+		}
+		if v == 1 {
+			<-started
+		}
+		next.Add(1)
+		return int(v + 1), nil
+	})
+	release := make(chan struct{})
+	var mu sync.Mutex
+	var seen []int
+	consumer := gostage.WorkHandler(func(in interface{}) (interface{}, error) {
+		if in.(int) == 1 {
+			close(started)
+			<-release
+		}
+		mu.Lock()
+		seen = append(seen, in.(int))
+		mu.Unlock()
+		return nil, nil
+	})
+	var reported atomic.Int64
+	configs := []*gostage.Config{
+		{Name: "producer", Worker: producer},
+		{Name: "consumer", Worker: consumer, SubscribeToName: "producer", BufferSize: bufferSize, Queue: queue},
+	}
+	gs := gostage.New(context.Background(), configs, &recordingLogger{},
+		gostage.WithNoDataCountSleep(time.Millisecond),
+		gostage.WithStopMode(gostage.StopDrain),
+		gostage.WithOnError(func(e *gostage.StageError) {
+			if errors.Is(e, gostage.ErrDropped) {
+				reported.Add(1)
+			}
+		}))
+	done := make(chan struct{})
+	if err := gs.RunAsync(func() { close(done) }); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "the producer to finish", func() bool { return next.Load() == int64(n) })
+	// the last pushes are done once the producer asks for the next event
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	gs.Stop()
+	<-done
+	return seen, gs, reported.Load()
+}
+
+// held removes the event the pump took out of the queue while the consumer was handling 1,
+// it's the most urgent one when the pump got to it
+func held(seen []int) (int, []int) {
+	if len(seen) < 2 || seen[0] != 1 {
+		return 0, seen
+	}
+	return seen[1], append([]int{1}, seen[2:]...)
+}
+
+func Test_PriorityQueue(t *testing.T) {
+	highest := gostage.PriorityQueue(func(a, b interface{}) bool { return a.(int) > b.(int) })
+	seen, gs, _ := runQueued(t, 8, 16, highest)
+	first, rest := held(seen)
+	want := []int{1}
+	for v := 8; v > 1; v-- {
+		if v != first {
+			want = append(want, v)
+		}
+	}
+	if !reflect.DeepEqual(rest, want) {
+		t.Fatalf("consumer saw %v, want %v after the one out of the queue", seen, want)
+	}
+	if a := gs.Stats().Accounting; a.Completed != 8 || a.Accounted() != a.Produced {
+		t.Fatalf("accounting %+v", a)
+	}
+}
+
+func Test_PriorityQueueKeepsOrderOfEquals(t *testing.T) {
+	evens := gostage.PriorityQueue(func(a, b interface{}) bool { return a.(int)%2 == 0 && b.(int)%2 == 1 })
+	seen, _, _ := runQueued(t, 9, 16, evens)
+	first, rest := held(seen)
+	want := []int{1}
+	for _, v := range []int{2, 4, 6, 8, 3, 5, 7, 9} {
+		if v != first {
+			want = append(want, v)
+		}
+	}
+	if !reflect.DeepEqual(rest, want) {
+		t.Fatalf("consumer saw %v, want %v after the one out of the queue", seen, want)
+	}
+}
+
+func Test_DropOldestQueue(t *testing.T) {
+	seen, gs, reported := runQueued(t, 10, 3, gostage.DropOldestQueue)
+	// 1 is being handled, the queue keeps the last three and the pump may hold an older one
+	n := len(seen)
+	if n < 4 || n > 5 || seen[0] != 1 || !reflect.DeepEqual(seen[n-3:], []int{8, 9, 10}) {
+		t.Fatalf("consumer saw %v, want 1 and the last three", seen)
+	}
+	dropped := int64(10 - n)
+	if got := gs.Stats().Stages[1].Dropped; got != dropped || reported != dropped {
+		t.Fatalf("%d dropped, %d reported, want %d", got, reported, dropped)
+	}
+	if a := gs.Stats().Accounting; a.DeadLettered != dropped || a.Accounted() != a.Produced {
+		t.Fatalf("accounting %+v", a)
+	}
+}
+
+func Test_QueueLeftoversAreAccounted(t *testing.T) {
+	next := 0
+	producer := gostage.WorkHandler(func(_ interface{}) (interface{}, error) {
+		next++
+		return next, nil
+	})
+	gate := make(chan struct{})
+	consumer := gostage.WorkHandler(func(in interface{}) (interface{}, error) {
+		<-gate
+		return nil, nil
+	})
+	configs := []*gostage.Config{
+		{Name: "producer", Worker: producer},
+		{Name: "consumer", Worker: consumer, SubscribeToName: "producer", BufferSize: 5, Queue: gostage.ChannelQueue},
+	}
+	gs := gostage.New(context.Background(), configs, &recordingLogger{})
+	done := make(chan struct{})
+	if err := gs.RunAsync(func() { close(done) }); err != nil {
+		t.Fatal(err)
+	}
+	// one event in the consumer, one out of the queue, five in it and one blocked in the producer
+	waitFor(t, "the queue to be full", func() bool {
+		return gs.Stats().Stages[0].Processed == 8
+	})
+	gs.Stop()
+	waitFor(t, "the pipeline to be stopping", func() bool {
+		return gs.State() == gostage.StateStopping
+	})
+	time.Sleep(20 * time.Millisecond)
+	close(gate)
+	<-done
+
+	want := gostage.Accounting{Produced: 8, Completed: 1, DroppedInChannel: 6, DroppedInFlight: 1}
+	if got := gs.Stats().Accounting; got != want {
+		t.Fatalf("got %+v, want %+v", got, want)
+	}
+}
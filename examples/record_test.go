@@ -0,0 +1,158 @@
+package examples
+
+import (
+	"context"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/qgymje/gostage"
+)
+
+func Test_RecordReplay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "run.rec")
+	recorded, err := collectPipeline(20, gostage.WithRecording(path)).Collect(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	events, err := gostage.ReadRecording(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 20 {
+		t.Fatalf("%d events recorded, want 20", len(events))
+	}
+	ids := make(map[string]bool)
+	for k, e := range events {
+		if e.Payload != k+1 || e.Producer != "producer" || ids[e.ID] {
+			t.Fatalf("recorded %+v", e)
+		}
+		if k > 0 && e.Time.Before(events[k-1].Time) {
+			t.Fatalf("recorded %+v before %+v", e, events[k-1])
+		}
+		ids[e.ID] = true
+	}
+
+	// the same stages fed by the recording
+	replay, err := gostage.ReplayProducer("replay", path, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	double := gostage.WorkHandler(func(in interface{}) (interface{}, error) {
+		return in.(int) * 2, nil
+	})
+	configs := []*gostage.Config{
+		replay,
+		{Name: "double", Worker: double, SubscribeToName: "replay"},
+	}
+	replayed, err := gostage.New(context.Background(), configs, &recordingLogger{}).Collect(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(replayed, recorded) {
+		t.Fatalf("replayed %v, recorded %v", replayed, recorded)
+	}
+
+	if _, err := gostage.ReplayProducer("replay", filepath.Join(t.TempDir(), "missing"), 1); err == nil {
+		t.Fatal("replayed a missing recording")
+	}
+}
+
+// recordSpaced records the events 1, 2, ... emitted after the gaps since the previous one
+func recordSpaced(t *testing.T, gaps ...time.Duration) string {
+	path := filepath.Join(t.TempDir(), "run.rec")
+	clock := &manualClock{now: time.Unix(0, 0)}
+	next := 0
+	producer := gostage.WorkHandler(func(_ interface{}) (interface{}, error) {
+		if next == len(gaps) {
+			return nil, gostage.ErrQuit
+		}
+		clock.Add(gaps[next])
+		next++
+		return next, nil
+	})
+	configs := []*gostage.Config{
+		{Name: "producer", Worker: producer},
+		{Name: "sink", Worker: passThrough{}, SubscribeToName: "producer"},
+	}
+	gs := gostage.New(context.Background(), configs, &recordingLogger{},
+		gostage.WithRecording(path), gostage.WithClock(clock))
+	if err := gs.Run(func() {}); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func Test_ReplayGivesUpAGap(t *testing.T) {
+	path := recordSpaced(t, 0, time.Hour)
+	for _, cancelled := range []bool{false, true} {
+		replay, err := gostage.ReplayProducer("replay", path, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		first := make(chan struct{}, 1)
+		sink := gostage.WorkHandler(func(in interface{}) (interface{}, error) {
+			first <- struct{}{}
+			return nil, nil
+		})
+		configs := []*gostage.Config{replay, {Name: "sink", Worker: sink, SubscribeToName: "replay"}}
+		ctx, cancel := context.WithCancel(context.Background())
+		gs := gostage.New(ctx, configs, &recordingLogger{})
+		done := make(chan struct{})
+		if err := gs.RunAsync(func() { close(done) }); err != nil {
+			t.Fatal(err)
+		}
+		<-first
+		if cancelled {
+			cancel()
+		} else {
+			gs.Stop()
+		}
+		select {
+		case <-done:
+		case <-time.After(3 * time.Second):
+			t.Fatalf("cancelled %v: the pipeline waited for the recorded hour", cancelled)
+		}
+		cancel()
+	}
+}
+
+// steppingClock moves forward by the duration every After waits for and keeps the durations
+type steppingClock struct {
+	manualClock
+	waits []time.Duration
+}
+
+func (c *steppingClock) After(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	c.waits = append(c.waits, d)
+	c.mu.Unlock()
+	c.Add(d)
+	return c.manualClock.After(d)
+}
+
+func Test_ReplayKeepsIntervals(t *testing.T) {
+	path := recordSpaced(t, 0, 10*time.Millisecond, 30*time.Millisecond, 5*time.Millisecond)
+	for _, c := range []struct {
+		speed float64
+		want  []time.Duration
+	}{
+		{1, []time.Duration{10 * time.Millisecond, 30 * time.Millisecond, 5 * time.Millisecond}},
+		{2, []time.Duration{5 * time.Millisecond, 15 * time.Millisecond, 2500 * time.Microsecond}},
+	} {
+		replay, err := gostage.ReplayProducer("replay", path, c.speed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		clock := &steppingClock{manualClock: manualClock{now: time.Unix(100, 0)}}
+		configs := []*gostage.Config{replay, {Name: "sink", Worker: passThrough{}, SubscribeToName: "replay"}}
+		if err := gostage.New(context.Background(), configs, &recordingLogger{}, gostage.WithClock(clock)).Run(func() {}); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(clock.waits, c.want) {
+			t.Fatalf("speed %v: waited %v between the events, want %v", c.speed, clock.waits, c.want)
+		}
+	}
+}
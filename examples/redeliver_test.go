@@ -0,0 +1,84 @@
+package examples
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/qgymje/gostage"
+)
+
+// redeliverPipeline produces 1..10 into a consumer which panics on the
+// first crashes attempts of event 7
+func redeliverPipeline(crashes, redeliver int, opts ...gostage.Option) (*gostage.GoStage, map[int]int) {
+	next := 0
+	producer := gostage.WorkHandler(func(_ interface{}) (interface{}, error) {
+		if next == 10 {
+			return nil, gostage.ErrQuit
+		}
+		next++
+		return next, nil
+	})
+	attempts := map[int]int{}
+	consumer := gostage.WorkHandler(func(in interface{}) (interface{}, error) {
+		n := in.(int)
+		attempts[n]++
+		if n == 7 && attempts[n] <= crashes {
+			panic("crash on 7")
+		}
+		return n, nil
+	})
+	configs := []*gostage.Config{
+		{Name: "producer", Worker: producer},
+		{Name: "consumer", Worker: consumer, SubscribeTo: producer, Restart: 5, Redeliver: redeliver},
+	}
+	return gostage.New(context.Background(), configs, &recordingLogger{}, opts...), attempts
+}
+
+func Test_Redeliver(t *testing.T) {
+	gs, attempts := redeliverPipeline(1, 1)
+	results, err := gs.Collect(context.Background())
+	if err != nil {
+		t.Fatalf("Collect error = %v", err)
+	}
+	if len(results) != 10 {
+		t.Fatalf("got %v, want 1..10", results)
+	}
+	for n := 1; n <= 10; n++ {
+		want := 1
+		if n == 7 {
+			want = 2
+		}
+		if attempts[n] != want {
+			t.Fatalf("event %d handled %d times, want %d", n, attempts[n], want)
+		}
+	}
+}
+
+func Test_RedeliverPoisonEvent(t *testing.T) {
+	var mu sync.Mutex
+	var poisoned []interface{}
+	onError := gostage.WithOnError(func(err *gostage.StageError) {
+		mu.Lock()
+		defer mu.Unlock()
+		if errors.Is(err, gostage.ErrPoisonEvent) {
+			poisoned = append(poisoned, err.Input)
+		}
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	gs, attempts := redeliverPipeline(100, 2, onError)
+	results, err := gs.Collect(ctx)
+	if err != nil {
+		t.Fatalf("Collect error = %v", err)
+	}
+	if len(results) != 9 || attempts[7] != 3 {
+		t.Fatalf("got %v and %d attempts of 7, want 9 results and 3 attempts", results, attempts[7])
+	}
+	if len(poisoned) != 1 || poisoned[0] != 7 {
+		t.Fatalf("poisoned = %v, want [7]", poisoned)
+	}
+}
@@ -0,0 +1,38 @@
+package examples
+
+import (
+	"context"
+	"testing"
+
+	"github.com/qgymje/gostage"
+)
+
+func Test_Reduce(t *testing.T) {
+	next := 0
+	producer := gostage.WorkHandler(func(_ interface{}) (interface{}, error) {
+		if next == 100 {
+			return nil, gostage.ErrQuit
+		}
+		next++
+		return next, nil
+	})
+	middle := passThrough{}
+	sum, result := gostage.Reduce("sum", 0, func(acc, in interface{}) (interface{}, error) {
+		return acc.(int) + in.(int), nil
+	})
+	sum.SubscribeTo = middle
+
+	configs := []*gostage.Config{
+		{Name: "producer", Worker: producer},
+		{Name: "middle", Worker: middle, SubscribeTo: producer, Size: 4},
+		sum,
+	}
+	gs := gostage.New(context.Background(), configs, &recordingLogger{})
+	if err := gs.RunAsync(func() {}); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := result.Result(); got != 5050 {
+		t.Fatalf("Result() = %v, want 5050", got)
+	}
+}
@@ -0,0 +1,262 @@
+package examples
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/qgymje/gostage"
+)
+
+// the reference pipelines below are the guarantees of the package, each one is a
+// starting point to copy, they use the manual clock so none of them really waits
+
+func Test_ReferenceLinear(t *testing.T) {
+	// 4 instances in the middle, none of the 1000 events is lost or duplicated
+	producer := countdown(1000, func(n int) interface{} { return n })
+	var handled atomic.Int64
+	middle := gostage.ContextHandler(func(_ context.Context, in interface{}) (interface{}, error) {
+		handled.Add(1)
+		return in.(int) * 2, nil
+	})
+	sum, result := gostage.Reduce("sum", 0, func(acc, in interface{}) (interface{}, error) {
+		return acc.(int) + in.(int), nil
+	})
+	sum.SubscribeToName = "middle"
+	configs := []*gostage.Config{
+		{Name: "producer", Worker: producer},
+		{Name: "middle", Worker: middle, SubscribeToName: "producer", Size: 4, ShareInstance: true},
+		sum,
+	}
+	gs := gostage.New(context.Background(), configs, &recordingLogger{},
+		gostage.WithClock(&manualClock{now: time.Unix(0, 0)}))
+	if err := gs.Run(func() {}); err != nil {
+		t.Fatal(err)
+	}
+	if got := result.Result(); got != 1000*1001 {
+		t.Errorf("sum %v, want %d", got, 1000*1001)
+	}
+	if handled.Load() != 1000 {
+		t.Errorf("middle handled %d events", handled.Load())
+	}
+	if a := gs.Stats().Accounting; a.Produced != 1000 || a.Completed != 1000 {
+		t.Errorf("accounting %+v", a)
+	}
+}
+
+func Test_ReferenceFanOutFanIn(t *testing.T) {
+	// the evens and the odds branch out to their own pipelines, the rest goes on
+	evens, evensDone := sidePipeline(t)
+	odds, oddsDone := sidePipeline(t)
+	producer := countdown(30, func(n int) interface{} { return n })
+	router := gostage.SideHandler(func(in interface{}, emit func(string, interface{})) (interface{}, error) {
+		n := in.(int)
+		if n%2 == 0 {
+			emit("evens", n)
+		} else {
+			emit("odds", n)
+		}
+		if n%3 == 0 {
+			return n, nil
+		}
+		return nil, gostage.ErrNoData
+	})
+	configs := []*gostage.Config{
+		{Name: "producer", Worker: producer},
+		{Name: "router", Worker: router, SubscribeToName: "producer", Size: 3, ShareInstance: true,
+			SideOutputs: map[string]*gostage.GoStage{"evens": evens, "odds": odds}},
+		// the instances of the router fan in to one sink
+		{Name: "sink", Worker: passThrough{}, SubscribeToName: "router"},
+	}
+	gs := gostage.New(context.Background(), configs, &recordingLogger{},
+		gostage.WithClock(&manualClock{now: time.Unix(0, 0)}))
+	main, err := gs.Collect(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	evens.Stop()
+	odds.Stop()
+	<-evensDone
+	<-oddsDone
+
+	total := func(vs []interface{}) (sum int) {
+		for _, v := range vs {
+			sum += v.(int)
+		}
+		return sum
+	}
+	// 2+4+...+30, 1+3+...+29 and 3+6+...+30
+	if len(evens.Results()) != 15 || total(evens.Results()) != 240 {
+		t.Errorf("evens %v", evens.Results())
+	}
+	if len(odds.Results()) != 15 || total(odds.Results()) != 225 {
+		t.Errorf("odds %v", odds.Results())
+	}
+	if len(main) != 10 || total(main) != 165 {
+		t.Errorf("main %v", main)
+	}
+	if st := gs.Stats().Stages[1]; st.SideOutputs != 30 || st.SideDropped != 0 {
+		t.Errorf("%d side outputs, %d dropped", st.SideOutputs, st.SideDropped)
+	}
+}
+
+// batcher writes the events in batches of size, the last one is flushed when it's closed
+type batcher struct {
+	size    int
+	pending []int
+	flushed [][]int
+}
+
+func (b *batcher) HandleEvent(in interface{}) (interface{}, error) {
+	b.pending = append(b.pending, in.(int))
+	if len(b.pending) == b.size {
+		b.flush()
+	}
+	return nil, nil
+}
+
+func (b *batcher) Close() {
+	if len(b.pending) > 0 {
+		b.flush()
+	}
+}
+
+func (b *batcher) flush() {
+	b.flushed = append(b.flushed, b.pending)
+	b.pending = nil
+}
+
+func Test_ReferenceBatchFlushOnQuit(t *testing.T) {
+	sink := &batcher{size: 10}
+	configs := []*gostage.Config{
+		{Name: "producer", Worker: countdown(25, func(n int) interface{} { return n })},
+		{Name: "sink", Worker: sink, SubscribeToName: "producer"},
+	}
+	gs := gostage.New(context.Background(), configs, &recordingLogger{},
+		gostage.WithClock(&manualClock{now: time.Unix(0, 0)}))
+	if err := gs.Run(func() {}); err != nil {
+		t.Fatal(err)
+	}
+	var sizes []int
+	next := 1
+	for _, batch := range sink.flushed {
+		sizes = append(sizes, len(batch))
+		for _, n := range batch {
+			if n != next {
+				t.Fatalf("batches %v", sink.flushed)
+			}
+			next++
+		}
+	}
+	if want := []int{10, 10, 5}; !reflect.DeepEqual(sizes, want) {
+		t.Errorf("batch sizes %v, want %v", sizes, want)
+	}
+}
+
+func Test_ReferenceDeadLetters(t *testing.T) {
+	// the odd events fail and are routed to the dead letter file, the others go on
+	path := filepath.Join(t.TempDir(), "dead.letters")
+	sink := &collecting{}
+	configs := []*gostage.Config{
+		{Name: "producer", Worker: countdown(10, func(n int) interface{} { return n })},
+		{Name: "validate", Worker: validator(false), SubscribeToName: "producer"},
+		{Name: "sink", Worker: sink, SubscribeToName: "validate"},
+	}
+	gs := gostage.New(context.Background(), configs, &recordingLogger{},
+		gostage.WithDeadLetterFile(path), gostage.WithClock(&manualClock{now: time.Unix(0, 0)}))
+	if err := gs.Run(func() {}); err != nil {
+		t.Fatal(err)
+	}
+	if got := sink.sorted(); !reflect.DeepEqual(got, []int{2, 4, 6, 8, 10}) {
+		t.Errorf("sink got %v", got)
+	}
+	var dead []int
+	for _, ev := range deadLetters(t, path, gs) {
+		if ev.Stage != "validate" || ev.Err != errOdd.Error() {
+			t.Errorf("dead letter %+v", ev)
+		}
+		dead = append(dead, ev.Payload.(int))
+	}
+	if !reflect.DeepEqual(dead, []int{1, 3, 5, 7, 9}) {
+		t.Errorf("dead letters %v", dead)
+	}
+	if a := gs.Stats().Accounting; gs.Stats().Stages[1].Errors != 5 || a.DeadLettered != 5 || a.Completed != 5 {
+		t.Errorf("%d errors, accounting %+v", gs.Stats().Stages[1].Errors, a)
+	}
+	if sink.nilCount() != 0 {
+		t.Errorf("sink got %d nil payloads", sink.nilCount())
+	}
+}
+
+func Test_ReferenceGracefulDrain(t *testing.T) {
+	// stopped while the sink is busy, the buffered events are still handled
+	clock := &manualClock{now: time.Unix(0, 0)}
+	started := make(chan struct{})
+	release := make(chan struct{})
+	var once sync.Once
+	sink := gostage.WorkHandler(func(in interface{}) (interface{}, error) {
+		once.Do(func() {
+			close(started)
+			<-release
+		})
+		clock.Add(5 * time.Millisecond)
+		return nil, nil
+	})
+	configs := []*gostage.Config{
+		{Name: "producer", Worker: idle},
+		{Name: "sink", Worker: sink, SubscribeToName: "producer", BufferSize: 8},
+	}
+	gs := gostage.New(context.Background(), configs, &recordingLogger{},
+		gostage.WithClock(clock), gostage.WithStopMode(gostage.StopDrain))
+	done := make(chan struct{})
+	if err := gs.RunAsync(func() { close(done) }); err != nil {
+		t.Fatal(err)
+	}
+	for n := 1; n <= 8; n++ {
+		if err := gs.Push(context.Background(), n); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// the first event is being handled, the 7 others wait in the buffer
+	<-started
+	if err := gs.Stop(); err != nil {
+		t.Fatal(err)
+	}
+	close(release)
+	<-done
+
+	r := gs.Report()
+	if r.Accounting.Produced != 8 || r.Accounting.Completed != 8 || r.Accounting.DroppedInChannel != 0 {
+		t.Errorf("accounting %+v", r.Accounting)
+	}
+	if r.WallTime != 40*time.Millisecond {
+		t.Errorf("drained in %v, want 40ms", r.WallTime)
+	}
+}
+
+func Test_ReferencePanicRecovery(t *testing.T) {
+	// the consumer crashes on an event, it's restarted and the event is handled again
+	gs, attempts := redeliverPipeline(1, 1, gostage.WithClock(&manualClock{now: time.Unix(0, 0)}))
+	results, err := gs.Collect(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 10 || attempts[7] != 2 {
+		t.Errorf("got %v, event 7 handled %d times", results, attempts[7])
+	}
+	st := gs.Stats().Stages[1]
+	if st.Restarts != 1 {
+		t.Errorf("%d restarts, want 1", st.Restarts)
+	}
+	if a := gs.Stats().Accounting; a.Produced != 10 || a.Completed != 10 {
+		t.Errorf("accounting %+v", a)
+	}
+	if !errors.Is(gs.Reason(), gostage.ErrQuit) {
+		t.Errorf("stopped by %v", gs.Reason())
+	}
+}
@@ -0,0 +1,158 @@
+package examples
+
+import (
+	"context"
+	"errors"
+	"os"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/qgymje/gostage"
+)
+
+// tally counts the events it's given, the inits and the closes of its instances
+type tally struct {
+	events *atomic.Int64
+	inits  *atomic.Int64
+	closes *atomic.Int64
+}
+
+func newTally() *tally {
+	return &tally{events: &atomic.Int64{}, inits: &atomic.Int64{}, closes: &atomic.Int64{}}
+}
+
+func (c *tally) Create() gostage.Worker {
+	return &tally{events: c.events, inits: c.inits, closes: c.closes}
+}
+
+func (c *tally) Init() error {
+	c.inits.Add(1)
+	return nil
+}
+
+func (c *tally) Close() {
+	c.closes.Add(1)
+}
+
+func (c *tally) HandleEvent(in interface{}) (interface{}, error) {
+	c.events.Add(1)
+	return in, nil
+}
+
+func reloadConfigs(middle, sink *tally, size int) []*gostage.Config {
+	return []*gostage.Config{
+		{Name: "producer", Worker: idle},
+		{Name: "middle", Worker: middle, SubscribeToName: "producer", Size: size},
+		{Name: "sink", Worker: sink, SubscribeToName: "middle"},
+	}
+}
+
+func Test_ReloadChangesSize(t *testing.T) {
+	middle, sink := newTally(), newTally()
+	observer := &lifecycleObserver{}
+	gs := gostage.New(context.Background(), reloadConfigs(middle, sink, 1), &recordingLogger{},
+		gostage.WithStopMode(gostage.StopDrain), gostage.WithNoDataCountSleep(time.Millisecond),
+		gostage.WithObserver(observer),
+		gostage.WithReload(syscall.SIGHUP, func() ([]*gostage.Config, []gostage.Option, error) {
+			return reloadConfigs(middle, sink, 3), nil, nil
+		}))
+	done := make(chan struct{})
+	if err := gs.RunAsync(func() { close(done) }); err != nil {
+		t.Fatal(err)
+	}
+	for n := 0; n < 3; n++ {
+		if err := gs.Push(context.Background(), n); err != nil {
+			t.Fatal(err)
+		}
+	}
+	waitFor(t, "the first events", func() bool { return sink.events.Load() == 3 })
+
+	if err := gs.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "the reload", func() bool { return gs.Stats().Reloads == 1 })
+	if got := gs.Stats().Stages[1].Concurrency; got != 3 {
+		t.Errorf("middle runs %d instances, want 3", got)
+	}
+	// the middle stage changed and was made again, the sink is the same worker
+	if middle.inits.Load() != 4 || middle.closes.Load() != 1 {
+		t.Errorf("middle inited %d times, closed %d times", middle.inits.Load(), middle.closes.Load())
+	}
+	if sink.inits.Load() != 1 || sink.closes.Load() != 0 {
+		t.Errorf("sink inited %d times, closed %d times", sink.inits.Load(), sink.closes.Load())
+	}
+
+	for n := 0; n < 6; n++ {
+		if err := gs.Push(context.Background(), n); err != nil {
+			t.Fatal(err)
+		}
+	}
+	waitFor(t, "the events after the reload", func() bool { return sink.events.Load() == 9 })
+	gs.Stop()
+	<-done
+	if sink.closes.Load() != 1 || middle.closes.Load() != 4 {
+		t.Errorf("closed %d sinks and %d middles after the stop", sink.closes.Load(), middle.closes.Load())
+	}
+	if kinds := observer.kinds(gostage.Reloaded); kinds != 1 {
+		t.Errorf("%d reloaded events", kinds)
+	}
+}
+
+func Test_ReloadFailures(t *testing.T) {
+	middle, sink := newTally(), newTally()
+	var rebuilds atomic.Int64
+	logger := &recordingLogger{}
+	gs := gostage.New(context.Background(), reloadConfigs(middle, sink, 2), logger,
+		gostage.WithStopMode(gostage.StopDrain), gostage.WithNoDataCountSleep(time.Millisecond),
+		gostage.WithReload(syscall.SIGHUP, func() ([]*gostage.Config, []gostage.Option, error) {
+			if rebuilds.Add(1) == 1 {
+				return nil, nil, errors.New("bad config file")
+			}
+			// the sink subscribes to a stage which isn't there
+			configs := reloadConfigs(middle, sink, 4)
+			configs[2].SubscribeToName = "enrich"
+			return configs, nil, nil
+		}))
+	done := make(chan struct{})
+	if err := gs.RunAsync(func() { close(done) }); err != nil {
+		t.Fatal(err)
+	}
+
+	// from the signal
+	p, err := os.FindProcess(os.Getpid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Signal(syscall.SIGHUP); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "the failed rebuild", func() bool { return gs.Stats().FailedReloads == 1 })
+	if err := gs.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "the invalid topology", func() bool { return gs.Stats().FailedReloads == 2 })
+
+	// still running the first topology
+	if err := gs.Push(context.Background(), 1); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "the event", func() bool { return sink.events.Load() == 1 })
+	st := gs.Stats()
+	if st.Reloads != 0 || st.Stages[1].Concurrency != 2 || middle.inits.Load() != 2 {
+		t.Errorf("%d reloads, %d instances, %d inits", st.Reloads, st.Stages[1].Concurrency, middle.inits.Load())
+	}
+	if len(logger.find("[Error]gostage reload: bad config file")) != 1 {
+		t.Errorf("the failed rebuild wasn't logged")
+	}
+	if len(logger.find("[Error]gostage reload:", "enrich")) != 1 {
+		t.Errorf("the invalid topology wasn't logged")
+	}
+	gs.Stop()
+	<-done
+
+	if err := gs.Reload(); !errors.Is(err, gostage.ErrNotRunning) {
+		t.Errorf("reload of a stopped pipeline: %v", err)
+	}
+}
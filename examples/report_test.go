@@ -0,0 +1,106 @@
+package examples
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/qgymje/gostage"
+)
+
+// manualClock only moves when Add is called, its timers fire right away
+type manualClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *manualClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *manualClock) After(time.Duration) <-chan time.Time {
+	ch := make(chan time.Time, 1)
+	ch <- c.Now()
+	return ch
+}
+
+func (c *manualClock) Add(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+func Test_Report(t *testing.T) {
+	clock := &manualClock{now: time.Unix(0, 0)}
+	producer := countdown(20, func(n int) interface{} { return n })
+	// takes 1ms per event but 10ms for the last one, and skips two events
+	middle := gostage.WorkHandler(func(in interface{}) (interface{}, error) {
+		if in.(int) == 20 {
+			clock.Add(10 * time.Millisecond)
+		} else {
+			clock.Add(time.Millisecond)
+		}
+		if in.(int)%10 == 3 {
+			return nil, gostage.ErrNoData
+		}
+		return in, nil
+	})
+	// fails the 5th event once
+	failed := false
+	sink := gostage.WorkHandler(func(in interface{}) (interface{}, error) {
+		if in.(int) == 5 && !failed {
+			failed = true
+			return nil, errors.New("try again")
+		}
+		return nil, nil
+	})
+	configs := []*gostage.Config{
+		{Name: "producer", Worker: producer},
+		{Name: "middle", Worker: middle, SubscribeToName: "producer"},
+		{Name: "sink", Worker: sink, SubscribeToName: "middle", ErrorMode: gostage.RetryN, Retries: 2},
+	}
+	gs := gostage.New(context.Background(), configs, &recordingLogger{}, gostage.WithClock(clock), gostage.WithTiming())
+	if err := gs.Run(func() {}); err != nil {
+		t.Fatal(err)
+	}
+
+	r := gs.Report()
+	if !errors.Is(r.Reason, gostage.ErrQuit) || r.WallTime != 29*time.Millisecond {
+		t.Fatalf("stopped by %v after %v", r.Reason, r.WallTime)
+	}
+	if r.Accounting.Produced != 20 || r.Accounting.Completed != 18 || r.Accounting.Skipped != 2 {
+		t.Fatalf("accounting %+v", r.Accounting)
+	}
+	want := []gostage.StageReport{
+		{Name: "producer", Out: 20},
+		{Name: "middle", In: 20, Out: 18},
+		{Name: "sink", In: 18, Out: 18, Errors: 1, Retries: 1},
+	}
+	for k, st := range r.Stages {
+		if st.Name == "middle" {
+			if st.P95 < time.Millisecond || st.P95 > 1250*time.Microsecond || st.BusyTime != 29*time.Millisecond {
+				t.Fatalf("middle p95 %v, busy %v", st.P95, st.BusyTime)
+			}
+		}
+		st.P95, st.BusyTime = 0, 0
+		if st != want[k] {
+			t.Fatalf("report %+v, want %+v", st, want[k])
+		}
+	}
+
+	// frozen once stopped
+	clock.Add(time.Hour)
+	if again := gs.Report(); !reflect.DeepEqual(again, r) {
+		t.Fatalf("report changed to %+v", again)
+	}
+	table := r.String()
+	if !strings.Contains(table, "stopped: quit") || len(strings.Split(strings.TrimSpace(table), "\n")) != 5 {
+		t.Fatalf("report:\n%s", table)
+	}
+}
@@ -0,0 +1,187 @@
+package examples
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/qgymje/gostage"
+)
+
+type closeCounter struct {
+	closed *int64
+}
+
+func (c *closeCounter) Create() gostage.Worker {
+	return &closeCounter{closed: c.closed}
+}
+
+func (c *closeCounter) Close() {
+	atomic.AddInt64(c.closed, 1)
+}
+
+func (c *closeCounter) HandleEvent(in interface{}) (interface{}, error) {
+	return in, nil
+}
+
+func Test_RunAgain(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var produced, consumed int64
+	producer := gostage.WorkHandler(func(_ interface{}) (interface{}, error) {
+		if atomic.LoadInt64(&produced) == 3 {
+			// wait for the consumer, so quitting doesn't race with the last event
+			for atomic.LoadInt64(&consumed) != 3 {
+				time.Sleep(time.Millisecond)
+			}
+			return nil, gostage.ErrQuit
+		}
+		return atomic.AddInt64(&produced, 1), nil
+	})
+
+	var closed int64
+	middle := &closeCounter{closed: &closed}
+	consumer := gostage.WorkHandler(func(in interface{}) (interface{}, error) {
+		atomic.AddInt64(&consumed, 1)
+		return nil, nil
+	})
+
+	configs := []*gostage.Config{
+		{Name: "producer", Worker: producer},
+		{Name: "middle", Worker: middle, SubscribeTo: producer, Size: 2},
+		{Name: "consumer", Worker: consumer, SubscribeTo: middle},
+	}
+	gs := gostage.New(ctx, configs, gostage.NewStdLogger())
+
+	for run := 1; run <= 2; run++ {
+		atomic.StoreInt64(&produced, 0)
+		atomic.StoreInt64(&consumed, 0)
+
+		if err := gs.Run(func() {}); err != nil {
+			t.Fatalf("run #%d: %v", run, err)
+		}
+
+		stats := gs.Stats()
+		if len(stats.Stages) != 3 {
+			t.Fatalf("run #%d: got %d stages, want 3", run, len(stats.Stages))
+		}
+		if got := stats.Stages[2].Processed; got != 3 {
+			t.Fatalf("run #%d: consumer processed %d events, want 3", run, got)
+		}
+		if got := atomic.LoadInt64(&closed); got != int64(2*run) {
+			t.Fatalf("run #%d: Close called %d times, want %d", run, got, 2*run)
+		}
+	}
+}
+
+func Test_RunWhileRunning(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	producer := gostage.WorkHandler(func(_ interface{}) (interface{}, error) {
+		return nil, gostage.ErrNoData
+	})
+	consumer := gostage.WorkHandler(func(in interface{}) (interface{}, error) {
+		return nil, nil
+	})
+	configs := []*gostage.Config{
+		{Worker: producer},
+		{Worker: consumer, SubscribeTo: producer},
+	}
+	gs := gostage.New(ctx, configs, gostage.NewStdLogger(), gostage.WithNoDataCountSleep(time.Millisecond))
+
+	done := make(chan struct{})
+	if err := gs.RunAsync(func() { close(done) }); err != nil {
+		t.Fatal(err)
+	}
+	if err := gs.RunAsync(func() {}); !errors.Is(err, gostage.ErrAlreadyRunning) {
+		t.Fatalf("second RunAsync returned %v, want ErrAlreadyRunning", err)
+	}
+	if err := gs.Run(func() {}); !errors.Is(err, gostage.ErrAlreadyRunning) {
+		t.Fatalf("Run returned %v, want ErrAlreadyRunning", err)
+	}
+
+	cancel()
+	<-done
+}
+
+func Test_ConcurrentRunAndStop(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var active, overlapped int64
+	producer := gostage.WorkHandler(func(_ interface{}) (interface{}, error) {
+		if atomic.AddInt64(&active, 1) > 1 {
+			atomic.StoreInt64(&overlapped, 1)
+		}
+		atomic.AddInt64(&active, -1)
+		return nil, gostage.ErrNoData
+	})
+	consumer := gostage.WorkHandler(func(in interface{}) (interface{}, error) {
+		return nil, nil
+	})
+	configs := []*gostage.Config{
+		{Worker: producer},
+		{Worker: consumer, SubscribeTo: producer},
+	}
+	gs := gostage.New(ctx, configs, gostage.NewStdLogger(),
+		gostage.WithNoDataCount(1),
+		gostage.WithNoDataCountSleep(time.Millisecond),
+	)
+
+	var started, finished int64
+	callback := func() { atomic.AddInt64(&finished, 1) }
+
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 20; i++ {
+				switch (g + i) % 3 {
+				case 0:
+					if gs.RunAsync(callback) == nil {
+						atomic.AddInt64(&started, 1)
+					}
+				case 1:
+					if gs.Run(callback) == nil {
+						atomic.AddInt64(&started, 1)
+					}
+				default:
+					gs.Stop()
+				}
+			}
+		}(g)
+	}
+
+	// keep stopping until every blocked Run has returned
+	finishedAll := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(finishedAll)
+	}()
+	for {
+		select {
+		case <-finishedAll:
+		case <-time.After(time.Millisecond):
+			gs.Stop()
+			continue
+		}
+		break
+	}
+	waitFor(t, "the last run to stop", func() bool {
+		gs.Stop()
+		return gs.State() == gostage.StateStopped
+	})
+
+	if atomic.LoadInt64(&overlapped) != 0 {
+		t.Fatal("two runs were active at the same time")
+	}
+	if s, f := atomic.LoadInt64(&started), atomic.LoadInt64(&finished); s == 0 || s != f {
+		t.Fatalf("started %d runs, finished %d", s, f)
+	}
+}
@@ -0,0 +1,73 @@
+package examples
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/qgymje/gostage"
+)
+
+// panicsOn returns a consumer which panics on the given events
+func panicsOn(events ...int) gostage.WorkHandler {
+	return func(in interface{}) (interface{}, error) {
+		for _, n := range events {
+			if in.(int) == n {
+				panic(n)
+			}
+		}
+		return nil, nil
+	}
+}
+
+func Test_RestartMatrix(t *testing.T) {
+	cases := []struct {
+		name    string
+		config  gostage.Config
+		panics  []int
+		survive bool
+	}{
+		{"unset uses default", gostage.Config{}, []int{2}, true},
+		{"unset exhausts default", gostage.Config{}, []int{2, 4}, false},
+		{"disabled", gostage.Config{DisableRestart: true}, []int{2}, false},
+		{"disabled wins over restart", gostage.Config{Restart: 3, DisableRestart: true}, []int{2}, false},
+		{"positive", gostage.Config{Restart: 2}, []int{2, 4}, true},
+	}
+
+	for _, c := range cases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			next := 0
+			producer := gostage.WorkHandler(func(_ interface{}) (interface{}, error) {
+				if next == 5 {
+					return nil, gostage.ErrQuit
+				}
+				next++
+				return next, nil
+			})
+			consumer := c.config
+			consumer.Name = "consumer"
+			consumer.Worker = panicsOn(c.panics...)
+			consumer.SubscribeTo = producer
+			configs := []*gostage.Config{
+				{Name: "producer", Worker: producer},
+				&consumer,
+			}
+
+			gs := gostage.New(ctx, configs, &recordingLogger{})
+			gs.Run(func() {})
+
+			reason := gs.Reason()
+			if c.survive && !errors.Is(reason, gostage.ErrQuit) {
+				t.Fatalf("reason = %v, want ErrQuit", reason)
+			}
+			if !c.survive && !errors.Is(reason, gostage.ErrSupervision) {
+				t.Fatalf("reason = %v, want ErrSupervision", reason)
+			}
+		})
+	}
+}
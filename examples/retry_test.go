@@ -0,0 +1,143 @@
+package examples
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/qgymje/gostage"
+)
+
+// flakySink fails the first failures attempts of every event
+func flakySink(failures int) gostage.WorkHandler {
+	attempts := map[interface{}]int{}
+	return func(in interface{}) (interface{}, error) {
+		attempts[in]++
+		if attempts[in] <= failures {
+			return nil, fmt.Errorf("attempt %d of %v failed", attempts[in], in)
+		}
+		return in, nil
+	}
+}
+
+func retryPipeline(sink gostage.Config, opts ...gostage.Option) *gostage.GoStage {
+	next := 0
+	producer := gostage.WorkHandler(func(_ interface{}) (interface{}, error) {
+		if next == 10 {
+			return nil, gostage.ErrQuit
+		}
+		next++
+		return next, nil
+	})
+	sink.Name = "sink"
+	sink.SubscribeTo = producer
+	sink.RetryBackoff = func(int) time.Duration { return 0 }
+	configs := []*gostage.Config{
+		{Name: "producer", Worker: producer},
+		&sink,
+	}
+	return gostage.New(context.Background(), configs, &recordingLogger{}, opts...)
+}
+
+func Test_RetryForever(t *testing.T) {
+	gs := retryPipeline(gostage.Config{Worker: flakySink(5), ErrorMode: gostage.RetryForever})
+	results, err := gs.Collect(context.Background())
+	if err != nil {
+		t.Fatalf("Collect error = %v", err)
+	}
+	if len(results) != 10 {
+		t.Fatalf("got %v, want every event once", results)
+	}
+	for k, v := range results {
+		if v != k+1 {
+			t.Fatalf("got %v, want 1..10 in order", results)
+		}
+	}
+	if stats := gs.Stats().Stages[1]; stats.Processed != 10 || stats.Errors != 50 {
+		t.Fatalf("processed = %d, errors = %d, want 10 and 50", stats.Processed, stats.Errors)
+	}
+}
+
+func Test_RetryN(t *testing.T) {
+	var mu sync.Mutex
+	exhausted := 0
+	onError := gostage.WithOnError(func(err *gostage.StageError) {
+		mu.Lock()
+		defer mu.Unlock()
+		if errors.Is(err, gostage.ErrRetriesExhausted) {
+			exhausted++
+		}
+	})
+
+	// the third attempt succeeds
+	gs := retryPipeline(gostage.Config{Worker: flakySink(2), ErrorMode: gostage.RetryN, Retries: 2}, onError)
+	if results, _ := gs.Collect(context.Background()); len(results) != 10 || exhausted != 0 {
+		t.Fatalf("got %d results and %d exhausted events, want 10 and 0", len(results), exhausted)
+	}
+
+	gs = retryPipeline(gostage.Config{Worker: flakySink(3), ErrorMode: gostage.RetryN, Retries: 2}, onError)
+	if results, _ := gs.Collect(context.Background()); len(results) != 0 || exhausted != 10 {
+		t.Fatalf("got %d results and %d exhausted events, want 0 and 10", len(results), exhausted)
+	}
+}
+
+func Test_RetryStops(t *testing.T) {
+	gs := retryPipeline(gostage.Config{Worker: flakySink(1 << 30), ErrorMode: gostage.RetryForever})
+	done := make(chan struct{})
+	if err := gs.RunAsync(func() { close(done) }); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "the sink to retry", func() bool {
+		return gs.Stats().Stages[1].Errors > 3
+	})
+
+	gs.Stop()
+	select {
+	case <-done:
+	case <-time.After(3 * time.Second):
+		t.Fatal("the retrying sink didn't stop")
+	}
+}
+
+func Test_FailedEventsAreNotForwarded(t *testing.T) {
+	for _, middle := range []gostage.Config{
+		{ErrorMode: gostage.Drop},
+		{ErrorMode: gostage.RetryN, Retries: 2},
+	} {
+		var mu sync.Mutex
+		var got []interface{}
+		sink := gostage.WorkHandler(func(in interface{}) (interface{}, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			got = append(got, in)
+			return nil, nil
+		})
+		middle.Name = "middle"
+		middle.Worker = gostage.WorkHandler(func(in interface{}) (interface{}, error) {
+			if in == 2 {
+				return nil, errors.New("bad event")
+			}
+			return in, nil
+		})
+		middle.SubscribeToName = "producer"
+		middle.RetryBackoff = func(int) time.Duration { return 0 }
+		configs := []*gostage.Config{
+			{Name: "producer", Worker: countdown(3, func(n int) interface{} { return n })},
+			&middle,
+			{Name: "sink", Worker: sink, SubscribeToName: "middle"},
+		}
+		gs := gostage.New(context.Background(), configs, &recordingLogger{})
+		if err := gs.Run(func() {}); err != nil {
+			t.Fatal(err)
+		}
+		if len(got) != 2 || got[0] != 1 || got[1] != 3 {
+			t.Fatalf("%v: sink got %v, want [1 3]", middle.ErrorMode, got)
+		}
+		if a := gs.Stats().Accounting; a.Completed != 2 || a.DeadLettered != 1 {
+			t.Fatalf("%v: accounting %+v, want 2 completed and 1 dead-lettered", middle.ErrorMode, a)
+		}
+	}
+}
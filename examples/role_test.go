@@ -0,0 +1,57 @@
+package examples
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/qgymje/gostage"
+)
+
+func Test_ExplicitRoles(t *testing.T) {
+	producer := counter(0, 3)
+	configs := []*gostage.Config{
+		{Name: "producer", Worker: producer, Role: gostage.Source},
+		{Name: "add", Worker: adder{}, SubscribeTo: producer, Role: gostage.Transform},
+		{Name: "sink", Worker: passThrough{}, SubscribeToName: "add", Role: gostage.Sink},
+	}
+	results, err := gostage.New(context.Background(), configs, &recordingLogger{}).Collect(context.Background())
+	if err != nil || len(results) != 3 {
+		t.Fatalf("got %v, %v, want 3 results", results, err)
+	}
+}
+
+func Test_RoleMismatch(t *testing.T) {
+	producer := counter(0, 3)
+	sink := gostage.SinkHandler(func(interface{}) error { return nil })
+	cases := []struct {
+		name    string
+		configs []*gostage.Config
+	}{
+		{"sink with subscribers", []*gostage.Config{
+			{Name: "producer", Worker: producer},
+			{Name: "former sink", Worker: passThrough{}, SubscribeTo: producer, Role: gostage.Sink},
+			{Name: "appended", Worker: adder{}, SubscribeToName: "former sink"},
+		}},
+		{"subscribed source", []*gostage.Config{
+			{Name: "producer", Worker: producer},
+			{Name: "source", Worker: adder{}, SubscribeTo: producer, Role: gostage.Source},
+		}},
+		{"transform without subscribers", []*gostage.Config{
+			{Name: "producer", Worker: producer},
+			{Name: "transform", Worker: adder{}, SubscribeTo: producer, Role: gostage.Transform},
+		}},
+		{"role conflicting with the worker", []*gostage.Config{
+			{Name: "producer", Worker: producer},
+			{Name: "sink", Worker: sink, SubscribeTo: producer, Role: gostage.Transform},
+			{Name: "after", Worker: adder{}, SubscribeToName: "sink"},
+		}},
+	}
+
+	for _, c := range cases {
+		err := gostage.New(context.Background(), c.configs, &recordingLogger{}).Run(func() {})
+		if !errors.Is(err, gostage.ErrMisplacedStage) {
+			t.Fatalf("%s: Run error = %v, want ErrMisplacedStage", c.name, err)
+		}
+	}
+}
@@ -0,0 +1,219 @@
+package examples
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/qgymje/gostage"
+)
+
+// source emits its tag every interval, quits after limit events if limit > 0
+func source(tag string, interval time.Duration, limit int) gostage.WorkHandler {
+	sent := 0
+	return func(_ interface{}) (interface{}, error) {
+		if limit > 0 && sent == limit {
+			return nil, gostage.ErrQuit
+		}
+		sent++
+		time.Sleep(interval)
+		return tag, nil
+	}
+}
+
+func Test_MultipleRoots(t *testing.T) {
+	cases := []struct {
+		name   string
+		fast   int
+		slow   int
+		quitBy string
+	}{
+		{"fast root quits", 30, 0, "fast"},
+		{"slow root quits", 0, 10, "slow"},
+	}
+
+	for _, c := range cases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			fast := source("fast", time.Millisecond, c.fast)
+			slow := source("slow", 3*time.Millisecond, c.slow)
+			sink := gostage.WorkHandler(func(in interface{}) (interface{}, error) {
+				return in, nil
+			})
+			configs := []*gostage.Config{
+				{Name: "fast", Worker: fast},
+				{Name: "sink", Worker: sink, SubscribeTo: fast},
+				// nothing subscribes to slow, it feeds the same stage as fast
+				{Name: "slow", Worker: slow},
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			gs := gostage.New(ctx, configs, &recordingLogger{})
+			results, err := gs.Collect(ctx)
+			if err != nil {
+				t.Fatalf("Collect error = %v", err)
+			}
+			if !errors.Is(gs.Reason(), gostage.ErrQuit) {
+				t.Fatalf("reason = %v, want ErrQuit", gs.Reason())
+			}
+
+			count := map[interface{}]int{}
+			firstSlow, lastFast := -1, -1
+			for k, v := range results {
+				count[v]++
+				if v == "slow" && firstSlow < 0 {
+					firstSlow = k
+				}
+				if v == "fast" {
+					lastFast = k
+				}
+			}
+			if count["fast"] == 0 || count["slow"] == 0 {
+				t.Fatalf("got %v, want events from both roots", count)
+			}
+			if firstSlow > lastFast {
+				t.Fatalf("events of the roots aren't interleaved: %v", results)
+			}
+			if limit := map[string]int{"fast": c.fast, "slow": c.slow}[c.quitBy]; count[c.quitBy] != limit {
+				t.Fatalf("got %d events from %s, want %d", count[c.quitBy], c.quitBy, limit)
+			}
+		})
+	}
+}
+
+func Test_RootsFeedingDifferentStages(t *testing.T) {
+	a, b := source("a", 0, 1), source("b", 0, 1)
+	configs := []*gostage.Config{
+		{Name: "a", Worker: a},
+		{Name: "b", Worker: b},
+		{Name: "after a", Worker: passThrough{}, SubscribeTo: a},
+		{Name: "after b", Worker: adder{}, SubscribeTo: b},
+	}
+	err := gostage.New(context.Background(), configs, &recordingLogger{}).Run(func() {})
+	if !errors.Is(err, gostage.ErrMultipleRoots) {
+		t.Fatalf("Run error = %v, want ErrMultipleRoots", err)
+	}
+}
+
+func Test_TwoSubscribersOfAStage(t *testing.T) {
+	for _, c := range []struct {
+		name    string
+		second  string
+		message string
+	}{
+		{"a producer", "a", "second subscribes to a which already feeds merge"},
+		{"the merge point", "merge", "second subscribes to merge which already feeds sink"},
+	} {
+		a, b := source("a", 0, 1), source("b", 0, 1)
+		configs := []*gostage.Config{
+			{Name: "a", Worker: a},
+			{Name: "b", Worker: b},
+			{Name: "merge", Worker: passThrough{}, SubscribeTo: a},
+			{Name: "sink", Worker: adder{}, SubscribeToName: "merge"},
+			{Name: "second", Worker: adder{n: 1}, SubscribeToName: c.second},
+		}
+		err := gostage.New(context.Background(), configs, &recordingLogger{}).Run(func() {})
+		if !errors.Is(err, gostage.ErrFanOut) || !strings.Contains(err.Error(), c.message) {
+			t.Fatalf("%s: Run error = %v, want ErrFanOut naming %q", c.name, err, c.message)
+		}
+	}
+}
+
+func Test_QuitPolicy(t *testing.T) {
+	cases := []struct {
+		policy gostage.QuitPolicy
+		// the events expected from late, zero if not checked
+		wantLate int
+		reason   string
+	}{
+		{gostage.QuitAny, 0, "early finished"},
+		{gostage.QuitAll, 20, "early, late finished"},
+	}
+
+	for _, c := range cases {
+		early := source("early", 0, 5)
+		late := source("late", 5*time.Millisecond, 20)
+		configs := []*gostage.Config{
+			{Name: "early", Worker: early},
+			{Name: "late", Worker: late},
+			{Name: "sink", Worker: passThrough{}, SubscribeTo: early},
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		gs := gostage.New(ctx, configs, &recordingLogger{}, gostage.WithQuitPolicy(c.policy))
+		results, err := gs.Collect(ctx)
+		cancel()
+		if err != nil {
+			t.Fatalf("policy %d: Collect error = %v", c.policy, err)
+		}
+
+		reason := gs.Reason()
+		if !errors.Is(reason, gostage.ErrQuit) || reason.Error() != "quit: "+c.reason {
+			t.Fatalf("policy %d: reason = %v, want %q", c.policy, reason, c.reason)
+		}
+		count := map[interface{}]int{}
+		for _, v := range results {
+			count[v]++
+		}
+		if count["early"] != 5 || (c.wantLate > 0 && count["late"] != c.wantLate) {
+			t.Fatalf("policy %d: got %v", c.policy, count)
+		}
+		stats := gs.Stats()
+		if !stats.Stages[0].Finished || stats.Stages[1].Finished != (c.policy == gostage.QuitAll) {
+			t.Fatalf("policy %d: got stats %+v", c.policy, stats.Stages)
+		}
+	}
+}
+
+// poller is a producer created for several instances, instance 1 quits after 5 events
+type poller struct {
+	n    int
+	sent int
+}
+
+func (p *poller) CreateWithInfo(_ string, instance, _ int) gostage.Worker {
+	return &poller{n: instance}
+}
+
+func (p *poller) HandleEvent(interface{}) (interface{}, error) {
+	if p.n == 1 && p.sent == 5 {
+		return nil, gostage.ErrQuit
+	}
+	p.sent++
+	time.Sleep(time.Millisecond)
+	return p.n, nil
+}
+
+func Test_QuitStopsSiblingInstances(t *testing.T) {
+	configs := []*gostage.Config{
+		{Name: "pollers", Worker: &poller{}, Size: 3},
+		// keeps the pipeline running after the pollers have quit
+		{Name: "other", Worker: source("other", time.Millisecond, 0)},
+		{Name: "sink", Worker: passThrough{}, SubscribeToName: "pollers"},
+	}
+	gs := gostage.New(context.Background(), configs, &recordingLogger{}, gostage.WithQuitPolicy(gostage.QuitAll))
+	done := make(chan struct{})
+	if err := gs.RunAsync(func() { close(done) }); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		gs.Stop()
+		<-done
+	}()
+
+	processed := func() int64 {
+		return gs.Stats().Stages[0].Processed
+	}
+	waitFor(t, "the pollers to finish", func() bool {
+		return gs.Stats().Stages[0].Finished
+	})
+	time.Sleep(5 * time.Millisecond)
+	before := processed()
+	time.Sleep(30 * time.Millisecond)
+	if after := processed(); after != before {
+		t.Fatalf("pollers produced %d events after quitting", after-before)
+	}
+}
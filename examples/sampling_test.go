@@ -0,0 +1,57 @@
+package examples
+
+import (
+	"context"
+	"math/rand"
+	"regexp"
+	"testing"
+
+	"github.com/qgymje/gostage"
+)
+
+func Test_DebugSampling(t *testing.T) {
+	const seed, events = 42, 200
+	// the producer draws every event in order
+	draws := rand.New(rand.NewSource(seed))
+	var want02, want05 int
+	for k := 0; k < events; k++ {
+		u := draws.Float64()
+		if u < 0.2 {
+			want02++
+		}
+		if u < 0.5 {
+			want05++
+		}
+	}
+
+	configs := []*gostage.Config{
+		{Name: "producer", Worker: countdown(events, func(n int) interface{} { return n })},
+		{Name: "quiet", Worker: passThrough{}, SubscribeToName: "producer", DebugSampling: -1},
+		{Name: "sink", Worker: passThrough{}, SubscribeToName: "quiet", DebugSampling: 0.5},
+	}
+	logger := &recordingLogger{}
+	gs := gostage.New(context.Background(), configs, logger,
+		gostage.WithDebugSampling(0.2), gostage.WithSamplingSource(rand.NewSource(seed)))
+	if err := gs.Run(func() {}); err != nil {
+		t.Fatal(err)
+	}
+
+	ids := regexp.MustCompile(`sampled event (\d+): input = `)
+	sampled := func(stage string) map[string]bool {
+		found := make(map[string]bool)
+		for _, line := range logger.find("[Debug]" + stage + "_#0 sampled event") {
+			found[ids.FindStringSubmatch(line)[1]] = true
+		}
+		return found
+	}
+	producer, quiet, sink := sampled("producer"), sampled("quiet"), sampled("sink")
+	if len(producer) != want02 || len(quiet) != 0 || len(sink) != want05 {
+		t.Fatalf("sampled %d, %d and %d events, want %d, 0 and %d", len(producer), len(quiet), len(sink), want02, want05)
+	}
+	// the events sampled by the producer are followed by the sink
+	for id := range producer {
+		if !sink[id] {
+			t.Fatalf("event %s sampled by the producer only", id)
+		}
+	}
+}
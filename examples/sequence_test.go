@@ -0,0 +1,55 @@
+package examples
+
+import (
+	"context"
+	"testing"
+
+	"github.com/qgymje/gostage"
+)
+
+// counter emits from+1 .. from+n then quits
+func counter(from, n int) gostage.WorkHandler {
+	next := from
+	return func(_ interface{}) (interface{}, error) {
+		if next == from+n {
+			return nil, gostage.ErrQuit
+		}
+		next++
+		return next, nil
+	}
+}
+
+func Test_SequenceCheck(t *testing.T) {
+	a, b := counter(0, 200), counter(1000, 200)
+	// loses event 5 of a by crashing on it
+	faulty := gostage.WorkHandler(func(in interface{}) (interface{}, error) {
+		if in.(int) == 5 {
+			panic("faulty middle stage")
+		}
+		return in, nil
+	})
+	configs := []*gostage.Config{
+		{Name: "a", Worker: a},
+		{Name: "b", Worker: b},
+		{Name: "faulty", Worker: faulty, SubscribeTo: a, Restart: 1},
+		{Name: "sink", Worker: passThrough{}, SubscribeTo: faulty},
+	}
+
+	logger := &recordingLogger{}
+	gs := gostage.New(context.Background(), configs, logger,
+		gostage.WithQuitPolicy(gostage.QuitAll), gostage.WithSequenceCheck(64))
+	if err := gs.Run(func() {}); err != nil {
+		t.Fatal(err)
+	}
+
+	stats := gs.Stats()
+	if st := stats.Stages[0]; st.Gaps != 1 || st.Duplicates != 0 || st.Late != 0 {
+		t.Fatalf("a: got %+v, want one gap", st)
+	}
+	if st := stats.Stages[1]; st.Gaps != 0 || st.Duplicates != 0 || st.Late != 0 {
+		t.Fatalf("b: got %+v, want no gap", st)
+	}
+	if len(logger.find("a sequence check: 200 produced, 1 gaps")) != 1 {
+		t.Fatal("missing the log line of the gap")
+	}
+}
@@ -0,0 +1,77 @@
+package examples
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/qgymje/gostage"
+)
+
+// doubler is stateless and has no Create method
+type doubler struct {
+	closed *int32
+}
+
+func (d doubler) HandleEvent(in interface{}) (interface{}, error) {
+	return in.(int) * 2, nil
+}
+
+func (d doubler) Close() {
+	atomic.AddInt32(d.closed, 1)
+}
+
+func Test_ShareInstance(t *testing.T) {
+	next := 0
+	producer := gostage.WorkHandler(func(_ interface{}) (interface{}, error) {
+		if next == 200 {
+			return nil, gostage.ErrQuit
+		}
+		next++
+		return next, nil
+	})
+	var closed int32
+	configs := []*gostage.Config{
+		{Name: "producer", Worker: producer},
+		{Name: "doubler", Worker: doubler{closed: &closed}, SubscribeTo: producer, Size: 4, ShareInstance: true},
+		{Name: "sink", Worker: passThrough{}, SubscribeToName: "doubler"},
+	}
+
+	gs := gostage.New(context.Background(), configs, &recordingLogger{})
+	out, err := gs.Collect(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := 0
+	for _, v := range out {
+		sum += v.(int)
+	}
+	if len(out) != 200 || sum != 200*201 {
+		t.Fatalf("collected %d events summing to %d, want 200 summing to %d", len(out), sum, 200*201)
+	}
+	if closed != 1 {
+		t.Fatalf("shared worker closed %d times, want 1", closed)
+	}
+}
+
+func Test_MissingCreate(t *testing.T) {
+	producer := gostage.WorkHandler(func(_ interface{}) (interface{}, error) {
+		return nil, gostage.ErrQuit
+	})
+	var closed int32
+	configs := []*gostage.Config{
+		{Name: "producer", Worker: producer},
+		{Name: "doubler", Worker: doubler{closed: &closed}, SubscribeTo: producer, Size: 3},
+	}
+
+	gs := gostage.New(context.Background(), configs, &recordingLogger{})
+	err := gs.Run(func() {})
+	if !errors.Is(err, gostage.ErrMissingCreate) || !strings.Contains(err.Error(), "doubler") {
+		t.Fatalf("Run() = %v, want ErrMissingCreate naming the stage", err)
+	}
+	if gs.State() != gostage.StateStopped {
+		t.Fatalf("state is %s, want stopped", gs.State())
+	}
+}
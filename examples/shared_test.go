@@ -0,0 +1,93 @@
+package examples
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/qgymje/gostage"
+)
+
+// sharedWorker is a worker safe for concurrent use which counts its calls
+type sharedWorker struct {
+	inits, closes, handled atomic.Int64
+}
+
+func (c *sharedWorker) Init() error {
+	c.inits.Add(1)
+	return nil
+}
+
+func (c *sharedWorker) Close() {
+	c.closes.Add(1)
+}
+
+func (c *sharedWorker) HandleEvent(in interface{}) (interface{}, error) {
+	c.handled.Add(1)
+	return in, nil
+}
+
+func sharedConfigs(w *sharedWorker) []*gostage.Config {
+	return []*gostage.Config{
+		{Name: "producer", Worker: countdown(30, func(n int) interface{} { return n })},
+		{Name: "clean", Worker: w, SubscribeToName: "producer", Size: 2, ShareInstance: true},
+		{Name: "enrich", Worker: w, SubscribeToName: "clean"},
+		{Name: "sink", Worker: passThrough{}, SubscribeToName: "enrich"},
+	}
+}
+
+func Test_SharedWorkerRejected(t *testing.T) {
+	w := &sharedWorker{}
+	gs := gostage.New(context.Background(), sharedConfigs(w), &recordingLogger{})
+	_, err := gs.Collect(context.Background())
+	if !errors.Is(err, gostage.ErrSharedWorker) || !strings.Contains(err.Error(), "clean and enrich") {
+		t.Fatalf("got %v, want ErrSharedWorker naming both stages", err)
+	}
+	if w.inits.Load() != 0 || w.handled.Load() != 0 {
+		t.Errorf("the rejected pipeline ran the worker")
+	}
+}
+
+func Test_SharedWorkerAllowed(t *testing.T) {
+	w := &sharedWorker{}
+	gs := gostage.New(context.Background(), sharedConfigs(w), &recordingLogger{}, gostage.WithAllowSharedWorker())
+	results, err := gs.Collect(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 30 {
+		t.Errorf("got %d results, want 30", len(results))
+	}
+	if w.inits.Load() != 1 || w.closes.Load() != 1 {
+		t.Errorf("initialized %d times and closed %d times, want once", w.inits.Load(), w.closes.Load())
+	}
+	if w.handled.Load() != 60 {
+		t.Errorf("handled %d events, want 30 by each stage", w.handled.Load())
+	}
+	stats := gs.Stats()
+	if stats.Stages[1].Name != "clean" || stats.Stages[2].Name != "enrich" {
+		t.Fatalf("stages %s and %s", stats.Stages[1].Name, stats.Stages[2].Name)
+	}
+	if stats.Stages[1].Processed != 30 || stats.Stages[2].Processed != 30 {
+		t.Errorf("processed %d and %d, want 30 each", stats.Stages[1].Processed, stats.Stages[2].Processed)
+	}
+}
+
+func Test_SharedWorkerSubscribedByPointer(t *testing.T) {
+	w := &sharedWorker{}
+	configs := sharedConfigs(w)
+	configs[3].SubscribeToName, configs[3].SubscribeTo = "", w
+	gs := gostage.New(context.Background(), configs, &recordingLogger{}, gostage.WithAllowSharedWorker())
+	if _, err := gs.Collect(context.Background()); !errors.Is(err, gostage.ErrInvalidSubscription) {
+		t.Fatalf("got %v, want ErrInvalidSubscription", err)
+	}
+
+	configs = sharedConfigs(w)
+	configs[2].Name = "clean"
+	gs = gostage.New(context.Background(), configs, &recordingLogger{}, gostage.WithAllowSharedWorker())
+	if _, err := gs.Collect(context.Background()); !errors.Is(err, gostage.ErrDuplicateStage) {
+		t.Fatalf("got %v, want ErrDuplicateStage", err)
+	}
+}
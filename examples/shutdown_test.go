@@ -0,0 +1,83 @@
+package examples
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/qgymje/gostage"
+)
+
+// endless produces events until it's stopped, it can be created for Size > 1
+type endless struct{}
+
+func (e endless) Create() gostage.Worker {
+	return e
+}
+
+func (e endless) HandleEvent(interface{}) (interface{}, error) {
+	return 1, nil
+}
+
+// widePipeline has Size > 1 and large buffers at every stage, its producers never quit
+func widePipeline(opts ...gostage.Option) *gostage.GoStage {
+	configs := []*gostage.Config{
+		{Name: "producer", Worker: endless{}, Size: 4},
+		{Name: "first", Worker: passThrough{}, SubscribeToName: "producer", Size: 4, BufferSize: 1000},
+		{Name: "second", Worker: passThrough{}, SubscribeToName: "first", Size: 4, BufferSize: 1000},
+		{Name: "sink", Worker: passThrough{}, SubscribeToName: "second", Size: 4, BufferSize: 1000},
+	}
+	return gostage.New(context.Background(), configs, &recordingLogger{}, opts...)
+}
+
+// stopWide runs gs for a moment, stops it and fails if it doesn't stop in time
+func stopWide(t *testing.T, gs *gostage.GoStage, beforeStop func()) gostage.Accounting {
+	t.Helper()
+	done := make(chan struct{})
+	if err := gs.RunAsync(func() { close(done) }); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(20 * time.Millisecond)
+	if beforeStop != nil {
+		beforeStop()
+	}
+	gs.Stop()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the pipeline didn't stop")
+	}
+	a := gs.Stats().Accounting
+	if a.Accounted() != a.Produced {
+		t.Fatalf("accounted for %d of %d events: %+v", a.Accounted(), a.Produced, a)
+	}
+	return a
+}
+
+func Test_ShutdownImmediate(t *testing.T) {
+	for k := 0; k < 10; k++ {
+		stopWide(t, widePipeline(), nil)
+	}
+}
+
+func Test_ShutdownDrain(t *testing.T) {
+	for k := 0; k < 10; k++ {
+		a := stopWide(t, widePipeline(gostage.WithStopMode(gostage.StopDrain)), nil)
+		// every stage finished what the stage before it had sent
+		if a.Completed != a.Produced {
+			t.Fatalf("completed %d of %d events: %+v", a.Completed, a.Produced, a)
+		}
+	}
+}
+
+func Test_ShutdownDrainWithStoppedStage(t *testing.T) {
+	gs := widePipeline(gostage.WithStopMode(gostage.StopDrain))
+	a := stopWide(t, gs, func() {
+		if err := gs.StopStage("second"); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if a.DroppedInChannel == 0 {
+		t.Fatalf("no event left in the stopped stage's buffer: %+v", a)
+	}
+}
@@ -0,0 +1,141 @@
+package examples
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sort"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/qgymje/gostage"
+)
+
+type anomaly struct {
+	line   string
+	reason string
+}
+
+// sidePipeline collects what is pushed to it until it's stopped
+func sidePipeline(t *testing.T) (*gostage.GoStage, chan struct{}) {
+	configs := []*gostage.Config{
+		{Name: "producer", Worker: idle},
+		{Name: "sink", Worker: passThrough{}, SubscribeToName: "producer"},
+	}
+	side := gostage.New(context.Background(), configs, &recordingLogger{},
+		gostage.WithOutput(gostage.ToCollector), gostage.WithStopMode(gostage.StopDrain),
+		gostage.WithNoDataCountSleep(time.Millisecond))
+	done := make(chan struct{})
+	if err := side.RunAsync(func() { close(done) }); err != nil {
+		t.Fatal(err)
+	}
+	return side, done
+}
+
+func Test_SideOutputs(t *testing.T) {
+	anomalies, anomaliesDone := sidePipeline(t)
+	lines := []string{"1", "2", "x", "4", "", "6"}
+	producer := countdown(len(lines), func(n int) interface{} { return lines[n-1] })
+	parser := gostage.SideHandler(func(in interface{}, emit func(string, interface{})) (interface{}, error) {
+		n, err := strconv.Atoi(in.(string))
+		if err != nil {
+			emit("anomalies", anomaly{line: in.(string), reason: "not a number"})
+			return nil, gostage.ErrNoData
+		}
+		emit("metrics", n)
+		return n, nil
+	})
+	configs := []*gostage.Config{
+		{Name: "producer", Worker: producer},
+		{Name: "parser", Worker: parser, SubscribeToName: "producer",
+			SideOutputs: map[string]*gostage.GoStage{"anomalies": anomalies}},
+	}
+	var reported atomic.Int64
+	gs := gostage.New(context.Background(), configs, &recordingLogger{},
+		gostage.WithUnknownSideOutput(gostage.ReportUnknownSideOutput),
+		gostage.WithOnError(func(e *gostage.StageError) {
+			if errors.Is(e.Err, gostage.ErrUnknownSideOutput) {
+				reported.Add(1)
+			}
+		}))
+	parsed, err := gs.Collect(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	anomalies.Stop()
+	<-anomaliesDone
+
+	if want := []interface{}{1, 2, 4, 6}; !reflect.DeepEqual(parsed, want) {
+		t.Fatalf("parsed %v, want %v", parsed, want)
+	}
+	var bad []string
+	for _, v := range anomalies.Results() {
+		bad = append(bad, v.(anomaly).line)
+	}
+	sort.Strings(bad)
+	if want := []string{"", "x"}; !reflect.DeepEqual(bad, want) {
+		t.Fatalf("anomalies %q, want %q", bad, want)
+	}
+	// metrics has no pipeline
+	st := gs.Stats().Stages[1]
+	if st.SideOutputs != 2 || st.SideDropped != 4 || reported.Load() != 4 {
+		t.Fatalf("%d side outputs, %d dropped, %d reported", st.SideOutputs, st.SideDropped, reported.Load())
+	}
+}
+
+func Test_SideOutputsValidation(t *testing.T) {
+	anomalies := gostage.New(context.Background(), nil, &recordingLogger{})
+	configs := []*gostage.Config{
+		{Name: "producer", Worker: idle},
+		{Name: "parser", Worker: passThrough{}, SubscribeToName: "producer",
+			SideOutputs: map[string]*gostage.GoStage{"anomalies": anomalies}},
+	}
+	gs := gostage.New(context.Background(), configs, &recordingLogger{})
+	if err := gs.Run(func() {}); !errors.Is(err, gostage.ErrInvalidSideOutput) {
+		t.Fatalf("err %v", err)
+	}
+	configs[1].Worker = gostage.SideHandler(func(in interface{}, _ func(string, interface{})) (interface{}, error) {
+		return in, nil
+	})
+	configs[1].SideOutputs["anomalies"] = nil
+	if err := gs.Run(func() {}); !errors.Is(err, gostage.ErrInvalidSideOutput) {
+		t.Fatalf("err %v", err)
+	}
+}
+
+func Test_SideOutputsTargetNotRunning(t *testing.T) {
+	// never started
+	anomalies := gostage.New(context.Background(), []*gostage.Config{
+		{Name: "producer", Worker: idle},
+		{Name: "sink", Worker: passThrough{}, SubscribeToName: "producer"},
+	}, &recordingLogger{})
+	producer := countdown(3, func(n int) interface{} { return n })
+	parser := gostage.SideHandler(func(in interface{}, emit func(string, interface{})) (interface{}, error) {
+		emit("anomalies", in)
+		return in, nil
+	})
+	configs := []*gostage.Config{
+		{Name: "producer", Worker: producer},
+		{Name: "parser", Worker: parser, SubscribeToName: "producer",
+			SideOutputs: map[string]*gostage.GoStage{"anomalies": anomalies}},
+	}
+	logger := &recordingLogger{}
+	gs := gostage.New(context.Background(), configs, logger)
+	parsed, err := gs.Collect(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Slice(parsed, func(i, j int) bool { return parsed[i].(int) < parsed[j].(int) })
+	if want := []interface{}{1, 2, 3}; !reflect.DeepEqual(parsed, want) {
+		t.Fatalf("parsed %v, want %v", parsed, want)
+	}
+	st := gs.Stats().Stages[1]
+	if st.SideOutputs != 0 || st.SideDropped != 3 {
+		t.Fatalf("%d side outputs, %d dropped", st.SideOutputs, st.SideDropped)
+	}
+	if lines := logger.find(`side output "anomalies" lost`, gostage.ErrPipelineStopped.Error()); len(lines) != 3 {
+		t.Fatalf("logged %q", lines)
+	}
+}
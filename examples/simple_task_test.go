@@ -2,7 +2,8 @@ package examples
 
 import (
 	"context"
-	"log"
+	"reflect"
+	"sync"
 	"testing"
 	"time"
 
@@ -11,28 +12,32 @@ import (
 
 func Test_simpleTask(t *testing.T) {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	lg := gostage.NewStdLogger()
+	defer cancel()
 
 	data := []int{1, 2, 3, 4, 5}
 	idx := 0
+	// no data for the first two calls
+	empty := 2
 	producer := gostage.WorkHandler(func(_ interface{}) (interface{}, error) {
+		if empty > 0 {
+			empty--
+			return nil, gostage.ErrNoData
+		}
 		if idx == len(data) {
 			return nil, gostage.ErrQuit
 		}
 
 		i := data[idx]
 		idx++
-
-		if idx < 1 {
-			log.Println("no data")
-			return nil, gostage.ErrNoData
-		}
-
 		return i, nil
 	})
 
+	var mu sync.Mutex
+	var got []int
 	consumer := gostage.WorkHandler(func(in interface{}) (interface{}, error) {
-		log.Println("got data", in)
+		mu.Lock()
+		got = append(got, in.(int))
+		mu.Unlock()
 		return nil, nil
 	})
 
@@ -46,9 +51,25 @@ func Test_simpleTask(t *testing.T) {
 		},
 	}
 
-	gs := gostage.New(ctx, config, lg, gostage.WithNoDataCount(1), gostage.WithNoDataCountSleep(1*time.Second))
-	gs.Run(func() {
-		log.Println("I'm done")
-		cancel()
-	})
+	// the sleeps after ErrNoData don't wait with the manual clock
+	clock := &manualClock{now: time.Unix(0, 0)}
+	gs := gostage.New(ctx, config, &recordingLogger{}, gostage.WithNoDataCount(1),
+		gostage.WithNoDataCountSleep(1*time.Second), gostage.WithClock(clock))
+	done := false
+	if err := gs.Run(func() { done = true }); err != nil {
+		t.Fatal(err)
+	}
+	if !done {
+		t.Error("the callback wasn't called")
+	}
+	if !reflect.DeepEqual(got, data) {
+		t.Errorf("got %v, want %v", got, data)
+	}
+	if n := gs.Stats().Stages[0].Skipped; n != 0 {
+		t.Errorf("%d skipped events", n)
+	}
+	// both workers are WorkHandlers, so their default names are numbered
+	if a, b := config[0].Name, config[1].Name; a == b || b != a+"-2" {
+		t.Errorf("got the stage names %q and %q", a, b)
+	}
 }
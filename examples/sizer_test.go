@@ -0,0 +1,67 @@
+package examples
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/qgymje/gostage"
+)
+
+type blob int
+
+func (b blob) SizeBytes() int {
+	return int(b)
+}
+
+func Test_MaxBufferedBytes(t *testing.T) {
+	sizes := []blob{10, 40, 40, 40, 10, 50}
+	next := 0
+	producer := gostage.WorkHandler(func(_ interface{}) (interface{}, error) {
+		if next == len(sizes) {
+			return nil, gostage.ErrQuit
+		}
+		next++
+		return sizes[next-1], nil
+	})
+	gate := make(chan struct{})
+	first := true
+	consumer := gostage.WorkHandler(func(in interface{}) (interface{}, error) {
+		if first {
+			first = false
+			<-gate
+		}
+		return in, nil
+	})
+	configs := []*gostage.Config{
+		{Name: "producer", Worker: producer},
+		{Name: "consumer", Worker: consumer, SubscribeTo: producer, BufferSize: 100, MaxBufferedBytes: 100},
+	}
+
+	gs := gostage.New(context.Background(), configs, &recordingLogger{})
+	results := make(chan []interface{}, 1)
+	go func() {
+		r, _ := gs.Collect(context.Background())
+		results <- r
+	}()
+
+	// the consumer holds the first blob, two blobs of 40 are buffered
+	// and the third one doesn't fit in 100 bytes
+	waitFor(t, "the producer to block", func() bool {
+		return gs.State() == gostage.StateRunning && gs.Stats().Stages[0].Processed == 4
+	})
+	time.Sleep(50 * time.Millisecond)
+	stats := gs.Stats()
+	if stats.Stages[0].Processed != 4 || stats.Stages[1].BufferedBytes != 80 {
+		t.Fatalf("produced %d events with %d bytes buffered, want 4 and 80",
+			stats.Stages[0].Processed, stats.Stages[1].BufferedBytes)
+	}
+
+	close(gate)
+	if r := <-results; len(r) != len(sizes) {
+		t.Fatalf("got %v, want every blob", r)
+	}
+	if buffered := gs.Stats().Stages[1].BufferedBytes; buffered != 0 {
+		t.Fatalf("%d bytes left buffered", buffered)
+	}
+}
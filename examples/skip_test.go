@@ -0,0 +1,59 @@
+package examples
+
+import (
+	"context"
+	"testing"
+
+	"github.com/qgymje/gostage"
+)
+
+func Test_ErrNoDataSkipsInConsumers(t *testing.T) {
+	next := 0
+	producer := gostage.WorkHandler(func(_ interface{}) (interface{}, error) {
+		if next == 10 {
+			return nil, gostage.ErrQuit
+		}
+		next++
+		return next, nil
+	})
+	// only passes the even numbers on
+	evens := gostage.WorkHandler(func(in interface{}) (interface{}, error) {
+		if in.(int)%2 == 1 {
+			return nil, gostage.ErrNoData
+		}
+		return in, nil
+	})
+	configs := []*gostage.Config{
+		{Name: "producer", Worker: producer},
+		{Name: "evens", Worker: evens, SubscribeTo: producer},
+		{Name: "sink", Worker: passThrough{}, SubscribeTo: evens},
+	}
+
+	lg := &recordingLogger{}
+	gs := gostage.New(context.Background(), configs, lg, gostage.WithSequenceCheck(0))
+	out, err := gs.Collect(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(out) != 5 {
+		t.Fatalf("collected %v, want the 5 even numbers", out)
+	}
+	if lines := lg.find("[Error]evens"); len(lines) != 0 {
+		t.Fatalf("got error logs %q", lines)
+	}
+
+	stats := gs.Stats()
+	middle := stats.Stages[1]
+	if middle.Processed != 10 || middle.Skipped != 5 || middle.Errors != 0 {
+		t.Fatalf("evens stats %+v, want 10 processed, 5 skipped, no errors", middle)
+	}
+	if stats.Stages[2].Processed != 5 {
+		t.Fatalf("sink processed %d events, want 5", stats.Stages[2].Processed)
+	}
+	if a := stats.Accounting; a.Skipped != 5 || a.Completed != 5 || a.Accounted() != a.Produced {
+		t.Fatalf("accounting %+v, want 5 skipped and 5 completed", a)
+	}
+	if stats.Stages[0].Gaps != 0 {
+		t.Fatalf("skipped events counted as %d gaps", stats.Stages[0].Gaps)
+	}
+}
@@ -0,0 +1,95 @@
+package examples
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/qgymje/gostage"
+)
+
+func Test_InterruptibleSleeps(t *testing.T) {
+	const long = time.Hour
+	// the worker has been called calls times, the pipeline is waiting then
+	var calls int64
+	called := func() { atomic.AddInt64(&calls, 1) }
+	idle := gostage.WorkHandler(func(_ interface{}) (interface{}, error) {
+		called()
+		return nil, gostage.ErrNoData
+	})
+	failing := gostage.WorkHandler(func(in interface{}) (interface{}, error) {
+		called()
+		return nil, errors.New("failed")
+	})
+	crashing := gostage.WorkHandler(func(in interface{}) (interface{}, error) {
+		called()
+		panic("crashed")
+	})
+	one := func() gostage.WorkHandler { return countdown(1, func(n int) interface{} { return n }) }
+
+	cases := []struct {
+		name    string
+		configs func() []*gostage.Config
+		opts    []gostage.Option
+		calls   int64
+	}{
+		{"no data", func() []*gostage.Config {
+			return []*gostage.Config{
+				{Name: "producer", Worker: idle},
+				{Name: "sink", Worker: passThrough{}, SubscribeToName: "producer"},
+			}
+		}, []gostage.Option{gostage.WithNoDataCount(1), gostage.WithNoDataCountSleep(long)}, 1},
+		{"retry backoff", func() []*gostage.Config {
+			return []*gostage.Config{
+				{Name: "producer", Worker: one()},
+				{Name: "sink", Worker: failing, SubscribeToName: "producer", ErrorMode: gostage.RetryForever,
+					RetryBackoff: func(int) time.Duration { return long }},
+			}
+		}, nil, 1},
+		{"rate limit", func() []*gostage.Config {
+			return []*gostage.Config{
+				{Name: "producer", Worker: gostage.WorkHandler(func(_ interface{}) (interface{}, error) {
+					called()
+					return 1, nil
+				}), RateLimit: 1 / long.Seconds()},
+				{Name: "sink", Worker: passThrough{}, SubscribeToName: "producer"},
+			}
+		}, nil, 1},
+		{"restart backoff", func() []*gostage.Config {
+			return []*gostage.Config{
+				{Name: "producer", Worker: one()},
+				{Name: "sink", Worker: crashing, SubscribeToName: "producer",
+					RestartBackoff: func(int) time.Duration { return long }},
+			}
+		}, nil, 1},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			atomic.StoreInt64(&calls, 0)
+			opts := append([]gostage.Option{gostage.WithStopMode(gostage.StopDrain)}, c.opts...)
+			gs := gostage.New(context.Background(), c.configs(), &recordingLogger{}, opts...)
+			done := make(chan struct{})
+			if err := gs.RunAsync(func() { close(done) }); err != nil {
+				t.Fatal(err)
+			}
+			waitFor(t, "the worker", func() bool { return atomic.LoadInt64(&calls) >= c.calls })
+			// let the pipeline start waiting
+			time.Sleep(20 * time.Millisecond)
+
+			start := time.Now()
+			if err := gs.Stop(); err != nil {
+				t.Fatal(err)
+			}
+			select {
+			case <-done:
+			case <-time.After(time.Second):
+				t.Fatal("the pipeline waited out the sleep")
+			}
+			if took := time.Since(start); took > 100*time.Millisecond {
+				t.Fatalf("stopping took %v", took)
+			}
+		})
+	}
+}
@@ -0,0 +1,142 @@
+package examples
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/qgymje/gostage"
+)
+
+// sseEvent is an event read from a stream
+type sseEvent struct {
+	id, data, event string
+}
+
+// readSSE connects to url and sends the events it reads to the returned channel
+// the channel is closed when the stream ends
+func readSSE(t *testing.T, url, lastEventID string) <-chan sseEvent {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lastEventID != "" {
+		req.Header.Set("Last-Event-ID", lastEventID)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	events := make(chan sseEvent, 100)
+	go func() {
+		defer close(events)
+		defer resp.Body.Close()
+		var ev sseEvent
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			line := scanner.Text()
+			switch {
+			case strings.HasPrefix(line, "event: "):
+				ev.event = strings.TrimPrefix(line, "event: ")
+			case strings.HasPrefix(line, "id: "):
+				ev.id = strings.TrimPrefix(line, "id: ")
+			case strings.HasPrefix(line, "data: "):
+				ev.data += strings.TrimPrefix(line, "data: ")
+			case line == "":
+				events <- ev
+				ev = sseEvent{}
+			}
+		}
+	}()
+	return events
+}
+
+// countdown emits the numbers 1 to n as events then quits
+func countdown(n int, payload func(int) interface{}) gostage.WorkHandler {
+	next := 0
+	return func(_ interface{}) (interface{}, error) {
+		if next == n {
+			return nil, gostage.ErrQuit
+		}
+		next++
+		return payload(next), nil
+	}
+}
+
+func Test_SSESink(t *testing.T) {
+	producer := countdown(12, func(n int) interface{} { return n })
+	sink, stream := gostage.SSESink("sse", gostage.WithSSEReplay(8))
+	sink.SubscribeTo = producer
+	configs := []*gostage.Config{{Name: "producer", Worker: producer}, sink}
+
+	server := httptest.NewServer(stream)
+	defer server.Close()
+	first := readSSE(t, server.URL, "")
+	second := readSSE(t, server.URL, "")
+	waitFor(t, "the clients to connect", func() bool { return stream.Clients() == 2 })
+
+	gs := gostage.New(context.Background(), configs, &recordingLogger{})
+	if _, err := gs.Collect(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	for k, events := range []<-chan sseEvent{first, second} {
+		var got []string
+		for ev := range events {
+			got = append(got, ev.id+"="+ev.data)
+		}
+		if len(got) != 12 || got[0] != "1=1" || got[11] != "12=12" {
+			t.Fatalf("client %d got %v, want the 12 events", k, got)
+		}
+	}
+
+	// a client reconnecting gets the events after its last one
+	var replayed []string
+	for ev := range readSSE(t, server.URL, "6") {
+		replayed = append(replayed, ev.data)
+	}
+	if strings.Join(replayed, ",") != "7,8,9,10,11,12" {
+		t.Fatalf("replayed %v, want 7 to 12", replayed)
+	}
+}
+
+func Test_SSESinkStalledClient(t *testing.T) {
+	big := strings.Repeat("x", 256<<10)
+	producer := countdown(100, func(int) interface{} { return big })
+	sink, stream := gostage.SSESink("sse", gostage.WithSSEClientBuffer(1), gostage.WithSSEReplay(0))
+	sink.SubscribeTo = producer
+	configs := []*gostage.Config{{Name: "producer", Worker: producer}, sink}
+
+	server := httptest.NewServer(stream)
+	defer server.Close()
+	// connects and never reads
+	resp, err := http.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	waitFor(t, "the client to connect", func() bool { return stream.Clients() == 1 })
+
+	gs := gostage.New(context.Background(), configs, &recordingLogger{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if _, err := gs.Collect(context.Background()); err != nil {
+			t.Error(err)
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the stalled client stalled the pipeline")
+	}
+	if stream.Dropped() == 0 {
+		t.Fatal("no event was dropped for the stalled client")
+	}
+}
@@ -0,0 +1,56 @@
+package examples
+
+import (
+	"context"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/qgymje/gostage"
+)
+
+type startRecorder struct {
+	gostage.BaseObserver
+	mu     sync.Mutex
+	stages []string
+}
+
+func (r *startRecorder) OnStageStarted(stage string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.stages = append(r.stages, stage)
+}
+
+func (r *startRecorder) started() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string(nil), r.stages...)
+}
+
+func Test_StartOrder(t *testing.T) {
+	configs := []*gostage.Config{
+		{Name: "producer", Worker: endless{}, Size: 2},
+		{Name: "first", Worker: passThrough{}, SubscribeToName: "producer", Size: 3},
+		{Name: "second", Worker: passThrough{}, SubscribeToName: "first", Size: 3, Pool: true},
+		{Name: "sink", Worker: passThrough{}, SubscribeToName: "second", Size: 2},
+	}
+	rec := &startRecorder{}
+	gs := gostage.New(context.Background(), configs, &recordingLogger{}, gostage.WithObserver(rec))
+	done := make(chan struct{})
+	if err := gs.RunAsync(func() { close(done) }); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-gs.Ready():
+	case <-time.After(3 * time.Second):
+		t.Fatal("the pipeline isn't ready")
+	}
+	gs.Stop()
+	<-done
+
+	want := []string{"sink", "second", "first", "producer"}
+	if got := rec.started(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("stages started in order %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,75 @@
+package examples
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/qgymje/gostage"
+)
+
+func Test_StopMode(t *testing.T) {
+	cases := []struct {
+		mode  gostage.StopMode
+		batch int
+		want  int64
+	}{
+		// only the event being handled when Stop was called
+		{gostage.StopImmediate, 1, 1},
+		// plus the four events waiting in the buffer
+		{gostage.StopDrain, 1, 5},
+		// the events taken along with the first one are abandoned as well
+		{gostage.StopImmediate, 3, 1},
+		{gostage.StopDrain, 3, 5},
+	}
+
+	for _, c := range cases {
+		next := 0
+		producer := gostage.WorkHandler(func(_ interface{}) (interface{}, error) {
+			if next == 5 {
+				return nil, gostage.ErrNoData
+			}
+			next++
+			return next, nil
+		})
+		gate := make(chan struct{})
+		var handled int64
+		consumer := gostage.WorkHandler(func(in interface{}) (interface{}, error) {
+			if in.(int) == 1 {
+				<-gate
+			}
+			atomic.AddInt64(&handled, 1)
+			return nil, nil
+		})
+		configs := []*gostage.Config{
+			{Name: "producer", Worker: producer},
+			{Name: "consumer", Worker: consumer, SubscribeTo: producer, BufferSize: 10},
+		}
+
+		gs := gostage.New(context.Background(), configs, &recordingLogger{}, gostage.WithStopMode(c.mode), gostage.WithReceiveBatch(c.batch), gostage.WithNoDataCountSleep(time.Millisecond))
+		done := make(chan struct{})
+		if err := gs.RunAsync(func() { close(done) }); err != nil {
+			t.Fatal(err)
+		}
+		waitFor(t, "the buffer to be loaded", func() bool {
+			return gs.Stats().Stages[0].Processed == 5
+		})
+
+		gs.Stop()
+		waitFor(t, "the pipeline to be stopping", func() bool {
+			return gs.State() == gostage.StateStopping
+		})
+		// let the shutdown reach the blocked consumer
+		time.Sleep(20 * time.Millisecond)
+		close(gate)
+		<-done
+
+		if got := atomic.LoadInt64(&handled); got != c.want {
+			t.Fatalf("mode %d, batch %d: handled %d events, want %d", c.mode, c.batch, got, c.want)
+		}
+		if a := gs.Stats().Accounting; a.Accounted() != a.Produced {
+			t.Fatalf("mode %d, batch %d: accounted for %d of %d events", c.mode, c.batch, a.Accounted(), a.Produced)
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package examples
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/qgymje/gostage"
+)
+
+func Test_StopStage(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var produced int64
+	gate := make(chan struct{})
+	producer := gostage.WorkHandler(func(_ interface{}) (interface{}, error) {
+		switch atomic.LoadInt64(&produced) {
+		case 20:
+			return nil, gostage.ErrNoData
+		case 3:
+			<-gate
+		}
+		return int(atomic.AddInt64(&produced, 1)), nil
+	})
+
+	var closed int64
+	middle := &closeCounter{closed: &closed}
+
+	var mu sync.Mutex
+	var seen []int
+	consumer := gostage.WorkHandler(func(in interface{}) (interface{}, error) {
+		mu.Lock()
+		seen = append(seen, in.(int))
+		mu.Unlock()
+		return nil, nil
+	})
+	received := func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(seen)
+	}
+
+	configs := []*gostage.Config{
+		{Name: "producer", Worker: producer},
+		{Name: "middle", Worker: middle, SubscribeTo: producer, BufferSize: 5},
+		{Name: "consumer", Worker: consumer, SubscribeTo: middle},
+	}
+	gs := gostage.New(ctx, configs, gostage.NewStdLogger(), gostage.WithNoDataCountSleep(time.Millisecond))
+
+	done := make(chan struct{})
+	gs.RunAsync(func() { close(done) })
+
+	waitFor(t, "first events", func() bool { return received() == 3 })
+	if err := gs.StopStage("middle"); err != nil {
+		t.Fatal(err)
+	}
+	if atomic.LoadInt64(&closed) != 1 {
+		t.Fatal("Close wasn't called on the stopped stage")
+	}
+	if !gs.Stats().Stages[1].Stopped {
+		t.Fatal("stats don't report the stage as stopped")
+	}
+
+	// the producer fills the buffer with 5 events and then blocks on the 6th
+	close(gate)
+	waitFor(t, "producer to fill the buffer", func() bool { return atomic.LoadInt64(&produced) == 9 })
+	time.Sleep(20 * time.Millisecond)
+	if n := atomic.LoadInt64(&produced); n != 9 {
+		t.Fatalf("producer isn't blocked: produced %d events", n)
+	}
+	if n := received(); n != 3 {
+		t.Fatalf("consumer received %d events from a stopped stage", n)
+	}
+
+	if err := gs.StartStage("middle"); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "all events", func() bool { return received() == 20 })
+	cancel()
+	<-done
+
+	for i, v := range seen {
+		if v != i+1 {
+			t.Fatalf("consumer saw %v", seen)
+		}
+	}
+	if gs.Stats().Stages[1].Stopped {
+		t.Fatal("stats report the restarted stage as stopped")
+	}
+}
+
+func Test_StopStageNoConsumer(t *testing.T) {
+	stopped := make(chan struct{})
+	next := 0
+	producer := gostage.WorkHandler(func(_ interface{}) (interface{}, error) {
+		if next == 1 {
+			<-stopped
+		}
+		next++
+		return next, nil
+	})
+	consumer := gostage.WorkHandler(func(in interface{}) (interface{}, error) {
+		return nil, nil
+	})
+	configs := []*gostage.Config{
+		{Name: "producer", Worker: producer},
+		{Name: "consumer", Worker: consumer, SubscribeTo: producer, BufferSize: 1},
+	}
+	gs := gostage.New(context.Background(), configs, &recordingLogger{},
+		gostage.WithNoConsumerTimeout(50*time.Millisecond))
+	done := make(chan struct{})
+	if err := gs.RunAsync(func() { close(done) }); err != nil {
+		t.Fatal(err)
+	}
+	// the only consumer is stopped and never started again
+	if err := gs.StopStage("consumer"); err != nil {
+		t.Fatal(err)
+	}
+	close(stopped)
+
+	select {
+	case <-done:
+	case <-time.After(3 * time.Second):
+		t.Fatal("the producer hung on a stage without instances")
+	}
+	reason := gs.Reason()
+	if !errors.Is(reason, gostage.ErrNoConsumer) || !strings.Contains(reason.Error(), "producer -> consumer") {
+		t.Fatalf("reason = %v, want ErrNoConsumer naming producer -> consumer", reason)
+	}
+	if a := gs.Stats().Accounting; a.Accounted() != a.Produced {
+		t.Fatalf("accounting %+v", a)
+	}
+}
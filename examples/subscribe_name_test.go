@@ -0,0 +1,87 @@
+package examples
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/qgymje/gostage"
+)
+
+type adder struct {
+	n int
+}
+
+func (a adder) HandleEvent(in interface{}) (interface{}, error) {
+	return in.(int) + a.n, nil
+}
+
+func Test_SubscribeToName(t *testing.T) {
+	next := 0
+	configs := []*gostage.Config{
+		{Name: "sink", SubscribeToName: "add ten", Worker: adder{}},
+		{Name: "add one", SubscribeToName: "producer", Worker: adder{n: 1}},
+		{Name: "producer", Worker: gostage.WorkHandler(func(_ interface{}) (interface{}, error) {
+			if next == 3 {
+				return nil, gostage.ErrQuit
+			}
+			next++
+			return next, nil
+		})},
+		// the same worker type as the stage above
+		{Name: "add ten", SubscribeToName: "add one", Worker: adder{n: 10}},
+	}
+
+	results, err := gostage.New(context.Background(), configs, &recordingLogger{}).Collect(context.Background())
+	if err != nil {
+		t.Fatalf("Collect error = %v", err)
+	}
+	want := []interface{}{12, 13, 14}
+	if len(results) != len(want) {
+		t.Fatalf("got %v, want %v", results, want)
+	}
+	for k := range want {
+		if results[k] != want[k] {
+			t.Fatalf("got %v, want %v", results, want)
+		}
+	}
+}
+
+func Test_SubscribeToNameInvalid(t *testing.T) {
+	producer := gostage.WorkHandler(func(_ interface{}) (interface{}, error) {
+		return nil, gostage.ErrQuit
+	})
+	cases := []struct {
+		name    string
+		configs []*gostage.Config
+		want    error
+	}{
+		{"unknown", []*gostage.Config{
+			{Name: "producer", Worker: producer},
+			{Name: "sink", SubscribeToName: "nope", Worker: adder{}},
+		}, gostage.ErrUnknownStage},
+		{"duplicate", []*gostage.Config{
+			{Name: "producer", Worker: producer},
+			{Name: "middle", SubscribeToName: "producer", Worker: adder{}},
+			{Name: "middle", SubscribeToName: "producer", Worker: adder{}},
+			{Name: "sink", SubscribeToName: "middle", Worker: adder{}},
+		}, gostage.ErrDuplicateStage},
+		{"both", []*gostage.Config{
+			{Name: "producer", Worker: producer},
+			{Name: "sink", SubscribeTo: producer, SubscribeToName: "producer", Worker: adder{}},
+		}, gostage.ErrInvalidSubscription},
+	}
+
+	for _, c := range cases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			gs := gostage.New(context.Background(), c.configs, &recordingLogger{})
+			if err := gs.Run(func() {}); !errors.Is(err, c.want) {
+				t.Fatalf("Run error = %v, want %v", err, c.want)
+			}
+			if st := gs.State(); st != gostage.StateStopped {
+				t.Fatalf("state = %s, want stopped", st)
+			}
+		})
+	}
+}
@@ -0,0 +1,124 @@
+package examples
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"testing"
+
+	"github.com/qgymje/gostage"
+)
+
+// errorSet gathers the inputs of errors
+type errorSet struct {
+	mu     sync.Mutex
+	inputs []int
+}
+
+func (e *errorSet) add(in interface{}) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.inputs = append(e.inputs, in.(int))
+}
+
+func (e *errorSet) sorted() []int {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	sorted := append([]int(nil), e.inputs...)
+	sort.Ints(sorted)
+	return sorted
+}
+
+// failing fails the events for which fail returns true and records them
+func failing(failed *errorSet, fail func(int) bool) gostage.WorkHandler {
+	return func(in interface{}) (interface{}, error) {
+		if n := in.(int); fail(n) {
+			failed.add(n)
+			return nil, fmt.Errorf("%d failed", n)
+		}
+		return in, nil
+	}
+}
+
+func Test_ErrorSubscriptions(t *testing.T) {
+	var billingFailed, shippingFailed errorSet
+	configs := []*gostage.Config{
+		{Name: "producer", Worker: countdown(100, func(n int) interface{} { return n })},
+		{Name: "billing", Worker: failing(&billingFailed, func(n int) bool { return n%3 == 0 }), SubscribeToName: "producer"},
+		{Name: "shipping", Worker: failing(&shippingFailed, func(n int) bool { return n%5 == 0 }), SubscribeToName: "billing"},
+	}
+	gs := gostage.New(context.Background(), configs, &recordingLogger{}, gostage.WithName("subscriptions"))
+
+	var billing, shipping, all, cancelled errorSet
+	var stages sync.Map
+	gs.OnStageError("billing", func(e gostage.StageError) {
+		stages.Store(e.Stage, true)
+		billing.add(e.Input)
+	})
+	gs.OnStageError("shipping", func(e gostage.StageError) { shipping.add(e.Input) })
+	gs.OnAnyError(func(e gostage.StageError) { all.add(e.Input) })
+	cancel := gs.OnAnyError(func(e gostage.StageError) { cancelled.add(e.Input) })
+	cancel()
+	// contained, the others are still passed their errors
+	gs.OnAnyError(func(gostage.StageError) { panic("boom") })
+
+	if err := gs.Run(func() {}); err != nil {
+		t.Fatal(err)
+	}
+	if len(billing.sorted()) == 0 || len(shipping.sorted()) == 0 {
+		t.Fatal("no errors")
+	}
+	if got, want := billing.sorted(), billingFailed.sorted(); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("billing subscriber saw %v, want %v", got, want)
+	}
+	if got, want := shipping.sorted(), shippingFailed.sorted(); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("shipping subscriber saw %v, want %v", got, want)
+	}
+	var both errorSet
+	for _, n := range append(billingFailed.sorted(), shippingFailed.sorted()...) {
+		both.add(n)
+	}
+	if got, want := all.sorted(), both.sorted(); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("catch-all saw %v, want %v", got, want)
+	}
+	if len(cancelled.sorted()) != 0 {
+		t.Fatalf("cancelled subscriber saw %v", cancelled.sorted())
+	}
+	if _, ok := stages.Load(gs.Name() + "/billing"); !ok {
+		t.Fatal("the stage of the errors isn't qualified")
+	}
+	if err := gs.Err(); !errors.Is(err, gostage.ErrCallbackPanic) {
+		t.Fatalf("err %v", err)
+	}
+	if dropped := gs.Stats().DroppedErrors; dropped != 0 {
+		t.Fatalf("%d errors dropped", dropped)
+	}
+}
+
+func Test_ErrorSubscriptionDuringRun(t *testing.T) {
+	var failed, seen errorSet
+	release := make(chan struct{})
+	configs := []*gostage.Config{
+		{Name: "producer", Worker: countdown(20, func(n int) interface{} {
+			if n == 11 {
+				<-release
+			}
+			return n
+		})},
+		{Name: "sink", Worker: failing(&failed, func(int) bool { return true }), SubscribeToName: "producer"},
+	}
+	gs := gostage.New(context.Background(), configs, &recordingLogger{})
+	done := make(chan struct{})
+	if err := gs.RunAsync(func() { close(done) }); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "10 errors", func() bool { return len(failed.sorted()) == 10 })
+	gs.OnStageError("sink", func(e gostage.StageError) { seen.add(e.Input) })
+	close(release)
+	<-done
+	if got := seen.sorted(); fmt.Sprint(got) != "[11 12 13 14 15 16 17 18 19 20]" {
+		t.Fatalf("saw %v", got)
+	}
+}
@@ -0,0 +1,209 @@
+package examples
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/qgymje/gostage"
+)
+
+type payload struct {
+	ID   int
+	Body string
+}
+
+func Test_SupervisorPanicLog(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	next := 0
+	producer := gostage.WorkHandler(func(_ interface{}) (interface{}, error) {
+		if next == 3 {
+			return nil, gostage.ErrQuit
+		}
+		next++
+		body := "small"
+		if next == 2 {
+			body = string(make([]byte, 1000))
+		}
+		return &payload{ID: next, Body: body}, nil
+	})
+	consumer := gostage.WorkHandler(func(in interface{}) (interface{}, error) {
+		if in.(*payload).ID == 2 {
+			panic("bad payload")
+		}
+		return nil, nil
+	})
+
+	lg := &recordingLogger{}
+	configs := []*gostage.Config{
+		{Name: "producer", Worker: producer},
+		{Name: "consumer", Worker: consumer, SubscribeTo: producer, Restart: 4},
+	}
+	gs := gostage.New(ctx, configs, lg)
+	gs.Run(func() {})
+
+	panics := lg.find("consumer_#0 panic: bad payload", "input = &{ID:2")
+	if len(panics) != 1 {
+		t.Fatalf("got panic log lines %q", panics)
+	}
+	if len(panics[0]) > 2000 || len(lg.find("...")) == 0 {
+		t.Fatal("input isn't capped in the panic log")
+	}
+	if restarts := lg.find("consumer_#0 restart 1/4"); len(restarts) != 1 {
+		t.Fatalf("got restart log lines %q", restarts)
+	}
+}
+
+func Test_SupervisorRestarts(t *testing.T) {
+	var calls int64
+	var restarts []gostage.RestartInfo
+	sup := gostage.NewSupervisor(func(ctx context.Context) {
+		if atomic.AddInt64(&calls, 1) <= 2 {
+			panic("boom")
+		}
+	},
+		gostage.WithSupervisorName("job"),
+		gostage.WithMaxRestarts(3),
+		gostage.WithSupervisorLogger(&recordingLogger{}),
+		gostage.WithOnRestart(func(info gostage.RestartInfo) {
+			restarts = append(restarts, info)
+		}),
+	)
+	sup.Start(context.Background())
+	<-sup.Done()
+
+	if err := sup.Err(); err != nil {
+		t.Fatalf("err = %v, want nil", err)
+	}
+	if n := sup.RestartCount(); n != 2 {
+		t.Fatalf("restarts = %d, want 2", n)
+	}
+	if len(restarts) != 2 || restarts[0].Attempt != 1 || restarts[1].Attempt != 2 || restarts[1].Recovered != "boom" {
+		t.Fatalf("OnRestart got %+v", restarts)
+	}
+}
+
+func Test_SupervisorBudget(t *testing.T) {
+	sup := gostage.NewSupervisor(func(ctx context.Context) {
+		panic("boom")
+	}, gostage.WithMaxRestarts(2), gostage.WithSupervisorLogger(&recordingLogger{}))
+	sup.Start(context.Background())
+	<-sup.Done()
+
+	if err := sup.Err(); err != gostage.ErrSupervision {
+		t.Fatalf("err = %v, want ErrSupervision", err)
+	}
+	if n := sup.RestartCount(); n != 2 {
+		t.Fatalf("restarts = %d, want 2", n)
+	}
+}
+
+func Test_SupervisorWindowAndBackoff(t *testing.T) {
+	var calls int64
+	sup := gostage.NewSupervisor(func(ctx context.Context) {
+		if atomic.AddInt64(&calls, 1) <= 4 {
+			panic("boom")
+		}
+	},
+		gostage.WithMaxRestarts(1),
+		gostage.WithRestartWindow(5*time.Millisecond),
+		gostage.WithRestartBackoff(func(attempt int) time.Duration { return 10 * time.Millisecond }),
+		gostage.WithSupervisorLogger(&recordingLogger{}),
+	)
+	sup.Start(context.Background())
+	<-sup.Done()
+
+	// every restart falls out of the window before the next panic
+	if err := sup.Err(); err != nil {
+		t.Fatalf("err = %v, want nil", err)
+	}
+	if n := sup.RestartCount(); n != 4 {
+		t.Fatalf("restarts = %d, want 4", n)
+	}
+}
+
+func Test_SupervisorStop(t *testing.T) {
+	exited := make(chan struct{})
+	sup := gostage.NewSupervisor(func(ctx context.Context) {
+		<-ctx.Done()
+		close(exited)
+	})
+	sup.Start(context.Background())
+	sup.Stop()
+	sup.Stop()
+
+	<-exited
+	if err := sup.Err(); err != nil {
+		t.Fatalf("err = %v, want nil", err)
+	}
+}
+
+type restartRecorder struct {
+	gostage.BaseObserver
+	mu     sync.Mutex
+	events []gostage.RestartEvent
+}
+
+func (r *restartRecorder) OnRestart(ev gostage.RestartEvent) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, ev)
+}
+
+func Test_OnRestart(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	next := 0
+	producer := gostage.WorkHandler(func(_ interface{}) (interface{}, error) {
+		if next == 5 {
+			return nil, gostage.ErrQuit
+		}
+		next++
+		return next, nil
+	})
+	consumer := gostage.WorkHandler(func(in interface{}) (interface{}, error) {
+		if n := in.(int); n == 2 || n == 4 {
+			panic(n)
+		}
+		return nil, nil
+	})
+
+	perStage := &restartRecorder{}
+	pipeline := &restartRecorder{}
+	configs := []*gostage.Config{
+		{Name: "producer", Worker: producer},
+		{
+			Name:        "consumer",
+			Worker:      consumer,
+			SubscribeTo: producer,
+			Restart:     3,
+			OnRestart: func(ev gostage.RestartEvent) {
+				// a slow alerting call doesn't delay the restart
+				time.Sleep(10 * time.Millisecond)
+				perStage.OnRestart(ev)
+			},
+		},
+	}
+	gs := gostage.New(ctx, configs, &recordingLogger{}, gostage.WithObserver(pipeline))
+	gs.Run(func() {})
+
+	for _, r := range []*restartRecorder{perStage, pipeline} {
+		if len(r.events) != 2 {
+			t.Fatalf("got restart events %+v, want 2", r.events)
+		}
+		for k, ev := range r.events {
+			if ev.Stage != "consumer" || ev.Instance != 0 || ev.Attempt != k+1 || ev.Recovered != 2*(k+1) {
+				t.Fatalf("unexpected restart event %+v", ev)
+			}
+		}
+	}
+	stats := gs.Stats().Stages[1]
+	if stats.Restarts != 2 || stats.RecentRestarts != 2 {
+		t.Fatalf("restarts = %d, recent = %d, want 2 and 2", stats.Restarts, stats.RecentRestarts)
+	}
+}
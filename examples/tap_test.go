@@ -0,0 +1,128 @@
+package examples
+
+import (
+	"context"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/qgymje/gostage"
+)
+
+// holdAfter emits 1 to first, then waits for open before emitting up to last and quitting
+func holdAfter(first, last int, open <-chan struct{}) gostage.WorkHandler {
+	next := 0
+	return func(_ interface{}) (interface{}, error) {
+		if next == first {
+			select {
+			case <-open:
+			default:
+				return nil, gostage.ErrNoData
+			}
+		}
+		if next == last {
+			return nil, gostage.ErrQuit
+		}
+		next++
+		return next, nil
+	}
+}
+
+func Test_TapReplayBuffer(t *testing.T) {
+	open := make(chan struct{})
+	configs := []*gostage.Config{
+		{Name: "producer", Worker: holdAfter(50, 60, open)},
+		{Name: "parse", Worker: passThrough{}, SubscribeToName: "producer"},
+		{Name: "sink", Worker: passThrough{}, SubscribeToName: "parse"},
+	}
+	gs := gostage.New(context.Background(), configs, &recordingLogger{},
+		gostage.WithReplayBuffer("parse", 10), gostage.WithNoDataCountSleep(time.Millisecond))
+	done := make(chan struct{})
+	if err := gs.RunAsync(func() { close(done) }); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "50 events", func() bool { return gs.Stats().Stages[2].Processed == 50 })
+
+	tap, cancel := gs.Tap("parse", 16)
+	close(open)
+	<-done
+	cancel()
+
+	var got []string
+	for ev := range tap {
+		if ev.Stage != "parse" {
+			t.Fatalf("event of %s", ev.Stage)
+		}
+		s := strconv.Itoa(ev.Payload.(int))
+		if ev.Replayed {
+			s += "r"
+		}
+		got = append(got, s)
+	}
+	want := "41r,42r,43r,44r,45r,46r,47r,48r,49r,50r,51,52,53,54,55,56,57,58,59,60"
+	if strings.Join(got, ",") != want {
+		t.Fatalf("tap got %v, want %s", got, want)
+	}
+}
+
+func Test_TapWithoutReplayBuffer(t *testing.T) {
+	configs := []*gostage.Config{
+		{Name: "producer", Worker: countdown(5, func(n int) interface{} { return n * 10 })},
+		{Name: "sink", Worker: passThrough{}, SubscribeToName: "producer"},
+	}
+	gs := gostage.New(context.Background(), configs, &recordingLogger{})
+	producer, stopProducer := gs.Tap("producer", 8)
+	sink, stopSink := gs.Tap("sink", 8)
+	if err := gs.Run(func() {}); err != nil {
+		t.Fatal(err)
+	}
+	stopProducer()
+	stopSink()
+	for _, tap := range []<-chan gostage.TapEvent{producer, sink} {
+		var got []int
+		for ev := range tap {
+			if ev.Replayed {
+				t.Fatal("replayed event without a replay buffer")
+			}
+			got = append(got, ev.Payload.(int))
+		}
+		if len(got) != 5 || got[0] != 10 || got[4] != 50 {
+			t.Fatalf("tap got %v", got)
+		}
+	}
+}
+
+func Test_SSEHistory(t *testing.T) {
+	open := make(chan struct{})
+	producer := holdAfter(50, 55, open)
+	sink, stream := gostage.SSESink("sse", gostage.WithSSEReplay(100), gostage.WithSSEHistory(10))
+	sink.SubscribeTo = producer
+	configs := []*gostage.Config{{Name: "producer", Worker: producer}, sink}
+	server := httptest.NewServer(stream)
+	defer server.Close()
+
+	gs := gostage.New(context.Background(), configs, &recordingLogger{}, gostage.WithNoDataCountSleep(time.Millisecond))
+	done := make(chan struct{})
+	if err := gs.RunAsync(func() { close(done) }); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "50 events", func() bool { return gs.Stats().Stages[1].Processed == 50 })
+	events := readSSE(t, server.URL, "")
+	waitFor(t, "the client to connect", func() bool { return stream.Clients() == 1 })
+	close(open)
+	<-done
+
+	var got []string
+	for ev := range events {
+		if ev.event == "replay" {
+			ev.data += "r"
+		}
+		got = append(got, ev.data)
+	}
+	want := "41r,42r,43r,44r,45r,46r,47r,48r,49r,50r,51,52,53,54,55"
+	if strings.Join(got, ",") != want {
+		t.Fatalf("client got %v, want %s", got, want)
+	}
+}
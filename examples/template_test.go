@@ -0,0 +1,79 @@
+package examples
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/qgymje/gostage"
+)
+
+type tenant struct {
+	Step int
+}
+
+// tenantTemplate counts by the tenant's step, slowly
+var tenantTemplate = gostage.Template{
+	Configs: func(params interface{}) []*gostage.Config {
+		step := params.(tenant).Step
+		n := 0
+		producer := gostage.WorkHandler(func(_ interface{}) (interface{}, error) {
+			time.Sleep(time.Millisecond)
+			n += step
+			return n, nil
+		})
+		return []*gostage.Config{
+			{Name: "producer", Worker: producer},
+			{Name: "sink", Worker: passThrough{}, SubscribeToName: "producer"},
+		}
+	},
+	Options: func(interface{}) []gostage.Option {
+		return []gostage.Option{gostage.WithStopMode(gostage.StopDrain)}
+	},
+}
+
+func Test_Manager(t *testing.T) {
+	logger := &recordingLogger{}
+	m := gostage.NewManager(context.Background(), logger)
+	for k, name := range []string{"a", "b", "c"} {
+		if _, err := m.Add(name, tenantTemplate, tenant{Step: k + 1}); err != nil {
+			t.Fatal(err)
+		}
+		if err := m.Start(name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := m.Add("a", tenantTemplate, tenant{Step: 1}); !errors.Is(err, gostage.ErrDuplicateInstance) {
+		t.Fatalf("added a twice: %v", err)
+	}
+	if err := m.Stop("d"); !errors.Is(err, gostage.ErrUnknownInstance) {
+		t.Fatalf("stopped an unknown instance: %v", err)
+	}
+
+	processed := func(name string) int64 {
+		return m.Stats()[name].Stages[1].Processed
+	}
+	waitFor(t, "all instances to run", func() bool {
+		return processed("a") > 0 && processed("b") > 0 && processed("c") > 0
+	})
+
+	if err := m.Stop("b"); err != nil {
+		t.Fatal(err)
+	}
+	stopped, a, c := processed("b"), processed("a"), processed("c")
+	waitFor(t, "the other instances to go on", func() bool {
+		return processed("a") > a && processed("c") > c
+	})
+	if got := processed("b"); got != stopped {
+		t.Fatalf("b handled %d events after it was stopped", got-stopped)
+	}
+	if stats := m.Stats(); len(stats) != 3 {
+		t.Fatalf("stats of %d instances, want 3", len(stats))
+	}
+
+	m.StopAll()
+	if len(logger.find("[Info]b: gostage stopped")) != 1 || len(logger.find("[Info]a: gostage stopped")) != 1 {
+		t.Fatal("the logs don't tell the instances apart")
+	}
+}
@@ -0,0 +1,167 @@
+package examples
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/qgymje/gostage"
+)
+
+// race ends a run with both triggers at once and checks that the done callback is
+// called exactly once, then returns gs once it has stopped
+func race(t *testing.T, gs *gostage.GoStage, start func(done func()) error, first, second func()) *gostage.GoStage {
+	var calls atomic.Int32
+	done := make(chan struct{})
+	if err := start(func() {
+		if calls.Add(1) == 1 {
+			close(done)
+		}
+	}); err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	for _, trigger := range []func(){first, second} {
+		wg.Add(1)
+		go func(trigger func()) {
+			defer wg.Done()
+			trigger()
+		}(trigger)
+	}
+	wg.Wait()
+	select {
+	case <-done:
+	case <-time.After(3 * time.Second):
+		t.Fatal("the done callback wasn't called")
+	}
+	waitFor(t, "the pipeline to stop", func() bool { return gs.State() == gostage.StateStopped })
+	// a second call would have come with the first one
+	time.Sleep(5 * time.Millisecond)
+	if n := calls.Load(); n != 1 {
+		t.Fatalf("the done callback was called %d times", n)
+	}
+	return gs
+}
+
+// stable checks that Err doesn't change once the run has stopped
+func stable(t *testing.T, gs *gostage.GoStage) error {
+	err := gs.Err()
+	time.Sleep(time.Millisecond)
+	if again := gs.Err(); !errors.Is(again, err) || !errors.Is(err, again) {
+		t.Fatalf("Err() went from %v to %v", err, again)
+	}
+	return err
+}
+
+func Test_StopRacesContext(t *testing.T) {
+	for k := 0; k < 20; k++ {
+		ctx, cancel := context.WithCancel(context.Background())
+		configs := []*gostage.Config{
+			{Name: "producer", Worker: idle},
+			{Name: "sink", Worker: passThrough{}, SubscribeToName: "producer"},
+		}
+		gs := gostage.New(ctx, configs, &recordingLogger{}, gostage.WithNoDataCountSleep(time.Millisecond))
+		race(t, gs, gs.RunAsync, func() { gs.Stop() }, cancel)
+		if r := gs.Reason(); !errors.Is(r, gostage.ErrStopped) && !errors.Is(r, context.Canceled) {
+			t.Fatalf("reason %v", r)
+		}
+		if err := stable(t, gs); err != nil {
+			t.Fatalf("Err() = %v", err)
+		}
+		cancel()
+	}
+}
+
+func Test_QuitRacesSignal(t *testing.T) {
+	// keeps the signals from killing the test when the pipeline isn't listening
+	guard := make(chan os.Signal, 16)
+	signal.Notify(guard, syscall.SIGTERM)
+	defer signal.Stop(guard)
+	self, err := os.FindProcess(os.Getpid())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for k := 0; k < 10; k++ {
+		quit := make(chan struct{})
+		producer := gostage.WorkHandler(func(_ interface{}) (interface{}, error) {
+			select {
+			case <-quit:
+				return nil, gostage.ErrQuit
+			default:
+				return nil, gostage.ErrNoData
+			}
+		})
+		configs := []*gostage.Config{
+			{Name: "producer", Worker: producer},
+			{Name: "sink", Worker: passThrough{}, SubscribeToName: "producer"},
+		}
+		gs := gostage.New(context.Background(), configs, &recordingLogger{}, gostage.WithNoDataCountSleep(time.Millisecond))
+		run := func(done func()) error {
+			errs := make(chan error, 1)
+			go func() { errs <- gs.Run(done) }()
+			waitFor(t, "the pipeline to run", func() bool { return gs.State() == gostage.StateRunning })
+			select {
+			case err := <-errs:
+				return err
+			default:
+				return nil
+			}
+		}
+		race(t, gs, run, func() { close(quit) }, func() { self.Signal(syscall.SIGTERM) })
+		if r := gs.Reason(); !errors.Is(r, gostage.ErrQuit) && !errors.Is(r, gostage.ErrSignal) {
+			t.Fatalf("reason %v", r)
+		}
+		if err := stable(t, gs); err != nil {
+			t.Fatalf("Err() = %v", err)
+		}
+	}
+}
+
+func Test_StopRacesSupervision(t *testing.T) {
+	for k := 0; k < 20; k++ {
+		crash := make(chan struct{})
+		configs := []*gostage.Config{
+			{Name: "producer", Worker: gostage.WorkHandler(func(_ interface{}) (interface{}, error) {
+				<-crash
+				panic("crash")
+			}), DisableRestart: true},
+			{Name: "sink", Worker: passThrough{}, SubscribeToName: "producer"},
+		}
+		gs := gostage.New(context.Background(), configs, &recordingLogger{})
+		race(t, gs, gs.RunAsync, func() { gs.Stop() }, func() { close(crash) })
+		err := stable(t, gs)
+		switch r := gs.Reason(); {
+		case errors.Is(r, gostage.ErrStopped):
+			if err != nil {
+				t.Fatalf("stopped by Stop but Err() = %v", err)
+			}
+		case errors.Is(r, gostage.ErrSupervision):
+			if !errors.Is(err, gostage.ErrSupervision) {
+				t.Fatalf("stopped by the crash but Err() = %v", err)
+			}
+		default:
+			t.Fatalf("reason %v", r)
+		}
+	}
+}
+
+func Test_NilDoneCallback(t *testing.T) {
+	configs := []*gostage.Config{
+		{Name: "producer", Worker: countdown(3, func(n int) interface{} { return n })},
+		{Name: "sink", Worker: passThrough{}, SubscribeToName: "producer"},
+	}
+	gs := gostage.New(context.Background(), configs, &recordingLogger{})
+	if err := gs.Run(nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := gs.Err(); err != nil {
+		t.Fatalf("Err() = %v", err)
+	}
+}
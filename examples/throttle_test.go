@@ -0,0 +1,93 @@
+package examples
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/qgymje/gostage"
+)
+
+// refusingPipeline fails 1000 events with the same error
+func refusingPipeline(logger *recordingLogger, reported *atomic.Int64, opts ...gostage.Option) *gostage.GoStage {
+	refused := gostage.WorkHandler(func(interface{}) (interface{}, error) {
+		return nil, errors.New("connection refused")
+	})
+	configs := []*gostage.Config{
+		{Name: "producer", Worker: countdown(1000, func(n int) interface{} { return n })},
+		{Name: "client", Worker: refused, SubscribeToName: "producer", Size: 8, ShareInstance: true},
+	}
+	opts = append(opts, gostage.WithOnError(func(*gostage.StageError) { reported.Add(1) }))
+	return gostage.New(context.Background(), configs, logger, opts...)
+}
+
+func Test_ErrorLogThrottle(t *testing.T) {
+	logger := &recordingLogger{}
+	var reported atomic.Int64
+	gs := refusingPipeline(logger, &reported, gostage.WithErrorLogThrottle(time.Hour, 3))
+	if err := gs.Run(func() {}); err != nil {
+		t.Fatal(err)
+	}
+	if lines := logger.find("client_#", "connection refused"); len(lines) != 3 {
+		t.Fatalf("logged %d errors, want 3", len(lines))
+	}
+	// the rest is logged once the pipeline stops
+	if lines := logger.find("[Error]client error repeated 997 times in "); len(lines) != 1 {
+		t.Fatalf("logged %q", logger.find("repeated"))
+	}
+	if errs := gs.Stats().Stages[1].Errors; errs != 1000 || reported.Load() != 1000 {
+		t.Fatalf("%d errors counted, %d reported, want 1000", errs, reported.Load())
+	}
+}
+
+func Test_ErrorLogThrottleWindow(t *testing.T) {
+	clock := &manualClock{now: time.Unix(0, 0)}
+	logger := &recordingLogger{}
+	var reported atomic.Int64
+	refused := gostage.WorkHandler(func(in interface{}) (interface{}, error) {
+		// the second half fails a second later
+		if in.(int) == 501 {
+			clock.Add(time.Second)
+		}
+		return nil, errors.New("connection refused")
+	})
+	configs := []*gostage.Config{
+		{Name: "producer", Worker: countdown(1000, func(n int) interface{} { return n })},
+		{Name: "client", Worker: refused, SubscribeToName: "producer"},
+	}
+	gs := gostage.New(context.Background(), configs, logger, gostage.WithErrorLogThrottle(time.Second, 2),
+		gostage.WithClock(clock), gostage.WithOnError(func(*gostage.StageError) { reported.Add(1) }))
+	if err := gs.Run(func() {}); err != nil {
+		t.Fatal(err)
+	}
+	// the first window is over with the 501st error, the second one when the pipeline stops
+	if lines := logger.find("[Error]client error repeated 498 times in 1s: connection refused"); len(lines) != 1 {
+		t.Fatalf("logged %q", logger.find("repeated"))
+	}
+	if lines := logger.find("[Error]client error repeated 498 times in 0s: connection refused"); len(lines) != 1 {
+		t.Fatalf("logged %q", logger.find("repeated"))
+	}
+	if lines := logger.find("client_#", "connection refused"); len(lines) != 4 {
+		t.Fatalf("logged %d errors, want 4", len(lines))
+	}
+	if reported.Load() != 1000 {
+		t.Fatalf("%d errors reported", reported.Load())
+	}
+}
+
+func Test_ErrorLogThrottleOff(t *testing.T) {
+	logger := &recordingLogger{}
+	var reported atomic.Int64
+	gs := refusingPipeline(logger, &reported, gostage.WithErrorLogThrottle(0, 0))
+	if err := gs.Run(func() {}); err != nil {
+		t.Fatal(err)
+	}
+	if lines := logger.find("client_#", "connection refused"); len(lines) != 1000 {
+		t.Fatalf("logged %d errors, want 1000", len(lines))
+	}
+	if lines := logger.find("repeated"); len(lines) != 0 {
+		t.Fatalf("logged %q", lines)
+	}
+}
@@ -0,0 +1,94 @@
+package examples
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/qgymje/gostage"
+)
+
+func Test_SetStageEnabled(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	gate := make(chan struct{})
+	next := 0
+	producer := gostage.WorkHandler(func(_ interface{}) (interface{}, error) {
+		if next == 9 {
+			return nil, gostage.ErrNoData
+		}
+		if next == 3 || next == 6 {
+			<-gate
+		}
+		next++
+		return next, nil
+	})
+
+	double := gostage.WorkHandler(func(in interface{}) (interface{}, error) {
+		return in.(int) * 2, nil
+	})
+
+	var mu sync.Mutex
+	var seen []int
+	consumer := gostage.WorkHandler(func(in interface{}) (interface{}, error) {
+		mu.Lock()
+		seen = append(seen, in.(int))
+		mu.Unlock()
+		return nil, nil
+	})
+	received := func(n int) func() bool {
+		return func() bool {
+			mu.Lock()
+			defer mu.Unlock()
+			return len(seen) == n
+		}
+	}
+
+	configs := []*gostage.Config{
+		{Name: "producer", Worker: producer},
+		{Name: "double", Worker: double, SubscribeTo: producer},
+		{Name: "consumer", Worker: consumer, SubscribeTo: double},
+	}
+	gs := gostage.New(ctx, configs, gostage.NewStdLogger(), gostage.WithNoDataCountSleep(time.Millisecond))
+
+	done := make(chan struct{})
+	gs.RunAsync(func() { close(done) })
+
+	waitFor(t, "enabled events", received(3))
+	if err := gs.SetStageEnabled("double", false); err != nil {
+		t.Fatal(err)
+	}
+	gate <- struct{}{}
+
+	waitFor(t, "pass-through events", received(6))
+	if err := gs.SetStageEnabled("double", true); err != nil {
+		t.Fatal(err)
+	}
+	gate <- struct{}{}
+
+	waitFor(t, "re-enabled events", received(9))
+	if err := gs.SetStageEnabled("missing", false); !errors.Is(err, gostage.ErrUnknownStage) {
+		t.Fatalf("err %v, want ErrUnknownStage", err)
+	}
+	cancel()
+	<-done
+
+	want := []int{2, 4, 6, 4, 5, 6, 14, 16, 18}
+	for i := range want {
+		if seen[i] != want[i] {
+			t.Fatalf("consumer saw %v, want %v", seen, want)
+		}
+	}
+	if got := gs.Stats().Stages[1].Bypassed; got != 3 {
+		t.Fatalf("bypassed = %d, want 3", got)
+	}
+	if configs[1].Disabled {
+		t.Fatal("SetStageEnabled changed Config.Disabled")
+	}
+	if err := gs.SetStageEnabled("double", false); !errors.Is(err, gostage.ErrNotRunning) {
+		t.Fatalf("err %v, want ErrNotRunning", err)
+	}
+}
@@ -0,0 +1,89 @@
+package examples
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/qgymje/gostage"
+)
+
+func Test_InvalidTopology(t *testing.T) {
+	producer := gostage.WorkHandler(func(_ interface{}) (interface{}, error) {
+		return nil, gostage.ErrQuit
+	})
+	a, b, missing := adder{n: 1}, adder{n: 2}, adder{n: 3}
+
+	cases := []struct {
+		name    string
+		configs []*gostage.Config
+		want    error
+		message string
+	}{
+		{"self subscription", []*gostage.Config{
+			{Name: "producer", Worker: producer},
+			{Name: "a", Worker: a, SubscribeTo: a},
+		}, gostage.ErrCycle, "a -> a"},
+		{"self subscription in the chain", []*gostage.Config{
+			{Name: "producer", Worker: producer},
+			{Name: "a", Worker: a, SubscribeTo: producer},
+			{Name: "b", Worker: a, SubscribeTo: a},
+		}, gostage.ErrCycle, "b -> b"},
+		{"two configs", []*gostage.Config{
+			{Name: "producer", Worker: producer},
+			{Name: "a", Worker: a, SubscribeTo: b},
+			{Name: "b", Worker: b, SubscribeTo: a},
+		}, gostage.ErrCycle, "a -> b -> a"},
+		{"no producer", []*gostage.Config{
+			{Name: "a", SubscribeToName: "b", Worker: a},
+			{Name: "b", SubscribeToName: "a", Worker: b},
+		}, gostage.ErrCycle, "a -> b -> a"},
+		{"producers only", []*gostage.Config{
+			{Name: "producer", Worker: producer},
+			{Name: "another", Worker: idle},
+		}, gostage.ErrNoConsumer, "nothing subscribes to producer, another"},
+		{"two stages of the same name", []*gostage.Config{
+			{Name: "producer", Worker: producer},
+			{Name: "a", Worker: a, SubscribeTo: producer},
+			{Name: "a", Worker: b, SubscribeTo: a},
+		}, gostage.ErrDuplicateStage, "a is the name of 2 stages"},
+		{"dangling reference", []*gostage.Config{
+			{Name: "producer", Worker: producer},
+			{Name: "a", Worker: a, SubscribeTo: missing},
+		}, gostage.ErrOrphanStage, "a subscribes"},
+		{"two subscribers of a stage", []*gostage.Config{
+			{Name: "producer", Worker: producer},
+			{Name: "a", Worker: a, SubscribeTo: producer},
+			{Name: "b", Worker: b, SubscribeToName: "a"},
+			{Name: "c", Worker: missing, SubscribeToName: "a"},
+		}, gostage.ErrFanOut, "c subscribes to a which already feeds b"},
+		{"a branch off a cycle", []*gostage.Config{
+			{Name: "producer", Worker: producer},
+			{Name: "a", Worker: a, SubscribeTo: producer},
+			{Name: "b", Worker: b, SubscribeToName: "c"},
+			{Name: "c", Worker: missing, SubscribeToName: "b"},
+		}, gostage.ErrCycle, "b -> c -> b"},
+	}
+
+	for _, c := range cases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			gs := gostage.New(context.Background(), c.configs, &recordingLogger{})
+			result := make(chan error, 1)
+			go func() {
+				result <- gs.Run(func() {})
+			}()
+
+			select {
+			case err := <-result:
+				if !errors.Is(err, c.want) || !strings.Contains(err.Error(), c.message) {
+					t.Fatalf("Run error = %v, want %v naming %q", err, c.want, c.message)
+				}
+			case <-time.After(3 * time.Second):
+				t.Fatal("Run hung on an invalid topology")
+			}
+		})
+	}
+}
@@ -0,0 +1,89 @@
+package examples
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/qgymje/gostage"
+)
+
+func Test_MaxEventAge(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	next := 0
+	producer := gostage.WorkHandler(func(_ interface{}) (interface{}, error) {
+		if next == 10 {
+			return nil, gostage.ErrNoData
+		}
+		next++
+		return next, nil
+	})
+
+	var seen []int
+	last := make(chan struct{})
+	consumer := gostage.WorkHandler(func(in interface{}) (interface{}, error) {
+		// stall on the first event so the second one waits in the pipeline
+		if in.(int) == 1 {
+			time.Sleep(100 * time.Millisecond)
+		}
+		seen = append(seen, in.(int))
+		if in.(int) == 10 {
+			close(last)
+		}
+		return nil, nil
+	})
+
+	var mu sync.Mutex
+	var expired []interface{}
+	onError := func(e *gostage.StageError) {
+		mu.Lock()
+		defer mu.Unlock()
+		if errors.Is(e, gostage.ErrExpired) {
+			expired = append(expired, e.Input)
+		}
+	}
+
+	configs := []*gostage.Config{
+		{Name: "producer", Worker: producer},
+		{Name: "consumer", Worker: consumer, SubscribeTo: producer, MaxEventAge: 20 * time.Millisecond},
+	}
+
+	gs := gostage.New(ctx, configs, gostage.NewStdLogger(),
+		gostage.WithNoDataCountSleep(time.Millisecond),
+		gostage.WithOnError(onError),
+	)
+
+	done := make(chan struct{})
+	gs.RunAsync(func() { close(done) })
+
+	select {
+	case <-last:
+	case <-ctx.Done():
+		t.Fatal("consumer didn't receive the last event")
+	}
+	cancel()
+	<-done
+
+	want := []int{1, 3, 4, 5, 6, 7, 8, 9, 10}
+	if len(seen) != len(want) {
+		t.Fatalf("consumer saw %v, want %v", seen, want)
+	}
+	for i := range want {
+		if seen[i] != want[i] {
+			t.Fatalf("consumer saw %v, want %v", seen, want)
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(expired) != 1 || expired[0] != 2 {
+		t.Fatalf("expired events = %v, want [2]", expired)
+	}
+	if got := gs.Stats().Stages[1].Expired; got != 1 {
+		t.Fatalf("expired count = %d, want 1", got)
+	}
+}
@@ -0,0 +1,132 @@
+package examples
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/qgymje/gostage"
+)
+
+// enricher loads its reference data in Warmup
+type enricher struct {
+	loaded  chan struct{}
+	fail    error
+	warm    atomic.Bool
+	handled atomic.Int64
+	cold    atomic.Int64
+}
+
+func (e *enricher) Warmup(ctx context.Context) error {
+	select {
+	case <-e.loaded:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	if e.fail != nil {
+		return e.fail
+	}
+	e.warm.Store(true)
+	return nil
+}
+
+func (e *enricher) HandleEvent(in interface{}) (interface{}, error) {
+	e.handled.Add(1)
+	if !e.warm.Load() {
+		e.cold.Add(1)
+	}
+	return in, nil
+}
+
+func warmupPipeline(e *enricher, opts ...gostage.Option) *gostage.GoStage {
+	configs := []*gostage.Config{
+		{Name: "producer", Worker: countdown(10, func(n int) interface{} { return n })},
+		{Name: "enrich", Worker: e, SubscribeToName: "producer"},
+	}
+	return gostage.New(context.Background(), configs, &recordingLogger{}, opts...)
+}
+
+func Test_Warmup(t *testing.T) {
+	e := &enricher{loaded: make(chan struct{})}
+	gs := warmupPipeline(e)
+	started := make(chan error, 1)
+	done := make(chan struct{})
+	go func() {
+		started <- gs.RunAsync(func() { close(done) })
+	}()
+
+	time.Sleep(20 * time.Millisecond)
+	select {
+	case <-started:
+		t.Fatal("started before the warmup")
+	default:
+	}
+	if gs.State() != gostage.StateStarting || e.handled.Load() != 0 {
+		t.Fatalf("pipeline is %s, %d events handled before the warmup", gs.State(), e.handled.Load())
+	}
+
+	close(e.loaded)
+	if err := <-started; err != nil {
+		t.Fatal(err)
+	}
+	<-gs.Ready()
+	<-done
+	if e.handled.Load() != 10 || e.cold.Load() != 0 {
+		t.Fatalf("%d events handled, %d cold", e.handled.Load(), e.cold.Load())
+	}
+	if gs.Stats().Stages[1].Cold {
+		t.Fatal("the stage is cold")
+	}
+}
+
+func Test_WarmupTimeout(t *testing.T) {
+	// never loaded
+	e := &enricher{loaded: make(chan struct{})}
+	logger := &recordingLogger{}
+	configs := []*gostage.Config{
+		{Name: "producer", Worker: countdown(10, func(n int) interface{} { return n })},
+		{Name: "enrich", Worker: e, SubscribeToName: "producer"},
+	}
+	gs := gostage.New(context.Background(), configs, logger, gostage.WithWarmupTimeout(20*time.Millisecond))
+	if err := gs.Run(func() {}); err != nil {
+		t.Fatal(err)
+	}
+	if e.handled.Load() != 10 || e.cold.Load() != 10 {
+		t.Fatalf("%d events handled, %d cold", e.handled.Load(), e.cold.Load())
+	}
+	if !gs.Stats().Stages[1].Cold {
+		t.Fatal("the stage isn't cold")
+	}
+	if len(logger.find("[Error]enrich_#0 isn't warm after 20ms")) != 1 {
+		t.Fatalf("logged %q", logger.find("warm"))
+	}
+}
+
+func Test_WarmupFailure(t *testing.T) {
+	failure := errors.New("reference data unavailable")
+	loaded := make(chan struct{})
+	close(loaded)
+
+	// logged, the stage starts cold
+	e := &enricher{loaded: loaded, fail: failure}
+	gs := warmupPipeline(e)
+	if err := gs.Run(func() {}); err != nil {
+		t.Fatal(err)
+	}
+	if !gs.Stats().Stages[1].Cold || e.handled.Load() != 10 {
+		t.Fatalf("stats %+v", gs.Stats().Stages[1])
+	}
+
+	// fatal, nothing runs
+	e = &enricher{loaded: loaded, fail: failure}
+	gs = warmupPipeline(e, gostage.WithFatalWarmup())
+	err := gs.Run(func() {})
+	if !errors.Is(err, gostage.ErrWarmup) || !errors.Is(err, failure) {
+		t.Fatalf("err %v", err)
+	}
+	if e.handled.Load() != 0 || gs.State() != gostage.StateStopped {
+		t.Fatalf("%d events handled, pipeline is %s", e.handled.Load(), gs.State())
+	}
+}
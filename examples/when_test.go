@@ -0,0 +1,87 @@
+package examples
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/qgymje/gostage"
+)
+
+type order struct {
+	Kind     string
+	ID       int
+	Enriched bool
+}
+
+func Test_When(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	next := 0
+	producer := gostage.WorkHandler(func(_ interface{}) (interface{}, error) {
+		if next == 10 {
+			return nil, gostage.ErrNoData
+		}
+		next++
+		kind := "refund"
+		if next%2 == 0 {
+			kind = "order"
+		}
+		return &order{Kind: kind, ID: next}, nil
+	})
+
+	var handled []int
+	enrich := gostage.WorkHandler(func(in interface{}) (interface{}, error) {
+		o := in.(*order)
+		handled = append(handled, o.ID)
+		o.Enriched = true
+		return o, nil
+	})
+
+	var mu sync.Mutex
+	var seen []*order
+	consumer := gostage.WorkHandler(func(in interface{}) (interface{}, error) {
+		mu.Lock()
+		seen = append(seen, in.(*order))
+		mu.Unlock()
+		return nil, nil
+	})
+
+	configs := []*gostage.Config{
+		{Name: "producer", Worker: producer},
+		{
+			Name:        "enrich",
+			Worker:      enrich,
+			SubscribeTo: producer,
+			When: func(in interface{}) bool {
+				return in.(*order).Kind == "order"
+			},
+		},
+		{Name: "consumer", Worker: consumer, SubscribeTo: enrich},
+	}
+	gs := gostage.New(ctx, configs, gostage.NewStdLogger(), gostage.WithNoDataCountSleep(time.Millisecond))
+
+	done := make(chan struct{})
+	gs.RunAsync(func() { close(done) })
+	waitFor(t, "all events", func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(seen) == 10
+	})
+	cancel()
+	<-done
+
+	if want := []int{2, 4, 6, 8, 10}; len(handled) != len(want) {
+		t.Fatalf("enrich handled %v, want %v", handled, want)
+	}
+	for _, o := range seen {
+		if o.Enriched != (o.Kind == "order") {
+			t.Fatalf("event %d of kind %s enriched = %v", o.ID, o.Kind, o.Enriched)
+		}
+	}
+	if got := gs.Stats().Stages[1].Bypassed; got != 5 {
+		t.Fatalf("bypassed = %d, want 5", got)
+	}
+}
@@ -0,0 +1,139 @@
+package gostage
+
+// RoundRobinAcrossSources is a Queue which takes the events of the producers they come from
+// in turn, so that a producer sending much more than the others can't starve them
+// each producer has a buffer of capacity events of its own, a full one makes its pushes wait,
+// the events put by Push or Inject are a source of their own, see StageStats.Sources
+func RoundRobinAcrossSources(capacity int, _ func(interface{})) Queue {
+	return newBufferQueue(capacity, &sourceItems{}, nil)
+}
+
+// WeightedBySource returns a QueueFactory like RoundRobinAcrossSources which takes up to
+// weights[name] events in a row from the producer with that name when its turn comes,
+// the producers which aren't in weights have a weight of 1
+func WeightedBySource(weights map[string]int) QueueFactory {
+	return func(capacity int, _ func(interface{})) Queue {
+		return newBufferQueue(capacity, &sourceItems{weights: weights}, nil)
+	}
+}
+
+// sourceItems keeps a fifo lane per source and takes from them in turn
+type sourceItems struct {
+	weights map[string]int
+	// the name of the producer with the given index, set once the queue is made
+	name func(root int) string
+
+	lanes []*sourceLane
+	// the lanes by the index of their producer
+	byRoot map[int]*sourceLane
+	// the lane whose turn it is, and the events taken from it in this turn
+	cur    int
+	served int
+	n      int
+}
+
+type sourceLane struct {
+	fifo
+	root      int
+	weight    int
+	delivered int64
+}
+
+// sourceOf returns the index of the producer the event v came from, -1 if it was pushed
+func sourceOf(v interface{}) int {
+	if env, ok := v.(*envelope); ok {
+		return env.root
+	}
+	return -1
+}
+
+func (it *sourceItems) lane(v interface{}) *sourceLane {
+	root := sourceOf(v)
+	if l := it.byRoot[root]; l != nil {
+		return l
+	}
+	if it.byRoot == nil {
+		it.byRoot = make(map[int]*sourceLane)
+	}
+	l := &sourceLane{root: root, weight: 1}
+	if w := it.weights[it.sourceName(root)]; w > 1 {
+		l.weight = w
+	}
+	it.byRoot[root] = l
+	it.lanes = append(it.lanes, l)
+	return l
+}
+
+func (it *sourceItems) sourceName(root int) string {
+	if it.name == nil {
+		return ""
+	}
+	return it.name(root)
+}
+
+func (it *sourceItems) laneLen(v interface{}) int { return it.lane(v).len() }
+
+func (it *sourceItems) len() int { return it.n }
+
+func (it *sourceItems) add(v interface{}) {
+	it.lane(v).add(v)
+	it.n++
+}
+
+func (it *sourceItems) take() interface{} {
+	l := it.lanes[it.cur]
+	if l.len() == 0 || it.served >= l.weight {
+		// the next lane with events, there's one since the queue isn't empty
+		for {
+			it.cur = (it.cur + 1) % len(it.lanes)
+			if l = it.lanes[it.cur]; l.len() > 0 {
+				break
+			}
+		}
+		it.served = 0
+	}
+	it.served++
+	it.n--
+	l.delivered++
+	return l.take()
+}
+
+// sourceItemsOf returns the lanes of q if it's RoundRobinAcrossSources or WeightedBySource
+func sourceItemsOf(q Queue) (*bufferQueue, *sourceItems) {
+	bq, ok := q.(*bufferQueue)
+	if !ok {
+		return nil, nil
+	}
+	it, ok := bq.items.(*sourceItems)
+	if !ok {
+		return nil, nil
+	}
+	return bq, it
+}
+
+// sourceName returns the name of the producer with index root, empty for the pushed events
+func (s *GoStage) sourceName(root int) string {
+	if root < 0 || root >= s.producers {
+		return ""
+	}
+	return s.linkedWorkers[root].Name
+}
+
+// sources returns the events lw took from each producer, by name,
+// nil unless it's fed by RoundRobinAcrossSources or WeightedBySource
+func (s *GoStage) sources(lw *linkedWorker) map[string]int64 {
+	if lw.queue == nil {
+		return nil
+	}
+	q, it := sourceItemsOf(lw.queue.q)
+	if it == nil {
+		return nil
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	counts := make(map[string]int64, len(it.lanes))
+	for _, l := range it.lanes {
+		counts[it.sourceName(l.root)] += l.delivered
+	}
+	return counts
+}
@@ -0,0 +1,59 @@
+package gostage
+
+import (
+	"errors"
+	"os"
+)
+
+// WithExitOnFatal exits the process with code once the pipeline has stopped
+// because of a fatal error, after the workers are closed and the done callback has run
+// without it the fatal error is only returned by Err
+func WithExitOnFatal(code int) Option {
+	return func(gs *GoStage) {
+		gs.exitOnFatal = true
+		gs.exitCode = code
+	}
+}
+
+// Err returns the fatal error which stopped the last run, nil if there was none
+// it's ErrSupervision or ErrErrorBudgetExceeded, see Reason for why the run stopped otherwise
+// the panics recovered from the callbacks of the run are joined to it as ErrCallbackPanic
+func (s *GoStage) Err() error {
+	s.mu.Lock()
+	fatal := s.fatal
+	s.mu.Unlock()
+
+	s.panicsMu.Lock()
+	defer s.panicsMu.Unlock()
+	if len(s.panics) == 0 {
+		return fatal
+	}
+	return errors.Join(append([]error{fatal}, s.panics...)...)
+}
+
+// failed handles a fatal error received on errChan, only the first one is kept
+// the pipeline stops through the same path as any other reason
+func (s *GoStage) failed(err error) {
+	s.logger.Error("gostage fatal error happened: %+v", err)
+	s.setReason(err)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.fatal == nil {
+		s.fatal = err
+	}
+}
+
+// exitIfFatal exits the process if the last run failed and WithExitOnFatal is set
+// the panics of callbacks don't count
+func (s *GoStage) exitIfFatal() {
+	if !s.exitOnFatal {
+		return
+	}
+	s.mu.Lock()
+	fatal := s.fatal
+	s.mu.Unlock()
+	if fatal != nil {
+		os.Exit(s.exitCode)
+	}
+}
@@ -0,0 +1,436 @@
+package gostage
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"sync"
+)
+
+// ErrSinkFailed if a FileSink is given an event after a write to its file failed
+var ErrSinkFailed = errors.New("file sink failed")
+
+// fileSinkBuffer is how many bytes a FileSink buffers before writing them
+const fileSinkBuffer = 64 << 10
+
+// FromJSONLines creates a producer emitting the records of the JSON lines file at path,
+// then returns ErrQuit at the end of the file, the file is opened by every run
+// every line is decoded into newRecord(), which must return a pointer, the empty lines are
+// skipped, a nil newRecord decodes the lines into map[string]interface{}
+func FromJSONLines(name, path string, newRecord func() interface{}) (*Config, error) {
+	if _, err := os.Stat(path); err != nil {
+		return nil, err
+	}
+	if newRecord == nil {
+		newRecord = func() interface{} { return &map[string]interface{}{} }
+	}
+	src := &fileSource{path: path}
+	src.decode = func(line []byte) (interface{}, error) {
+		v := newRecord()
+		if err := json.Unmarshal(line, v); err != nil {
+			return nil, err
+		}
+		return v, nil
+	}
+	return &Config{Name: name, Size: 1, Worker: src, Role: Source}, nil
+}
+
+// ToJSONLines creates a terminal stage writing its events to the file at path,
+// one JSON document per line, see FileSink
+// set SubscribeTo on the returned Config to link it to the pipeline
+func ToJSONLines(name, path string) (*Config, *FileSink, error) {
+	return newFileSink(name, path, func(v interface{}) ([]byte, error) {
+		line, err := json.Marshal(v)
+		if err != nil {
+			return nil, err
+		}
+		return append(line, '\n'), nil
+	})
+}
+
+// CSVOption configures FromCSV and ToCSV
+type CSVOption func(*csvFormat)
+
+// CSVColumns sets the columns of the file: FromCSV reads no header, the file has none,
+// ToCSV writes them in this order, default is the header of the file, and the sorted
+// keys of the first record for ToCSV
+func CSVColumns(columns ...string) CSVOption {
+	return func(f *csvFormat) {
+		f.columns = columns
+	}
+}
+
+// CSVMapping maps the columns of the file to the keys of the records,
+// the columns not mapped keep their name
+func CSVMapping(m map[string]string) CSVOption {
+	return func(f *csvFormat) {
+		f.mapping = m
+	}
+}
+
+// CSVComma sets the field delimiter, default is ','
+func CSVComma(comma rune) CSVOption {
+	return func(f *csvFormat) {
+		f.comma = comma
+	}
+}
+
+type csvFormat struct {
+	columns []string
+	mapping map[string]string
+	comma   rune
+}
+
+func newCSVFormat(opts []CSVOption) *csvFormat {
+	f := &csvFormat{comma: ','}
+	for _, opt := range opts {
+		opt(f)
+	}
+	return f
+}
+
+// keys returns the keys of the records for columns, nil if columns is nil
+func (f *csvFormat) keys(columns []string) []string {
+	if columns == nil {
+		return nil
+	}
+	keys := make([]string, len(columns))
+	for k, c := range columns {
+		keys[k] = f.key(c)
+	}
+	return keys
+}
+
+// columnsOf returns the columns of the sorted keys of rec
+func (f *csvFormat) columnsOf(rec map[string]string) []string {
+	columnOf := make(map[string]string, len(f.mapping))
+	for c, k := range f.mapping {
+		columnOf[k] = c
+	}
+	keys := make([]string, 0, len(rec))
+	for k := range rec {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	columns := make([]string, len(keys))
+	for n, k := range keys {
+		columns[n] = k
+		if c, ok := columnOf[k]; ok {
+			columns[n] = c
+		}
+	}
+	return columns
+}
+
+// key returns the key of the records for column
+func (f *csvFormat) key(column string) string {
+	if k, ok := f.mapping[column]; ok {
+		return k
+	}
+	return column
+}
+
+// FromCSV creates a producer emitting the rows of the CSV file at path as map[string]string
+// keyed by column, see CSVColumns and CSVMapping, then returns ErrQuit at the end of the file,
+// the file is opened by every run
+func FromCSV(name, path string, opts ...CSVOption) (*Config, error) {
+	format := newCSVFormat(opts)
+	if _, err := os.Stat(path); err != nil {
+		return nil, err
+	}
+	src := &fileSource{path: path}
+	var r *csv.Reader
+	var keys []string
+	src.start = func() {
+		r = csv.NewReader(src.r)
+		r.Comma = format.comma
+		// a row with the wrong number of fields is an error of its own line, not of the file
+		r.FieldsPerRecord = -1
+		keys = format.keys(format.columns)
+	}
+	src.next = func() (interface{}, error) {
+		row, err := r.Read()
+		var perr *csv.ParseError
+		if errors.As(err, &perr) {
+			src.line = perr.Line
+			return nil, perr.Err
+		}
+		if err != nil {
+			return nil, err
+		}
+		src.line, _ = r.FieldPos(0)
+		if keys == nil {
+			// the header
+			keys = format.keys(row)
+			return nil, nil
+		}
+		if len(row) != len(keys) {
+			return nil, fmt.Errorf("%d fields, the header has %d", len(row), len(keys))
+		}
+		rec := make(map[string]string, len(row))
+		for k, v := range row {
+			rec[keys[k]] = v
+		}
+		return rec, nil
+	}
+	return &Config{Name: name, Size: 1, Worker: src, Role: Source}, nil
+}
+
+// ToCSV creates a terminal stage writing its events, which must be map[string]string,
+// to the CSV file at path, the header row is written with the first event,
+// see CSVColumns, CSVMapping and FileSink
+// set SubscribeTo on the returned Config to link it to the pipeline
+func ToCSV(name, path string, opts ...CSVOption) (*Config, *FileSink, error) {
+	format := newCSVFormat(opts)
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	w.Comma = format.comma
+	columns := format.columns
+	header := true
+	// the sink encodes one event at a time
+	return newFileSink(name, path, func(v interface{}) ([]byte, error) {
+		rec, ok := v.(map[string]string)
+		if !ok {
+			return nil, Permanent(fmt.Errorf("%T isn't a map[string]string", v))
+		}
+		if columns == nil {
+			columns = format.columnsOf(rec)
+		}
+		buf.Reset()
+		if header {
+			w.Write(columns)
+			header = false
+		}
+		fields := make([]string, len(columns))
+		for k, c := range columns {
+			fields[k] = rec[format.key(c)]
+		}
+		w.Write(fields)
+		w.Flush()
+		if err := w.Error(); err != nil {
+			return nil, err
+		}
+		return append([]byte(nil), buf.Bytes()...), nil
+	})
+}
+
+// fileSource emits the records of a file, see FromJSONLines and FromCSV
+// the file is opened by the first HandleEvent of a run and closed at its end
+type fileSource struct {
+	path string
+	file *os.File
+	r    *bufio.Reader
+	// the line of the last record
+	line int
+	// start prepares the reading of the file just opened, if it's set
+	start func()
+	// decode parses a line, next reads the next record instead if it's set
+	decode func(line []byte) (interface{}, error)
+	next   func() (interface{}, error)
+}
+
+// HandleEvent emits the next record, a record which can't be parsed is an error
+// with its line, ErrQuit at the end of the file
+func (f *fileSource) HandleEvent(interface{}) (interface{}, error) {
+	if f.file == nil {
+		if err := f.open(); err != nil {
+			return nil, err
+		}
+	}
+	for {
+		v, err := f.read()
+		if errors.Is(err, io.EOF) {
+			// the next run reads the file again
+			f.Close()
+			return nil, ErrQuit
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", f.path, f.line, err)
+		}
+		if v != nil {
+			return v, nil
+		}
+	}
+}
+
+// open opens the file and reads it from the start
+func (f *fileSource) open() error {
+	file, err := os.Open(f.path)
+	if err != nil {
+		return err
+	}
+	f.file, f.r, f.line = file, bufio.NewReader(file), 0
+	if f.start != nil {
+		f.start()
+	}
+	return nil
+}
+
+// read returns the next record, nil for an empty line
+func (f *fileSource) read() (interface{}, error) {
+	if f.next != nil {
+		return f.next()
+	}
+	line, err := f.r.ReadBytes('\n')
+	if len(line) == 0 && err != nil {
+		return nil, err
+	}
+	f.line++
+	line = bytes.TrimSpace(line)
+	if len(line) == 0 {
+		return nil, nil
+	}
+	return f.decode(line)
+}
+
+// Close closes the file if it's open
+func (f *fileSource) Close() {
+	if f.file == nil {
+		return
+	}
+	f.file.Close()
+	f.file, f.r = nil, nil
+}
+
+// FileSink is a terminal worker writing its events to a file, one per line,
+// made by ToJSONLines and ToCSV
+// the lines are buffered and written by fileSinkBuffer bytes, the file is flushed
+// and synced when the stage stops; if a write fails the lines written in full are kept,
+// the partial one is cut off and every event from then on is counted as lost,
+// HandleEvent returns the error then, see Written, Lost and Err
+type FileSink struct {
+	path   string
+	encode func(v interface{}) ([]byte, error)
+
+	mu   sync.Mutex
+	file *os.File
+	buf  []byte
+	// the end of every line in buf
+	ends []int
+	// the size of the lines written in full
+	size    int64
+	written int64
+	lost    int64
+	err     error
+	closed  bool
+}
+
+func newFileSink(name, path string, encode func(v interface{}) ([]byte, error)) (*Config, *FileSink, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	s := &FileSink{path: path, encode: encode, file: f}
+	return &Config{Name: name, Size: 1, Worker: s, Role: Sink}, s, nil
+}
+
+// HandleEvent buffers the line of in, it's passed on unchanged
+func (s *FileSink) HandleEvent(in interface{}) (interface{}, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil || s.closed {
+		s.lost++
+		return nil, Permanent(fmt.Errorf("%w: %s: %v", ErrSinkFailed, s.path, s.failure()))
+	}
+	line, err := s.encode(in)
+	if err != nil {
+		return nil, err
+	}
+	s.buf = append(s.buf, line...)
+	s.ends = append(s.ends, len(s.buf))
+	if len(s.buf) < fileSinkBuffer {
+		return in, nil
+	}
+	if err := s.flush(); err != nil {
+		return nil, err
+	}
+	return in, nil
+}
+
+// failure returns why the sink doesn't take events anymore, s.mu must be held
+func (s *FileSink) failure() error {
+	if s.err != nil {
+		return s.err
+	}
+	return errors.New("closed")
+}
+
+// flush writes the buffered lines, s.mu must be held
+func (s *FileSink) flush() error {
+	if len(s.buf) == 0 {
+		return nil
+	}
+	n, err := s.file.Write(s.buf)
+	if err == nil {
+		s.size += int64(n)
+		s.written += int64(len(s.ends))
+		s.buf, s.ends = s.buf[:0], s.ends[:0]
+		return nil
+	}
+
+	// keep the lines written in full
+	complete, whole := 0, 0
+	for _, end := range s.ends {
+		if end > n {
+			break
+		}
+		complete, whole = end, whole+1
+	}
+	s.size += int64(complete)
+	s.written += int64(whole)
+	s.lost += int64(len(s.ends) - whole)
+	s.buf, s.ends = nil, nil
+	if complete < n {
+		if terr := s.file.Truncate(s.size); terr != nil {
+			err = fmt.Errorf("%w, the partial line is left: %v", err, terr)
+		}
+	}
+	s.err = fmt.Errorf("%s: %w", s.path, err)
+	return s.err
+}
+
+// Close flushes the buffered lines, syncs and closes the file
+func (s *FileSink) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return
+	}
+	s.closed = true
+	if s.err == nil {
+		s.flush()
+	}
+	if err := s.file.Sync(); err != nil && s.err == nil {
+		s.err = fmt.Errorf("%s: %w", s.path, err)
+	}
+	if err := s.file.Close(); err != nil && s.err == nil {
+		s.err = fmt.Errorf("%s: %w", s.path, err)
+	}
+}
+
+// Written returns the number of events written to the file
+func (s *FileSink) Written() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.written
+}
+
+// Lost returns the number of events which couldn't be written, once a write has failed
+func (s *FileSink) Lost() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.lost
+}
+
+// Err returns the error of the failed write, sync or close of the file, nil if none
+func (s *FileSink) Err() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
@@ -3,19 +3,35 @@ package gostage
 import (
 	"context"
 	"errors"
+	"fmt"
+	"math/rand"
 	"os"
 	"os/signal"
 	"reflect"
+	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 )
 
 // ErrNoData if the producer worker generates no data
+// returned by any other stage it consumes the input without passing anything on,
+// it isn't counted as an error
 var ErrNoData = errors.New("no data")
 
 // ErrQuit if producer is about the quit, return this error
 var ErrQuit = errors.New("quit")
 
+// ErrAlreadyRunning if Run or RunAsync is called while the pipeline is running
+var ErrAlreadyRunning = errors.New("gostage is already running")
+
+// ErrInvalidConcurrency if Config.Concurrency is set without ShareInstance or along with Size
+var ErrInvalidConcurrency = errors.New("invalid concurrency")
+
+// ErrMissingCreate if a stage with Size > 1 has a worker which can't be created
+// and doesn't set ShareInstance
+var ErrMissingCreate = errors.New("worker needs a Create method")
+
 // DefaultRestart the default restart times for each worker
 var DefaultRestart = 1
 
@@ -31,6 +47,8 @@ var NoDataCount = 100
 var NoDataCountSleep = time.Second
 
 // Logger the logger interface
+// the pipeline never calls Fatal, a fatal error is logged with Error
+// and returned by Err, see WithExitOnFatal to exit the process
 type Logger interface {
 	Fatal(format string, args ...interface{})
 	Error(format string, args ...interface{})
@@ -38,6 +56,21 @@ type Logger interface {
 	Debug(format string, args ...interface{})
 }
 
+// WarnLogger is implemented by the loggers with a Warn level,
+// the warnings go to Error with the others
+type WarnLogger interface {
+	Warn(format string, args ...interface{})
+}
+
+// logWarn logs at Warn level if l is a WarnLogger, at Error level otherwise
+func logWarn(l Logger, format string, args ...interface{}) {
+	if w, ok := l.(WarnLogger); ok {
+		w.Warn(format, args...)
+		return
+	}
+	l.Error(format, args...)
+}
+
 // WorkHandler is a handy function type that implements Worker
 type WorkHandler func(interface{}) (interface{}, error)
 
@@ -46,6 +79,28 @@ func (wh WorkHandler) HandleEvent(in interface{}) (interface{}, error) {
 	return wh(in)
 }
 
+// SourceHandler is a handy function type for producers, which have no input
+// it can't subscribe to anything
+type SourceHandler func() (interface{}, error)
+
+// HandleEvent implements the Worker
+func (sh SourceHandler) HandleEvent(interface{}) (interface{}, error) {
+	return sh()
+}
+
+func (SourceHandler) source() {}
+
+// SinkHandler is a handy function type for terminal consumers, which have no output
+// nothing can subscribe to it
+type SinkHandler func(interface{}) error
+
+// HandleEvent implements the Worker
+func (sh SinkHandler) HandleEvent(in interface{}) (interface{}, error) {
+	return nil, sh(in)
+}
+
+func (SinkHandler) sink() {}
+
 // Worker describes a worker abstaction
 type Worker interface {
 	// Create creates a fresh new worker in order to avoid data race
@@ -63,6 +118,18 @@ type Worker interface {
 	// Close()
 }
 
+// InstanceAware is implemented by workers that need to know which instance they are
+// SetInstanceInfo is called before the worker handles any event
+type InstanceAware interface {
+	SetInstanceInfo(stage string, instance, total int)
+}
+
+// CreatorWithInfo is like Create but receives the stage name, the instance index
+// and the total number of instances, it is preferred over Create if both exist
+type CreatorWithInfo interface {
+	CreateWithInfo(stage string, instance, total int) Worker
+}
+
 // Config is a description of a Worker
 type Config struct {
 	// the worker's name, shows in logger
@@ -70,34 +137,406 @@ type Config struct {
 	// the number of worker that will create
 	// each worker runs in a goroutine
 	Size int
+	// all instances run the Worker itself instead of workers made by Create,
+	// it must be safe for concurrent use, Close is called once
+	ShareInstance bool
+	// the number of goroutines calling HandleEvent on the shared Worker,
+	// it requires ShareInstance and replaces Size
+	Concurrency int
+	// scales the instances of a consumer stage with the length of its input buffer, optional
+	AutoScale *AutoScale
+	// how the events of a consumer stage reach its instances, default is Compete
+	Dispatch Dispatch
+	// the key of the events of a Partitioned stage without a partition attached by
+	// WithPartition, the events with the same key go to the same instance
+	PartitionBy func(v interface{}) string
+	// maps the partitions of a Partitioned stage to its instances, default is Modulo,
+	// see ConsistentHash to move fewer partitions when the stage is scaled
+	Partitioner Partitioner
+	// acknowledges that the stage subscribes to a Broadcast stage and so gets
+	// every event once per instance of it, the validation fails otherwise
+	ExpectBroadcast bool
+	// only run as many of a consumer stage's instances as its backlog needs,
+	// the others are parked without a goroutine, for wide mostly idle stages
+	Pool bool
+	// how long a pooled instance waits for an event before it's parked, default is a second
+	PoolIdleTimeout time.Duration
 	// the worker itself
 	Worker Worker
 	// this worker's HandleEvent function will return data which
 	// pass to the current worker's HandleEvent's input
 	SubscribeTo Worker
-	// each worker has a change to restart
+	// the Name of the config this worker subscribes to
+	// use it instead of SubscribeTo, the two can't be both set
+	SubscribeToName string
+	// each worker has a change to restart, default is DefaultRestart
 	Restart int
+	// the first panic ends the pipeline, Restart is ignored
+	// use it for workers with side effects which can't be repeated
+	DisableRestart bool
+	// only the restarts in the last RestartWindow count against Restart
+	// it's also the window of StageStats.RecentRestarts, default is a minute
+	RestartWindow time.Duration
+	// how long to wait before restarting a crashed worker, optional
+	RestartBackoff func(attempt int) time.Duration
+	// called after a crashed worker is restarted, off the worker's goroutine
+	OnRestart func(RestartEvent)
+	// events older than MaxEventAge are dropped before HandleEvent is called
+	// zero means the pipeline-wide value set by WithMaxEventAge
+	MaxEventAge time.Duration
+	// the place of the stage in the pipeline, checked against the subscriptions
+	// default is Auto, which infers it from the stage's position
+	Role Role
+	// the number of events buffered in front of this worker, default is 0
+	BufferSize int
+	// the bytes of the Sizer events buffered in front of this worker
+	// zero means the pipeline-wide value set by WithMaxBufferedBytes
+	MaxBufferedBytes int64
+	// the size of the biggest Sizer event let in this stage, the bigger ones are rejected
+	// before they're buffered: counted, logged at Warn, reported with ErrPayloadTooLarge
+	// and dead-lettered truncated, zero means no limit
+	MaxPayloadBytes int64
+	// what to do when the buffer is full, default is Block
+	OverflowPolicy OverflowPolicy
+	// makes the queue the events wait in for this worker instead of a channel,
+	// the queue decides what to do when it's full and OverflowPolicy is ignored
+	Queue QueueFactory
+	// the fraction of the events whose input and output this stage logs at Debug level,
+	// zero means the pipeline-wide value set by WithDebugSampling, negative disables it
+	DebugSampling float64
+	// the stage stops as with StopImmediate whatever the StopMode,
+	// its buffered events are dropped and counted in Accounting.DroppedBestEffort
+	BestEffort bool
+	// the events sent to this worker are passed as bytes encoded by Codec
+	// it overrides the pipeline-wide codec set by WithCodec
+	Codec Codec
+	// a disabled producer produces nothing, a disabled consumer discards events
+	// and a disabled middle worker passes events through untouched
+	Disabled bool
+	// if When returns false for an event, HandleEvent isn't called
+	// and the event is passed downstream untouched
+	// it is checked before any other processing of the event
+	When func(interface{}) bool
+	// what to do when HandleEvent panics, default is Restart
+	PanicPolicy PanicPolicy
+	// how many times the event being handled when the worker panicked is
+	// delivered again after the restart, zero means it's dropped
+	// once exhausted the event is dropped and reported with ErrPoisonEvent
+	// it also bounds the redeliveries of the events given up with ErrInterrupted
+	Redeliver int
+	// what to do when HandleEvent returns an error, default is Drop
+	ErrorMode ErrorMode
+	// the number of retries of the RetryN ErrorMode
+	Retries int
+	// how long to wait before the given retry, default doubles from 10ms up to a second
+	RetryBackoff func(attempt int) time.Duration
+	// how to treat the errors Classify can't tell, default is RetryableError
+	Unclassified ErrorClass
+	// the pipeline stops with ErrErrorBudgetExceeded when more than
+	// MaxConsecutiveErrors events in a row fail, zero means no limit
+	MaxConsecutiveErrors int
+	// the most events handled per second by all instances of the stage, zero means no limit
+	RateLimit float64
+	// returns the idempotency key of an event, a consumer doesn't handle an event
+	// whose key has been marked by the KeyStore, the key is marked once HandleEvent succeeded
+	// two instances handling events with the same key at once may both handle them
+	IdempotencyKey func(interface{}) string
+	// remembers the handled keys, default keeps the last DefaultKeyStoreSize keys in memory for the run
+	KeyStore KeyStore
+	// what to do with an event when the KeyStore fails, default is FailClosed
+	KeyStoreFailure KeyStoreFailure
+	// the pipelines the side outputs of a SideOutputWorker are pushed to, by label,
+	// each is a separate GoStage that must be running while the stage runs,
+	// the side outputs pushed to it while it isn't are dropped and counted in StageStats.SideDropped
+	SideOutputs map[string]*GoStage
 }
 
 type linkedWorker struct {
 	*Config
-	in  chan interface{}
-	out chan interface{}
+	in    chan *envelope
+	out   chan *envelope
+	stats *stageStats
+	// feeds in from Config.Queue, nil if in is a plain channel
+	queue *queuedEdge
+	// the taps of the stage, see Tap
+	tap *stageTap
+	// the stage is unchanged by a reload, its workers are kept for the next run
+	keep atomic.Bool
+	// toggled by SetStageEnabled
+	disabled atomic.Bool
+	// set by StopStage
+	stopped atomic.Bool
+	// set when the stage exceeds its error budget, it stops without draining
+	overBudget atomic.Bool
+	// the role of the stage in this run, Auto resolved
+	role Role
+	// the bytes buffered in front of the stage
+	bytes *byteBudget
+	// set when a producer returns ErrQuit
+	finished atomic.Bool
+	// the next sequence number of a producer's events
+	seq atomic.Uint64
+	// the sequence numbers received by the terminal stage, nil unless WithSequenceCheck
+	sequence *sequenceTracker
+	// the error budget of the stage
+	consecutiveErrors atomic.Int64
+	errorRate         errorRate
+
+	// protects instances
+	mu        sync.Mutex
+	instances []*instance
+	// closed once the last instance has exited
+	emptied chan struct{}
+	// the number of instances the stage runs, changed by Scale
+	scale int
+	// the reference of a worker shared by the instances, nil unless ShareInstance
+	shared *workerRef
+	// the workers created and initialized before the run, used by its first instances
+	prepared []Worker
+	// the effective config, a copy of Config changed by Apply
+	tuned   atomic.Pointer[Config]
+	limiter rateLimiter
+	// the events handled by each instance, by index
+	processed []*atomic.Int64
+	// wakes the dispatcher up when an instance may take an event, see LeastBusy
+	kick        chan struct{}
+	dispatching bool
+	// the events handed to each instance of a Partitioned stage, by index
+	partitions []int64
+	// the instances the partitions of a Partitioned stage are mapped to, and the
+	// changes of them whose moved partitions wait for the old instances
+	layout  []*instance
+	changes []layoutChange
+	// set once the upstream has stopped and closed in
+	upstreamDone atomic.Bool
+	// the KeyStore of the run unless Config.KeyStore is set
+	keys KeyStore
+	// closes the StopToken of the stage
+	interrupter *interrupter
+	// set if a Warmup of the stage failed or didn't return in time
+	cold atomic.Bool
+	// the events interrupted in the previous run, handled before the input
+	carried    []*envelope
+	hasCarried atomic.Bool
+}
+
+// size returns the number of instances the stage starts with
+func (c *Config) size() int {
+	size := DefaultSize
+	if c.Concurrency > 0 {
+		size = c.Concurrency
+	} else if c.Size > 0 {
+		size = c.Size
+	}
+	if a := c.AutoScale; a != nil {
+		if size < a.Min {
+			size = a.Min
+		}
+		if size > a.Max {
+			size = a.Max
+		}
+	}
+	return size
+}
+
+// maxRestarts returns how many times a crashed instance can be restarted
+func (lw *linkedWorker) maxRestarts() int {
+	if lw.DisableRestart {
+		return 0
+	}
+	if lw.Restart > 0 {
+		return lw.Restart
+	}
+	return DefaultRestart
+}
+
+// the window of StageStats.RecentRestarts if RestartWindow isn't set
+const defaultRestartWindow = time.Minute
+
+func (lw *linkedWorker) restartWindow() time.Duration {
+	if lw.RestartWindow > 0 {
+		return lw.RestartWindow
+	}
+	return defaultRestartWindow
+}
+
+func (lw *linkedWorker) maxBufferedBytes(s *GoStage) int64 {
+	if lw.MaxBufferedBytes > 0 {
+		return lw.MaxBufferedBytes
+	}
+	return s.maxBufferedBytes
+}
+
+func (lw *linkedWorker) maxEventAge(s *GoStage) time.Duration {
+	if lw.MaxEventAge > 0 {
+		return lw.MaxEventAge
+	}
+	return s.maxEventAge
 }
 
 // GoStage provides a simple way to run a data pipeline, just like unix pipeline.
 type GoStage struct {
-	ctx           context.Context
-	logger        Logger
+	// protects the per run state below
+	mu    sync.Mutex
+	state atomic.Int32
+
+	ctx context.Context
+	// the name given to WithName, and the one of the last run, unique among the running pipelines
+	askedName string
+	name      atomic.Pointer[string]
+	// whether name is held, see pipelineNames
+	nameHeld      bool
+	logger        *safeLogger
 	configs       []*Config
 	linkedWorkers []*linkedWorker
 	errChan       chan error
 	quitChan      chan error
-	// close goroutines one by one
-	stopChan []chan chan struct{}
+	// the first producers stages of linkedWorkers are the roots
+	// they all feed the stage right after them
+	producers int
+	// closed by Stop
+	stopRequest chan struct{}
+	// ends the run once, see finish
+	finishing *sync.Once
+	// closed once all stages are running, see Ready
+	ready chan struct{}
+	// closed when the pipeline starts stopping, ends the autoscalers and the maxHeld of Delay stages
+	scaling chan struct{}
+	// why the last run stopped
+	reason error
+	// the fatal error of the last run, see Err
+	fatal       error
+	exitOnFatal bool
+	exitCode    int
+	// log the steps of the start and the stop, see WithLifecycleLogging
+	lifecycleLogging bool
+	// see WarmupWorker
+	warmupTimeout time.Duration
+	fatalWarmup   bool
+	// see WithNoConsumerTimeout
+	noConsumerTimeout time.Duration
+
+	unknownSideOutput UnknownSideOutput
+	// collapses the identical errors of the stages in the log, nil if off
+	errorLogWindow time.Duration
+	errorLogBurst  int
+	errorThrottle  *errorThrottle
+	// the panics of the callbacks in the last run, see Err
+	panicsMu sync.Mutex
+	panics   []error
 
 	noDataCount      int
 	noDataCountSleep time.Duration
+	maxEventAge      time.Duration
+	maxEvents        int64
+	maxRuntime       time.Duration
+	maxErrorRatio    float64
+	errorWindow      time.Duration
+	maxResults       int
+	quitPolicy       QuitPolicy
+	sequenceWindow   uint64
+	// true if some stage needs to know when events were produced
+	timestamps       bool
+	maxBufferedBytes int64
+	queueSampling    time.Duration
+	stopMode         StopMode
+	codec            Codec
+	receiveBatch     int
+
+	clock   Clock
+	onError func(*StageError)
+	onIdle  func()
+
+	// what happens to the terminal stage's outputs, see WithOutput
+	disposition OutputDisposition
+	bridge      *GoStage
+	// the disposition of the current run, ToCollector for Collect
+	terminal OutputDisposition
+	// gathers the terminal stage's outputs for Collect and ToCollector
+	collector *collector
+	// the terminal stage's outputs with ToOutputChannel
+	output chan interface{}
+	// the outputs the output channel or the bridge didn't take
+	lostOutputs atomic.Int64
+	// the subscriptions of OnStageError and OnAnyError
+	errorSubs errorSubscribers
+	// the taps and replay buffers of the stages, kept across runs
+	taps stageTaps
+	// set by WithMetadata, headers has the keys prefixed by HeaderPrefix
+	metadata map[string]string
+	headers  map[string]string
+	// the edges replaced by ReplaceWithChannels for the next run, by the stage they feed
+	bridges map[string]*bridge
+	// the interrupted events to deliver again in the next run, by stage
+	carriedMu sync.Mutex
+	carried   map[string][]carriedEvent
+
+	observer      Observer
+	notifications chan func()
+	notifierDone  chan struct{}
+	// the notifications dropped because the queue was full
+	droppedNotifications atomic.Int64
+
+	// the number of events emitted by the producer in this run
+	produced atomic.Int64
+	// the extra copies made by the Broadcast stages in this run
+	copies atomic.Int64
+	// the last ID given to an event, see EventIDFromContext
+	eventIDs atomic.Uint64
+	// makes the IDs seen by the workers, the numbers of eventIDs if nil
+	idGen IDGenerator
+	// the events given an ID, see CancelEvent
+	events eventIndex
+	// the pushes in progress hold a read lock, see Push
+	pushMu sync.RWMutex
+	// cancelled when the pipeline starts stopping, ends the pushes
+	pushing     context.Context
+	stopPushing context.CancelFunc
+	// the events dropped from the batches of BestEffort stages
+	droppedBestEffort atomic.Int64
+	// nil unless WithAuditLog
+	audit *auditLog
+	// set by WithDebugSampling
+	debugSampling float64
+	sampler       *sampler
+	// true if any stage samples events
+	sampling atomic.Bool
+	// set by WithForwardNil
+	forwardNil bool
+	// set by WithAllowSharedWorker
+	allowSharedWorker bool
+	// set by WithMemoryLimit and WithMemoryGauge
+	memory      *memoryWatchdog
+	reloader    *reloader
+	memoryGauge func() uint64
+	// set by WithRecording, the recorder is nil unless a run is recorded
+	recordingPath string
+	recorder      *recorder
+	// set by WithDeadLetterFile, the file is nil outside of a run
+	deadLetterPath string
+	deadLetters    *deadLetterFile
+	// the number of events produced but not consumed yet
+	inflight     atomic.Int64
+	producerDone atomic.Bool
+	idle         chan struct{}
+	idleOnce     *sync.Once
+
+	// the number of events which left the pipeline, by fate
+	settled [fates]atomic.Int64
+	// the accounting of the last run, set once its workers have stopped
+	accounting Accounting
+	// when the current run started and its Report, set once its workers have stopped
+	startedAt time.Time
+	report    Report
+	// set by WithTiming
+	timing bool
+
+	// helper goroutines of the current run
+	bg sync.WaitGroup
+
+	// the workers shared by several instances, keyed by pointer
+	refsMu sync.Mutex
+	refs   map[uintptr]*workerRef
 }
 
 type Option func(gs *GoStage)
@@ -114,119 +553,616 @@ func WithNoDataCountSleep(n time.Duration) func(*GoStage) {
 	}
 }
 
+// WithForwardNil passes on the nil events returned by the workers, by default
+// a nil event with a nil error is handled as ErrNoData, except from the terminal stage
+func WithForwardNil() Option {
+	return func(gs *GoStage) {
+		gs.forwardNil = true
+	}
+}
+
+// WithMaxEventAge drops events older than d in every stage
+// which doesn't set its own Config.MaxEventAge
+func WithMaxEventAge(d time.Duration) Option {
+	return func(gs *GoStage) {
+		gs.maxEventAge = d
+	}
+}
+
+// WithMaxEvents stops the pipeline once the producer has emitted n events
+// the pipeline is drained as if the producer returned ErrQuit
+func WithMaxEvents(n int64) Option {
+	return func(gs *GoStage) {
+		gs.maxEvents = n
+	}
+}
+
+// WithMaxRuntime stops the pipeline gracefully after d
+func WithMaxRuntime(d time.Duration) Option {
+	return func(gs *GoStage) {
+		gs.maxRuntime = d
+	}
+}
+
 // New creates a new GoStage
 func New(ctx context.Context, configs []*Config, logger Logger, opts ...Option) *GoStage {
 	gs := &GoStage{
-		ctx:           ctx,
-		logger:        logger,
-		configs:       configs,
-		errChan:       make(chan error),
-		quitChan:      make(chan error),
-		stopChan:      []chan chan struct{}{},
-		linkedWorkers: make([]*linkedWorker, 0, len(configs)),
+		ctx:      ctx,
+		configs:  configs,
+		idle:     make(chan struct{}),
+		idleOnce: &sync.Once{},
 	}
 
 	// set default value
 	gs.noDataCount = NoDataCount
 	gs.noDataCountSleep = NoDataCountSleep
+	gs.clock = realClock{}
+	gs.lifecycleLogging = true
+	gs.noConsumerTimeout = DefaultNoConsumerTimeout
+	gs.errorLogWindow, gs.errorLogBurst = DefaultErrorLogWindow, DefaultErrorLogBurst
+	gs.sampler = &sampler{rand: rand.New(rand.NewSource(time.Now().UnixNano()))}
 
 	for _, opt := range opts {
 		opt(gs)
 	}
+	gs.logger = &safeLogger{s: gs, Logger: logger}
+	gs.register()
+	if gs.errorLogWindow > 0 {
+		gs.errorThrottle = newErrorThrottle(gs.errorLogWindow, gs.errorLogBurst)
+	}
 
 	return gs
 }
 
 // Run blocks the current goroutine
-func (s *GoStage) Run(fn func()) {
-	s.run()
+// it can be called again once the previous run has finished
+func (s *GoStage) Run(fn func()) error {
+	if err := s.run(nil); err != nil {
+		return err
+	}
 
 	stopSignals := make(chan os.Signal, 1)
 	signal.Notify(stopSignals, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(stopSignals)
+
+	reloads, stopWatching := s.watchReload()
+	defer stopWatching()
+	s.serve(stopSignals, reloads, fn)
+	return nil
+}
+
+// RunAsync doesn't block the current goroutine
+func (s *GoStage) RunAsync(fn func()) error {
+	if err := s.run(nil); err != nil {
+		return err
+	}
+
+	// the reload signal is watched before RunAsync returns
+	reloads, stopWatching := s.watchReload()
+	go func() {
+		defer stopWatching()
+		s.serve(nil, reloads, fn)
+	}()
+	return nil
+}
+
+// finish ends the run whatever stopped it, the reason is recorded by then:
+// the workers are stopped and the stats reconciled, then fn is called and the
+// pipeline is marked stopped, only the first call of a run does it
+func (s *GoStage) finish(fn func()) {
+	s.finishing.Do(func() {
+		// a reload which couldn't start any topology has stopped it already
+		if s.State() != StateStopped {
+			s.state.Store(int32(StateStopping))
+			s.ensureAllWorkerStopped()
+		}
+		if fn != nil {
+			s.protect("done callback", fn)
+		}
+		s.state.Store(int32(StateStopped))
+		s.releaseName()
+	})
+	s.exitIfFatal()
+}
+
+// wait blocks until the pipeline should stop and records the reason
+// if the producer quits or a limit is reached, it waits for the produced events to be drained
+// returns true if a reload is asked on reloads instead
+func (s *GoStage) wait(signals chan os.Signal, reloads <-chan struct{}) bool {
+	var deadline <-chan time.Time
+	if s.maxRuntime > 0 {
+		deadline = s.clock.After(s.maxRuntime)
+	}
 
 	select {
 	case <-s.ctx.Done():
-	case <-stopSignals:
+		s.setReason(s.ctx.Err())
+	case sig := <-signals:
+		s.setReason(fmt.Errorf("%w: %v", ErrSignal, sig))
+	case <-s.stopRequest:
+		s.setReason(ErrStopped)
+	case <-deadline:
+		s.logger.Info("gostage reached max runtime: %v", s.maxRuntime)
+		s.setReason(ErrMaxRuntime)
+		s.drain(signals)
 	case err := <-s.quitChan:
+		// a fatal error which happened before the quit wins
+		select {
+		case fatal := <-s.errChan:
+			s.failed(fatal)
+			return false
+		default:
+		}
 		s.logger.Error("gostage quit: %+v", err)
+		s.setReason(err)
+		s.drain(signals)
 	case err := <-s.errChan:
-		s.logger.Fatal("gostage fatal error happened: %+v", err)
+		s.failed(err)
+	case <-reloads:
+		return true
 	}
+	return false
+}
 
-	s.ensureAllWorkerStopped()
-	fn()
+// drain stops the producer and waits until the produced events have left the pipeline
+func (s *GoStage) drain(signals chan os.Signal) {
+	s.lifecycle(DrainStarted, "", "drain started: %d events in flight", s.inflight.Load())
+	s.finishProducer()
+	select {
+	case <-s.idle:
+	case <-s.ctx.Done():
+	case <-signals:
+	case <-s.stopRequest:
+	case err := <-s.errChan:
+		// the events in flight won't all get through, the failure is why the run stopped
+		s.replaceReason(err)
+		s.failed(err)
+	}
+	s.lifecycle(DrainFinished, "", "drain finished: %d events in flight", s.inflight.Load())
 }
 
-// RunAsync doesn't block the current goroutine
-func (s *GoStage) RunAsync(fn func()) {
-	s.run()
+// ensureAllWorkerStopped stops the stages in order from the producers to the terminal stage
+// once all instances of a stage have exited its out channel is closed, the next stage
+// sees the end of its input after the events left in it
+func (s *GoStage) ensureAllWorkerStopped() {
+	s.lifecycle(PipelineStopping, "", "stopping: %v", s.Reason())
+	close(s.scaling)
+	s.stopPushes()
+	// a StartStage which has seen the pipeline running is done once the lock is free
+	s.mu.Lock()
+	s.mu.Unlock()
 
-	go func() {
-		select {
-		case <-s.ctx.Done():
-		case err := <-s.quitChan:
-			s.logger.Error("gostage quit: %+v", err)
-		case err := <-s.errChan:
-			s.logger.Fatal("gostage fatal error happened: %+v", err)
+	if s.stopMode == StopImmediate {
+		// don't let the downstream stages handle more events while waiting for the upstream
+		for _, lw := range s.linkedWorkers {
+			lw.killAll()
 		}
-
-		s.ensureAllWorkerStopped()
-		fn()
-	}()
+	}
+	for i := 0; i < len(s.linkedWorkers); {
+		// the producers share their out channel and stop together
+		end := i + 1
+		if i < s.producers {
+			end = s.producers
+		}
+		s.stopStages(i, end)
+		for _, lw := range s.linkedWorkers[i:end] {
+			s.lifecycle(StageStopped, lw.Name, "stage %s stopped: %d events processed", lw.Name, lw.processedCount())
+		}
+		for _, lw := range s.linkedWorkers[i:end] {
+			if lw.queue != nil {
+				lw.queue.stop()
+			}
+		}
+		if s.linkedWorkers[i].out != nil {
+			s.closeInput(i)
+			if end < len(s.linkedWorkers) {
+				s.linkedWorkers[end].upstreamDone.Store(true)
+			}
+		}
+		i = end
+	}
+	s.bg.Wait()
+	if s.audit != nil {
+		s.audit.stop()
+	}
+	s.stopRecording()
+	s.closeDeadLetters()
+	s.stopOutput()
+	s.flushErrorLog()
+	s.errorSubs.stop()
+	s.reconcile()
+	s.stopNotifier()
 }
 
-func (s *GoStage) ensureAllWorkerStopped() {
-	confirm := 0
-	for _, stop := range s.stopChan {
-		done := make(chan struct{})
-		stop <- done
-		for range done {
-			confirm++
-			if confirm == len(s.stopChan) {
-				return
+// stopStages halts the instances of the stages from..to-1 and waits for them
+// their sends are given up if a stage downstream has no instance left,
+// the events could never get through it
+func (s *GoStage) stopStages(from, to int) {
+	var stopping []*instance
+	for k := from; k < to; k++ {
+		if s.stopModeOf(s.linkedWorkers[k]) == StopImmediate {
+			s.linkedWorkers[k].interrupter.interrupt()
+		}
+		stopping = append(stopping, s.linkedWorkers[k].haltAll()...)
+	}
+	stopped := make(chan struct{})
+	defer close(stopped)
+	gone := s.downstreamGone(to, stopped)
+	for _, inst := range stopping {
+		select {
+		case <-inst.done:
+		case <-gone:
+			for _, other := range stopping {
+				other.kill()
 			}
+			<-inst.done
 		}
+		inst.sup.Stop()
+	}
+}
+
+// downstreamGone returns a channel closed once any stage from i on has no instance left
+// it isn't watched anymore after stop is closed
+func (s *GoStage) downstreamGone(i int, stop <-chan struct{}) <-chan struct{} {
+	gone := make(chan struct{})
+	var once sync.Once
+	for ; i < len(s.linkedWorkers); i++ {
+		go func(emptied <-chan struct{}) {
+			select {
+			case <-emptied:
+				once.Do(func() { close(gone) })
+			case <-stop:
+			}
+		}(s.linkedWorkers[i].gone())
 	}
+	return gone
 }
 
-func (s *GoStage) run() {
+// run resets the per run state and starts all workers
+// the pipeline is left stopped if the configs are invalid
+// c gathers the outputs of the terminal stage whatever WithOutput, it's nil unless called by Collect
+func (s *GoStage) run(c *collector) (err error) {
+	if !s.transit(StateStarting, StateIdle, StateStopped) {
+		return fmt.Errorf("%w: pipeline is %s", ErrAlreadyRunning, s.State())
+	}
+	s.claimName()
+	defer func() {
+		if err != nil {
+			s.releaseName()
+		}
+	}()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.validate(); err != nil {
+		s.reason = err
+		s.state.Store(int32(StateStopped))
+		return err
+	}
+	s.linkedWorkers = make([]*linkedWorker, 0, len(s.configs))
 	s.buildLinkedWorkers()
+	err = s.prepare()
+	s.closeUnused()
+	if err != nil {
+		s.reason = err
+		s.state.Store(int32(StateStopped))
+		return err
+	}
+	if err := s.startRecording(); err != nil {
+		s.closePrepared(s.preparedWorkers())
+		s.reason = err
+		s.state.Store(int32(StateStopped))
+		return err
+	}
+	if err := s.openDeadLetters(); err != nil {
+		s.stopRecording()
+		s.closePrepared(s.preparedWorkers())
+		s.reason = err
+		s.state.Store(int32(StateStopped))
+		return err
+	}
+
+	s.stopRequest = make(chan struct{})
+	s.finishing = new(sync.Once)
+	s.ready = make(chan struct{})
+	s.scaling = make(chan struct{})
+	s.pushing, s.stopPushing = context.WithCancel(context.Background())
+	// the first supervision failure stops the pipeline, later ones are ignored
+	s.errChan = make(chan error, 1)
+	s.quitChan = make(chan error)
+	// the stages wait for the warmups, Ready isn't closed until they're running
+	if err := s.warmup(); err != nil {
+		s.stopPushing()
+		s.stopRecording()
+		s.closeDeadLetters()
+		s.closePrepared(s.preparedWorkers())
+		s.reason = err
+		s.state.Store(int32(StateStopped))
+		return err
+	}
+	s.reason = nil
+	s.fatal = nil
+	s.panicsMu.Lock()
+	s.panics = nil
+	s.panicsMu.Unlock()
+	s.startOutput(c)
+	s.timestamps = s.maxEventAge > 0
+	s.sampling.Store(false)
+	for _, config := range s.configs {
+		if config.MaxEventAge > 0 {
+			s.timestamps = true
+		}
+		if config.DebugSampling > 0 || config.DebugSampling == 0 && s.debugSampling > 0 {
+			s.sampling.Store(true)
+		}
+	}
+	s.startedAt = s.clock.Now()
+	s.produced.Store(0)
+	s.copies.Store(0)
+	s.droppedBestEffort.Store(0)
+	s.events.reset()
+	s.resetIdle()
+	s.startNotifier()
+	s.errorSubs.start(s)
+	if s.audit != nil {
+		s.audit.start()
+	}
+
+	s.lifecycle(PipelineStarting, "", "starting: %d stages: %s", len(s.linkedWorkers), s.describeStages())
+	s.carryOver()
 	s.setupChannels()
 	s.startWorkers()
+	s.lifecycle(ProducersStarted, "", "producers started: %s", s.producerNames())
+
+	s.state.Store(int32(StateRunning))
+	s.startAutoScalers()
+	s.startMemoryWatchdog()
+	s.startQueueSampler()
+	return nil
 }
 
+// startWorkers starts the stages from the terminal stage to the producers
+// so that every stage is running before anything is sent to it
 func (s *GoStage) startWorkers() {
-	for i := 0; i < len(s.linkedWorkers); i++ {
-		size := DefaultSize
-		if s.linkedWorkers[i].Size > 0 {
-			size = s.linkedWorkers[i].Size
+	for i := len(s.linkedWorkers) - 1; i >= 0; i-- {
+		// the workers have been prepared, instances can't fail to be created
+		_ = s.startStage(i)
+	}
+	close(s.ready)
+}
+
+// Ready returns a channel closed once all stages of the current run are running
+func (s *GoStage) Ready() <-chan struct{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.ready
+}
+
+// startStage creates and starts all instances of stage i
+// and waits until they're running
+// returns the error of the first instance which couldn't be created
+func (s *GoStage) startStage(i int) error {
+	lw := s.linkedWorkers[i]
+	running, err := s.createInstances(i)
+	for _, inst := range running {
+		<-inst.started
+	}
+	if len(running) > 0 {
+		if lw.dispatched() {
+			s.startDispatcher(lw)
 		}
+		s.lifecycle(StageStarted, lw.Name, "stage %s started: %d instances", lw.Name, len(running))
+		s.stageStarted(lw)
+	}
+	return err
+}
+
+// createInstances creates and starts all instances of stage i,
+// it stops at the first instance which couldn't be created
+// returns the instances which have been given a goroutine
+func (s *GoStage) createInstances(i int) ([]*instance, error) {
+	lw := s.linkedWorkers[i]
 
-		for n := 0; n < size; n++ {
+	size := lw.size()
 
-			i, n := i, n
-			w := s.linkedWorkers[i].Worker
+	lw.mu.Lock()
+	defer lw.mu.Unlock()
+
+	lw.emptied = make(chan struct{})
+	lw.scale = size
+	var pool *stagePool
+	if lw.Pool && lw.role != Source {
+		pool = newStagePool(s, i)
+	}
+	// the instances sharing a worker which isn't a pointer
+	lw.shared = nil
+	if lw.ShareInstance {
+		lw.shared = &workerRef{}
+	}
 
-			if n != 0 {
-				w = s.callWorkerCreate(w)
+	defer func() { lw.prepared = nil }()
+	var running []*instance
+	for n := 0; n < size; n++ {
+		inst, err := s.addInstance(i, n, size)
+		if err != nil {
+			lw.scale = n
+			if n == 0 {
+				close(lw.emptied)
+			}
+			return running, err
+		}
+		if pool != nil {
+			// the supervisor only keeps the restart budget, the pool runs the instance
+			inst.pool = pool
+			if n == 0 {
+				pool.start(inst)
+				running = append(running, inst)
+			} else {
+				pool.parked = append(pool.parked, inst)
+				pool.parkedCount.Add(1)
 			}
+			continue
+		}
+		s.startInstance(inst)
+		running = append(running, inst)
+	}
+	return running, nil
+}
 
-			stop := make(chan chan struct{})
-			s.stopChan = append(s.stopChan, stop)
+// addInstance creates instance n of the total instances of stage i, lw.mu must be held
+// the worker made by prepare is used if there's one, otherwise it's created and initialized
+func (s *GoStage) addInstance(i, n, total int) (*instance, error) {
+	lw := s.linkedWorkers[i]
+	w := lw.Worker
+	prepared := n < len(lw.prepared)
+	if prepared {
+		w = lw.prepared[n]
+	} else if n != 0 && !lw.ShareInstance {
+		created, err := s.create(lw, n, total)
+		if err != nil {
+			return nil, err
+		}
+		w = created
+	}
+	owner := n == 0 || !lw.ShareInstance
+	if ia, ok := w.(InstanceAware); ok && owner && !prepared {
+		ia.SetInstanceInfo(lw.Name, n, total)
+	}
+	if owner && !prepared {
+		setStopToken(lw, w)
+	}
 
-			restart := DefaultRestart
-			if s.linkedWorkers[i].Restart > 0 {
-				restart = s.linkedWorkers[i].Restart
+	ref := s.acquire(w)
+	if ref == nil && lw.shared != nil {
+		ref = s.retain(lw.shared)
+	}
+	// a worker already run by another instance has been initialized
+	if owner && !prepared && (ref == nil || s.count(ref) == 1) {
+		if err := initWorker(lw, n, w); err != nil {
+			if s.release(ref) {
+				s.callWorkerClose(w)
 			}
+			return nil, err
+		}
+	}
+
+	inst := newInstance(lw, w, n)
+	for len(lw.processed) <= n {
+		lw.processed = append(lw.processed, &atomic.Int64{})
+	}
+	inst.processed = lw.processed[n]
+	if lw.dispatched() {
+		inst.inbox = make(chan *envelope, lw.inboxSize())
+		lw.wake()
+	}
+	if cw, ok := w.(ContextWorker); ok {
+		inst.cw, inst.ctx = cw, s.instanceContext(lw, n)
+	} else if sw, ok := w.(SideOutputWorker); ok {
+		inst.sw, inst.emitSide = sw, s.sideEmitter(inst)
+	}
+	inst.ref = ref
+	lw.instances = append(lw.instances, inst)
+
+	work := func(context.Context) {
+		s.runWorker(inst, i)
+	}
+	inst.sup = NewSupervisor(work,
+		WithSupervisorName(fmt.Sprintf("%s_#%d", lw.Name, n)),
+		WithMaxRestarts(lw.maxRestarts()),
+		WithRestartWindow(lw.RestartWindow),
+		WithRestartBackoff(lw.RestartBackoff),
+		WithOnRestart(func(info RestartInfo) {
+			s.restarted(lw, n, info)
+		}),
+		WithSupervisorLogger(s.logger),
+		withCurrentInput(inst.input),
+		withInterrupt(inst.stop),
+		withClock(s.clock),
+	)
+	return inst, nil
+}
+
+// startInstance runs inst under its supervisor
+func (s *GoStage) startInstance(inst *instance) {
+	// workers are stopped by the pipeline, not by the supervisor's context
+	inst.sup.Start(context.Background())
+	go s.watch(inst, s.errChan)
+}
+
+func (s *GoStage) reachedMaxEvents() bool {
+	return s.maxEvents > 0 && s.produced.Load() >= s.maxEvents
+}
 
-			s.errChan = Supervise((func() {
-				s.runWorker(w, stop, i, n)
-			}), restart, s.logger)
+// admit counts a produced event, returns false if it exceeds WithMaxEvents
+func (s *GoStage) admit() bool {
+	n := s.produced.Add(1)
+	if s.maxEvents > 0 && n > s.maxEvents {
+		s.produced.Add(-1)
+		return false
+	}
+	return true
+}
+
+// watch passes the supervisor's error to the pipeline
+// and cleans up the instance which won't be restarted anymore
+func (s *GoStage) watch(inst *instance, to chan error) {
+	<-inst.sup.Done()
+	if err := inst.sup.Err(); err != nil {
+		s.abandon(inst, err, to)
+	}
+}
+
+// abandon drops the events held by an instance which won't be restarted anymore,
+// cleans it up and passes err to the pipeline
+func (s *GoStage) abandon(inst *instance, err error, to chan error) {
+	if env := inst.current; env != nil {
+		inst.current = nil
+		s.leave(lostInFlight)
+		env.free()
+	}
+	s.dropBatch(inst)
+	s.exit(inst)
+	select {
+	case to <- err:
+	default:
+	}
+}
+
+// canCreate returns true if more instances of w can be created
+func canCreate(w Worker) bool {
+	if _, ok := w.(CreatorWithInfo); ok {
+		return true
+	}
+	m, ok := reflect.TypeOf(w).MethodByName("Create")
+	if !ok {
+		return false
+	}
+	// the receiver is the first input
+	return m.Type.NumIn() == 1 && m.Type.NumOut() == 1 && m.Type.Out(0).Implements(reflect.TypeOf((*Worker)(nil)).Elem())
+}
+
+// validateCreators checks that the workers of the stages with several instances can be created
+func (s *GoStage) validateCreators() error {
+	for _, config := range s.configs {
+		if config.Concurrency > 0 && !config.ShareInstance {
+			return fmt.Errorf("%w: %s sets Concurrency without ShareInstance", ErrInvalidConcurrency, config.Name)
+		}
+		if config.Concurrency > 0 && config.Size > 1 {
+			return fmt.Errorf("%w: %s sets both Concurrency and Size", ErrInvalidConcurrency, config.Name)
+		}
+		if config.size() > 1 && !config.ShareInstance && !canCreate(config.Worker) {
+			return fmt.Errorf("%w: %s has Size %d, add a Create method or set ShareInstance", ErrMissingCreate, config.Name, config.size())
 		}
 	}
+	return nil
 }
 
-func (s *GoStage) callWorkerCreate(w Worker) Worker {
+func (s *GoStage) callWorkerCreate(w Worker, name string, n, size int) Worker {
+	if c, ok := w.(CreatorWithInfo); ok {
+		return c.CreateWithInfo(name, n, size)
+	}
+
 	v := reflect.ValueOf(w)
 
 	if !v.MethodByName("Create").IsValid() {
@@ -248,84 +1184,399 @@ func (s *GoStage) callWorkerClose(w Worker) {
 	}
 }
 
-func (s *GoStage) runWorker(w Worker, stop chan chan struct{}, i, n int) {
+func (s *GoStage) runWorker(inst *instance, i int) {
+	inst.start()
+	if s.linkedWorkers[i].role != Source {
+		switch w := inst.w.(type) {
+		case *Delayer:
+			s.runDelay(inst, w, i)
+			return
+		case *Joiner:
+			s.runJoin(inst, w, i)
+			return
+		}
+	}
 	var errNoDataCount int
-	if i == 0 {
+	n := inst.n
+
+	// restarted after a panic, the event being handled may be delivered again
+	pending := s.redeliver(inst)
+	if s.linkedWorkers[i].role == Source {
 		for {
 			select {
-			case done := <-stop:
-				s.callWorkerClose(w)
-				done <- struct{}{}
-				close(done)
+			case <-inst.stop:
+				s.exit(inst)
 				return
 			default:
-				output, err := w.HandleEvent(nil)
+				// another instance of the stage has returned ErrQuit, produce nothing more
+				if s.linkedWorkers[i].finished.Load() {
+					<-inst.stop
+					s.exit(inst)
+					return
+				}
+				// a disabled producer behaves as if it has no data
+				var output interface{}
+				var values context.Context
+				var partition int
+				var hinted bool
+				var id uint64
+				var start time.Time
+				if s.audit != nil {
+					start = s.clock.Now()
+				}
+				err := ErrNoData
+				if s.reachedMaxEvents() {
+					err = ErrMaxEvents
+				} else if !s.linkedWorkers[i].disabled.Load() {
+					if !s.memory.pause(inst.stop) {
+						continue
+					}
+					if !s.linkedWorkers[i].limiter.wait(s.clock, s.linkedWorkers[i].current().RateLimit, inst.stop) {
+						continue
+					}
+					if inst.cw != nil {
+						id = s.newEventID()
+					}
+					output, err = s.callHandleEvent(inst, id, nil, s.headers, nil)
+					output, values, partition, hinted = unwrapEvent(output)
+					if err == nil && output == nil && !s.forwardNil {
+						s.linkedWorkers[i].stats.skipped.Add(1)
+						err = ErrNoData
+					}
+					if id != 0 && (err != nil || s.events.cancelled(id)) {
+						// the event isn't emitted
+						s.events.forget(id)
+						if err == nil {
+							s.linkedWorkers[i].stats.cancelled.Add(1)
+							continue
+						}
+					}
+				}
+				if err == nil && !s.admit() {
+					err = ErrMaxEvents
+				}
 				if err != nil {
-					if err == ErrNoData {
+					if d, ok := noDataHint(err); ok {
+						// the producer knows when it has data again
+						errNoDataCount = 0
+						select {
+						case <-s.clock.After(d):
+						case <-inst.stop:
+						}
+					} else if err == ErrNoData {
 						errNoDataCount++
 						if errNoDataCount >= s.noDataCount {
-							time.Sleep(s.noDataCountSleep)
 							errNoDataCount = 0
+							// the stop isn't kept waiting for the sleep
+							select {
+							case <-s.clock.After(s.noDataCountSleep):
+							case <-inst.stop:
+							}
 						}
-					} else if err == ErrQuit {
-						s.quitChan <- err
-						select {
-						case done := <-stop:
-							s.callWorkerClose(w)
-							done <- struct{}{}
-							close(done)
-							return
+					} else if err == ErrQuit || err == ErrMaxEvents {
+						if err == ErrQuit {
+							err = s.rootQuit(s.linkedWorkers[i])
 						}
+						if err != nil {
+							select {
+							case s.quitChan <- err:
+							case <-inst.stop:
+							}
+						}
+						<-inst.stop
+						s.exit(inst)
+						return
+					} else if errors.Is(err, ErrInterrupted) && ShouldStop(s.linkedWorkers[i].interrupter.token()) {
+						// the producer gave up its event, nothing was produced
+						s.linkedWorkers[i].stats.interrupted.Add(1)
 					} else {
-						s.logger.Error("%s_#%d error: %+v", s.linkedWorkers[i].Name, n, err)
+						s.linkedWorkers[i].stats.errors.Add(1)
+						s.logWorkerError(s.linkedWorkers[i], err, "%s_#%d error: %+v", s.linkedWorkers[i].Name, n, err)
+						s.reportError(s.linkedWorkers[i], n, nil, err)
+						s.recordOutcome(s.linkedWorkers[i], err)
 					}
 				} else {
-					s.linkedWorkers[i].out <- output
+					inst.processed.Add(1)
+					s.recordOutcome(s.linkedWorkers[i], nil)
+					s.enter()
+					env := s.newEnvelope(output)
+					env.values = values
+					env.partition, env.partitioned = partition, hinted
+					if id != 0 {
+						env.setID(&s.events, id)
+					}
+					s.sample(env)
+					if s.recorder != nil {
+						s.record(s.linkedWorkers[i], env)
+					}
+					if s.audit != nil {
+						s.audit.record(s.linkedWorkers[i], n, s.eventName(s.eventID(env)), "", false, AuditOK, start, s.clock.Now())
+					}
+					if s.sampled(s.linkedWorkers[i], env) {
+						s.logSample(inst, env, nil, output, nil)
+					}
+					s.linkedWorkers[i].stats.out.Add(1)
+					s.linkedWorkers[i].tapped(output)
+					s.send(i, inst, s.stamp(i, env))
 				}
 			}
 		}
-	} else if i == len(s.linkedWorkers)-1 {
+	} else {
 		for {
-			select {
-			case done := <-stop:
-				s.callWorkerClose(w)
-				done <- struct{}{}
-				close(done)
-				return
-			case input := <-s.linkedWorkers[i].in:
-				_, err := w.HandleEvent(input)
-				if err != nil {
-					s.logger.Error("%s_#%d error: %+v, input = %+v", s.linkedWorkers[i].Name, n, err, input)
+			env := pending
+			pending = nil
+			if env == nil {
+				env = s.linkedWorkers[i].takeCarried()
+			}
+			if env == nil {
+				var stopped, parked bool
+				env, stopped, parked = s.receive(inst, s.inputOf(inst))
+				if parked {
+					return
 				}
+				if stopped {
+					s.exit(inst)
+					return
+				}
+				s.linkedWorkers[i].bytes.release(env.size)
+				s.linkedWorkers[i].stats.in.Add(1)
+			}
+
+			inst.current = env
+			var start, since time.Time
+			var id, parent string
+			var injected bool
+			if inst.inbox != nil {
+				since = s.clock.Now()
+			}
+			if s.audit != nil {
+				// env may be freed by handle
+				start, id, parent, injected = s.clock.Now(), s.auditID(env), env.parent, env.injected
+			}
+			output, ok, outcome := s.handle(inst, env, i)
+			if s.audit != nil {
+				s.audit.record(s.linkedWorkers[i], n, id, parent, injected, outcome, start, s.clock.Now())
 			}
+			inst.current = nil
+			if !ok {
+				s.finished(inst, since)
+				continue
+			}
+			if s.linkedWorkers[i].role == Sink {
+				s.linkedWorkers[i].stats.out.Add(1)
+				s.linkedWorkers[i].tapped(output)
+				s.emit(inst, output)
+				s.checkSequence(env)
+				s.leave(completed)
+				env.free()
+				s.finished(inst, since)
+				continue
+			}
+			env.payload = output
+			s.linkedWorkers[i].stats.out.Add(1)
+			s.linkedWorkers[i].tapped(output)
+			s.send(i, inst, env)
+			s.finished(inst, since)
 		}
-	} else {
-		for {
-			select {
-			case done := <-stop:
-				s.callWorkerClose(w)
-				done <- struct{}{}
-				close(done)
-				return
-			case input := <-s.linkedWorkers[i].in:
-				output, err := w.HandleEvent(input)
-				if err != nil {
-					s.logger.Error("%s_#%d error: %+v, input = %+v", s.linkedWorkers[i].Name, n, err, input)
-				}
-				s.linkedWorkers[i].out <- output
+	}
+}
+
+// redeliver returns the event the instance was handling when it panicked
+// if it can be delivered again, otherwise the event is dropped
+func (s *GoStage) redeliver(inst *instance) *envelope {
+	env := inst.current
+	if env == nil {
+		return nil
+	}
+	inst.current = nil
+
+	lw := inst.lw
+	if env.redelivered < lw.Redeliver {
+		env.redelivered++
+		return env
+	}
+	if inst.inbox != nil {
+		inst.load.Add(-1)
+	}
+	if lw.Redeliver > 0 {
+		s.logger.Error("%s_#%d gave up redelivering: %+v", lw.Name, inst.n, env.payload)
+		s.reportError(lw, inst.n, env.payload, ErrPoisonEvent)
+		s.deadLetter(lw, inst.n, env, ErrPoisonEvent, Classify(ErrPoisonEvent))
+		lw.stats.deadLettered.Add(1)
+		s.leave(deadLettered)
+		env.free()
+		return nil
+	}
+	s.leave(lostInFlight)
+	env.free()
+	return nil
+}
+
+// handle calls HandleEvent of a consumer worker, retrying it as set by the ErrorMode
+// returns false if the event was dropped before reaching the worker, while retrying
+// or dead-lettered, it has left the pipeline then, and the outcome for the audit log
+func (s *GoStage) handle(inst *instance, env *envelope, i int) (interface{}, bool, AuditOutcome) {
+	lw, n := s.linkedWorkers[i], inst.n
+	if !s.decode(lw, n, env) {
+		return nil, false, AuditError
+	}
+	if env.expired(s.clock, lw.maxEventAge(s)) {
+		lw.stats.expired.Add(1)
+		lw.stats.deadLettered.Add(1)
+		s.reportError(lw, n, env.payload, ErrExpired)
+		s.leave(deadLettered)
+		env.free()
+		return nil, false, AuditDropped
+	}
+
+	if lw.disabled.Load() {
+		if lw.role == Sink {
+			lw.stats.discarded.Add(1)
+			s.leave(discarded)
+			env.free()
+			return nil, false, AuditDropped
+		}
+		lw.stats.bypassed.Add(1)
+		return env.payload, true, AuditFiltered
+	}
+
+	if lw.When != nil && !lw.When(env.payload) {
+		lw.stats.bypassed.Add(1)
+		return env.payload, true, AuditFiltered
+	}
+	if s.dropCancelled(lw, env) {
+		return nil, false, AuditDropped
+	}
+	key, ok := s.seen(lw, n, env)
+	if !ok {
+		return nil, false, AuditFiltered
+	}
+
+	if !lw.limiter.wait(s.clock, lw.current().RateLimit, inst.abort) {
+		s.leave(lostInFlight)
+		env.free()
+		return nil, false, AuditDropped
+	}
+	inst.processed.Add(1)
+	if env.injected {
+		lw.stats.injected.Add(1)
+	}
+	for attempt := 1; ; attempt++ {
+		var id uint64
+		if inst.cw != nil {
+			id = s.eventID(env)
+		}
+		output, err := s.callHandleEvent(inst, id, env.values, env.headers, env.payload)
+		if err == nil {
+			var values context.Context
+			var partition int
+			var hinted bool
+			if output, values, partition, hinted = unwrapEvent(output); values != nil {
+				env.values = values
+			}
+			if hinted {
+				env.partition, env.partitioned = partition, true
+			}
+		}
+		if s.sampled(lw, env) {
+			s.logSample(inst, env, env.payload, output, err)
+		}
+		if err == nil && output == nil && lw.role != Sink && !s.forwardNil {
+			err = ErrNoData
+		}
+		if s.dropCancelled(lw, env) {
+			return nil, false, AuditDropped
+		}
+		if err != nil && errors.Is(err, ErrInterrupted) && ShouldStop(lw.interrupter.token()) {
+			s.interrupted(inst, env)
+			return nil, false, AuditDropped
+		}
+		if err != nil && noData(err) {
+			lw.stats.skipped.Add(1)
+			s.recordOutcome(lw, nil)
+			s.checkSequence(env)
+			s.leave(skipped)
+			env.free()
+			return nil, false, AuditFiltered
+		}
+		var class ErrorClass
+		if err != nil {
+			class = lw.classify(err)
+			lw.stats.errors.Add(1)
+			if class == PermanentError {
+				lw.stats.permanentErrors.Add(1)
+			} else {
+				lw.stats.retryableErrors.Add(1)
 			}
+			s.logWorkerError(lw, err, "%s_#%d error: %+v, input = %+v", lw.Name, n, err, env.payload)
+			s.reportClassified(lw, n, env.payload, err, class)
+		}
+		s.recordOutcome(lw, err)
+		if err == nil {
+			s.mark(lw, n, env.payload, key)
+			return output, true, AuditOK
 		}
+		tuned := lw.current()
+		if tuned.ErrorMode == Drop || class == PermanentError {
+			s.giveUp(lw, n, env, err, class)
+			return nil, false, AuditError
+		}
+		if tuned.ErrorMode == RetryN && attempt > tuned.Retries {
+			s.reportClassified(lw, n, env.payload, fmt.Errorf("%w after %d retries: %w", ErrRetriesExhausted, tuned.Retries, err), class)
+			s.giveUp(lw, n, env, err, class)
+			return nil, false, AuditError
+		}
+		if !s.waitRetry(inst, attempt) {
+			s.leave(lostInFlight)
+			env.free()
+			return nil, false, AuditDropped
+		}
+		lw.stats.retries.Add(1)
 	}
 }
 
+// giveUp dead-letters an event its worker failed on, it leaves the pipeline
+// there and is never forwarded
+func (s *GoStage) giveUp(lw *linkedWorker, n int, env *envelope, err error, class ErrorClass) {
+	s.deadLetter(lw, n, env, err, class)
+	lw.stats.deadLettered.Add(1)
+	s.checkSequence(env)
+	s.leave(deadLettered)
+	env.free()
+}
+
 func (s *GoStage) buildLinkedWorkers() {
-	for config := s.findRoot(); config != nil; config = s.findNext(config) {
-		s.setWorkerName(config)
-		lw := &linkedWorker{Config: config}
-		s.linkedWorkers = append(s.linkedWorkers, lw)
+	roots := s.findRoots()
+	s.producers = len(roots)
+	for _, config := range roots {
+		s.link(config)
+	}
+	// validate rejects cycles, the cap guards against looping forever anyway
+	for config := s.findFirst(roots); config != nil && len(s.linkedWorkers) < len(s.configs); config = s.findNext(config) {
+		s.link(config)
+	}
+	for i, lw := range s.linkedWorkers {
+		lw.role = s.role(i)
 	}
 }
 
+func (s *GoStage) link(config *Config) {
+	s.setWorkerName(config)
+	lw := &linkedWorker{Config: config, stats: &stageStats{}, kick: make(chan struct{}, 1), interrupter: newInterrupter()}
+	lw.tap = s.taps.of(config.Name)
+	tuned := *config
+	lw.tuned.Store(&tuned)
+	if config.IdempotencyKey != nil && config.KeyStore == nil {
+		lw.keys = NewMemoryKeyStore(DefaultKeyStoreSize)
+	}
+	lw.disabled.Store(config.Disabled)
+	lw.bytes = newByteBudget(lw.maxBufferedBytes(s))
+	if s.sequenceWindow > 0 && len(s.linkedWorkers) < s.producers {
+		lw.sequence = newSequenceTracker(s.sequenceWindow)
+	}
+	s.linkedWorkers = append(s.linkedWorkers, lw)
+}
+
 func (s *GoStage) setWorkerName(c *Config) {
 	if c.Name == "" {
 		c.Name = reflect.ValueOf(c).Elem().FieldByName("Worker").Elem().String()
@@ -334,23 +1585,65 @@ func (s *GoStage) setWorkerName(c *Config) {
 
 func (s *GoStage) setupChannels() {
 	for i := 0; i < len(s.linkedWorkers); i++ {
-		if i == 0 {
-			out := make(chan interface{})
-			s.linkedWorkers[i].out = out
+		if i < s.producers {
+			// the producers share the input of the stage after them
+			if i == 0 {
+				s.linkedWorkers[i].out = s.makeOut(i)
+			} else {
+				s.linkedWorkers[i].out = s.linkedWorkers[0].out
+			}
 		} else if i == len(s.linkedWorkers)-1 {
 			s.linkedWorkers[i].in = s.linkedWorkers[i-1].out
 		} else {
-			out := make(chan interface{})
 			s.linkedWorkers[i].in = s.linkedWorkers[i-1].out
-			s.linkedWorkers[i].out = out
+			s.linkedWorkers[i].out = s.makeOut(i)
+		}
+	}
+}
+
+// makeOut makes the channel between stage i and the next stage
+// sized by the downstream stage's BufferSize, or fed by its Queue or the channels
+// given to ReplaceWithChannels
+func (s *GoStage) makeOut(i int) chan *envelope {
+	next := s.linkedWorkers[s.next(i)]
+	if b := s.bridgeTo(next); b != nil {
+		next.queue = pumpQueue(b)
+		return next.queue.out
+	}
+	if next.Queue != nil {
+		next.queue = s.newQueuedEdge(next)
+		return next.queue.out
+	}
+	return make(chan *envelope, next.BufferSize)
+}
+
+// next returns the index of the stage which stage i sends to
+func (s *GoStage) next(i int) int {
+	if i < s.producers {
+		return s.producers
+	}
+	return i + 1
+}
+
+// findRoots returns the configs which don't subscribe to anything
+func (s *GoStage) findRoots() []*Config {
+	var roots []*Config
+	for _, config := range s.configs {
+		if config.SubscribeTo == nil && config.SubscribeToName == "" {
+			roots = append(roots, config)
 		}
 	}
+	return roots
 }
 
-func (s *GoStage) findRoot() *Config {
+// findFirst returns the stage all the roots feed
+// the first config subscribing to any of the roots
+func (s *GoStage) findFirst(roots []*Config) *Config {
 	for _, config := range s.configs {
-		if config.SubscribeTo == nil {
-			return config
+		for _, root := range roots {
+			if config != root && s.findParent(config) == root {
+				return config
+			}
 		}
 	}
 	return nil
@@ -359,6 +1652,12 @@ func (s *GoStage) findRoot() *Config {
 func (s *GoStage) findNext(config *Config) *Config {
 	wrkVal := reflect.ValueOf(config.Worker)
 	for _, c := range s.configs {
+		if c.SubscribeToName != "" {
+			if c.SubscribeToName == config.Name {
+				return c
+			}
+			continue
+		}
 		subVal := reflect.ValueOf(c.SubscribeTo)
 		if reflect.DeepEqual(subVal, wrkVal) {
 			return c
@@ -0,0 +1,133 @@
+package gostage
+
+import (
+	"container/list"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// ErrKeyStore if the KeyStore of a stage failed
+var ErrKeyStore = errors.New("key store failed")
+
+// DefaultKeyStoreSize is the number of keys remembered by the KeyStore of a stage
+// which sets IdempotencyKey without a KeyStore
+const DefaultKeyStoreSize = 10000
+
+// KeyStore remembers the idempotency keys of the events a stage has handled, see Config.IdempotencyKey
+// implement it on Redis or SQL to remember them across runs and processes, it must be safe for concurrent use
+type KeyStore interface {
+	// Seen returns true if the event of key has been handled
+	Seen(key string) (bool, error)
+	// Mark records that the event of key has been handled
+	Mark(key string) error
+}
+
+// KeyStoreFailure decides what happens to an event when the KeyStore fails
+type KeyStoreFailure int
+
+const (
+	// FailClosed drops the event if the KeyStore can't tell whether it was handled
+	FailClosed KeyStoreFailure = iota
+	// FailOpen handles the event anyway, it may be handled twice
+	FailOpen
+)
+
+// memoryKeyStore keeps the most recent keys in memory
+type memoryKeyStore struct {
+	mu   sync.Mutex
+	size int
+	keys map[string]*list.Element
+	// the most recently marked key first
+	order *list.List
+}
+
+// NewMemoryKeyStore returns a KeyStore remembering the last size keys
+func NewMemoryKeyStore(size int) KeyStore {
+	if size <= 0 {
+		size = DefaultKeyStoreSize
+	}
+	return &memoryKeyStore{size: size, keys: make(map[string]*list.Element), order: list.New()}
+}
+
+func (m *memoryKeyStore) Seen(key string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e, ok := m.keys[key]
+	if ok {
+		m.order.MoveToFront(e)
+	}
+	return ok, nil
+}
+
+func (m *memoryKeyStore) Mark(key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if e, ok := m.keys[key]; ok {
+		m.order.MoveToFront(e)
+		return nil
+	}
+	m.keys[key] = m.order.PushFront(key)
+	if m.order.Len() > m.size {
+		oldest := m.order.Back()
+		m.order.Remove(oldest)
+		delete(m.keys, oldest.Value.(string))
+	}
+	return nil
+}
+
+// keyStore returns the KeyStore of lw, nil unless it sets IdempotencyKey
+func (lw *linkedWorker) keyStore() KeyStore {
+	if lw.IdempotencyKey == nil {
+		return nil
+	}
+	if lw.KeyStore != nil {
+		return lw.KeyStore
+	}
+	return lw.keys
+}
+
+// seen checks the idempotency key of env before lw handles it
+// returns the key to mark once it's handled, and false if env mustn't be handled,
+// it has left the pipeline then
+func (s *GoStage) seen(lw *linkedWorker, n int, env *envelope) (string, bool) {
+	store := lw.keyStore()
+	if store == nil {
+		return "", true
+	}
+	key := lw.IdempotencyKey(env.payload)
+	seen, err := store.Seen(key)
+	if err != nil {
+		err = fmt.Errorf("%w: %s: %w", ErrKeyStore, key, err)
+		s.logger.Error("%s_#%d %v", lw.Name, n, err)
+		if lw.KeyStoreFailure == FailOpen {
+			return key, true
+		}
+		lw.stats.errors.Add(1)
+		lw.stats.deadLettered.Add(1)
+		s.reportError(lw, n, env.payload, err)
+		s.leave(deadLettered)
+		env.free()
+		return "", false
+	}
+	if seen {
+		lw.stats.deduplicated.Add(1)
+		s.checkSequence(env)
+		s.leave(skipped)
+		env.free()
+		return "", false
+	}
+	return key, true
+}
+
+// mark records that lw has handled the event of key
+func (s *GoStage) mark(lw *linkedWorker, n int, input interface{}, key string) {
+	if key == "" {
+		return
+	}
+	if err := lw.keyStore().Mark(key); err != nil {
+		err = fmt.Errorf("%w: %s: %w", ErrKeyStore, key, err)
+		s.logger.Error("%s_#%d %v", lw.Name, n, err)
+		s.reportError(lw, n, input, err)
+	}
+}
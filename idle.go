@@ -0,0 +1,79 @@
+package gostage
+
+import "sync"
+
+// WithOnIdle registers a callback which is called once the producer is done
+// and every produced event has left the pipeline
+func WithOnIdle(fn func()) Option {
+	return func(gs *GoStage) {
+		gs.onIdle = fn
+	}
+}
+
+// Idle returns a channel which is closed once the producer is done
+// and every produced event has left the pipeline
+func (s *GoStage) Idle() <-chan struct{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.idle
+}
+
+// resetIdle prepares the idle channel for a new run
+// unless nobody has seen the previous one closed yet
+func (s *GoStage) resetIdle() {
+	select {
+	case <-s.idle:
+		s.idle = make(chan struct{})
+		s.idleOnce = &sync.Once{}
+	default:
+	}
+	s.inflight.Store(0)
+	for f := range s.settled {
+		s.settled[f].Store(0)
+	}
+	s.producerDone.Store(false)
+}
+
+// enter counts an event produced into the pipeline
+func (s *GoStage) enter() {
+	s.inflight.Add(1)
+}
+
+// leave counts an event which won't travel any further
+func (s *GoStage) leave(f fate) {
+	s.settled[f].Add(1)
+	if s.inflight.Add(-1) == 0 {
+		s.checkIdle()
+	}
+}
+
+func (s *GoStage) checkIdle() {
+	if !s.producerDone.Load() || s.inflight.Load() != 0 {
+		return
+	}
+	s.idleOnce.Do(func() {
+		close(s.idle)
+		if s.onIdle != nil {
+			s.protect("OnIdle", s.onIdle)
+		}
+	})
+}
+
+// finishProducer stops the producers once their current events are sent
+// and marks them as done when all of their instances have exited
+func (s *GoStage) finishProducer() {
+	var instances []*instance
+	for i := 0; i < s.producers; i++ {
+		instances = append(instances, s.linkedWorkers[i].haltAll()...)
+	}
+
+	s.bg.Add(1)
+	go func() {
+		defer s.bg.Done()
+		for _, inst := range instances {
+			<-inst.done
+		}
+		s.producerDone.Store(true)
+		s.checkIdle()
+	}()
+}
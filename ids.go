@@ -0,0 +1,105 @@
+package gostage
+
+import (
+	crand "crypto/rand"
+	"encoding/binary"
+	"math/rand"
+	"strconv"
+	"sync"
+	"sync/atomic"
+)
+
+// IDGenerator returns a new event ID each time it's called, see EventIDFromContext
+// it's called by concurrent producers and must be safe for concurrent use
+type IDGenerator func() string
+
+// WithIDGenerator sets how the IDs of the events are made, they're numbers counting up
+// within the process by default, which repeat after a restart
+func WithIDGenerator(gen IDGenerator) Option {
+	return func(gs *GoStage) {
+		gs.idGen = gen
+	}
+}
+
+// CounterIDs returns IDs counting up from 1, like the default ones but with its own counter
+func CounterIDs() IDGenerator {
+	var n atomic.Uint64
+	return func() string {
+		return strconv.FormatUint(n.Add(1), 10)
+	}
+}
+
+// TimeOrderedIDs returns 26 characters IDs made of the time in milliseconds and 80 random bits,
+// like ULIDs: they sort in the order they're made and don't repeat across restarts
+// the random bits are counted up within the same millisecond, c is the real clock if nil
+func TimeOrderedIDs(c Clock) IDGenerator {
+	if c == nil {
+		c = realClock{}
+	}
+	g := &timeOrdered{clock: c}
+	return g.next
+}
+
+type timeOrdered struct {
+	mu      sync.Mutex
+	clock   Clock
+	ms      uint64
+	hi, low uint64 // the 80 random bits, 16 in hi
+}
+
+func (g *timeOrdered) next() string {
+	ms := uint64(g.clock.Now().UnixMilli())
+	g.mu.Lock()
+	if ms > g.ms {
+		var b [10]byte
+		if _, err := crand.Read(b[:]); err != nil {
+			panic(err)
+		}
+		g.ms = ms
+		g.hi, g.low = uint64(binary.BigEndian.Uint16(b[:2])), binary.BigEndian.Uint64(b[2:])
+	} else {
+		// the clock didn't move or went back, keep the order
+		g.low++
+		if g.low == 0 {
+			g.hi = (g.hi + 1) & 0xffff
+		}
+	}
+	id := encodeID(g.ms<<16|g.hi, g.low)
+	g.mu.Unlock()
+	return id
+}
+
+// SeededIDs returns random IDs shaped like the TimeOrderedIDs ones, the same seed gives
+// the same IDs in the same order, for reproducible tests
+func SeededIDs(seed int64) IDGenerator {
+	var mu sync.Mutex
+	r := rand.New(rand.NewSource(seed))
+	return func() string {
+		mu.Lock()
+		hi, low := r.Uint64(), r.Uint64()
+		mu.Unlock()
+		return encodeID(hi, low)
+	}
+}
+
+// crockford is the base32 alphabet of ULIDs, without I, L, O and U
+const crockford = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// encodeID writes the 128 bits hi:low as 26 base32 characters, the first one holds 3 bits
+func encodeID(hi, low uint64) string {
+	var b [26]byte
+	for i := 25; i >= 0; i-- {
+		b[i] = crockford[low&31]
+		low = low>>5 | hi<<59
+		hi >>= 5
+	}
+	return string(b[:])
+}
+
+// eventName returns the ID of the event id as seen by the workers
+func (s *GoStage) eventName(id uint64) string {
+	if s.idGen == nil {
+		return strconv.FormatUint(id, 10)
+	}
+	return s.events.name(id)
+}
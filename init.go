@@ -0,0 +1,164 @@
+package gostage
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+)
+
+// ErrInit if a worker couldn't be created or its Init failed
+var ErrInit = errors.New("init failed")
+
+// Initializer is implemented by workers which set up something before handling
+// events, e.g. open a connection, Init is called once per worker and run
+// before the pipeline starts, a run fails with the error of any Init
+// and Close is then called on the workers already created
+type Initializer interface {
+	Init() error
+}
+
+// create makes instance n of lw with Create, a panic is returned as an error
+func (s *GoStage) create(lw *linkedWorker, n, total int) (w Worker, err error) {
+	defer func() {
+		if v := recover(); v != nil {
+			w, err = nil, fmt.Errorf("%w: %s_#%d Create panicked: %v", ErrInit, lw.Name, n, v)
+		}
+	}()
+	return s.callWorkerCreate(lw.Worker, lw.Name, n, total), nil
+}
+
+// initWorker calls the Init of instance n of lw if it has one
+func initWorker(lw *linkedWorker, n int, w Worker) (err error) {
+	in, ok := w.(Initializer)
+	if !ok {
+		return nil
+	}
+	defer func() {
+		if v := recover(); v != nil {
+			err = fmt.Errorf("%w: %s_#%d Init panicked: %v", ErrInit, lw.Name, n, v)
+		}
+	}()
+	if err := in.Init(); err != nil {
+		return fmt.Errorf("%w: %s_#%d: %w", ErrInit, lw.Name, n, err)
+	}
+	return nil
+}
+
+// prepare creates and initializes the workers every stage starts with, before
+// anything runs, the workers are closed if any of them failed
+// returns all the failures
+func (s *GoStage) prepare() error {
+	var errs []error
+	var workers []Worker
+	seen := make(map[uintptr]bool)
+	for _, lw := range s.linkedWorkers {
+		size := lw.size()
+		lw.prepared = make([]Worker, 0, size)
+		for n := 0; n < size; n++ {
+			if w := s.reused(lw, n); w != nil {
+				lw.prepared = append(lw.prepared, w)
+				if v := reflect.ValueOf(w); v.Kind() == reflect.Ptr {
+					if seen[v.Pointer()] {
+						continue
+					}
+					seen[v.Pointer()] = true
+				}
+				workers = append(workers, w)
+				continue
+			}
+			w := lw.Worker
+			if n != 0 && !lw.ShareInstance {
+				created, err := s.create(lw, n, size)
+				if err != nil {
+					errs = append(errs, err)
+					break
+				}
+				w = created
+			}
+			lw.prepared = append(lw.prepared, w)
+			if n != 0 && lw.ShareInstance {
+				continue
+			}
+			if ia, ok := w.(InstanceAware); ok {
+				ia.SetInstanceInfo(lw.Name, n, size)
+			}
+			setStopToken(lw, w)
+			// a worker used by several stages is initialized once
+			if v := reflect.ValueOf(w); v.Kind() == reflect.Ptr {
+				if seen[v.Pointer()] {
+					continue
+				}
+				seen[v.Pointer()] = true
+			}
+			workers = append(workers, w)
+			if err := initWorker(lw, n, w); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+	s.closePrepared(workers)
+	return errors.Join(errs...)
+}
+
+// closePrepared closes the workers made by prepare, which won't be run
+func (s *GoStage) closePrepared(workers []Worker) {
+	for _, w := range workers {
+		s.callWorkerClose(w)
+	}
+	for _, lw := range s.linkedWorkers {
+		lw.prepared = nil
+	}
+}
+
+// preparedWorkers returns the distinct workers made by prepare
+func (s *GoStage) preparedWorkers() []Worker {
+	var workers []Worker
+	seen := make(map[uintptr]bool)
+	for _, lw := range s.linkedWorkers {
+		for n, w := range lw.prepared {
+			if n != 0 && lw.ShareInstance {
+				continue
+			}
+			if v := reflect.ValueOf(w); v.Kind() == reflect.Ptr {
+				if seen[v.Pointer()] {
+					continue
+				}
+				seen[v.Pointer()] = true
+			}
+			workers = append(workers, w)
+		}
+	}
+	return workers
+}
+
+// DryRun checks the pipeline without handling any event: it validates the configs,
+// creates the workers of every stage with Create, calls their Init and closes them
+// no goroutine is started, returns all the problems found
+func (s *GoStage) DryRun() error {
+	prev := s.State()
+	if prev != StateIdle && prev != StateStopped || !s.transit(StateStarting, prev) {
+		return fmt.Errorf("%w: pipeline is %s", ErrAlreadyRunning, s.State())
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// the stages of the last run are kept for Stats
+	linked, producers := s.linkedWorkers, s.producers
+	defer func() {
+		s.linkedWorkers, s.producers = linked, producers
+		s.state.Store(int32(prev))
+	}()
+
+	if err := s.validate(); err != nil {
+		return err
+	}
+	s.linkedWorkers = make([]*linkedWorker, 0, len(s.configs))
+	s.buildLinkedWorkers()
+	if err := s.prepare(); err != nil {
+		return err
+	}
+	s.closePrepared(s.preparedWorkers())
+	return nil
+}
@@ -0,0 +1,34 @@
+package gostage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// ErrInjectIntoProducer if Inject is given a producer, Push hands events to the stage after them
+var ErrInjectIntoProducer = errors.New("can't inject into a producer, use Push")
+
+// ErrStageStopped if Inject is given a stage stopped by StopStage
+var ErrStageStopped = errors.New("stage stopped")
+
+// Inject hands v to the stage with the given name, waiting until it has room for it
+// the event goes downstream from there like any other, the stages before never see it
+// it's counted as produced and as Injected by the stages which process it, and its
+// AuditRecords are marked Injected, the OverflowPolicy of the stage doesn't apply to it
+// returns ErrPipelineStopped if the pipeline isn't running or starts stopping meanwhile
+func (s *GoStage) Inject(stage string, v interface{}) error {
+	return s.enqueue(context.Background(), stage, v, 0)
+}
+
+// injectable returns why stage i can't be injected into, nil if it can, s.mu must be held
+func (s *GoStage) injectable(i int) error {
+	lw := s.linkedWorkers[i]
+	if i < s.producers {
+		return fmt.Errorf("%w: %s", ErrInjectIntoProducer, lw.Name)
+	}
+	if lw.stopped.Load() {
+		return fmt.Errorf("%w: %s", ErrStageStopped, lw.Name)
+	}
+	return nil
+}
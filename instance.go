@@ -0,0 +1,243 @@
+package gostage
+
+import (
+	"context"
+	"reflect"
+	"sync"
+	"sync/atomic"
+)
+
+// instance is one goroutine of a stage
+type instance struct {
+	lw *linkedWorker
+	n  int
+	w  Worker
+	// w if it's a ContextWorker, and the context of its events
+	cw  ContextWorker
+	ctx context.Context
+	// w if it's a SideOutputWorker, and the emit function passed to it
+	sw       SideOutputWorker
+	emitSide func(string, interface{})
+	// closed to stop the instance once its current event is done
+	stop     chan struct{}
+	stopOnce sync.Once
+	// set with stop, cheaper to check on every event
+	halted atomic.Bool
+	// set by Scale, the instance stops once its batch is done whatever the StopMode
+	retiring atomic.Bool
+	// closed to give up an event the instance is blocked on sending
+	abort     chan struct{}
+	abortOnce sync.Once
+	// closed once the instance has started running
+	started   chan struct{}
+	startOnce sync.Once
+	// closed by the instance after Close is called
+	done chan struct{}
+	// the event being handled, only touched by the instance's goroutine
+	current *envelope
+	// events taken from the input channel but not handled yet, see WithReceiveBatch
+	batch []*envelope
+	head  int
+	sup   *Supervisor
+	// the pool running the instance, nil unless Config.Pool
+	pool *stagePool
+	// shared with the other instances running the same worker, nil if w isn't shared
+	ref *workerRef
+	// the events of the instance, nil unless the stage is dispatched
+	inbox chan *envelope
+	// set under lw.mu once the dispatcher has closed inbox
+	inboxClosed bool
+	// the events given to the instance and not done yet, and its average latency in ns
+	load    atomic.Int64
+	latency atomic.Int64
+	// the events given to the instance which are done
+	finished atomic.Int64
+	// the events the instance has handled, kept by the stage after it exits
+	processed *atomic.Int64
+}
+
+// workerRef counts the instances running a worker referenced by pointer
+// so that Close is called once the last of them has exited
+type workerRef struct {
+	ptr  uintptr
+	refs int
+}
+
+func newInstance(lw *linkedWorker, w Worker, n int) *instance {
+	return &instance{
+		lw:      lw,
+		n:       n,
+		w:       w,
+		stop:    make(chan struct{}),
+		abort:   make(chan struct{}),
+		started: make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+}
+
+// start marks the instance as running, it's called again after every restart
+func (inst *instance) start() {
+	inst.startOnce.Do(func() { close(inst.started) })
+}
+
+// input returns the payload being handled, for panic logs
+func (inst *instance) input() interface{} {
+	if inst.current == nil {
+		return nil
+	}
+	return inst.current.payload
+}
+
+// halt asks the instance to stop after the current event
+func (inst *instance) halt() {
+	inst.stopOnce.Do(func() {
+		inst.halted.Store(true)
+		close(inst.stop)
+		if inst.pool != nil {
+			inst.pool.wake(inst)
+		}
+	})
+}
+
+// retire asks the instance to stop after the events it has taken
+func (inst *instance) retire() {
+	inst.retiring.Store(true)
+	inst.halt()
+}
+
+// kill asks the instance to stop as soon as possible
+func (inst *instance) kill() {
+	inst.lw.interrupter.interrupt()
+	inst.halt()
+	inst.abortOnce.Do(func() { close(inst.abort) })
+}
+
+// exit cleans up the worker, removes the instance from its stage
+// and confirms the instance has stopped
+func (s *GoStage) exit(inst *instance) {
+	// the worker of a stage kept by a reload is run again by the next run
+	last := s.release(inst.ref)
+	if !s.keptWorker(inst) && last {
+		s.callWorkerClose(inst.w)
+	}
+
+	if inst.pool != nil {
+		inst.pool.exited()
+	}
+
+	lw := inst.lw
+	lw.mu.Lock()
+	for k, other := range lw.instances {
+		if other == inst {
+			lw.instances = append(lw.instances[:k], lw.instances[k+1:]...)
+			break
+		}
+	}
+	s.strand(inst)
+	if len(lw.instances) == 0 {
+		close(lw.emptied)
+	}
+	lw.mu.Unlock()
+	if inst.inbox != nil {
+		lw.wake()
+	}
+
+	close(inst.done)
+}
+
+// haltAll asks all running instances of the stage to stop after their current events
+func (lw *linkedWorker) haltAll() []*instance {
+	lw.mu.Lock()
+	defer lw.mu.Unlock()
+
+	instances := append([]*instance(nil), lw.instances...)
+	for _, inst := range instances {
+		inst.halt()
+	}
+	return instances
+}
+
+// killAll asks all running instances of the stage to stop as soon as possible
+func (lw *linkedWorker) killAll() {
+	lw.mu.Lock()
+	defer lw.mu.Unlock()
+
+	for _, inst := range lw.instances {
+		inst.kill()
+	}
+}
+
+// instanceCount returns the number of instances the stage runs
+func (lw *linkedWorker) instanceCount() int {
+	lw.mu.Lock()
+	defer lw.mu.Unlock()
+	return lw.scale
+}
+
+// vacant returns true if the stage has no instance running
+func (lw *linkedWorker) vacant() bool {
+	lw.mu.Lock()
+	defer lw.mu.Unlock()
+	return len(lw.instances) == 0
+}
+
+// gone returns a channel closed once the stage has no instance left
+func (lw *linkedWorker) gone() <-chan struct{} {
+	lw.mu.Lock()
+	defer lw.mu.Unlock()
+	return lw.emptied
+}
+
+// acquire returns the reference of the worker shared between instances
+// only workers held by pointer can be shared, other workers are copies
+// owned by a single instance and get a nil reference
+func (s *GoStage) acquire(w Worker) *workerRef {
+	v := reflect.ValueOf(w)
+	if v.Kind() != reflect.Ptr {
+		return nil
+	}
+
+	s.refsMu.Lock()
+	defer s.refsMu.Unlock()
+	if s.refs == nil {
+		s.refs = make(map[uintptr]*workerRef)
+	}
+	ref, ok := s.refs[v.Pointer()]
+	if !ok {
+		ref = &workerRef{ptr: v.Pointer()}
+		s.refs[v.Pointer()] = ref
+	}
+	ref.refs++
+	return ref
+}
+
+// retain adds a reference to ref
+func (s *GoStage) retain(ref *workerRef) *workerRef {
+	s.refsMu.Lock()
+	defer s.refsMu.Unlock()
+	ref.refs++
+	return ref
+}
+
+// count returns the number of references to the worker
+func (s *GoStage) count(ref *workerRef) int {
+	s.refsMu.Lock()
+	defer s.refsMu.Unlock()
+	return ref.refs
+}
+
+// release drops a reference, returns true if the worker should be closed
+func (s *GoStage) release(ref *workerRef) bool {
+	if ref == nil {
+		return true
+	}
+
+	s.refsMu.Lock()
+	defer s.refsMu.Unlock()
+	ref.refs--
+	if ref.refs > 0 {
+		return false
+	}
+	delete(s.refs, ref.ptr)
+	return true
+}
@@ -0,0 +1,147 @@
+package gostage
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// ErrInterrupted is returned by a HandleEvent which gave up its event because ShouldStop returned true
+// the event isn't reported as an error, it's delivered again in the next run of the pipeline
+// if Config.Redeliver allows it, otherwise it's counted in Accounting.DroppedInFlight
+var ErrInterrupted = errors.New("interrupted by shutdown")
+
+// StopToken tells the HandleEvent of a stage that the pipeline is stopping and
+// won't wait for its current event: the pipeline or the stage stops with StopImmediate,
+// or the stages downstream are gone, the zero StopToken never stops
+type StopToken struct {
+	interrupted <-chan struct{}
+}
+
+// Done returns a channel closed once the stage is interrupted
+func (t StopToken) Done() <-chan struct{} {
+	return t.interrupted
+}
+
+// ShouldStop returns true once the stage is interrupted, it's cheap enough to be polled
+// in the loops of a long HandleEvent, which returns a partial result or ErrInterrupted then
+func ShouldStop(t StopToken) bool {
+	select {
+	case <-t.interrupted:
+		return true
+	default:
+		return false
+	}
+}
+
+// StopAware is implemented by workers polling ShouldStop, SetStopToken is called before
+// the worker handles any event, the token is the stage's one
+type StopAware interface {
+	SetStopToken(StopToken)
+}
+
+// StopTokenFromContext returns the StopToken of the stage handling the event
+func StopTokenFromContext(ctx context.Context) (StopToken, bool) {
+	t, ok := ctx.Value(stopTokenKey).(StopToken)
+	return t, ok
+}
+
+// interrupter closes the StopToken of a stage
+type interrupter struct {
+	once sync.Once
+	c    chan struct{}
+}
+
+func newInterrupter() *interrupter {
+	return &interrupter{c: make(chan struct{})}
+}
+
+func (i *interrupter) token() StopToken {
+	return StopToken{interrupted: i.c}
+}
+
+func (i *interrupter) interrupt() {
+	i.once.Do(func() { close(i.c) })
+}
+
+// setStopToken passes the stage's StopToken to w if it wants it
+func setStopToken(lw *linkedWorker, w Worker) {
+	if sa, ok := w.(StopAware); ok {
+		sa.SetStopToken(lw.interrupter.token())
+	}
+}
+
+// interrupted handles an event given up by HandleEvent with ErrInterrupted,
+// it's kept for the next run if it can be redelivered
+func (s *GoStage) interrupted(inst *instance, env *envelope) {
+	lw := inst.lw
+	lw.stats.interrupted.Add(1)
+	s.logger.Debug("%s_#%d interrupted: %+v", lw.Name, inst.n, env.payload)
+	if lw.BestEffort {
+		s.droppedBestEffort.Add(1)
+	}
+	if env.redelivered < lw.Redeliver {
+		s.carriedMu.Lock()
+		if s.carried == nil {
+			s.carried = make(map[string][]carriedEvent)
+		}
+		s.carried[lw.Name] = append(s.carried[lw.Name], carriedEvent{payload: env.payload, redelivered: env.redelivered + 1})
+		s.carriedMu.Unlock()
+	}
+	s.leave(lostInFlight)
+	env.free()
+}
+
+// carriedEvent is an interrupted event delivered again in the next run
+type carriedEvent struct {
+	payload     interface{}
+	redelivered int
+}
+
+// carryOver hands the interrupted events of the previous run to their stages,
+// they're counted as produced, the stages handle them before their input
+func (s *GoStage) carryOver() {
+	s.carriedMu.Lock()
+	carried := s.carried
+	s.carried = nil
+	s.carriedMu.Unlock()
+	for name, events := range carried {
+		i, err := s.stage(name)
+		if err != nil {
+			s.logger.Error("gostage dropped %d interrupted events of %s: %v", len(events), name, err)
+			continue
+		}
+		lw := s.linkedWorkers[i]
+		if lw.role == Source {
+			continue
+		}
+		for _, e := range events {
+			s.produced.Add(1)
+			s.enter()
+			env := s.newEnvelope(e.payload)
+			// not sent by a producer, out of the sequence check
+			env.root = -1
+			env.redelivered = e.redelivered
+			lw.carried = append(lw.carried, env)
+		}
+		lw.hasCarried.Store(true)
+	}
+}
+
+// takeCarried returns the next interrupted event of the previous run for the stage, nil if none is left
+func (lw *linkedWorker) takeCarried() *envelope {
+	if !lw.hasCarried.Load() {
+		return nil
+	}
+	lw.mu.Lock()
+	defer lw.mu.Unlock()
+	if len(lw.carried) == 0 {
+		return nil
+	}
+	env := lw.carried[0]
+	lw.carried = lw.carried[1:]
+	if len(lw.carried) == 0 {
+		lw.hasCarried.Store(false)
+	}
+	return env
+}
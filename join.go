@@ -0,0 +1,397 @@
+package gostage
+
+import (
+	"container/list"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrInvalidJoin if a Join stage isn't fed by both of its producers
+var ErrInvalidJoin = errors.New("invalid join")
+
+// DefaultJoinTTL is how long a Join stage waits for the other side of an event
+// unless WithJoinTTL is given
+const DefaultJoinTTL = time.Minute
+
+// DefaultMaxPending is the most unmatched events a Join stage holds unless WithMaxPending is given
+const DefaultMaxPending = 1024
+
+// Joined is the event a Join stage emits
+type Joined struct {
+	Key string
+	// the events of the left and right producers, one is nil if the other
+	// is emitted alone, see EmitUnmatched
+	Left, Right interface{}
+}
+
+// Unmatched decides what a Join stage does with an event whose other side didn't come in time
+type Unmatched int
+
+const (
+	// DropUnmatched consumes the event, it's counted in Accounting.Skipped
+	DropUnmatched Unmatched = iota
+	// EmitUnmatched emits a Joined with the event alone
+	EmitUnmatched
+)
+
+// JoinOption configures a Join stage
+type JoinOption func(*Joiner)
+
+// WithJoinTTL sets how long an event waits for its other side, default is DefaultJoinTTL
+func WithJoinTTL(d time.Duration) JoinOption {
+	return func(j *Joiner) {
+		j.ttl = d
+	}
+}
+
+// WithMaxPending sets the most unmatched events the stage holds, once reached
+// the oldest is given up as if its TTL had passed, default is DefaultMaxPending
+func WithMaxPending(n int) JoinOption {
+	return func(j *Joiner) {
+		j.maxPending = n
+	}
+}
+
+// WithUnmatched sets what happens to the events whose other side didn't come, default is DropUnmatched
+func WithUnmatched(u Unmatched) JoinOption {
+	return func(j *Joiner) {
+		j.onUnmatched = u
+	}
+}
+
+// Joiner matches the events of the two producers of a Join stage by key
+type Joiner struct {
+	left, right string
+	key         func(interface{}) string
+	ttl         time.Duration
+	maxPending  int
+	onUnmatched Unmatched
+
+	mu sync.Mutex
+	// the pending events, oldest first
+	pending *list.List
+	// the pending events of every key, by side, oldest first
+	keys      map[string]*[2][]*list.Element
+	unmatched atomic.Int64
+}
+
+// the sides of a Join, the index of a pending event's side in Joiner.keys
+const (
+	leftSide = iota
+	rightSide
+)
+
+type pendingEvent struct {
+	key     string
+	side    int
+	env     *envelope
+	expires time.Time
+}
+
+// Join creates a stage which emits a Joined once the events with the same key
+// have come from both the left and the right producers, an event waits for its
+// other side until its TTL has passed, then it's handled as WithUnmatched says
+// left and right are the names of two producers of the pipeline, the stage must be the one they feed,
+// the events of other producers or pushed ones are passed on untouched
+// if several events of a side share a key they're matched in order of arrival
+// the events are held in memory so the stage's Size should stay 1
+func Join(name, left, right string, key func(interface{}) string, opts ...JoinOption) (*Config, *Joiner) {
+	j := &Joiner{
+		left:       left,
+		right:      right,
+		key:        key,
+		ttl:        DefaultJoinTTL,
+		maxPending: DefaultMaxPending,
+		pending:    list.New(),
+		keys:       make(map[string]*[2][]*list.Element),
+	}
+	for _, opt := range opts {
+		opt(j)
+	}
+	if j.maxPending <= 0 {
+		j.maxPending = DefaultMaxPending
+	}
+	return &Config{Name: name, Size: 1, Worker: j, SubscribeToName: left}, j
+}
+
+// HandleEvent isn't called, the pipeline matches the events itself
+func (j *Joiner) HandleEvent(in interface{}) (interface{}, error) {
+	return in, nil
+}
+
+// Pending returns the number of events waiting for their other side
+func (j *Joiner) Pending() int {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.pending.Len()
+}
+
+// Unmatched returns the number of events whose other side didn't come in time
+func (j *Joiner) Unmatched() int64 {
+	return j.unmatched.Load()
+}
+
+// match returns the oldest pending event of the other side with key and forgets it,
+// otherwise env is held, and the oldest pending event is returned as evicted
+// if more than maxPending are held
+func (j *Joiner) match(key string, side int, env *envelope, expires time.Time) (other, evicted *pendingEvent) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	sides := j.keys[key]
+	if sides != nil && len(sides[1-side]) > 0 {
+		return j.forget(sides[1-side][0]), nil
+	}
+	if sides == nil {
+		sides = new([2][]*list.Element)
+		j.keys[key] = sides
+	}
+	sides[side] = append(sides[side], j.pending.PushBack(&pendingEvent{key: key, side: side, env: env, expires: expires}))
+	if j.pending.Len() > j.maxPending {
+		return nil, j.forget(j.pending.Front())
+	}
+	return nil, nil
+}
+
+// forget removes a pending event, which is the oldest of its key and side
+func (j *Joiner) forget(e *list.Element) *pendingEvent {
+	p := j.pending.Remove(e).(*pendingEvent)
+	sides := j.keys[p.key]
+	sides[p.side] = sides[p.side][1:]
+	if len(sides[leftSide]) == 0 && len(sides[rightSide]) == 0 {
+		delete(j.keys, p.key)
+	}
+	return p
+}
+
+// next returns when the oldest pending event expires, false if none is pending
+func (j *Joiner) next() (time.Time, bool) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.pending.Len() == 0 {
+		return time.Time{}, false
+	}
+	return j.pending.Front().Value.(*pendingEvent).expires, true
+}
+
+// expire forgets the oldest pending event if it has expired by now, any if now is zero
+func (j *Joiner) expire(now time.Time) *pendingEvent {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	front := j.pending.Front()
+	if front == nil || !now.IsZero() && front.Value.(*pendingEvent).expires.After(now) {
+		return nil
+	}
+	return j.forget(front)
+}
+
+func (j *Joiner) alone(p *pendingEvent) Joined {
+	if p.side == leftSide {
+		return Joined{Key: p.key, Left: p.env.payload}
+	}
+	return Joined{Key: p.key, Right: p.env.payload}
+}
+
+// validateJoins checks that the producers of every Join stage feed it
+func (s *GoStage) validateJoins() error {
+	for _, config := range s.configs {
+		j, ok := config.Worker.(*Joiner)
+		if !ok {
+			continue
+		}
+		if j.left == j.right {
+			return fmt.Errorf("%w: %s joins %s with itself", ErrInvalidJoin, config.Name, j.left)
+		}
+		if parent := s.findParent(config); parent == nil || parent.SubscribeTo != nil || parent.SubscribeToName != "" {
+			return fmt.Errorf("%w: %s must subscribe to %s or %s", ErrInvalidJoin, config.Name, j.left, j.right)
+		}
+		for _, side := range []string{j.left, j.right} {
+			var producer *Config
+			for _, other := range s.configs {
+				if other.Name == side && other.SubscribeTo == nil && other.SubscribeToName == "" {
+					producer = other
+				}
+			}
+			if producer == nil {
+				return fmt.Errorf("%w: %s joins %s which isn't a producer", ErrInvalidJoin, config.Name, side)
+			}
+		}
+	}
+	return nil
+}
+
+// joinSides returns the side of the events of every producer joined by j
+func (s *GoStage) joinSides(j *Joiner) map[int]int {
+	sides := make(map[int]int, 2)
+	for k := 0; k < s.producers; k++ {
+		switch s.linkedWorkers[k].Name {
+		case j.left:
+			sides[k] = leftSide
+		case j.right:
+			sides[k] = rightSide
+		}
+	}
+	return sides
+}
+
+// runJoin is the loop of an instance of a Join stage
+func (s *GoStage) runJoin(inst *instance, j *Joiner, i int) {
+	lw := s.linkedWorkers[i]
+	sides := s.joinSides(j)
+	// restarted after key panicked, the event may be delivered again
+	if env := s.redeliver(inst); env != nil && !s.join(inst, j, i, sides, env) {
+		s.dropPending(inst, j)
+		s.exit(inst)
+		return
+	}
+
+	in, stop := s.inputOf(inst), inst.stop
+	var timer <-chan time.Time
+	var timerAt time.Time
+	for {
+		if !s.expirePending(inst, j, i, s.clock.Now()) {
+			s.dropPending(inst, j)
+			s.exit(inst)
+			return
+		}
+		if at, ok := j.next(); !ok {
+			timer = nil
+		} else if timer == nil || !at.Equal(timerAt) {
+			timer, timerAt = s.clock.After(at.Sub(s.clock.Now())), at
+		}
+
+		select {
+		case env, ok := <-in:
+			if !ok {
+				// the upstream has stopped and everything it sent has been taken
+				s.stopJoin(inst, j, i)
+				return
+			}
+			lw.bytes.release(env.size)
+			lw.stats.in.Add(1)
+			if !s.join(inst, j, i, sides, env) {
+				s.dropPending(inst, j)
+				s.exit(inst)
+				return
+			}
+		case <-timer:
+			timer = nil
+		case <-stop:
+			if inst.retiring.Load() {
+				// the other instances match the pending events
+				s.exit(inst)
+				return
+			}
+			if s.stopModeOf(lw) == StopImmediate {
+				s.dropPending(inst, j)
+				s.exit(inst)
+				return
+			}
+		drain:
+			for {
+				select {
+				case env, ok := <-in:
+					if !ok {
+						break drain
+					}
+					lw.bytes.release(env.size)
+					lw.stats.in.Add(1)
+					if !s.join(inst, j, i, sides, env) {
+						s.dropPending(inst, j)
+						s.exit(inst)
+						return
+					}
+				default:
+					break drain
+				}
+			}
+			s.stopJoin(inst, j, i)
+			return
+		}
+	}
+}
+
+// join matches env with a pending event of the other side and emits them,
+// or holds it until its other side comes
+// returns false if the instance was aborted while passing an event on
+func (s *GoStage) join(inst *instance, j *Joiner, i int, sides map[int]int, env *envelope) bool {
+	lw := s.linkedWorkers[i]
+	if !s.decode(lw, inst.n, env) {
+		return true
+	}
+	side, ok := sides[env.root]
+	if !ok || lw.disabled.Load() || lw.When != nil && !lw.When(env.payload) {
+		lw.stats.bypassed.Add(1)
+		return s.releaseEvent(inst, i, env)
+	}
+	inst.current = env
+	key := j.key(env.payload)
+	inst.current = nil
+	inst.processed.Add(1)
+
+	other, evicted := j.match(key, side, env, s.clock.Now().Add(j.ttl))
+	if evicted != nil {
+		return s.unmatched(inst, j, i, evicted)
+	}
+	if other == nil {
+		return true
+	}
+	joined := Joined{Key: key, Left: env.payload, Right: other.env.payload}
+	if side == rightSide {
+		joined.Left, joined.Right = joined.Right, joined.Left
+	}
+	// the event is merged into env
+	lw.stats.skipped.Add(1)
+	s.checkSequence(other.env)
+	s.leave(skipped)
+	other.env.free()
+	env.payload = joined
+	return s.releaseEvent(inst, i, env)
+}
+
+// unmatched gives up waiting for the other side of p
+func (s *GoStage) unmatched(inst *instance, j *Joiner, i int, p *pendingEvent) bool {
+	j.unmatched.Add(1)
+	if j.onUnmatched == EmitUnmatched {
+		p.env.payload = j.alone(p)
+		return s.releaseEvent(inst, i, p.env)
+	}
+	s.linkedWorkers[i].stats.skipped.Add(1)
+	s.checkSequence(p.env)
+	s.leave(skipped)
+	p.env.free()
+	return true
+}
+
+// expirePending gives up the pending events expired by now, all of them if now is zero
+// returns false if the instance was aborted while passing one on
+func (s *GoStage) expirePending(inst *instance, j *Joiner, i int, now time.Time) bool {
+	for p := j.expire(now); p != nil; p = j.expire(now) {
+		if !s.unmatched(inst, j, i, p) {
+			return false
+		}
+	}
+	return true
+}
+
+// stopJoin gives up the pending events, then exits the instance
+func (s *GoStage) stopJoin(inst *instance, j *Joiner, i int) {
+	if !s.expirePending(inst, j, i, time.Time{}) {
+		s.dropPending(inst, j)
+	}
+	s.exit(inst)
+}
+
+// dropPending drops the pending events
+func (s *GoStage) dropPending(inst *instance, j *Joiner) {
+	for p := j.expire(time.Time{}); p != nil; p = j.expire(time.Time{}) {
+		if inst.lw.BestEffort {
+			s.droppedBestEffort.Add(1)
+		}
+		s.leave(lostInFlight)
+		p.env.free()
+	}
+}
@@ -0,0 +1,128 @@
+package gostage
+
+import (
+	"fmt"
+	"strings"
+)
+
+// LifecycleKind is a step of the start or the stop of a pipeline
+type LifecycleKind int
+
+const (
+	// PipelineStarting the stages are about to be started
+	PipelineStarting LifecycleKind = iota
+	// StageStarted all instances of a stage are running
+	StageStarted
+	// ProducersStarted every stage is running, the producers emit events
+	ProducersStarted
+	// PipelineStopping the pipeline has a reason to stop
+	PipelineStopping
+	// DrainStarted the producers are stopped, the events in flight are waited for
+	DrainStarted
+	// DrainFinished the events in flight have left the pipeline, or the wait was cut short
+	DrainFinished
+	// StageStopped all instances of a stage have exited
+	StageStopped
+	// PipelineStopped all stages have stopped and the events are accounted for
+	PipelineStopped
+	// MemoryPressure the memory is over WithMemoryLimit, one more mitigation is applied
+	MemoryPressure
+	// MemoryRelieved the memory is under the threshold of WithMemoryLimit again, the mitigations are lifted
+	MemoryRelieved
+	// ReloadStarted the reload signal was received, the topology is rebuilt
+	ReloadStarted
+	// Reloaded the pipeline runs the rebuilt topology
+	Reloaded
+	// ReloadFailed the rebuilt topology was refused or didn't start, the running one is kept
+	ReloadFailed
+)
+
+func (k LifecycleKind) String() string {
+	switch k {
+	case PipelineStarting:
+		return "pipeline starting"
+	case StageStarted:
+		return "stage started"
+	case ProducersStarted:
+		return "producers started"
+	case PipelineStopping:
+		return "pipeline stopping"
+	case DrainStarted:
+		return "drain started"
+	case DrainFinished:
+		return "drain finished"
+	case StageStopped:
+		return "stage stopped"
+	case PipelineStopped:
+		return "pipeline stopped"
+	case MemoryPressure:
+		return "memory pressure"
+	case MemoryRelieved:
+		return "memory relieved"
+	case ReloadStarted:
+		return "reload started"
+	case Reloaded:
+		return "reloaded"
+	case ReloadFailed:
+		return "reload failed"
+	}
+	return fmt.Sprintf("LifecycleKind(%d)", int(k))
+}
+
+// LifecycleEvent is passed to Observer.OnLifecycle for every step of the start and the stop of the pipeline
+type LifecycleEvent struct {
+	Kind LifecycleKind
+	// the stage of StageStarted and StageStopped, prefixed by the pipeline's name and a slash if it has one
+	Stage string
+	// the message logged for the step
+	Message string
+}
+
+// WithLifecycleLogging logs every step of the start and the stop of the pipeline at Info level,
+// the messages start with "gostage " followed by the step, default is on
+// the Observer is told about the steps either way
+func WithLifecycleLogging(on bool) Option {
+	return func(gs *GoStage) {
+		gs.lifecycleLogging = on
+	}
+}
+
+// lifecycle logs a step of the start or the stop of the pipeline and notifies the observer
+// stage is empty unless the step is about a stage
+func (s *GoStage) lifecycle(kind LifecycleKind, stage string, format string, args ...interface{}) {
+	if !s.lifecycleLogging && s.observer == nil {
+		return
+	}
+	msg := "gostage " + fmt.Sprintf(format, args...)
+	if s.lifecycleLogging {
+		s.logger.Info("%s", msg)
+	}
+	if s.observer == nil {
+		return
+	}
+	if stage != "" {
+		stage = s.qualify(stage)
+	}
+	ev := LifecycleEvent{Kind: kind, Stage: stage, Message: msg}
+	s.notify(func() {
+		s.observer.OnLifecycle(ev)
+	})
+}
+
+// describeStages lists the stages with their number of instances, e.g. producer(1) sink(4)
+func (s *GoStage) describeStages() string {
+	stages := make([]string, len(s.linkedWorkers))
+	for i, lw := range s.linkedWorkers {
+		stages[i] = fmt.Sprintf("%s(%d)", lw.Name, lw.size())
+	}
+	return strings.Join(stages, " ")
+}
+
+// producerNames lists the names of the producers
+func (s *GoStage) producerNames() string {
+	names := make([]string, s.producers)
+	for i := range names {
+		names[i] = s.linkedWorkers[i].Name
+	}
+	return strings.Join(names, " ")
+}
@@ -0,0 +1,179 @@
+package gostage
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrMemoryLimit if TryPush is refused an event while the memory is over WithMemoryLimit
+var ErrMemoryLimit = errors.New("memory limit reached")
+
+// DefaultMemoryCheckInterval is how often the memory is sampled if WithMemoryLimit isn't given an interval
+const DefaultMemoryCheckInterval = time.Second
+
+// memoryHysteresis is the fraction of the limit the memory must fall under to resume
+const memoryHysteresis = 0.9
+
+// the mitigations applied while the memory is over the limit, one more at every sample
+const (
+	// the producers don't produce
+	pauseProducers = 1
+	// the pushes and injections wait too, TryPush fails with ErrMemoryLimit
+	holdPushes = 2
+)
+
+// WithMemoryLimit samples the memory of the process every checkInterval and applies
+// back-pressure while it's over the soft limit of bytes: the producers are paused, then
+// if it's still over at the next sample the pushes and injections are held back too,
+// everything resumes once it's under 90% of the limit
+// the observer is told with the MemoryPressure and MemoryRelieved lifecycle events
+// the memory is what the Go runtime holds from the OS, see WithMemoryGauge
+func WithMemoryLimit(bytes uint64, checkInterval time.Duration) Option {
+	return func(gs *GoStage) {
+		if checkInterval <= 0 {
+			checkInterval = DefaultMemoryCheckInterval
+		}
+		gs.memory = &memoryWatchdog{limit: bytes, interval: checkInterval, gauge: gs.memoryGauge}
+	}
+}
+
+// WithMemoryGauge replaces how WithMemoryLimit samples the memory, e.g. with the usage of a cgroup
+func WithMemoryGauge(gauge func() uint64) Option {
+	return func(gs *GoStage) {
+		gs.memoryGauge = gauge
+		if gs.memory != nil {
+			gs.memory.gauge = gauge
+		}
+	}
+}
+
+// runtimeMemory returns the memory the Go runtime holds from the OS
+func runtimeMemory() uint64 {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	return m.Sys - m.HeapReleased
+}
+
+// memoryWatchdog applies the mitigations of WithMemoryLimit
+type memoryWatchdog struct {
+	limit    uint64
+	interval time.Duration
+	gauge    func() uint64
+
+	level atomic.Int32
+	mu    sync.Mutex
+	// closed once the memory is under the threshold again
+	relieved chan struct{}
+}
+
+// startMemoryWatchdog samples the memory until the pipeline stops
+func (s *GoStage) startMemoryWatchdog() {
+	m := s.memory
+	if m == nil {
+		return
+	}
+	if m.gauge == nil {
+		m.gauge = runtimeMemory
+	}
+	m.relieve()
+	s.bg.Add(1)
+	go func(stop <-chan struct{}) {
+		defer s.bg.Done()
+		// the workers being stopped mustn't be kept waiting
+		defer m.relieve()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-s.clock.After(m.interval):
+			}
+			s.sampleMemory(m.gauge())
+		}
+	}(s.scaling)
+}
+
+// sampleMemory applies one more mitigation if used is over the limit,
+// or lifts them all if it's under the threshold
+func (s *GoStage) sampleMemory(used uint64) {
+	m := s.memory
+	level := m.level.Load()
+	switch {
+	case used > m.limit && level < holdPushes:
+		if level == 0 {
+			m.mu.Lock()
+			m.relieved = make(chan struct{})
+			m.mu.Unlock()
+			s.lifecycle(MemoryPressure, "", "memory pressure: %d bytes over the limit of %d, producers paused", used, m.limit)
+		} else {
+			s.lifecycle(MemoryPressure, "", "memory pressure: %d bytes over the limit of %d, pushes held", used, m.limit)
+		}
+		m.level.Store(level + 1)
+	case level > 0 && float64(used) < float64(m.limit)*memoryHysteresis:
+		m.relieve()
+		s.lifecycle(MemoryRelieved, "", "memory relieved: %d bytes, resumed", used)
+	}
+}
+
+// relieve lifts the mitigations
+func (m *memoryWatchdog) relieve() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.level.Store(0)
+	if m.relieved == nil {
+		m.relieved = make(chan struct{})
+	}
+	select {
+	case <-m.relieved:
+	default:
+		close(m.relieved)
+	}
+}
+
+func (m *memoryWatchdog) relief() <-chan struct{} {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.relieved
+}
+
+// pause waits while the producers are paused, returns false if stop was closed meanwhile
+func (m *memoryWatchdog) pause(stop <-chan struct{}) bool {
+	if m == nil || m.level.Load() < pauseProducers {
+		return true
+	}
+	select {
+	case <-m.relief():
+		return true
+	case <-stop:
+		return false
+	}
+}
+
+// hold waits while the pushes are held, until ctx or stopping is done, it doesn't wait if ctx is nil
+func (m *memoryWatchdog) hold(ctx, stopping context.Context) error {
+	if m == nil || m.level.Load() < holdPushes {
+		return nil
+	}
+	if ctx == nil {
+		return ErrMemoryLimit
+	}
+	select {
+	case <-m.relief():
+		return nil
+	case <-stopping.Done():
+		return ErrPipelineStopped
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// memoryPressure returns the mitigations applied now, 0 if none
+func (s *GoStage) memoryPressure() int {
+	if s.memory == nil {
+		return 0
+	}
+	return int(s.memory.level.Load())
+}
@@ -0,0 +1,102 @@
+package gostage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// HeaderPrefix prefixes the keys of the metadata in the headers of the events
+const HeaderPrefix = "pipeline-"
+
+// WithMetadata describes the pipeline, e.g. its version, git SHA and environment,
+// see Metadata, the events are stamped with it as headers, see HeadersFromContext,
+// and the ContextWorkers find it in their context, see MetadataFromContext
+func WithMetadata(metadata map[string]string) Option {
+	return func(gs *GoStage) {
+		gs.setMetadata(metadata)
+	}
+}
+
+// SetMetadata replaces the metadata of the pipeline for its next run,
+// it returns ErrAlreadyRunning unless the pipeline is idle or stopped
+func (s *GoStage) SetMetadata(metadata map[string]string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if st := s.State(); st != StateIdle && st != StateStopped {
+		return fmt.Errorf("%w: pipeline is %s", ErrAlreadyRunning, st)
+	}
+	s.setMetadata(metadata)
+	return nil
+}
+
+func (s *GoStage) setMetadata(metadata map[string]string) {
+	s.metadata, s.headers = nil, nil
+	if len(metadata) == 0 {
+		return
+	}
+	s.metadata = make(map[string]string, len(metadata))
+	s.headers = make(map[string]string, len(metadata))
+	for k, v := range metadata {
+		s.metadata[k] = v
+		s.headers[HeaderPrefix+k] = v
+	}
+}
+
+// Metadata returns a copy of the metadata of the pipeline, nil if it has none
+func (s *GoStage) Metadata() map[string]string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return copyMetadata(s.metadata)
+}
+
+func copyMetadata(m map[string]string) map[string]string {
+	if m == nil {
+		return nil
+	}
+	c := make(map[string]string, len(m))
+	for k, v := range m {
+		c[k] = v
+	}
+	return c
+}
+
+// MetadataFromContext returns the metadata of the pipeline running the ContextWorker
+// it mustn't be changed
+func MetadataFromContext(ctx context.Context) (map[string]string, bool) {
+	m, ok := ctx.Value(metadataKey).(map[string]string)
+	return m, ok
+}
+
+// HeadersFromContext returns the headers of the event being handled, the metadata of the
+// pipeline which produced it with its keys prefixed by HeaderPrefix, it mustn't be changed
+func HeadersFromContext(ctx context.Context) (map[string]string, bool) {
+	h, ok := ctx.Value(headersKey).(map[string]string)
+	return h, ok
+}
+
+// Status is what StatusHandler serves
+type Status struct {
+	State      string            `json:"state"`
+	Metadata   map[string]string `json:"metadata,omitempty"`
+	Reason     string            `json:"reason,omitempty"`
+	Accounting Accounting        `json:"accounting"`
+	// in the order of Stats
+	Stages []StageStats `json:"stages"`
+}
+
+// StatusHandler serves the state, the metadata and the stats of the pipeline as JSON
+func (s *GoStage) StatusHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		stats := s.Stats()
+		st := Status{State: s.State().String(), Metadata: s.Metadata(), Accounting: stats.Accounting, Stages: stats.Stages}
+		if err := s.Reason(); err != nil {
+			st.Reason = err.Error()
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(st); err != nil {
+			s.logger.Error("status: %v", err)
+		}
+	})
+}
@@ -0,0 +1,128 @@
+package gostage
+
+import (
+	"fmt"
+	"sync"
+)
+
+// pipelineNames counts the running pipelines of the process which hold every name
+var pipelineNames = struct {
+	sync.Mutex
+	used map[string]int
+}{used: make(map[string]int)}
+
+// WithName names the pipeline to tell it apart from the others of the process
+// the framework prefixes its log messages with the name, and the stage names
+// it passes to Observer, OnRestart and OnError with the name and a slash
+// a run started while another running pipeline holds the name gets a numeric suffix, which is logged
+func WithName(name string) Option {
+	return func(gs *GoStage) {
+		gs.askedName = name
+	}
+}
+
+// Name returns the name of the pipeline in its last run, or the one given to WithName
+// before it has run, empty unless WithName is given
+func (s *GoStage) Name() string {
+	if name := s.name.Load(); name != nil {
+		return *name
+	}
+	return s.askedName
+}
+
+// register prefixes the framework's log messages with the name of the pipeline
+func (s *GoStage) register() {
+	if s.askedName == "" {
+		return
+	}
+	s.logger.Logger = &namedLogger{s: s, Logger: s.logger.Logger}
+}
+
+// claimName makes the name of the pipeline unique among the running ones for a new run
+func (s *GoStage) claimName() {
+	if s.askedName == "" {
+		return
+	}
+	pipelineNames.Lock()
+	if s.nameHeld {
+		pipelineNames.Unlock()
+		return
+	}
+	asked := s.askedName
+	name := asked
+	for k := pipelineNames.used[asked] + 1; pipelineNames.used[name] > 0; k++ {
+		name = fmt.Sprintf("%s-%d", asked, k)
+	}
+	s.name.Store(&name)
+	pipelineNames.used[asked]++
+	if name != asked {
+		pipelineNames.used[name]++
+	}
+	s.nameHeld = true
+	pipelineNames.Unlock()
+
+	if name != asked {
+		s.logger.Warn("the pipeline name %s is already used, renamed to %s", asked, name)
+	}
+}
+
+// releaseName lets other pipelines take the name once the run has ended
+func (s *GoStage) releaseName() {
+	if s.askedName == "" {
+		return
+	}
+	pipelineNames.Lock()
+	defer pipelineNames.Unlock()
+	if !s.nameHeld {
+		return
+	}
+	name := s.Name()
+	for _, held := range []string{s.askedName, name} {
+		if pipelineNames.used[held]--; pipelineNames.used[held] == 0 {
+			delete(pipelineNames.used, held)
+		}
+		if name == s.askedName {
+			break
+		}
+	}
+	s.nameHeld = false
+}
+
+// qualify returns the name of a stage as passed to the hooks
+func (s *GoStage) qualify(stage string) string {
+	name := s.Name()
+	if name == "" {
+		return stage
+	}
+	return name + "/" + stage
+}
+
+// namedLogger prefixes the messages of a named pipeline
+type namedLogger struct {
+	s *GoStage
+	Logger
+}
+
+func (l *namedLogger) prefix() string {
+	return "[" + l.s.Name() + "] "
+}
+
+func (l *namedLogger) Fatal(format string, args ...interface{}) {
+	l.Logger.Fatal(l.prefix()+format, args...)
+}
+
+func (l *namedLogger) Error(format string, args ...interface{}) {
+	l.Logger.Error(l.prefix()+format, args...)
+}
+
+func (l *namedLogger) Info(format string, args ...interface{}) {
+	l.Logger.Info(l.prefix()+format, args...)
+}
+
+func (l *namedLogger) Debug(format string, args ...interface{}) {
+	l.Logger.Debug(l.prefix()+format, args...)
+}
+
+func (l *namedLogger) Warn(format string, args ...interface{}) {
+	logWarn(l.Logger, l.prefix()+format, args...)
+}
@@ -0,0 +1,46 @@
+package gostage
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// noDataFor is the ErrNoData of a producer knowing when it has data again
+type noDataFor struct {
+	d time.Duration
+}
+
+func (e *noDataFor) Error() string {
+	return fmt.Sprintf("%v for %v", ErrNoData, e.d)
+}
+
+func (e *noDataFor) Unwrap() error {
+	return ErrNoData
+}
+
+// NoDataFor is ErrNoData telling how long the producer has no data for, e.g. the Retry-After
+// of the API it polls, the producer is called again after exactly d rather than after
+// NoDataCount and NoDataCountSleep, the wait ends early if the pipeline stops
+// returned by any other stage it's ErrNoData
+func NoDataFor(d time.Duration) error {
+	return &noDataFor{d: d}
+}
+
+// noDataHint returns the duration of a NoDataFor error
+func noDataHint(err error) (time.Duration, bool) {
+	var hint *noDataFor
+	if errors.As(err, &hint) {
+		return hint.d, true
+	}
+	return 0, false
+}
+
+// noData returns whether err is ErrNoData or NoDataFor
+func noData(err error) bool {
+	if err == ErrNoData {
+		return true
+	}
+	_, ok := noDataHint(err)
+	return ok
+}
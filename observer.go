@@ -0,0 +1,117 @@
+package gostage
+
+import "time"
+
+// RestartEvent describes a restart of a stage's instance after a panic
+type RestartEvent struct {
+	// the stage's name, prefixed by the pipeline's name and a slash if it has one
+	Stage    string
+	Instance int
+	// the number of restarts counted against the budget, starts from 1
+	Attempt int
+	// the value recovered from the panic
+	Recovered interface{}
+	// how long the supervisor waits before restarting
+	Backoff time.Duration
+}
+
+// Observer receives the pipeline's events
+// its methods are called in order from a single goroutine, never from a worker's goroutine,
+// the events are dropped while the calls are 64 events behind, see Stats.DroppedNotifications,
+// embed BaseObserver to implement only some of them
+type Observer interface {
+	// OnStageStarted is called once all instances of a stage are running
+	// the stages start from the terminal stage to the producers
+	// stage is prefixed by the pipeline's name and a slash if it has one
+	OnStageStarted(stage string)
+	OnRestart(RestartEvent)
+	// OnPipelineStopped is called once all workers have stopped
+	OnPipelineStopped(Accounting)
+	// OnLifecycle is called for every step of the start and the stop of the pipeline
+	OnLifecycle(LifecycleEvent)
+}
+
+// BaseObserver implements Observer with methods doing nothing
+type BaseObserver struct{}
+
+// OnStageStarted implements Observer
+func (BaseObserver) OnStageStarted(string) {}
+
+// OnRestart implements Observer
+func (BaseObserver) OnRestart(RestartEvent) {}
+
+// OnPipelineStopped implements Observer
+func (BaseObserver) OnPipelineStopped(Accounting) {}
+
+// OnLifecycle implements Observer
+func (BaseObserver) OnLifecycle(LifecycleEvent) {}
+
+// WithObserver registers an Observer for the whole pipeline
+func WithObserver(o Observer) Option {
+	return func(gs *GoStage) {
+		gs.observer = o
+	}
+}
+
+// the number of notifications queued before the next ones are dropped
+const notifyQueueSize = 64
+
+// startNotifier starts the goroutine which runs hooks off the workers' critical path
+func (s *GoStage) startNotifier() {
+	s.notifications = make(chan func(), notifyQueueSize)
+	s.notifierDone = make(chan struct{})
+	go func(notifications chan func(), done chan struct{}) {
+		defer close(done)
+		for fn := range notifications {
+			s.protect("hook", fn)
+		}
+	}(s.notifications, s.notifierDone)
+}
+
+// stopNotifier runs the queued hooks and stops the notifier goroutine
+// it must be called after all workers and supervisors have stopped
+func (s *GoStage) stopNotifier() {
+	close(s.notifications)
+	<-s.notifierDone
+}
+
+// notify runs fn on the notifier goroutine, it never blocks the caller
+// fn is dropped and counted in Stats.DroppedNotifications if the queue is full
+func (s *GoStage) notify(fn func()) {
+	select {
+	case s.notifications <- fn:
+	default:
+		s.droppedNotifications.Add(1)
+	}
+}
+
+// stageStarted notifies the observer that all instances of lw are running
+func (s *GoStage) stageStarted(lw *linkedWorker) {
+	if s.observer == nil {
+		return
+	}
+	s.notify(func() {
+		s.observer.OnStageStarted(s.qualify(lw.Name))
+	})
+}
+
+// restarted records a restart and notifies the hooks
+func (s *GoStage) restarted(lw *linkedWorker, n int, info RestartInfo) {
+	lw.stats.recordRestart(s.clock.Now(), lw.restartWindow())
+
+	ev := RestartEvent{
+		Stage:     s.qualify(lw.Name),
+		Instance:  n,
+		Attempt:   info.Attempt,
+		Recovered: info.Recovered,
+		Backoff:   info.Backoff,
+	}
+	s.notify(func() {
+		if lw.OnRestart != nil {
+			lw.OnRestart(ev)
+		}
+		if s.observer != nil {
+			s.observer.OnRestart(ev)
+		}
+	})
+}
@@ -0,0 +1,142 @@
+package gostage
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// DefaultOutputBuffer is the capacity of the channel returned by Output
+const DefaultOutputBuffer = 64
+
+// OutputDisposition decides what happens to the outputs of the terminal stage
+type OutputDisposition int
+
+const (
+	// Discard throws the outputs away
+	Discard OutputDisposition = iota
+	// ToOutputChannel sends the outputs to the channel returned by Output
+	ToOutputChannel
+	// ToCollector gathers the outputs in memory, see Results
+	ToCollector
+	// ToBridge pushes the outputs to another pipeline, see WithBridge
+	ToBridge
+)
+
+func (d OutputDisposition) String() string {
+	switch d {
+	case Discard:
+		return "discard"
+	case ToOutputChannel:
+		return "output channel"
+	case ToCollector:
+		return "collector"
+	case ToBridge:
+		return "bridge"
+	}
+	return fmt.Sprintf("OutputDisposition(%d)", int(d))
+}
+
+// WithOutput sets what happens to the outputs of the terminal stage, default is Discard
+// Collect gathers them whatever the disposition
+func WithOutput(d OutputDisposition) Option {
+	return func(gs *GoStage) {
+		gs.disposition = d
+	}
+}
+
+// WithBridge pushes the outputs of the terminal stage to next as with Push,
+// waiting while it has no room for them, next must be running
+func WithBridge(next *GoStage) Option {
+	return func(gs *GoStage) {
+		gs.disposition = ToBridge
+		gs.bridge = next
+	}
+}
+
+// Output returns the channel the outputs of the terminal stage are sent to with ToOutputChannel,
+// it's created by Run or RunAsync and closed once the run has stopped, nil with another disposition
+// the channel must be read until it's closed, the terminal stage waits while it's full
+func (s *GoStage) Output() <-chan interface{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.output
+}
+
+// Results returns the outputs gathered with ToCollector so far, in the order they were emitted
+func (s *GoStage) Results() []interface{} {
+	s.mu.Lock()
+	c := s.collector
+	s.mu.Unlock()
+	if c == nil {
+		return nil
+	}
+	return c.all()
+}
+
+// startOutput prepares the disposition of the outputs for a run
+// c is the collector of Collect, nil otherwise
+func (s *GoStage) startOutput(c *collector) {
+	s.terminal = s.disposition
+	if c != nil {
+		s.terminal = ToCollector
+	} else if s.terminal == ToCollector {
+		c = &collector{max: s.maxResults}
+	}
+	s.collector = c
+	s.output = nil
+	if s.terminal == ToOutputChannel {
+		s.output = make(chan interface{}, DefaultOutputBuffer)
+	}
+	s.lostOutputs.Store(0)
+}
+
+// stopOutput closes the output channel once the terminal stage has stopped
+func (s *GoStage) stopOutput() {
+	if s.output != nil {
+		close(s.output)
+	}
+}
+
+// emit passes an output of the terminal stage on as the disposition says
+// with ToOutputChannel and ToBridge it waits until the output is taken or the instance is aborted
+func (s *GoStage) emit(inst *instance, v interface{}) {
+	switch s.terminal {
+	case ToCollector:
+		if !s.collector.add(v) {
+			s.setReason(ErrMaxResults)
+			s.Stop()
+		}
+	case ToOutputChannel:
+		select {
+		case s.output <- v:
+		case <-inst.abort:
+			s.lostOutputs.Add(1)
+		}
+	case ToBridge:
+		if err := s.bridge.Push(abortContext{inst.abort}, v); err != nil {
+			s.lostOutputs.Add(1)
+			s.logger.Error("gostage %s lost an output, the bridged pipeline refused it: %v", inst.lw.Name, err)
+		}
+	}
+}
+
+// abortContext is done once the abort channel of an instance is closed
+type abortContext struct {
+	abort <-chan struct{}
+}
+
+func (abortContext) Deadline() (time.Time, bool) { return time.Time{}, false }
+
+func (c abortContext) Done() <-chan struct{} { return c.abort }
+
+func (c abortContext) Err() error {
+	select {
+	case <-c.abort:
+		return context.Canceled
+	default:
+		return nil
+	}
+}
+
+func (abortContext) Value(interface{}) interface{} { return nil }
@@ -0,0 +1,156 @@
+package gostage
+
+import "context"
+
+// WithPartition attaches the partition p to the event of payload, a worker returns it
+// instead of the payload and a Partitioned stage downstream hands the event to the
+// instance of p, p % Size by default, without calling PartitionBy, e.g. to keep the partitions of a source
+// the partition is kept by the event across the stages until another one is attached
+func WithPartition(payload interface{}, p int) interface{} {
+	return &partitionHint{partition: p, payload: payload}
+}
+
+// partitionHint is a payload carrying the partition of its event
+type partitionHint struct {
+	partition int
+	payload   interface{}
+}
+
+// unwrapEvent returns the payload of out with the values and the partition attached to it,
+// if any, WithEventContext and WithPartition can wrap each other
+func unwrapEvent(out interface{}) (payload interface{}, values context.Context, partition int, hinted bool) {
+	for {
+		switch h := out.(type) {
+		case *eventContext:
+			values, out = h.values, h.payload
+		case *partitionHint:
+			partition, hinted, out = h.partition, true, h.payload
+		default:
+			return out, values, partition, hinted
+		}
+	}
+}
+
+// partitionOf returns the partition of env for lw, false if it has none
+// the payload is the encoded one if the stage has a Codec
+func (lw *linkedWorker) partitionOf(env *envelope) (int, bool) {
+	if env.partitioned {
+		return env.partition, true
+	}
+	if lw.PartitionBy == nil {
+		return 0, false
+	}
+	return int(fnv32a(lw.PartitionBy(env.payload)) & 0x7fffffff), true
+}
+
+// fnv32a hashes key without allocating
+func fnv32a(key string) uint32 {
+	h := uint32(2166136261)
+	for i := 0; i < len(key); i++ {
+		h ^= uint32(key[i])
+		h *= 16777619
+	}
+	return h
+}
+
+// partitioned returns the instance of a Partitioned stage env must go to and its index,
+// nil if the event has no partition, and false if the partition has moved to the instance
+// from one which hasn't handled the events it had been given yet, lw.mu must be held
+func (lw *linkedWorker) partitioned(env *envelope) (*instance, int, bool) {
+	p, ok := lw.partitionOf(env)
+	if !ok {
+		return nil, -1, true
+	}
+	lw.relayout()
+	k := lw.partitioner().Instance(p, len(lw.instances))
+	inst := lw.instances[k]
+	return inst, k, !lw.moving(p, inst)
+}
+
+func (lw *linkedWorker) partitioner() Partitioner {
+	if lw.Partitioner == nil {
+		return Modulo
+	}
+	return lw.Partitioner
+}
+
+// layoutChange is a change of the number of instances of a Partitioned stage
+type layoutChange struct {
+	// the instances before the change
+	instances []*instance
+	// the events each of them had been given when it happened
+	given []int64
+}
+
+// drained returns true if the instance k has finished the events given before the change
+func (c *layoutChange) drained(k int) bool {
+	inst := c.instances[k]
+	select {
+	case <-inst.done:
+		return true
+	default:
+	}
+	return inst.finished.Load() >= c.given[k]
+}
+
+// relayout records a change of the number of instances, the dispatcher is the only one
+// giving events to the instances so the events they had been given are known, lw.mu must be held
+func (lw *linkedWorker) relayout() {
+	if len(lw.instances) == len(lw.layout) {
+		return
+	}
+	if lw.layout != nil {
+		c := layoutChange{instances: lw.layout, given: make([]int64, len(lw.layout))}
+		for k, inst := range lw.layout {
+			c.given[k] = inst.finished.Load() + inst.load.Load()
+		}
+		lw.changes = append(lw.changes, c)
+	}
+	lw.layout = append([]*instance(nil), lw.instances...)
+}
+
+// moving returns true if the partition p goes to inst now but some instance it was on before
+// a change still has events of it to handle, lw.mu must be held
+func (lw *linkedWorker) moving(p int, inst *instance) bool {
+	partitioner := lw.partitioner()
+	// forget the changes whose old instances are all done
+	for len(lw.changes) > 0 {
+		c := &lw.changes[0]
+		done := true
+		for k := range c.instances {
+			if !c.drained(k) {
+				done = false
+				break
+			}
+		}
+		if !done {
+			break
+		}
+		lw.changes = lw.changes[1:]
+	}
+	for i := range lw.changes {
+		c := &lw.changes[i]
+		if k := partitioner.Instance(p, len(c.instances)); c.instances[k] != inst && !c.drained(k) {
+			return true
+		}
+	}
+	return false
+}
+
+// countPartition counts an event handed to the instance k of a Partitioned stage, lw.mu must be held
+func (lw *linkedWorker) countPartition(k int) {
+	for len(lw.partitions) <= k {
+		lw.partitions = append(lw.partitions, 0)
+	}
+	lw.partitions[k]++
+}
+
+// partitionCounts returns the events handed to each instance of a Partitioned stage
+func (lw *linkedWorker) partitionCounts() []int64 {
+	lw.mu.Lock()
+	defer lw.mu.Unlock()
+	if lw.partitions == nil {
+		return nil
+	}
+	return append([]int64(nil), lw.partitions...)
+}
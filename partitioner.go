@@ -0,0 +1,91 @@
+package gostage
+
+import (
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// DefaultVirtualNodes is the number of points of an instance on the ring of ConsistentHash
+const DefaultVirtualNodes = 128
+
+// Partitioner maps the partitions of a Partitioned stage to its instances
+type Partitioner interface {
+	// Instance returns the index of the instance of partition p among n instances
+	Instance(p, n int) int
+}
+
+// Modulo is the default Partitioner, the partition p goes to the instance p % n,
+// scaling the stage moves most partitions
+var Modulo Partitioner = modulo{}
+
+type modulo struct{}
+
+func (modulo) Instance(p, n int) int {
+	k := p % n
+	if k < 0 {
+		k += n
+	}
+	return k
+}
+
+// ConsistentHash returns a Partitioner placing virtualNodes points of every instance on a
+// hash ring, a partition goes to the instance of the next point, so that scaling the stage
+// from n to n+1 instances only moves about 1/(n+1) of the partitions, default is DefaultVirtualNodes
+func ConsistentHash(virtualNodes int) Partitioner {
+	if virtualNodes < 1 {
+		virtualNodes = DefaultVirtualNodes
+	}
+	return &hashRing{virtualNodes: virtualNodes, rings: make(map[int][]ringPoint)}
+}
+
+type ringPoint struct {
+	hash     uint32
+	instance int
+}
+
+// hashRing keeps the ring of every number of instances it has been asked for
+type hashRing struct {
+	virtualNodes int
+	mu           sync.Mutex
+	rings        map[int][]ringPoint
+}
+
+func (r *hashRing) Instance(p, n int) int {
+	points := r.ring(n)
+	h := mix32(uint32(p))
+	i := sort.Search(len(points), func(i int) bool { return points[i].hash >= h })
+	if i == len(points) {
+		i = 0
+	}
+	return points[i].instance
+}
+
+// ring returns the points of n instances, sorted by hash
+func (r *hashRing) ring(n int) []ringPoint {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if points, ok := r.rings[n]; ok {
+		return points
+	}
+	points := make([]ringPoint, 0, n*r.virtualNodes)
+	for k := 0; k < n; k++ {
+		for v := 0; v < r.virtualNodes; v++ {
+			h := mix32(fnv32a(strconv.Itoa(k) + "-" + strconv.Itoa(v)))
+			points = append(points, ringPoint{hash: h, instance: k})
+		}
+	}
+	sort.Slice(points, func(i, j int) bool { return points[i].hash < points[j].hash })
+	r.rings[n] = points
+	return points
+}
+
+// mix32 spreads the bits of h, the partitions attached by WithPartition are small numbers
+func mix32(h uint32) uint32 {
+	h ^= h >> 16
+	h *= 0x85ebca6b
+	h ^= h >> 13
+	h *= 0xc2b2ae35
+	h ^= h >> 16
+	return h
+}
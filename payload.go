@@ -0,0 +1,51 @@
+package gostage
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// truncatedPayloadBytes is how much of an oversized string or []byte payload the dead letter file keeps
+const truncatedPayloadBytes = 256
+
+// oversized returns true if env is bigger than the MaxPayloadBytes of lw
+func (lw *linkedWorker) oversized(env *envelope) bool {
+	limit := lw.current().MaxPayloadBytes
+	return limit > 0 && env.size > limit
+}
+
+// rejectOversized gives up env at the entry of lw, instead of buffering it
+func (s *GoStage) rejectOversized(lw *linkedWorker, env *envelope) {
+	lw.stats.oversized.Add(1)
+	lw.stats.deadLettered.Add(1)
+	id := s.eventName(s.eventID(env))
+	s.logger.Warn("%s rejected event %s of %d bytes, MaxPayloadBytes is %d", lw.Name, id, env.size, lw.current().MaxPayloadBytes)
+	err := fmt.Errorf("%w: %d bytes", ErrPayloadTooLarge, env.size)
+	s.reportError(lw, -1, env.payload, err)
+	s.writeDeadLetter(lw, -1, env, truncatePayload(env.payload, env.size), true, err, PermanentError)
+	s.leave(deadLettered)
+	env.free()
+}
+
+// truncatePayload describes an oversized payload with its type, its size
+// and the beginning of it if it's a string or a []byte
+func truncatePayload(v interface{}, size int64) string {
+	var head string
+	switch rv := reflect.ValueOf(v); {
+	case rv.Kind() == reflect.String:
+		head = rv.String()
+	case rv.Kind() == reflect.Slice && rv.Type().Elem().Kind() == reflect.Uint8:
+		b := rv.Bytes()
+		if len(b) > truncatedPayloadBytes {
+			b = b[:truncatedPayloadBytes]
+		}
+		head = string(b)
+	}
+	if head == "" {
+		return fmt.Sprintf("%T of %d bytes", v, size)
+	}
+	if len(head) > truncatedPayloadBytes {
+		head = head[:truncatedPayloadBytes]
+	}
+	return fmt.Sprintf("%T of %d bytes: %q...", v, size, head)
+}
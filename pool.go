@@ -0,0 +1,128 @@
+package gostage
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// defaultPoolIdleTimeout is how long a pooled instance waits for an event before it's parked
+const defaultPoolIdleTimeout = time.Second
+
+func (lw *linkedWorker) poolIdleTimeout() time.Duration {
+	if lw.PoolIdleTimeout > 0 {
+		return lw.PoolIdleTimeout
+	}
+	return defaultPoolIdleTimeout
+}
+
+// stagePool runs the instances of a pooled stage on as many goroutines as its backlog needs
+// an instance is either active, running on its own goroutine, or parked without any
+type stagePool struct {
+	s *GoStage
+	i int
+
+	// the number of active instances, at least one unless the stage is stopping
+	active atomic.Int32
+	// the number of parked instances, read without the lock on every event
+	parkedCount atomic.Int32
+
+	mu     sync.Mutex
+	parked []*instance
+}
+
+func newStagePool(s *GoStage, i int) *stagePool {
+	return &stagePool{s: s, i: i}
+}
+
+// start runs inst on a new goroutine
+func (p *stagePool) start(inst *instance) {
+	p.active.Add(1)
+	go p.s.runPooled(inst, p.i)
+}
+
+// grow starts a parked instance, called when the stage has a backlog
+func (p *stagePool) grow() {
+	if p.parkedCount.Load() == 0 {
+		return
+	}
+	p.mu.Lock()
+	if len(p.parked) == 0 {
+		p.mu.Unlock()
+		return
+	}
+	inst := p.parked[len(p.parked)-1]
+	p.parked = p.parked[:len(p.parked)-1]
+	p.parkedCount.Add(-1)
+	p.mu.Unlock()
+	p.start(inst)
+}
+
+// park parks inst unless it's the last active instance or it's being stopped
+func (p *stagePool) park(inst *instance) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if inst.halted.Load() || p.active.Load() <= 1 {
+		return false
+	}
+	p.active.Add(-1)
+	p.parked = append(p.parked, inst)
+	p.parkedCount.Add(1)
+	return true
+}
+
+// wake starts inst if it's parked, so that it can stop like the active instances
+func (p *stagePool) wake(inst *instance) {
+	p.mu.Lock()
+	found := false
+	for k, other := range p.parked {
+		if other == inst {
+			p.parked = append(p.parked[:k], p.parked[k+1:]...)
+			p.parkedCount.Add(-1)
+			found = true
+			break
+		}
+	}
+	p.mu.Unlock()
+	if found {
+		p.start(inst)
+	}
+}
+
+// exited is called when an active instance has exited for good
+func (p *stagePool) exited() {
+	p.active.Add(-1)
+}
+
+// runPooled runs inst on the calling goroutine until it's parked or exits
+// a panic restarts it on the same goroutine, with the budget of its Supervisor
+func (s *GoStage) runPooled(inst *instance, i int) {
+	for {
+		recovered, panicked := s.runGuarded(inst, i)
+		if !panicked {
+			return
+		}
+		backoff, ok := inst.sup.crashed(recovered)
+		if !ok {
+			s.abandon(inst, ErrSupervision, s.errChan)
+			return
+		}
+		if backoff > 0 {
+			select {
+			case <-s.clock.After(backoff):
+			case <-inst.stop:
+			}
+		}
+	}
+}
+
+func (s *GoStage) runGuarded(inst *instance, i int) (recovered interface{}, panicked bool) {
+	defer func() {
+		if err := recover(); err != nil {
+			inst.sup.logPanic(err)
+			recovered, panicked = err, true
+		}
+	}()
+	s.runWorker(inst, i)
+	return nil, false
+}
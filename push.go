@@ -0,0 +1,196 @@
+package gostage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrPipelineStopped if an event is pushed to a pipeline which isn't running or is stopping
+var ErrPipelineStopped = errors.New("pipeline stopped")
+
+// ErrPushTimeout if PushWithTimeout couldn't hand the event over in time
+var ErrPushTimeout = errors.New("push timed out")
+
+// TryPush hands v to the stage after the producers if it has room for it right away
+// returns false if it hasn't or the pipeline isn't running, it's safe for concurrent use
+func (s *GoStage) TryPush(v interface{}) bool {
+	return s.push(nil, v) == nil
+}
+
+// Push hands v to the stage after the producers, waiting until it has room for it
+// the pushed events are counted as produced and go downstream like the producers' ones,
+// the OverflowPolicy of the stage doesn't apply to them
+// returns ErrPipelineStopped if the pipeline isn't running or starts stopping
+// meanwhile, or the error of ctx
+func (s *GoStage) Push(ctx context.Context, v interface{}) error {
+	return s.push(ctx, v)
+}
+
+// PushWithTimeout is Push giving up with ErrPushTimeout after d
+func (s *GoStage) PushWithTimeout(v interface{}, d time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), d)
+	defer cancel()
+	err := s.push(ctx, v)
+	if err == context.DeadlineExceeded {
+		return ErrPushTimeout
+	}
+	return err
+}
+
+// push sends v to the stage after the producers, waiting until ctx is done
+// it doesn't wait if ctx is nil
+func (s *GoStage) push(ctx context.Context, v interface{}) error {
+	return s.enqueue(ctx, "", v, 0)
+}
+
+// enqueue sends v to the input of the stage with the given name, or of the stage
+// after the producers if it's empty, waiting until ctx is done, it doesn't wait if ctx is nil
+// replays is the number of times the event has been replayed by ReplayDLQ
+func (s *GoStage) enqueue(ctx context.Context, stage string, v interface{}, replays int) error {
+	s.mu.Lock()
+	if st := s.State(); st != StateRunning {
+		s.mu.Unlock()
+		return fmt.Errorf("%w: pipeline is %s", ErrPipelineStopped, st)
+	}
+	next := s.linkedWorkers[s.next(0)]
+	if stage != "" {
+		i, err := s.stage(stage)
+		if err == nil {
+			err = s.injectable(i)
+		}
+		if err != nil {
+			s.mu.Unlock()
+			return err
+		}
+		next = s.linkedWorkers[i]
+	}
+	// shutdown waits for the pushes in progress before closing out
+	s.pushMu.RLock()
+	defer s.pushMu.RUnlock()
+	out, stopping := next.in, s.pushing
+	s.mu.Unlock()
+
+	if stopping.Err() != nil {
+		return ErrPipelineStopped
+	}
+	if err := s.memory.hold(ctx, stopping); err != nil {
+		return err
+	}
+	if !s.admit() {
+		return ErrMaxEvents
+	}
+	env := s.newEnvelope(v)
+	// not sent by a producer, out of the sequence check
+	env.root = -1
+	env.injected = stage != ""
+	env.replays = replays
+	env.size = sizeOf(v)
+	if next.oversized(env) {
+		size := env.size
+		s.enter()
+		s.rejectOversized(next, env)
+		return fmt.Errorf("%w: %d bytes", ErrPayloadTooLarge, size)
+	}
+	if c := s.codecOf(next); c != nil {
+		data, err := c.Encode(v)
+		if err != nil {
+			s.produced.Add(-1)
+			env.free()
+			return fmt.Errorf("%w: %w", ErrEncode, err)
+		}
+		env.payload, env.encoded = data, true
+	}
+	s.sample(env)
+
+	s.enter()
+	if !s.pushTo(ctx, stopping, out, next, env) {
+		// the event never got in
+		env.free()
+		s.produced.Add(-1)
+		if s.inflight.Add(-1) == 0 {
+			s.checkIdle()
+		}
+		if stopping.Err() != nil || ctx == nil {
+			return ErrPipelineStopped
+		}
+		return ctx.Err()
+	}
+	return nil
+}
+
+// pushTo sends env to out, returns false if it was given up
+func (s *GoStage) pushTo(ctx, stopping context.Context, out chan *envelope, next *linkedWorker, env *envelope) bool {
+	if next.queue != nil {
+		return s.pushQueued(ctx, stopping, next, env)
+	}
+	if ctx == nil {
+		if !next.bytes.tryAcquire(env.size) {
+			return false
+		}
+		next.stats.enqueued.Add(1)
+		select {
+		case out <- env:
+			return true
+		default:
+			next.stats.enqueued.Add(-1)
+			next.bytes.release(env.size)
+			return false
+		}
+	}
+
+	// the push is given up as soon as the pipeline starts stopping
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	defer context.AfterFunc(stopping, cancel)()
+	if !next.bytes.acquire(env.size, ctx.Done()) {
+		return false
+	}
+	next.stats.enqueued.Add(1)
+	select {
+	case out <- env:
+		return true
+	case <-ctx.Done():
+		next.stats.enqueued.Add(-1)
+		next.bytes.release(env.size)
+		return false
+	}
+}
+
+// pushQueued pushes env to the queue of next, returns false if it was given up
+// with a nil ctx it's only pushed if the queue has room at once
+func (s *GoStage) pushQueued(ctx, stopping context.Context, next *linkedWorker, env *envelope) bool {
+	if ctx == nil {
+		done, cancel := context.WithCancel(context.Background())
+		cancel()
+		ctx = done
+	} else {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithCancel(ctx)
+		defer cancel()
+		defer context.AfterFunc(stopping, cancel)()
+	}
+	if !next.bytes.acquire(env.size, ctx.Done()) {
+		return false
+	}
+	next.stats.enqueued.Add(1)
+	if err := next.queue.q.Push(ctx, env); err != nil {
+		next.stats.enqueued.Add(-1)
+		next.bytes.release(env.size)
+		if errors.Is(err, ErrDropped) {
+			// accounted as dropped by the stage, not as a failed push
+			s.drop(next, env)
+			return true
+		}
+		return false
+	}
+	return true
+}
+
+// stopPushes rejects the pushes from now on and waits for the ones in progress
+func (s *GoStage) stopPushes() {
+	s.stopPushing()
+	s.pushMu.Lock()
+	s.pushMu.Unlock()
+}
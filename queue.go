@@ -0,0 +1,395 @@
+package gostage
+
+import (
+	"container/heap"
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+)
+
+// ErrQueueClosed if a value is pushed to a closed Queue
+var ErrQueueClosed = errors.New("queue closed")
+
+// Queue holds the events sent to a stage until one of its instances takes them, see Config.Queue
+// the values are opaque to the queue and its methods are called concurrently
+type Queue interface {
+	// Push adds v, waiting while the queue is full until ctx is done,
+	// it returns ErrDropped if the queue discarded v instead
+	Push(ctx context.Context, v interface{}) error
+	// Pop takes the next value, waiting until there is one or ctx is done,
+	// ok is false once the queue is closed and empty
+	Pop(ctx context.Context) (v interface{}, ok bool, err error)
+	// Len returns the number of values in the queue
+	Len() int
+	// Close makes the pushes fail with ErrQueueClosed, the values left can still be popped
+	Close()
+}
+
+// QueueFactory makes the queue in front of a stage for a run, capacity is its BufferSize
+// the values the queue discards to make room must be passed to evict, they're counted as dropped
+type QueueFactory func(capacity int, evict func(v interface{})) Queue
+
+// ChannelQueue is a Queue backed by a channel, the one used when Config.Queue is nil
+func ChannelQueue(capacity int, _ func(interface{})) Queue {
+	return &channelQueue{c: make(chan interface{}, capacity), closed: make(chan struct{})}
+}
+
+type channelQueue struct {
+	c      chan interface{}
+	mu     sync.RWMutex
+	closed chan struct{}
+	once   sync.Once
+}
+
+func (q *channelQueue) Push(ctx context.Context, v interface{}) error {
+	// Close waits for the pushes in progress before closing c
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+	select {
+	case <-q.closed:
+		return ErrQueueClosed
+	default:
+	}
+	select {
+	case q.c <- v:
+		return nil
+	default:
+	}
+	select {
+	case q.c <- v:
+		return nil
+	case <-q.closed:
+		return ErrQueueClosed
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (q *channelQueue) Pop(ctx context.Context) (interface{}, bool, error) {
+	select {
+	case v, ok := <-q.c:
+		return v, ok, nil
+	case <-ctx.Done():
+		return nil, true, ctx.Err()
+	}
+}
+
+func (q *channelQueue) Len() int {
+	return len(q.c)
+}
+
+func (q *channelQueue) Close() {
+	q.once.Do(func() {
+		close(q.closed)
+		q.mu.Lock()
+		close(q.c)
+		q.mu.Unlock()
+	})
+}
+
+// DropOldestQueue is a Queue which evicts its oldest value to make room for a new one
+// as the DropOldest OverflowPolicy does
+func DropOldestQueue(capacity int, evict func(interface{})) Queue {
+	return newBufferQueue(capacity, &fifo{}, evict)
+}
+
+// PriorityQueue returns a QueueFactory of queues which give the stage their most urgent
+// event first, less reports whether the payload a is more urgent than b
+// the order of the events as urgent as each other is kept, a full queue makes the pushes wait
+// the payloads are the encoded ones if the stage has a Codec
+func PriorityQueue(less func(a, b interface{}) bool) QueueFactory {
+	return func(capacity int, _ func(interface{})) Queue {
+		return newBufferQueue(capacity, &priorityItems{less: less}, nil)
+	}
+}
+
+// items is the order a bufferQueue keeps its values in
+type items interface {
+	len() int
+	add(v interface{})
+	take() interface{}
+}
+
+// laned items give each kind of value its own capacity
+type laned interface {
+	laneLen(v interface{}) int
+}
+
+// bufferQueue is a bounded Queue whose pushes evict the value taken first if evict is set,
+// or wait for room otherwise
+type bufferQueue struct {
+	mu       sync.Mutex
+	items    items
+	capacity int
+	evict    func(interface{})
+	closed   bool
+	// closed and replaced when a value is added or taken, or the queue is closed
+	changed chan struct{}
+}
+
+func newBufferQueue(capacity int, it items, evict func(interface{})) *bufferQueue {
+	if capacity < 1 {
+		capacity = 1
+	}
+	return &bufferQueue{items: it, capacity: capacity, evict: evict, changed: make(chan struct{})}
+}
+
+// notify wakes the pushes and pops waiting, q.mu must be held
+func (q *bufferQueue) notify() {
+	close(q.changed)
+	q.changed = make(chan struct{})
+}
+
+func (q *bufferQueue) Push(ctx context.Context, v interface{}) error {
+	for {
+		q.mu.Lock()
+		if q.closed {
+			q.mu.Unlock()
+			return ErrQueueClosed
+		}
+		var evicted interface{}
+		if q.full(v) && q.evict != nil {
+			evicted = q.items.take()
+		}
+		if !q.full(v) {
+			q.items.add(v)
+			q.notify()
+			q.mu.Unlock()
+			if evicted != nil {
+				q.evict(evicted)
+			}
+			return nil
+		}
+		changed := q.changed
+		q.mu.Unlock()
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// full reports whether there's no room for v, q.mu must be held
+func (q *bufferQueue) full(v interface{}) bool {
+	if l, ok := q.items.(laned); ok {
+		return l.laneLen(v) >= q.capacity
+	}
+	return q.items.len() >= q.capacity
+}
+
+func (q *bufferQueue) Pop(ctx context.Context) (interface{}, bool, error) {
+	for {
+		q.mu.Lock()
+		if q.items.len() > 0 {
+			v := q.items.take()
+			q.notify()
+			q.mu.Unlock()
+			return v, true, nil
+		}
+		if q.closed {
+			q.mu.Unlock()
+			return nil, false, nil
+		}
+		changed := q.changed
+		q.mu.Unlock()
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return nil, true, ctx.Err()
+		}
+	}
+}
+
+func (q *bufferQueue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.items.len()
+}
+
+func (q *bufferQueue) Close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if !q.closed {
+		q.closed = true
+		q.notify()
+	}
+}
+
+// fifo takes the values in the order they were added
+type fifo struct {
+	values []interface{}
+}
+
+func (f *fifo) len() int { return len(f.values) }
+
+func (f *fifo) add(v interface{}) { f.values = append(f.values, v) }
+
+func (f *fifo) take() interface{} {
+	v := f.values[0]
+	f.values[0] = nil
+	f.values = f.values[1:]
+	return v
+}
+
+// priorityItems takes the most urgent payload first, then the one added first
+type priorityItems struct {
+	less   func(a, b interface{}) bool
+	values []prioritized
+	added  uint64
+}
+
+type prioritized struct {
+	v     interface{}
+	order uint64
+}
+
+func (p *priorityItems) len() int { return len(p.values) }
+
+func (p *priorityItems) add(v interface{}) {
+	p.added++
+	heap.Push((*priorityHeap)(p), prioritized{v: v, order: p.added})
+}
+
+func (p *priorityItems) take() interface{} {
+	return heap.Pop((*priorityHeap)(p)).(prioritized).v
+}
+
+// priorityHeap implements heap.Interface for priorityItems
+type priorityHeap priorityItems
+
+func (h *priorityHeap) Len() int { return len(h.values) }
+
+func (h *priorityHeap) Less(i, j int) bool {
+	a, b := payloadOf(h.values[i].v), payloadOf(h.values[j].v)
+	if h.less(a, b) {
+		return true
+	}
+	if h.less(b, a) {
+		return false
+	}
+	return h.values[i].order < h.values[j].order
+}
+
+func (h *priorityHeap) Swap(i, j int) { h.values[i], h.values[j] = h.values[j], h.values[i] }
+
+func (h *priorityHeap) Push(x interface{}) { h.values = append(h.values, x.(prioritized)) }
+
+func (h *priorityHeap) Pop() interface{} {
+	n := len(h.values) - 1
+	x := h.values[n]
+	h.values[n] = prioritized{}
+	h.values = h.values[:n]
+	return x
+}
+
+// payloadOf returns the payload of the event v held by a queue
+func payloadOf(v interface{}) interface{} {
+	if env, ok := v.(*envelope); ok {
+		return env.payload
+	}
+	return v
+}
+
+// queuedEdge feeds a stage from its Config.Queue, the stage reads its unbuffered out channel
+type queuedEdge struct {
+	q   Queue
+	out chan *envelope
+	// closed once the stage has stopped, the pump gives up the event it holds
+	abandon chan struct{}
+	done    chan struct{}
+	// the event the pump held when it was abandoned
+	held atomic.Int64
+}
+
+// newQueuedEdge makes the queue in front of lw and starts pumping it
+func (s *GoStage) newQueuedEdge(lw *linkedWorker) *queuedEdge {
+	q := lw.Queue(lw.BufferSize, func(v interface{}) {
+		env := v.(*envelope)
+		lw.stats.unqueued.Add(1)
+		lw.bytes.release(env.size)
+		s.drop(lw, env)
+	})
+	if _, it := sourceItemsOf(q); it != nil {
+		it.name = s.sourceName
+	}
+	return pumpQueue(q)
+}
+
+// pumpQueue starts pumping q into the out channel of a queuedEdge
+func pumpQueue(q Queue) *queuedEdge {
+	e := &queuedEdge{
+		q:       q,
+		out:     make(chan *envelope),
+		abandon: make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	go e.pump()
+	return e
+}
+
+// pump moves the events from the queue to out, it closes out once the queue is closed and empty
+func (e *queuedEdge) pump() {
+	defer close(e.done)
+	defer close(e.out)
+	ctx := abortContext{e.abandon}
+	for {
+		v, ok, err := e.q.Pop(ctx)
+		if !ok || err != nil {
+			return
+		}
+		select {
+		case e.out <- v.(*envelope):
+		case <-e.abandon:
+			e.held.Store(1)
+			return
+		}
+	}
+}
+
+// stop waits for the pump once the stage is stopped, the events left stay in the queue
+func (e *queuedEdge) stop() {
+	close(e.abandon)
+	<-e.done
+}
+
+// sendQueued pushes env from inst to the queue of next, returns false if inst was aborted
+func (s *GoStage) sendQueued(next *linkedWorker, inst *instance, env *envelope) bool {
+	if !next.bytes.acquire(env.size, inst.abort) {
+		s.leave(lostInFlight)
+		env.free()
+		return false
+	}
+	next.stats.enqueued.Add(1)
+	err := next.queue.q.Push(abortContext{inst.abort}, env)
+	if err == nil {
+		return true
+	}
+	next.stats.enqueued.Add(-1)
+	next.bytes.release(env.size)
+	if errors.Is(err, ErrDropped) {
+		s.drop(next, env)
+		return true
+	}
+	s.leave(lostInFlight)
+	env.free()
+	return false
+}
+
+// queued returns the number of events waiting in front of lw
+func (lw *linkedWorker) queued() int {
+	if lw.queue != nil {
+		return len(lw.in) + lw.queue.q.Len() + int(lw.queue.held.Load())
+	}
+	return len(lw.in)
+}
+
+// closeInput ends the input of the stage after stage i, once the events in it are taken
+func (s *GoStage) closeInput(i int) {
+	if next := s.linkedWorkers[s.next(i)]; next.queue != nil {
+		next.queue.q.Close()
+		return
+	}
+	close(s.linkedWorkers[i].out)
+}
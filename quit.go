@@ -0,0 +1,44 @@
+package gostage
+
+import (
+	"fmt"
+	"strings"
+)
+
+// QuitPolicy decides when ErrQuit returned by the producers stops the pipeline
+type QuitPolicy int
+
+const (
+	// QuitAny stops the pipeline once any producer returns ErrQuit
+	QuitAny QuitPolicy = iota
+	// QuitAll keeps the pipeline running until every producer has returned ErrQuit
+	QuitAll
+)
+
+// WithQuitPolicy sets when the producers' ErrQuit stops the pipeline, default is QuitAny
+func WithQuitPolicy(p QuitPolicy) Option {
+	return func(gs *GoStage) {
+		gs.quitPolicy = p
+	}
+}
+
+// rootQuit marks the producer lw as finished, its other instances stop producing
+// returns the error which stops the pipeline, nil if it should keep running
+func (s *GoStage) rootQuit(lw *linkedWorker) error {
+	lw.finished.Store(true)
+
+	var finished []string
+	for i := 0; i < s.producers; i++ {
+		if s.linkedWorkers[i].finished.Load() {
+			finished = append(finished, s.linkedWorkers[i].Name)
+		}
+	}
+	if s.quitPolicy == QuitAll && len(finished) < s.producers {
+		s.logger.Info("%s finished, waiting for the other producers", lw.Name)
+		return nil
+	}
+	if s.producers == 1 {
+		return ErrQuit
+	}
+	return fmt.Errorf("%w: %s finished", ErrQuit, strings.Join(finished, ", "))
+}
@@ -0,0 +1,213 @@
+package gostage
+
+import (
+	"bufio"
+	"context"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// RecordedEvent is an event emitted by a producer during a run made WithRecording
+type RecordedEvent struct {
+	// the ID of the event, see EventIDFromContext
+	ID       string
+	Producer string
+	// when the producer emitted the event
+	Time    time.Time
+	Payload interface{}
+}
+
+// recordedEvent is how an event is stored, the payload is encoded by the recording's codec
+type recordedEvent struct {
+	ID uint64
+	// the ID given by the IDGenerator, if any
+	Name     string
+	Producer string
+	Time     time.Time
+	Payload  []byte
+}
+
+// WithRecording writes the events emitted by the producers to the file at path,
+// to be replayed by ReplayProducer, the payloads are encoded by the codec set with
+// WithCodec, default is GobCodec, the file is truncated by every run
+func WithRecording(path string) Option {
+	return func(gs *GoStage) {
+		gs.recordingPath = path
+	}
+}
+
+// recorder writes the events of a run
+type recorder struct {
+	mu    sync.Mutex
+	codec Codec
+	file  *os.File
+	buf   *bufio.Writer
+	enc   *gob.Encoder
+}
+
+// startRecording creates the recording of a run, s.mu must be held
+func (s *GoStage) startRecording() error {
+	if s.recordingPath == "" {
+		return nil
+	}
+	f, err := os.Create(s.recordingPath)
+	if err != nil {
+		return fmt.Errorf("recording: %w", err)
+	}
+	codec := s.codec
+	if codec == nil {
+		codec = GobCodec{}
+	}
+	buf := bufio.NewWriter(f)
+	s.recorder = &recorder{codec: codec, file: f, buf: buf, enc: gob.NewEncoder(buf)}
+	return nil
+}
+
+// record writes env emitted by the producer lw
+func (s *GoStage) record(lw *linkedWorker, env *envelope) {
+	r := s.recorder
+	data, err := r.codec.Encode(env.payload)
+	if err == nil {
+		id := s.eventID(env)
+		r.mu.Lock()
+		err = r.enc.Encode(recordedEvent{ID: id, Name: s.events.name(id), Producer: lw.Name, Time: s.clock.Now(), Payload: data})
+		r.mu.Unlock()
+	}
+	if err != nil {
+		s.logger.Error("%s recording: %v", lw.Name, err)
+	}
+}
+
+// stopRecording flushes and closes the recording, once all workers have stopped
+func (s *GoStage) stopRecording() {
+	r := s.recorder
+	if r == nil {
+		return
+	}
+	s.recorder = nil
+	err := r.buf.Flush()
+	if cerr := r.file.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		s.logger.Error("recording: %v", err)
+	}
+}
+
+// ReplayOption configures a ReplayProducer
+type ReplayOption func(*replayer)
+
+// WithReplayCodec sets the codec the recording was made with, default is GobCodec
+func WithReplayCodec(c Codec) ReplayOption {
+	return func(r *replayer) {
+		r.codec = c
+	}
+}
+
+// replayer emits the events of a recording, then returns ErrQuit
+type replayer struct {
+	codec Codec
+	speed float64
+	file  *os.File
+	dec   *gob.Decoder
+	// the time of the last event emitted and when it was emitted
+	last, emitted time.Time
+}
+
+// ReplayProducer creates a producer emitting the events of a recording made WithRecording
+// speed 1 keeps the recorded intervals between the events, 2 halves them and
+// 0 emits the events as fast as possible, the intervals are waited on the pipeline's
+// Clock and given up when its context is done or the stage is interrupted
+func ReplayProducer(name, path string, speed float64, opts ...ReplayOption) (*Config, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	r := &replayer{codec: GobCodec{}, speed: speed, file: f, dec: gob.NewDecoder(bufio.NewReader(f))}
+	for _, opt := range opts {
+		opt(r)
+	}
+	return &Config{Name: name, Size: 1, Worker: r, Role: Source}, nil
+}
+
+// HandleEvent implements the Worker
+func (r *replayer) HandleEvent(in interface{}) (interface{}, error) {
+	return r.HandleEventContext(context.Background(), in)
+}
+
+// HandleEventContext implements the ContextWorker
+func (r *replayer) HandleEventContext(ctx context.Context, _ interface{}) (interface{}, error) {
+	var rec recordedEvent
+	if err := r.dec.Decode(&rec); err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, ErrQuit
+		}
+		return nil, err
+	}
+	clock := clockFromContext(ctx)
+	if r.speed > 0 && !r.last.IsZero() {
+		wait := time.Duration(float64(rec.Time.Sub(r.last)) / r.speed)
+		if d := r.emitted.Add(wait).Sub(clock.Now()); d > 0 {
+			stop, _ := StopTokenFromContext(ctx)
+			select {
+			case <-clock.After(d):
+			case <-ctx.Done():
+				return nil, ErrInterrupted
+			case <-stop.Done():
+				return nil, ErrInterrupted
+			}
+		}
+	}
+	r.last, r.emitted = rec.Time, clock.Now()
+	return r.codec.Decode(rec.Payload)
+}
+
+// Close closes the recording
+func (r *replayer) Close() {
+	r.file.Close()
+}
+
+// ReadRecording returns the events of a recording made WithRecording
+// c is the codec the recording was made with, nil means GobCodec
+func ReadRecording(path string, c Codec) ([]RecordedEvent, error) {
+	if c == nil {
+		c = GobCodec{}
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var events []RecordedEvent
+	dec := gob.NewDecoder(bufio.NewReader(f))
+	for {
+		var rec recordedEvent
+		if err := dec.Decode(&rec); err != nil {
+			if errors.Is(err, io.EOF) {
+				return events, nil
+			}
+			return events, err
+		}
+		v, err := c.Decode(rec.Payload)
+		if err != nil {
+			return events, err
+		}
+		id := rec.Name
+		if id == "" {
+			id = strconv.FormatUint(rec.ID, 10)
+		}
+		events = append(events, RecordedEvent{
+			ID:       id,
+			Producer: rec.Producer,
+			Time:     rec.Time,
+			Payload:  v,
+		})
+	}
+}
@@ -0,0 +1,54 @@
+package gostage
+
+import "sync"
+
+// Reducer is a terminal worker which folds every event into an accumulator
+type Reducer struct {
+	fn func(acc, in interface{}) (interface{}, error)
+
+	mu        sync.Mutex
+	acc       interface{}
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// Reduce creates a terminal stage which folds the events into seed with fn
+// if fn returns an error the accumulator is kept and the error is handled
+// like any other HandleEvent error
+// the accumulator lives in a single instance, so the stage's Size must stay 1
+// set SubscribeTo on the returned Config to link it to the pipeline
+func Reduce(name string, seed interface{}, fn func(acc, in interface{}) (interface{}, error)) (*Config, *Reducer) {
+	r := &Reducer{
+		fn:   fn,
+		acc:  seed,
+		done: make(chan struct{}),
+	}
+	return &Config{Name: name, Size: 1, Worker: r}, r
+}
+
+// HandleEvent folds in into the accumulator
+func (r *Reducer) HandleEvent(in interface{}) (interface{}, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	acc, err := r.fn(r.acc, in)
+	if err != nil {
+		return nil, err
+	}
+	r.acc = acc
+	return nil, nil
+}
+
+// Close is called by the pipeline when the stage stops
+func (r *Reducer) Close() {
+	r.closeOnce.Do(func() { close(r.done) })
+}
+
+// Result blocks until the stage has stopped and returns the final accumulator
+func (r *Reducer) Result() interface{} {
+	<-r.done
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.acc
+}
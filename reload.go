@@ -0,0 +1,281 @@
+package gostage
+
+import (
+	"errors"
+	"os"
+	"os/signal"
+	"reflect"
+	"sync"
+	"sync/atomic"
+)
+
+// ErrReloading is the reason the topology of a run stops when it's replaced by a reload
+var ErrReloading = errors.New("reloading")
+
+// ErrNoReload if Reload is called on a pipeline without WithReload
+var ErrNoReload = errors.New("no reload configured")
+
+// WithReload reloads the pipeline run by Run or RunAsync when the process gets sig, e.g. SIGHUP:
+// rebuild returns the new configs and the options to apply, the new topology is validated,
+// then the producers are stopped, the events in flight drained and the stages stopped,
+// and the pipeline is started again with the new configs, the workers of the stages whose
+// config hasn't changed are kept as they are, the others are closed and made again
+// the running topology is kept if rebuild fails or the new one is invalid, and it's started
+// again if the new one fails to start, see the Reload lifecycle events and Stats.Reloads
+// the stats of a run start over after a reload, the pushes are refused while it happens
+func WithReload(sig os.Signal, rebuild func() ([]*Config, []Option, error)) Option {
+	return func(gs *GoStage) {
+		gs.reloader = &reloader{sig: sig, rebuild: rebuild}
+	}
+}
+
+// reloader swaps the topology of the pipeline, see WithReload
+type reloader struct {
+	sig     os.Signal
+	rebuild func() ([]*Config, []Option, error)
+	// asks for a reload, from the signal or Reload
+	requests chan struct{}
+	reloads  atomic.Int64
+	failures atomic.Int64
+
+	// the workers of the unchanged stages by instance, from their stop to the next run
+	mu   sync.Mutex
+	kept map[string][]Worker
+}
+
+// Reload reloads the pipeline run by Run or RunAsync as if it got the signal of WithReload,
+// it doesn't wait for the reload
+func (s *GoStage) Reload() error {
+	if s.reloader == nil {
+		return ErrNoReload
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.requireRunning(); err != nil {
+		return err
+	}
+	select {
+	case s.reloader.requests <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// watchReload returns the channel the reloads are asked on, nil without WithReload,
+// and the func which stops watching the signal
+func (s *GoStage) watchReload() (<-chan struct{}, func()) {
+	r := s.reloader
+	if r == nil {
+		return nil, func() {}
+	}
+	r.requests = make(chan struct{}, 1)
+	if r.sig == nil {
+		return r.requests, func() {}
+	}
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, r.sig)
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-signals:
+				select {
+				case r.requests <- struct{}{}:
+				default:
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+	return r.requests, func() {
+		signal.Stop(signals)
+		close(done)
+	}
+}
+
+// serve waits until the run stops, reloading the topology when asked on reloads, then finishes it
+func (s *GoStage) serve(signals chan os.Signal, reloads <-chan struct{}, fn func()) {
+	for s.wait(signals, reloads) {
+		if stopped := s.reload(); stopped {
+			break
+		}
+	}
+	s.finish(fn)
+}
+
+// reload swaps the topology of the running pipeline for the one rebuilt,
+// returns true if the pipeline was stopped meanwhile and the run must be finished
+func (s *GoStage) reload() bool {
+	r := s.reloader
+	s.lifecycle(ReloadStarted, "", "reload started")
+	configs, opts, err := r.rebuild()
+	if err == nil {
+		err = validateReload(s, configs, opts)
+	}
+	if err != nil {
+		r.failures.Add(1)
+		s.logger.Error("gostage reload: %v", err)
+		s.lifecycle(ReloadFailed, "", "reload failed, the running topology is kept: %v", err)
+		return false
+	}
+
+	s.drain(nil)
+	select {
+	case <-s.ctx.Done():
+		s.setReason(s.ctx.Err())
+	case <-s.stopRequest:
+		s.setReason(ErrStopped)
+	default:
+	}
+	if err := s.Reason(); err != nil {
+		s.lifecycle(ReloadFailed, "", "reload abandoned: %v", err)
+		return true
+	}
+
+	old := s.configs
+	s.keepUnchanged(configs)
+	s.setReason(ErrReloading)
+	s.state.Store(int32(StateStopping))
+	s.ensureAllWorkerStopped()
+	s.state.Store(int32(StateStopped))
+
+	s.mu.Lock()
+	for _, opt := range opts {
+		opt(s)
+	}
+	s.configs = configs
+	s.mu.Unlock()
+	if err := s.run(nil); err != nil {
+		r.failures.Add(1)
+		s.logger.Error("gostage reload: %v", err)
+		s.lifecycle(ReloadFailed, "", "reload failed, the new topology didn't start, the previous one is started again: %v", err)
+		s.mu.Lock()
+		s.configs = old
+		s.mu.Unlock()
+		if err := s.run(nil); err != nil {
+			s.logger.Error("gostage can't start the previous topology again: %v", err)
+			return true
+		}
+		return false
+	}
+	r.reloads.Add(1)
+	s.lifecycle(Reloaded, "", "reloaded: %d stages: %s", len(s.linkedWorkers), s.describeStages())
+	return false
+}
+
+// validateReload checks the configs and options rebuilt for a reload of s
+func validateReload(s *GoStage, configs []*Config, opts []Option) error {
+	probe := New(s.ctx, configs, s.logger)
+	// the options are applied over the ones of the pipeline
+	probe.allowSharedWorker = s.allowSharedWorker
+	for _, opt := range opts {
+		opt(probe)
+	}
+	return probe.validate()
+}
+
+// keepUnchanged marks the stages whose config is the same in configs,
+// their workers aren't closed when they stop and are run again by the next run
+func (s *GoStage) keepUnchanged(configs []*Config) {
+	byName := make(map[string]*Config, len(configs))
+	for _, c := range configs {
+		byName[c.Name] = c
+	}
+	s.reloader.kept = make(map[string][]Worker)
+	for _, lw := range s.linkedWorkers {
+		if c := byName[lw.Name]; c != nil && sameConfig(lw.current(), c) {
+			lw.keep.Store(true)
+		}
+	}
+}
+
+// sameConfig returns true if a and b have the same fields, the workers are the same values
+func sameConfig(a, b *Config) bool {
+	va, vb := reflect.ValueOf(a).Elem(), reflect.ValueOf(b).Elem()
+	for k := 0; k < va.NumField(); k++ {
+		if !sameField(va.Field(k), vb.Field(k)) {
+			return false
+		}
+	}
+	return true
+}
+
+// keptWorker records the worker of an exiting instance of a kept stage,
+// returns false if the stage isn't kept
+func (s *GoStage) keptWorker(inst *instance) bool {
+	lw := inst.lw
+	if !lw.keep.Load() {
+		return false
+	}
+	r := s.reloader
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	kept := r.kept[lw.Name]
+	for len(kept) <= inst.n {
+		kept = append(kept, nil)
+	}
+	kept[inst.n] = inst.w
+	r.kept[lw.Name] = kept
+	return true
+}
+
+// reused takes the worker kept by a reload for the instance n of lw, nil if none
+func (s *GoStage) reused(lw *linkedWorker, n int) Worker {
+	r := s.reloader
+	if r == nil {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	kept := r.kept[lw.Name]
+	if n >= len(kept) {
+		return nil
+	}
+	w := kept[n]
+	kept[n] = nil
+	return w
+}
+
+// closeUnused closes the kept workers the run didn't take, e.g. if it has fewer instances
+func (s *GoStage) closeUnused() {
+	r := s.reloader
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	kept := r.kept
+	r.kept = nil
+	r.mu.Unlock()
+	seen := make(map[uintptr]bool)
+	for _, lw := range s.linkedWorkers {
+		for _, w := range lw.prepared {
+			if v := reflect.ValueOf(w); v.Kind() == reflect.Ptr {
+				seen[v.Pointer()] = true
+			}
+		}
+	}
+	for _, workers := range kept {
+		for _, w := range workers {
+			if w == nil {
+				continue
+			}
+			// the instances of a stage may share their worker, which may be still run
+			if v := reflect.ValueOf(w); v.Kind() == reflect.Ptr {
+				if seen[v.Pointer()] {
+					continue
+				}
+				seen[v.Pointer()] = true
+			}
+			s.callWorkerClose(w)
+		}
+	}
+}
+
+// reloadStats returns the number of reloads done and failed
+func (s *GoStage) reloadStats() (int64, int64) {
+	if s.reloader == nil {
+		return 0, 0
+	}
+	return s.reloader.reloads.Load(), s.reloader.failures.Load()
+}
@@ -0,0 +1,220 @@
+package gostage
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/bits"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"text/tabwriter"
+	"time"
+)
+
+// StageReport sums up what a stage did in a run
+type StageReport struct {
+	Name string `json:"name"`
+	// the events the stage received, zero for producers
+	In int64 `json:"in"`
+	// the events the stage passed on, or handled without error if it's the terminal stage
+	Out int64 `json:"out"`
+	// the errors returned by HandleEvent
+	Errors int64 `json:"errors"`
+	// the retries of the events whose HandleEvent failed
+	Retries int64 `json:"retries"`
+	// the events the stage discarded and reported, expired, dropped or undecodable ones
+	DeadLettered int64 `json:"dead_lettered"`
+	// the events discarded because the stage's buffer was full
+	Dropped int64 `json:"dropped"`
+	// the restarts after panics
+	Restarts int64 `json:"restarts"`
+	// 95% of the HandleEvent calls took at most P95, rounded up to a quarter of its power of two
+	// only measured with WithTiming
+	P95 time.Duration `json:"p95_ns"`
+	// the total time spent in HandleEvent by all instances, only measured with WithTiming
+	BusyTime time.Duration `json:"busy_time_ns"`
+}
+
+// Report sums up a run, see GoStage.Report, its stages are in the order of Stats
+type Report struct {
+	Stages []StageReport `json:"stages"`
+	// how long the run lasted, so far if it's still running
+	WallTime time.Duration `json:"wall_time_ns"`
+	// why the run stopped, nil if it's still running
+	Reason error `json:"-"`
+	// what happened to the produced events
+	Accounting Accounting `json:"accounting"`
+	// the metadata of the pipeline, see WithMetadata
+	Metadata map[string]string `json:"metadata,omitempty"`
+}
+
+// MarshalJSON encodes the durations in nanoseconds and as strings, e.g. "p95_ns": 1500000 and "p95": "1.5ms"
+func (r StageReport) MarshalJSON() ([]byte, error) {
+	type plain StageReport
+	return json.Marshal(struct {
+		plain
+		P95      string `json:"p95"`
+		BusyTime string `json:"busy_time"`
+	}{plain(r), r.P95.String(), r.BusyTime.String()})
+}
+
+// MarshalJSON encodes the wall time like StageReport.MarshalJSON and the reason as its message
+func (r Report) MarshalJSON() ([]byte, error) {
+	type plain Report
+	var reason string
+	if r.Reason != nil {
+		reason = r.Reason.Error()
+	}
+	return json.Marshal(struct {
+		plain
+		WallTime string `json:"wall_time"`
+		Reason   string `json:"reason,omitempty"`
+	}{plain(r), r.WallTime.String(), reason})
+}
+
+// String renders the report as a table, one stage per line
+func (r Report) String() string {
+	var b strings.Builder
+	w := tabwriter.NewWriter(&b, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "stage\tin\tout\terrors\tretries\tdead-lettered\tdropped\trestarts\tp95\tbusy\t")
+	for _, st := range r.Stages {
+		fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%d\t%d\t%d\t%d\t%v\t%v\t\n",
+			st.Name, st.In, st.Out, st.Errors, st.Retries, st.DeadLettered, st.Dropped, st.Restarts, st.P95, st.BusyTime)
+	}
+	w.Flush()
+	reason := "still running"
+	if r.Reason != nil {
+		reason = "stopped: " + r.Reason.Error()
+	}
+	fmt.Fprintf(&b, "wall time %v, %s, produced %d, completed %d\n", r.WallTime, reason, r.Accounting.Produced, r.Accounting.Completed)
+	if len(r.Metadata) > 0 {
+		keys := make([]string, 0, len(r.Metadata))
+		for k := range r.Metadata {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for k, key := range keys {
+			keys[k] = key + "=" + r.Metadata[key]
+		}
+		fmt.Fprintf(&b, "metadata %s\n", strings.Join(keys, " "))
+	}
+	return b.String()
+}
+
+// Report returns the summary of the current run, or of the last one once it has
+// stopped, it's frozen when the workers have stopped
+func (s *GoStage) Report() Report {
+	s.mu.Lock()
+	if s.State() == StateStopped {
+		defer s.mu.Unlock()
+		return s.report
+	}
+	s.mu.Unlock()
+	return s.makeReport()
+}
+
+// makeReport sums up the run from the stages' counters
+func (s *GoStage) makeReport() Report {
+	stats := s.Stats()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r := Report{
+		Stages:     make([]StageReport, 0, len(stats.Stages)),
+		Reason:     s.reason,
+		Accounting: stats.Accounting,
+		Metadata:   copyMetadata(s.metadata),
+	}
+	if !s.startedAt.IsZero() {
+		r.WallTime = s.clock.Now().Sub(s.startedAt)
+	}
+	for k, st := range stats.Stages {
+		lw := s.linkedWorkers[k]
+		r.Stages = append(r.Stages, StageReport{
+			Name:         st.Name,
+			In:           lw.stats.in.Load(),
+			Out:          lw.stats.out.Load(),
+			Errors:       st.Errors,
+			Retries:      lw.stats.retries.Load(),
+			DeadLettered: lw.stats.deadLettered.Load(),
+			Dropped:      st.Dropped,
+			Restarts:     st.Restarts,
+			P95:          lw.stats.latency.quantile(0.95),
+			BusyTime:     time.Duration(lw.stats.busyTime.Load()),
+		})
+	}
+	return r
+}
+
+// WithTiming measures how long every HandleEvent call takes for the P95 and
+// BusyTime of the Report, it costs two clock reads per call so it's off by default
+func WithTiming() Option {
+	return func(gs *GoStage) {
+		gs.timing = true
+	}
+}
+
+// time records a HandleEvent call of lw which started at start and returned *err
+func (s *GoStage) time(lw *linkedWorker, start time.Time, err *error) {
+	d := s.clock.Now().Sub(start)
+	lw.stats.busyTime.Add(int64(d))
+	// a producer without data hasn't handled an event
+	if lw.role != Source || *err == nil || !noData(*err) {
+		lw.stats.latency.record(d)
+	}
+}
+
+// histogramBuckets splits every power of two in 4 buckets, up to 2^63ns
+const histogramBuckets = 4 + 62*4
+
+// histogram counts durations in buckets of a quarter of their power of two
+type histogram struct {
+	counts [histogramBuckets]atomic.Int64
+}
+
+func (h *histogram) record(d time.Duration) {
+	if d < 0 {
+		d = 0
+	}
+	h.counts[bucketOf(uint64(d))].Add(1)
+}
+
+// bucketOf returns the bucket of v, v in [2^e, 2^(e+1)) falls in the bucket
+// of its 2 bits after the leading one
+func bucketOf(v uint64) int {
+	if v < 4 {
+		return int(v)
+	}
+	e := bits.Len64(v) - 1
+	return 4 + (e-2)*4 + int(v>>uint(e-2)) - 4
+}
+
+// upperBound returns the largest duration of bucket k
+func upperBound(k int) time.Duration {
+	if k < 4 {
+		return time.Duration(k)
+	}
+	e, sub := (k-4)/4+2, (k-4)%4
+	return time.Duration((uint64(4+sub+1) << uint(e-2)) - 1)
+}
+
+// quantile returns the upper bound of the bucket holding the q quantile, 0 if nothing was recorded
+func (h *histogram) quantile(q float64) time.Duration {
+	var counts [histogramBuckets]int64
+	var total int64
+	for k := range h.counts {
+		counts[k] = h.counts[k].Load()
+		total += counts[k]
+	}
+	if total == 0 {
+		return 0
+	}
+	rank := int64(q*float64(total) + 0.999999)
+	var seen int64
+	for k, n := range counts {
+		if seen += n; seen >= rank {
+			return upperBound(k)
+		}
+	}
+	return upperBound(histogramBuckets - 1)
+}
@@ -0,0 +1,60 @@
+package gostage
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrRetriesExhausted if an event still failed after Config.Retries retries
+var ErrRetriesExhausted = errors.New("retries exhausted")
+
+// ErrorMode decides what a consumer does when HandleEvent returns an error
+// an event the consumer gives up on is dead-lettered and never forwarded downstream
+type ErrorMode int
+
+const (
+	// Drop dead-letters the event and moves on to the next one
+	Drop ErrorMode = iota
+	// RetryForever retries the event until it succeeds, the stage doesn't
+	// take the next event meanwhile so the back-pressure reaches the upstream
+	RetryForever
+	// RetryN retries the event Config.Retries times, then moves on
+	// and reports ErrRetriesExhausted
+	RetryN
+)
+
+// stallWarning logs a warning every stallWarning failed attempts of an event
+const stallWarning = 10
+
+func (lw *linkedWorker) retryBackoff(attempt int) time.Duration {
+	if lw.RetryBackoff != nil {
+		return lw.RetryBackoff(attempt)
+	}
+	if attempt > 7 {
+		return time.Second
+	}
+	return 10 * time.Millisecond << uint(attempt-1)
+}
+
+// waitRetry waits before retrying the event after the given failed attempt
+// returns false if the instance was asked to stop meanwhile
+func (s *GoStage) waitRetry(inst *instance, attempt int) bool {
+	lw := inst.lw
+	if attempt%stallWarning == 0 {
+		s.logger.Error("%s_#%d stalled, the event failed %d times: %+v", lw.Name, inst.n, attempt, inst.input())
+	}
+
+	select {
+	case <-inst.stop:
+		s.logger.Error("%s_#%d stopped retrying: %+v", lw.Name, inst.n, inst.input())
+		return false
+	default:
+	}
+	select {
+	case <-s.clock.After(lw.retryBackoff(attempt)):
+		return true
+	case <-inst.stop:
+		s.logger.Error("%s_#%d stopped retrying: %+v", lw.Name, inst.n, inst.input())
+		return false
+	}
+}
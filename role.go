@@ -0,0 +1,96 @@
+package gostage
+
+import "fmt"
+
+// Role is the place of a stage in the pipeline
+type Role int
+
+const (
+	// Auto infers the role from the stage's position in the pipeline
+	Auto Role = iota
+	// Source produces events, it can't subscribe to anything
+	Source
+	// Transform passes its outputs to the stage subscribed to it
+	Transform
+	// Sink is the terminal consumer, its outputs aren't passed on
+	Sink
+)
+
+func (r Role) String() string {
+	switch r {
+	case Auto:
+		return "auto"
+	case Source:
+		return "source"
+	case Transform:
+		return "transform"
+	case Sink:
+		return "sink"
+	}
+	return fmt.Sprintf("Role(%d)", int(r))
+}
+
+// the workers which can only be producers or terminal consumers
+type sourceWorker interface{ source() }
+type sinkWorker interface{ sink() }
+
+// declaredRole returns the role set by the config or implied by its worker
+func declaredRole(config *Config) (Role, error) {
+	implied := Auto
+	if _, ok := config.Worker.(sourceWorker); ok {
+		implied = Source
+	}
+	if _, ok := config.Worker.(sinkWorker); ok {
+		implied = Sink
+	}
+
+	switch {
+	case config.Role == Auto:
+		return implied, nil
+	case implied == Auto || implied == config.Role:
+		return config.Role, nil
+	}
+	return Auto, fmt.Errorf("%w: %s has the %s role but its worker is a %s", ErrMisplacedStage, config.Name, config.Role, implied)
+}
+
+// validateRoles checks the declared roles match the subscriptions
+func (s *GoStage) validateRoles() error {
+	for _, config := range s.configs {
+		role, err := declaredRole(config)
+		if err != nil {
+			return err
+		}
+
+		subscribed := config.SubscribeTo != nil || config.SubscribeToName != ""
+		subscribers := false
+		for _, other := range s.configs {
+			if other != config && s.findParent(other) == config {
+				subscribers = true
+				break
+			}
+		}
+
+		switch {
+		case role == Source && subscribed:
+			return fmt.Errorf("%w: %s is a source, it can't subscribe to anything", ErrMisplacedStage, config.Name)
+		case (role == Transform || role == Sink) && !subscribed:
+			return fmt.Errorf("%w: %s is a %s, it must subscribe to a stage", ErrMisplacedStage, config.Name, role)
+		case role == Sink && subscribers:
+			return fmt.Errorf("%w: %s is a sink, nothing can subscribe to it", ErrMisplacedStage, config.Name)
+		case role == Transform && !subscribers:
+			return fmt.Errorf("%w: %s is a transform, a stage must subscribe to it", ErrMisplacedStage, config.Name)
+		}
+	}
+	return nil
+}
+
+// role returns the role of stage i in the pipeline
+func (s *GoStage) role(i int) Role {
+	switch {
+	case i < s.producers:
+		return Source
+	case i == len(s.linkedWorkers)-1:
+		return Sink
+	}
+	return Transform
+}
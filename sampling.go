@@ -0,0 +1,61 @@
+package gostage
+
+import (
+	"math/rand"
+	"sync"
+)
+
+// WithDebugSampling logs the input and output of HandleEvent at Debug level for
+// the given fraction of the events, from 0 to 1, see Config.DebugSampling
+// an event is drawn once by its producer and followed across the stages by its ID
+func WithDebugSampling(rate float64) Option {
+	return func(gs *GoStage) {
+		gs.debugSampling = rate
+	}
+}
+
+// WithSamplingSource sets the source of the draws of WithDebugSampling, for reproducible samples
+func WithSamplingSource(src rand.Source) Option {
+	return func(gs *GoStage) {
+		gs.sampler = &sampler{rand: rand.New(src)}
+	}
+}
+
+// sampler draws the events, a rand.Rand isn't safe for concurrent use
+type sampler struct {
+	mu   sync.Mutex
+	rand *rand.Rand
+}
+
+func (sp *sampler) draw() float64 {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	return sp.rand.Float64()
+}
+
+// debugSampling returns the fraction of the events sampled by the stage
+func (lw *linkedWorker) debugSampling(s *GoStage) float64 {
+	if rate := lw.current().DebugSampling; rate != 0 {
+		return rate
+	}
+	return s.debugSampling
+}
+
+// sample draws env if any stage samples events, the stages with a higher rate
+// sample a superset of the events sampled by the stages with a lower one
+func (s *GoStage) sample(env *envelope) {
+	if s.sampling.Load() {
+		env.sample, env.drawn = s.sampler.draw(), true
+	}
+}
+
+// sampled returns true if lw logs env
+func (s *GoStage) sampled(lw *linkedWorker, env *envelope) bool {
+	return env.drawn && env.sample < lw.debugSampling(s)
+}
+
+// logSample logs the input and output of a sampled event
+func (s *GoStage) logSample(inst *instance, env *envelope, input, output interface{}, err error) {
+	s.logger.Debug("%s_#%d sampled event %s: input = %+v, output = %+v, err = %v",
+		inst.lw.Name, inst.n, s.eventName(s.eventID(env)), input, output, err)
+}
@@ -0,0 +1,134 @@
+package gostage
+
+import "sync"
+
+// defaultSequenceWindow is the window of WithSequenceCheck if none is given
+const defaultSequenceWindow = 1024
+
+// WithSequenceCheck makes the terminal stage check the sequence numbers
+// which every producer assigns to its events, counting the gaps and
+// duplicates in the producer's StageStats and logging them when the pipeline stops
+// window is how far out of order events can arrive, rounded up to a multiple of 64
+// default is 1024, an event arriving later than that is counted as a gap, then as Late
+// an event skipped with ErrNoData or dead-lettered by a failing stage counts as arrived
+func WithSequenceCheck(window int) Option {
+	return func(gs *GoStage) {
+		if window <= 0 {
+			window = defaultSequenceWindow
+		}
+		gs.sequenceWindow = uint64(window+63) / 64 * 64
+	}
+}
+
+// sequenceTracker tracks the sequence numbers of a producer's events received
+// by the terminal stage, within a sliding window of bits
+type sequenceTracker struct {
+	mu   sync.Mutex
+	bits []uint64
+	// every sequence number below base has been counted
+	base uint64
+
+	gaps       int64
+	duplicates int64
+	late       int64
+}
+
+func newSequenceTracker(window uint64) *sequenceTracker {
+	return &sequenceTracker{bits: make([]uint64, window/64)}
+}
+
+func (t *sequenceTracker) size() uint64 {
+	return uint64(len(t.bits)) * 64
+}
+
+func (t *sequenceTracker) bit(seq uint64) (word int, mask uint64) {
+	k := seq % t.size()
+	return int(k / 64), 1 << (k % 64)
+}
+
+// receive records seq as received
+func (t *sequenceTracker) receive(seq uint64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if seq < t.base {
+		t.late++
+		return
+	}
+	if seq >= t.base+t.size() {
+		t.slide(seq - t.size() + 1)
+	}
+	word, mask := t.bit(seq)
+	if t.bits[word]&mask != 0 {
+		t.duplicates++
+		return
+	}
+	t.bits[word] |= mask
+}
+
+// slide moves the window to start at base, counting the sequences left unseen
+func (t *sequenceTracker) slide(base uint64) {
+	n := base - t.base
+	for k := uint64(0); k < n && k < t.size(); k++ {
+		word, mask := t.bit(t.base + k)
+		if t.bits[word]&mask == 0 {
+			t.gaps++
+		}
+		t.bits[word] &^= mask
+	}
+	// the sequences beyond the window were never seen
+	if n > t.size() {
+		t.gaps += int64(n - t.size())
+	}
+	t.base = base
+}
+
+// finish counts the sequences below end which were never received
+func (t *sequenceTracker) finish(end uint64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if end > t.base {
+		t.slide(end)
+	}
+}
+
+func (t *sequenceTracker) counts() (gaps, duplicates, late int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.gaps, t.duplicates, t.late
+}
+
+// stamp assigns the next sequence number of producer i to env
+func (s *GoStage) stamp(i int, env *envelope) *envelope {
+	env.root = i
+	if s.sequenceWindow == 0 {
+		return env
+	}
+	env.seq = s.linkedWorkers[i].seq.Add(1) - 1
+	return env
+}
+
+// checkSequence records that env has reached the terminal stage
+func (s *GoStage) checkSequence(env *envelope) {
+	if s.sequenceWindow == 0 || env.root < 0 {
+		return
+	}
+	if t := s.linkedWorkers[env.root].sequence; t != nil {
+		t.receive(env.seq)
+	}
+}
+
+// finishSequences counts the events of every producer which never arrived
+// and logs the producers with gaps or duplicates
+func (s *GoStage) finishSequences() {
+	for i := 0; i < s.producers; i++ {
+		lw := s.linkedWorkers[i]
+		if lw.sequence == nil {
+			continue
+		}
+		lw.sequence.finish(lw.seq.Load())
+		if gaps, duplicates, late := lw.sequence.counts(); gaps+duplicates+late > 0 {
+			s.logger.Error("%s sequence check: %d produced, %d gaps, %d duplicates, %d late", lw.Name, lw.seq.Load(), gaps, duplicates, late)
+		}
+	}
+}
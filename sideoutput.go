@@ -0,0 +1,98 @@
+package gostage
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrInvalidSideOutput if the side outputs of a config can't be wired
+var ErrInvalidSideOutput = errors.New("invalid side output")
+
+// ErrUnknownSideOutput if a SideOutputWorker emitted to a label missing from Config.SideOutputs
+var ErrUnknownSideOutput = errors.New("unknown side output")
+
+// SideOutputWorker is a Worker with secondary results, e.g. metrics or anomalies,
+// HandleEventSide is called instead of HandleEvent, its output goes downstream as usual
+// and emit pushes v to the pipeline Config.SideOutputs maps label to, waiting while it has no room for it,
+// the pipeline is another GoStage run on its own, not a stage of this one; when it isn't running
+// (not started yet or stopped) v is dropped, counted in StageStats.SideDropped and logged,
+// and the event goes on downstream, emit must only be called during HandleEventSide
+// a ContextWorker's HandleEventContext is called instead
+type SideOutputWorker interface {
+	Worker
+	HandleEventSide(in interface{}, emit func(label string, v interface{})) (interface{}, error)
+}
+
+// SideHandler is a handy function type that implements SideOutputWorker
+type SideHandler func(in interface{}, emit func(label string, v interface{})) (interface{}, error)
+
+// HandleEvent implements the Worker, the side outputs are dropped
+func (sh SideHandler) HandleEvent(in interface{}) (interface{}, error) {
+	return sh(in, func(string, interface{}) {})
+}
+
+// HandleEventSide implements the SideOutputWorker
+func (sh SideHandler) HandleEventSide(in interface{}, emit func(label string, v interface{})) (interface{}, error) {
+	return sh(in, emit)
+}
+
+// UnknownSideOutput decides what happens to a side output whose label isn't in Config.SideOutputs
+type UnknownSideOutput int
+
+const (
+	// DropUnknownSideOutput drops it, it's counted in StageStats.SideDropped
+	DropUnknownSideOutput UnknownSideOutput = iota
+	// ReportUnknownSideOutput also reports ErrUnknownSideOutput as an error of the stage
+	ReportUnknownSideOutput
+)
+
+// WithUnknownSideOutput sets what happens to the side outputs of unknown labels, default is DropUnknownSideOutput
+func WithUnknownSideOutput(u UnknownSideOutput) Option {
+	return func(gs *GoStage) {
+		gs.unknownSideOutput = u
+	}
+}
+
+// validateSideOutputs checks that the side outputs are emitted by SideOutputWorkers to other pipelines
+func (s *GoStage) validateSideOutputs() error {
+	for _, config := range s.configs {
+		if len(config.SideOutputs) == 0 {
+			continue
+		}
+		if _, ok := config.Worker.(SideOutputWorker); !ok {
+			return fmt.Errorf("%w: %s sets SideOutputs but isn't a SideOutputWorker", ErrInvalidSideOutput, config.Name)
+		}
+		for label, target := range config.SideOutputs {
+			switch target {
+			case nil:
+				return fmt.Errorf("%w: %s has no pipeline for %q", ErrInvalidSideOutput, config.Name, label)
+			case s:
+				return fmt.Errorf("%w: %s emits %q to its own pipeline", ErrInvalidSideOutput, config.Name, label)
+			}
+		}
+	}
+	return nil
+}
+
+// sideEmitter returns the emit function passed by inst to HandleEventSide
+func (s *GoStage) sideEmitter(inst *instance) func(string, interface{}) {
+	lw := inst.lw
+	ctx := abortContext{inst.abort}
+	return func(label string, v interface{}) {
+		target, ok := lw.SideOutputs[label]
+		if !ok {
+			lw.stats.sideDropped.Add(1)
+			if s.unknownSideOutput == ReportUnknownSideOutput {
+				lw.stats.errors.Add(1)
+				s.reportError(lw, inst.n, v, fmt.Errorf("%w: %q", ErrUnknownSideOutput, label))
+			}
+			return
+		}
+		if err := target.Push(ctx, v); err != nil {
+			lw.stats.sideDropped.Add(1)
+			s.logWorkerError(lw, err, "%s_#%d side output %q lost: %v", lw.Name, inst.n, label, err)
+			return
+		}
+		lw.stats.sideOut.Add(1)
+	}
+}
@@ -0,0 +1,92 @@
+package gostage
+
+import "sync"
+
+// Sizer is implemented by events which know their size in bytes
+// the framework uses it to track and limit the bytes buffered in front of each stage
+type Sizer interface {
+	SizeBytes() int
+}
+
+// WithMaxBufferedBytes limits the bytes of the Sizer events buffered in front of
+// every stage which doesn't set its own Config.MaxBufferedBytes
+func WithMaxBufferedBytes(n int64) Option {
+	return func(gs *GoStage) {
+		gs.maxBufferedBytes = n
+	}
+}
+
+func sizeOf(payload interface{}) int64 {
+	if sizer, ok := payload.(Sizer); ok {
+		return int64(sizer.SizeBytes())
+	}
+	return 0
+}
+
+// byteBudget counts the bytes buffered in front of a stage
+// a single event is always let in when nothing is buffered, whatever its size
+type byteBudget struct {
+	max int64
+
+	mu   sync.Mutex
+	used int64
+	// closed and replaced whenever bytes are released
+	freed chan struct{}
+}
+
+func newByteBudget(max int64) *byteBudget {
+	return &byteBudget{max: max, freed: make(chan struct{})}
+}
+
+// tryAcquire reserves n bytes, returns false if they don't fit
+func (b *byteBudget) tryAcquire(n int64) bool {
+	if n == 0 {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.max > 0 && b.used > 0 && b.used+n > b.max {
+		return false
+	}
+	b.used += n
+	return true
+}
+
+// acquire reserves n bytes, waiting until they fit
+// returns false if abort is closed meanwhile
+func (b *byteBudget) acquire(n int64, abort <-chan struct{}) bool {
+	for {
+		if b.tryAcquire(n) {
+			return true
+		}
+		b.mu.Lock()
+		freed := b.freed
+		b.mu.Unlock()
+		// bytes may have been released before freed was read
+		if b.tryAcquire(n) {
+			return true
+		}
+		select {
+		case <-freed:
+		case <-abort:
+			return false
+		}
+	}
+}
+
+func (b *byteBudget) release(n int64) {
+	if n == 0 {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.used -= n
+	close(b.freed)
+	b.freed = make(chan struct{})
+}
+
+func (b *byteBudget) buffered() int64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.used
+}
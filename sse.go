@@ -0,0 +1,247 @@
+package gostage
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+)
+
+// SSEOption configures an SSEStream
+type SSEOption func(*SSEStream)
+
+// WithSSEEncoder sets how events are encoded, default is json.Marshal
+func WithSSEEncoder(fn func(interface{}) ([]byte, error)) SSEOption {
+	return func(s *SSEStream) {
+		s.encode = fn
+	}
+}
+
+// WithSSEClientBuffer sets how many events are buffered for each client, default is 64
+// the events sent to a client whose buffer is full are dropped
+func WithSSEClientBuffer(n int) SSEOption {
+	return func(s *SSEStream) {
+		s.clientBuffer = n
+	}
+}
+
+// WithSSEReplay sets how many of the last events are kept for the clients
+// reconnecting with a Last-Event-ID, default is 100
+func WithSSEReplay(n int) SSEOption {
+	return func(s *SSEStream) {
+		s.replaySize = n
+	}
+}
+
+// WithSSEHistory sends the clients connecting without a Last-Event-ID up to n of the
+// last events kept by WithSSEReplay before the live ones, as events of type "replay"
+func WithSSEHistory(n int) SSEOption {
+	return func(s *SSEStream) {
+		s.history = n
+	}
+}
+
+// SSEStream is a terminal worker which streams the events to the connected
+// clients as server-sent events, it never blocks the pipeline on a slow client
+type SSEStream struct {
+	encode       func(interface{}) ([]byte, error)
+	clientBuffer int
+	replaySize   int
+	history      int
+
+	mu      sync.Mutex
+	lastID  uint64
+	replay  []sseEvent
+	clients map[*sseClient]struct{}
+	closed  bool
+
+	dropped atomic.Int64
+}
+
+type sseEvent struct {
+	id   uint64
+	data []byte
+	// sent from the history to a new client, see WithSSEHistory
+	replayed bool
+}
+
+type sseClient struct {
+	events chan sseEvent
+	// closed when the stream is closed
+	done chan struct{}
+}
+
+// SSESink creates a terminal stage streaming its events to the clients of the returned
+// SSEStream, which is an http.Handler
+// set SubscribeTo on the returned Config to link it to the pipeline
+func SSESink(name string, opts ...SSEOption) (*Config, *SSEStream) {
+	s := &SSEStream{
+		encode:       json.Marshal,
+		clientBuffer: 64,
+		replaySize:   100,
+		clients:      make(map[*sseClient]struct{}),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return &Config{Name: name, Size: 1, Worker: s, Role: Sink}, s
+}
+
+// HandleEvent sends in to every connected client, it's passed on unchanged
+func (s *SSEStream) HandleEvent(in interface{}) (interface{}, error) {
+	data, err := s.encode(in)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastID++
+	ev := sseEvent{id: s.lastID, data: data}
+	if s.replaySize > 0 {
+		if len(s.replay) == s.replaySize {
+			s.replay = append(s.replay[:0], s.replay[1:]...)
+		}
+		s.replay = append(s.replay, ev)
+	}
+	for c := range s.clients {
+		select {
+		case c.events <- ev:
+		default:
+			s.dropped.Add(1)
+		}
+	}
+	return in, nil
+}
+
+// Close ends the streams of the connected clients once they've got their buffered events
+func (s *SSEStream) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return
+	}
+	s.closed = true
+	for c := range s.clients {
+		close(c.done)
+	}
+}
+
+// Dropped returns the number of events dropped because a client's buffer was full
+func (s *SSEStream) Dropped() int64 {
+	return s.dropped.Load()
+}
+
+// Clients returns the number of connected clients
+func (s *SSEStream) Clients() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.clients)
+}
+
+// ServeHTTP streams the events to the client, starting after its Last-Event-ID if any
+// once the stream is closed it only sends the buffered events
+func (s *SSEStream) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	c := s.connect(r.Header.Get("Last-Event-ID"))
+	defer s.disconnect(c)
+
+	for {
+		select {
+		case ev := <-c.events:
+			if err := writeSSE(w, ev); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-c.done:
+			// send what is left in the buffer
+			for {
+				select {
+				case ev := <-c.events:
+					if err := writeSSE(w, ev); err != nil {
+						return
+					}
+				default:
+					flusher.Flush()
+					return
+				}
+			}
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// connect registers a client, its buffer starts with the events after lastEventID
+func (s *SSEStream) connect(lastEventID string) *sseClient {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var missed []sseEvent
+	if last, err := strconv.ParseUint(lastEventID, 10, 64); err == nil {
+		for _, ev := range s.replay {
+			if ev.id > last {
+				missed = append(missed, ev)
+			}
+		}
+	} else if s.history > 0 {
+		from := len(s.replay) - s.history
+		if from < 0 {
+			from = 0
+		}
+		for _, ev := range s.replay[from:] {
+			ev.replayed = true
+			missed = append(missed, ev)
+		}
+	}
+	size := s.clientBuffer
+	if len(missed) > size {
+		size = len(missed)
+	}
+	c := &sseClient{events: make(chan sseEvent, size), done: make(chan struct{})}
+	for _, ev := range missed {
+		c.events <- ev
+	}
+	if s.closed {
+		close(c.done)
+		return c
+	}
+	s.clients[c] = struct{}{}
+	return c
+}
+
+func (s *SSEStream) disconnect(c *sseClient) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.clients, c)
+}
+
+// writeSSE writes ev in the event stream format, a data line per line of the event
+func writeSSE(w http.ResponseWriter, ev sseEvent) error {
+	var buf bytes.Buffer
+	if ev.replayed {
+		buf.WriteString("event: replay\n")
+	}
+	fmt.Fprintf(&buf, "id: %d\n", ev.id)
+	for _, line := range bytes.Split(ev.data, []byte("\n")) {
+		buf.WriteString("data: ")
+		buf.Write(line)
+		buf.WriteByte('\n')
+	}
+	buf.WriteByte('\n')
+	_, err := w.Write(buf.Bytes())
+	return err
+}
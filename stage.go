@@ -0,0 +1,165 @@
+package gostage
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrUnknownStage if no stage has the given name
+var ErrUnknownStage = errors.New("unknown stage")
+
+// SetStageEnabled enables or disables the stage with the given name of a running pipeline
+// it takes effect immediately and lasts for the current run, Config.Disabled isn't changed
+// so later runs start from it again
+func (s *GoStage) SetStageEnabled(name string, enabled bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.requireRunning(); err != nil {
+		return err
+	}
+	i, err := s.stage(name)
+	if err != nil {
+		return err
+	}
+	s.linkedWorkers[i].disabled.Store(!enabled)
+	return nil
+}
+
+// stage returns the index of the running stage with the given name
+func (s *GoStage) stage(name string) (int, error) {
+	for i, lw := range s.linkedWorkers {
+		if lw.Name == name {
+			return i, nil
+		}
+	}
+	return -1, fmt.Errorf("%w: %s", ErrUnknownStage, name)
+}
+
+// StopStage stops all instances of a stage once their current events are done
+// and calls Close on them, the stage's buffer keeps filling until it's full
+// then the upstream is blocked, use StartStage to resume it, the pipeline fails
+// with ErrNoConsumer if it isn't resumed within WithNoConsumerTimeout
+func (s *GoStage) StopStage(name string) error {
+	s.mu.Lock()
+	if err := s.requireRunning(); err != nil {
+		s.mu.Unlock()
+		return err
+	}
+	i, err := s.stage(name)
+	if err != nil {
+		s.mu.Unlock()
+		return err
+	}
+	lw := s.linkedWorkers[i]
+	s.mu.Unlock()
+
+	if !lw.stopped.CompareAndSwap(false, true) {
+		return nil
+	}
+	for _, inst := range lw.haltAll() {
+		<-inst.done
+		inst.sup.Stop()
+	}
+	s.lifecycle(StageStopped, lw.Name, "stage %s stopped: %d events processed", lw.Name, lw.processedCount())
+	return nil
+}
+
+// StartStage creates new instances for a stage stopped by StopStage
+func (s *GoStage) StartStage(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.requireRunning(); err != nil {
+		return err
+	}
+	i, err := s.stage(name)
+	if err != nil {
+		return err
+	}
+	if !s.linkedWorkers[i].stopped.Load() {
+		return nil
+	}
+	err = s.startStage(i)
+	// the stage runs with the instances which could be created
+	if s.linkedWorkers[i].instanceCount() > 0 {
+		s.linkedWorkers[i].stopped.Store(false)
+	}
+	return err
+}
+
+// ErrInvalidScale if a stage can't run the asked number of instances
+var ErrInvalidScale = errors.New("invalid scale")
+
+// Scale changes the number of instances of a running stage to n
+// the new instances are made with Create, the removed ones finish the events
+// they have taken and are closed
+func (s *GoStage) Scale(name string, n int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.requireRunning(); err != nil {
+		return err
+	}
+	i, err := s.stage(name)
+	if err != nil {
+		return err
+	}
+	from, err := s.scale(i, n)
+	if err != nil {
+		return err
+	}
+	if from != n {
+		s.logger.Info("%s scaled from %d to %d instances", name, from, n)
+	}
+	return nil
+}
+
+// scale sets the number of instances of stage i, s.mu must be held
+// returns the number of instances it had
+func (s *GoStage) scale(i, n int) (int, error) {
+	lw := s.linkedWorkers[i]
+	switch {
+	case n < 1:
+		return 0, fmt.Errorf("%w: %s can't run %d instances", ErrInvalidScale, lw.Name, n)
+	case lw.Pool:
+		return 0, fmt.Errorf("%w: %s is pooled", ErrInvalidScale, lw.Name)
+	case lw.stopped.Load():
+		return 0, fmt.Errorf("%w: %s is stopped", ErrInvalidScale, lw.Name)
+	case n > 1 && !lw.ShareInstance && !canCreate(lw.Worker):
+		return 0, fmt.Errorf("%w: %s can't be scaled to %d, add a Create method or set ShareInstance", ErrMissingCreate, lw.Name, n)
+	}
+
+	lw.mu.Lock()
+	from := lw.scale
+	var started []*instance
+	var failed error
+	for k := from; k < n; k++ {
+		inst, err := s.addInstance(i, k, n)
+		if err != nil {
+			// the stage keeps the instances created so far
+			failed, n = err, k
+			break
+		}
+		s.startInstance(inst)
+		started = append(started, inst)
+	}
+	for _, inst := range lw.instances {
+		if inst.n >= n && !inst.retiring.Load() {
+			inst.retire()
+			s.bg.Add(1)
+			go func(inst *instance) {
+				defer s.bg.Done()
+				<-inst.done
+				inst.sup.Stop()
+			}(inst)
+		}
+	}
+	lw.scale = n
+	lw.mu.Unlock()
+
+	for _, inst := range started {
+		<-inst.started
+	}
+	return from, failed
+}
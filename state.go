@@ -0,0 +1,235 @@
+package gostage
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrNotRunning if an operation requires a running pipeline
+var ErrNotRunning = errors.New("gostage is not running")
+
+// ErrStopped the pipeline was stopped by Stop
+var ErrStopped = errors.New("stopped")
+
+// ErrSignal the pipeline was stopped by an os signal
+var ErrSignal = errors.New("signal received")
+
+// ErrMaxEvents the producer has emitted the number of events set by WithMaxEvents
+var ErrMaxEvents = errors.New("max events reached")
+
+// ErrMaxRuntime the pipeline has run for the duration set by WithMaxRuntime
+var ErrMaxRuntime = errors.New("max runtime reached")
+
+// State is the lifecycle state of a GoStage
+type State int32
+
+const (
+	// StateIdle the pipeline has never been run
+	StateIdle State = iota
+	// StateStarting workers are being created
+	StateStarting
+	// StateRunning workers are handling events
+	StateRunning
+	// StateStopping workers are being stopped
+	StateStopping
+	// StateStopped the pipeline has stopped and can be run again
+	StateStopped
+)
+
+func (st State) String() string {
+	switch st {
+	case StateIdle:
+		return "idle"
+	case StateStarting:
+		return "starting"
+	case StateRunning:
+		return "running"
+	case StateStopping:
+		return "stopping"
+	case StateStopped:
+		return "stopped"
+	}
+	return fmt.Sprintf("State(%d)", int32(st))
+}
+
+// State returns the current lifecycle state
+func (s *GoStage) State() State {
+	return State(s.state.Load())
+}
+
+// transit moves the state from one of the given states to the next one
+func (s *GoStage) transit(next State, from ...State) bool {
+	for _, st := range from {
+		if s.state.CompareAndSwap(int32(st), int32(next)) {
+			return true
+		}
+	}
+	return false
+}
+
+// Reason returns why the last run stopped, nil if it hasn't stopped yet
+// it's one of ErrQuit, ErrStopped, ErrSignal, ErrMaxEvents, ErrMaxRuntime,
+// ErrSupervision, ErrErrorBudgetExceeded or the context's error
+func (s *GoStage) Reason() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.reason
+}
+
+func (s *GoStage) setReason(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.reason == nil {
+		s.reason = err
+	}
+}
+
+// replaceReason sets err as the reason even if one has been set
+func (s *GoStage) replaceReason(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.reason = err
+}
+
+// requireRunning returns ErrNotRunning if the pipeline isn't running
+func (s *GoStage) requireRunning() error {
+	if st := s.State(); st != StateRunning {
+		return fmt.Errorf("%w: pipeline is %s", ErrNotRunning, st)
+	}
+	return nil
+}
+
+// Stop asks a running pipeline to stop, it doesn't wait for the workers
+// the callback passed to Run or RunAsync is called once the pipeline has stopped
+func (s *GoStage) Stop() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.requireRunning(); err != nil {
+		return err
+	}
+	select {
+	case <-s.stopRequest:
+	default:
+		close(s.stopRequest)
+	}
+	return nil
+}
+
+// StopMode decides what a consumer does with the events waiting in its
+// input buffer once it's asked to stop
+type StopMode int
+
+const (
+	// StopImmediate stops as soon as the current event is done
+	// the buffered events are abandoned
+	StopImmediate StopMode = iota
+	// StopDrain handles the buffered events until the buffer is empty, then stops
+	StopDrain
+)
+
+// WithStopMode sets how consumers stop, default is StopImmediate
+func WithStopMode(m StopMode) Option {
+	return func(gs *GoStage) {
+		gs.stopMode = m
+	}
+}
+
+// stopModeOf returns the StopMode of a stage
+func (s *GoStage) stopModeOf(lw *linkedWorker) StopMode {
+	if lw.BestEffort || lw.overBudget.Load() {
+		return StopImmediate
+	}
+	return s.stopMode
+}
+
+// receive waits for the next event of a consumer instance
+// returns stopped if the instance should stop instead, or parked if its pool
+// has parked it for lack of events
+// the preference between a pending event and the stop request follows the StopMode
+func (s *GoStage) receive(inst *instance, in chan *envelope) (env *envelope, stopped, parked bool) {
+	if inst.halted.Load() {
+		if inst.retiring.Load() {
+			if env := inst.pop(); env != nil {
+				return env, false, false
+			}
+			// the dispatcher doesn't give a retiring instance more than it has queued
+			if env, ok := s.tryTake(inst); ok {
+				return env, false, false
+			}
+			return nil, true, false
+		}
+		if s.stopModeOf(inst.lw) == StopImmediate {
+			s.dropBatch(inst)
+			return nil, true, false
+		}
+	}
+	if env := inst.pop(); env != nil {
+		return env, false, false
+	}
+	// don't pay for a select unless the buffer is empty
+	select {
+	case env, ok := <-in:
+		if !ok {
+			// the upstream has stopped and everything it sent has been taken
+			return nil, true, false
+		}
+		s.took(inst, in)
+		return env, false, false
+	default:
+	}
+
+	for {
+		// the last active instance of a pool never parks
+		var linger <-chan time.Time
+		if inst.pool != nil && inst.pool.active.Load() > 1 {
+			linger = s.clock.After(inst.lw.poolIdleTimeout())
+		}
+		select {
+		case <-inst.stop:
+			if inst.inbox != nil {
+				return s.drainInbox(inst)
+			}
+			if s.stopModeOf(inst.lw) == StopDrain && !inst.retiring.Load() {
+				if inst.lw.queue != nil && inst.lw.upstreamDone.Load() {
+					// the pump hands over what's left in the closed queue one by one
+					select {
+					case env, ok := <-in:
+						if ok {
+							return env, false, false
+						}
+					case <-inst.abort:
+					}
+					return nil, true, false
+				}
+				select {
+				case env, ok := <-in:
+					if ok {
+						return env, false, false
+					}
+				default:
+				}
+			}
+			return nil, true, false
+		case env, ok := <-in:
+			if !ok {
+				return nil, true, false
+			}
+			s.took(inst, in)
+			return env, false, false
+		case <-linger:
+			if inst.pool.park(inst) {
+				return nil, false, true
+			}
+		}
+	}
+}
+
+// took is called after the instance has taken an event from in
+func (s *GoStage) took(inst *instance, in chan *envelope) {
+	s.fill(inst, in)
+	if inst.pool != nil && inst.lw.queued() > 0 {
+		inst.pool.grow()
+	}
+}
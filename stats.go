@@ -0,0 +1,268 @@
+package gostage
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// StageStats is a snapshot of a stage's counters
+type StageStats struct {
+	Name string `json:"name"`
+	// true if the stage was stopped by StopStage
+	Stopped bool `json:"stopped"`
+	// true if the stage is a producer which has returned ErrQuit
+	Finished bool `json:"finished"`
+	// the number of events passed to HandleEvent
+	Processed int64 `json:"processed"`
+	// Processed by each instance, by index
+	InstanceProcessed []int64 `json:"instance_processed"`
+	// the number of errors returned by HandleEvent
+	Errors int64 `json:"errors"`
+	// the number of events dropped because of MaxEventAge
+	Expired int64 `json:"expired"`
+	// the number of events discarded because the stage's buffer was full
+	Dropped int64 `json:"dropped"`
+	// the number of events rejected because they were over MaxPayloadBytes
+	Oversized int64 `json:"oversized"`
+	// the number of events passed through without calling HandleEvent
+	// because the stage was disabled or When returned false
+	Bypassed int64 `json:"bypassed"`
+	// the number of events discarded by a disabled consumer
+	Discarded int64 `json:"discarded"`
+	// the number of events for which a consumer's HandleEvent returned ErrNoData
+	// or nil, and the nil events returned by a producer, see WithForwardNil
+	Skipped int64 `json:"skipped"`
+	// the number of events cancelled by CancelEvent while or before reaching the stage
+	Cancelled int64 `json:"cancelled"`
+	// the number of events not handled because their IdempotencyKey had been marked
+	Deduplicated int64 `json:"deduplicated"`
+	// the number of events HandleEvent gave up with ErrInterrupted
+	Interrupted int64 `json:"interrupted"`
+	// the number of the processed events which were put in the pipeline by Inject
+	Injected int64 `json:"injected"`
+	// the side outputs passed to their pipelines, and those dropped because their label
+	// isn't in Config.SideOutputs or their pipeline refused them
+	SideOutputs int64 `json:"side_outputs"`
+	SideDropped int64 `json:"side_dropped"`
+	// the errors of Errors by class, see Classify
+	RetryableErrors int64 `json:"retryable_errors"`
+	PermanentErrors int64 `json:"permanent_errors"`
+	// the number of HandleEvent calls running now
+	Busy int64 `json:"busy"`
+	// the number of instances the stage runs, each calling HandleEvent on its own goroutine
+	// unless the stage is pooled
+	Concurrency int `json:"concurrency"`
+	// the number of restarts after panics
+	Restarts int64 `json:"restarts"`
+	// the number of restarts in the last Config.RestartWindow
+	RecentRestarts int64 `json:"recent_restarts"`
+	// the events a Broadcast stage took and the copies it handed to its instances,
+	// Copies / Broadcasts is the multiplicity of the edge to the stage
+	Broadcasts int64 `json:"broadcasts"`
+	Copies     int64 `json:"copies"`
+	// the bytes of the Sizer events waiting in the stage's buffer
+	BufferedBytes int64 `json:"buffered_bytes"`
+	// the events waiting in front of the stage at the last sample and the most
+	// seen by a sample, zero unless WithQueueSampling is set
+	QueueDepth    int64 `json:"queue_depth"`
+	MaxQueueDepth int64 `json:"max_queue_depth"`
+	// the events handed to each instance of a Partitioned stage, by index, to reveal skew
+	Partitions []int64 `json:"partitions"`
+	// the events the stage took from each producer, by name, the pushed and injected ones
+	// are under the empty name, only counted if its Queue is RoundRobinAcrossSources
+	// or WeightedBySource
+	Sources map[string]int64 `json:"sources"`
+	// set if a Warmup of the stage failed or didn't return before WithWarmupTimeout
+	Cold bool `json:"cold"`
+
+	// only counted for producers with WithSequenceCheck
+	// the number of events of this producer which never reached the terminal stage
+	// final once the pipeline has stopped
+	Gaps int64 `json:"gaps"`
+	// the number of events of this producer which reached the terminal stage twice
+	Duplicates int64 `json:"duplicates"`
+	// the number of events which reached the terminal stage after the check's window
+	Late int64 `json:"late"`
+}
+
+// Stats is a snapshot of the pipeline's counters, the stages are ordered from the producers
+// to the terminal stage, the order the events go through them
+type Stats struct {
+	Stages []StageStats `json:"stages"`
+	// what happened to the produced events
+	Accounting Accounting `json:"accounting"`
+	// the records of WithAuditLog dropped because the writer couldn't keep up
+	DroppedAuditRecords int64 `json:"dropped_audit_records"`
+	// the outputs of the terminal stage the output channel or the bridge didn't take,
+	// because the stage was aborted or the bridged pipeline stopped
+	LostOutputs int64 `json:"lost_outputs"`
+	// the errors not passed to an OnStageError or OnAnyError subscriber whose queue was full
+	DroppedErrors int64 `json:"dropped_errors"`
+	// the calls of the Observer and OnRestart hooks dropped because they were too far behind
+	DroppedNotifications int64 `json:"dropped_notifications"`
+	// the mitigations of WithMemoryLimit applied now: 1 if the producers are paused,
+	// 2 if the pushes are held too, 0 if none
+	MemoryPressure int `json:"memory_pressure"`
+	// the reloads of WithReload done and failed since the pipeline was created
+	Reloads       int64 `json:"reloads"`
+	FailedReloads int64 `json:"failed_reloads"`
+}
+
+// stageStats are the counters of a stage, resolved once when the stage is linked
+// the event path only does atomic adds on them, no lookups nor allocations,
+// see Test_ForwardPathAllocs
+// the processed events are counted by the instances, see processedCount
+type stageStats struct {
+	errors       atomic.Int64
+	expired      atomic.Int64
+	dropped      atomic.Int64
+	oversized    atomic.Int64
+	bypassed     atomic.Int64
+	discarded    atomic.Int64
+	skipped      atomic.Int64
+	cancelled    atomic.Int64
+	deduplicated atomic.Int64
+	interrupted  atomic.Int64
+	injected     atomic.Int64
+	sideOut      atomic.Int64
+	sideDropped  atomic.Int64
+	broadcasts   atomic.Int64
+	copies       atomic.Int64
+	// errors by class, see Classify
+	retryableErrors atomic.Int64
+	permanentErrors atomic.Int64
+	busy            atomic.Int64
+	restarts        atomic.Int64
+	// for the Report
+	in           atomic.Int64
+	out          atomic.Int64
+	retries      atomic.Int64
+	deadLettered atomic.Int64
+	busyTime     atomic.Int64
+	latency      histogram
+	// the events put in front of the stage, and the ones removed from there
+	// without being taken, see queueDepth
+	enqueued atomic.Int64
+	unqueued atomic.Int64
+	// sampled by WithQueueSampling
+	depth    atomic.Int64
+	maxDepth atomic.Int64
+
+	// the time of the restarts in the window
+	mu           sync.Mutex
+	restartTimes []time.Time
+	window       time.Duration
+}
+
+func (st *stageStats) recordRestart(now time.Time, window time.Duration) {
+	st.restarts.Add(1)
+
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.window = window
+	st.restartTimes = append(st.restartTimes, now)
+}
+
+// recentRestarts counts the restarts in the window before now
+func (st *stageStats) recentRestarts(now time.Time) int64 {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	k := 0
+	for k < len(st.restartTimes) && now.Sub(st.restartTimes[k]) > st.window {
+		k++
+	}
+	st.restartTimes = st.restartTimes[k:]
+	return int64(len(st.restartTimes))
+}
+
+// instanceProcessed returns the events handled by each instance
+func (lw *linkedWorker) instanceProcessed() []int64 {
+	lw.mu.Lock()
+	defer lw.mu.Unlock()
+	counts := make([]int64, len(lw.processed))
+	for n, c := range lw.processed {
+		counts[n] = c.Load()
+	}
+	return counts
+}
+
+// processedCount returns the events handled by all instances of the stage
+func (lw *linkedWorker) processedCount() int64 {
+	return sum(lw.instanceProcessed())
+}
+
+func sum(counts []int64) int64 {
+	var total int64
+	for _, c := range counts {
+		total += c
+	}
+	return total
+}
+
+// Stats returns a snapshot of every stage's counters
+func (s *GoStage) Stats() Stats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.clock.Now()
+	stats := Stats{Stages: make([]StageStats, 0, len(s.linkedWorkers))}
+	for _, lw := range s.linkedWorkers {
+		var gaps, duplicates, late int64
+		if lw.sequence != nil {
+			gaps, duplicates, late = lw.sequence.counts()
+		}
+		processed := lw.instanceProcessed()
+		stats.Stages = append(stats.Stages, StageStats{
+			Name:              lw.Name,
+			Stopped:           lw.stopped.Load(),
+			Finished:          lw.finished.Load(),
+			Processed:         sum(processed),
+			InstanceProcessed: processed,
+			Errors:            lw.stats.errors.Load(),
+			Expired:           lw.stats.expired.Load(),
+			Dropped:           lw.stats.dropped.Load(),
+			Oversized:         lw.stats.oversized.Load(),
+			Bypassed:          lw.stats.bypassed.Load(),
+			Discarded:         lw.stats.discarded.Load(),
+			Skipped:           lw.stats.skipped.Load(),
+			Cancelled:         lw.stats.cancelled.Load(),
+			Deduplicated:      lw.stats.deduplicated.Load(),
+			Interrupted:       lw.stats.interrupted.Load(),
+			Injected:          lw.stats.injected.Load(),
+			SideOutputs:       lw.stats.sideOut.Load(),
+			SideDropped:       lw.stats.sideDropped.Load(),
+			RetryableErrors:   lw.stats.retryableErrors.Load(),
+			PermanentErrors:   lw.stats.permanentErrors.Load(),
+			Busy:              lw.stats.busy.Load(),
+			Concurrency:       lw.instanceCount(),
+			Restarts:          lw.stats.restarts.Load(),
+			RecentRestarts:    lw.stats.recentRestarts(now),
+			Broadcasts:        lw.stats.broadcasts.Load(),
+			Copies:            lw.stats.copies.Load(),
+			BufferedBytes:     lw.bytes.buffered(),
+			QueueDepth:        lw.stats.depth.Load(),
+			MaxQueueDepth:     lw.stats.maxDepth.Load(),
+			Partitions:        lw.partitionCounts(),
+			Sources:           s.sources(lw),
+			Cold:              lw.cold.Load(),
+			Gaps:              gaps,
+			Duplicates:        duplicates,
+			Late:              late,
+		})
+	}
+	stats.Accounting = s.accounting
+	if s.State() != StateStopped {
+		stats.Accounting = s.account()
+	}
+	if s.audit != nil {
+		stats.DroppedAuditRecords = s.audit.dropped.Load()
+	}
+	stats.LostOutputs = s.lostOutputs.Load()
+	stats.DroppedErrors = s.errorSubs.dropped.Load()
+	stats.DroppedNotifications = s.droppedNotifications.Load()
+	stats.MemoryPressure = s.memoryPressure()
+	stats.Reloads, stats.FailedReloads = s.reloadStats()
+	return stats
+}
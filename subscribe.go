@@ -0,0 +1,124 @@
+package gostage
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// the number of errors queued for a subscriber before the next ones are dropped
+const errorQueueSize = 256
+
+// OnStageError calls fn with every StageError of the named stage, stage is the name of
+// the Config, not prefixed by the pipeline's name, it can be called before or during a run
+// fn is called from its own goroutine in the order of the errors, they're dropped while
+// its queue is full, see Stats.DroppedErrors, it's passed the errors until the returned
+// function is called, the errors already queued are still passed then
+func (s *GoStage) OnStageError(stage string, fn func(StageError)) (cancel func()) {
+	return s.errorSubs.add(s, &errorSubscription{stage: stage, fn: fn})
+}
+
+// OnAnyError calls fn with every StageError of the pipeline, as OnStageError does
+func (s *GoStage) OnAnyError(fn func(StageError)) (cancel func()) {
+	return s.errorSubs.add(s, &errorSubscription{any: true, fn: fn})
+}
+
+type errorSubscription struct {
+	stage string
+	any   bool
+	fn    func(StageError)
+	// nil unless the pipeline is running
+	queue chan StageError
+}
+
+// errorSubscribers passes the errors to the subscriptions, each has a goroutine during the runs
+type errorSubscribers struct {
+	mu      sync.Mutex
+	subs    []*errorSubscription
+	running bool
+	wg      sync.WaitGroup
+	// set while there's a subscription, cheaper to check on every error
+	active  atomic.Bool
+	dropped atomic.Int64
+}
+
+func (e *errorSubscribers) add(s *GoStage, sub *errorSubscription) func() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.subs = append(e.subs, sub)
+	e.active.Store(true)
+	if e.running {
+		e.serve(s, sub)
+	}
+	var once sync.Once
+	return func() {
+		once.Do(func() { e.remove(sub) })
+	}
+}
+
+func (e *errorSubscribers) remove(sub *errorSubscription) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for k, other := range e.subs {
+		if other == sub {
+			e.subs = append(e.subs[:k:k], e.subs[k+1:]...)
+			break
+		}
+	}
+	e.active.Store(len(e.subs) > 0)
+	if sub.queue != nil {
+		close(sub.queue)
+		sub.queue = nil
+	}
+}
+
+// serve starts the goroutine of sub, e.mu is held
+func (e *errorSubscribers) serve(s *GoStage, sub *errorSubscription) {
+	sub.queue = make(chan StageError, errorQueueSize)
+	e.wg.Add(1)
+	go func(queue chan StageError) {
+		defer e.wg.Done()
+		for se := range queue {
+			s.protect("error subscriber", func() {
+				sub.fn(se)
+			})
+		}
+	}(sub.queue)
+}
+
+// start starts the goroutines of the subscriptions for a run
+func (e *errorSubscribers) start(s *GoStage) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.running = true
+	for _, sub := range e.subs {
+		e.serve(s, sub)
+	}
+}
+
+// stop waits for the subscriptions to be passed the errors queued during the run
+func (e *errorSubscribers) stop() {
+	e.mu.Lock()
+	e.running = false
+	for _, sub := range e.subs {
+		close(sub.queue)
+		sub.queue = nil
+	}
+	e.mu.Unlock()
+	e.wg.Wait()
+}
+
+// publish queues se for the subscriptions to its stage, name is the stage's unqualified name
+func (e *errorSubscribers) publish(name string, se StageError) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for _, sub := range e.subs {
+		if sub.queue == nil || !sub.any && sub.stage != name {
+			continue
+		}
+		select {
+		case sub.queue <- se:
+		default:
+			e.dropped.Add(1)
+		}
+	}
+}
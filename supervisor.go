@@ -1,54 +1,316 @@
 package gostage
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"runtime/debug"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 // ErrSupervision if restart time is reached will cause this error
 var ErrSupervision = errors.New("out of supervision")
 
-type supervisor struct {
-	maxRestart   int
-	restartCount int
-	restartChan  chan struct{}
-	errChan      chan error
-	workerFunc   func()
-	logger       Logger
+// maxInputLog caps the size of the input printed in a panic log
+const maxInputLog = 256
+
+// RestartInfo describes a restart done by a Supervisor
+type RestartInfo struct {
+	// the supervisor's name
+	Name string
+	// the number of restarts counted against the budget, starts from 1
+	Attempt int
+	// the value recovered from the panic
+	Recovered interface{}
+	// how long the supervisor waits before restarting
+	Backoff time.Duration
+}
+
+// SupervisorOption configures a Supervisor
+type SupervisorOption func(*Supervisor)
+
+// WithMaxRestarts sets how many times the function can be restarted, default is DefaultRestart
+// zero means the first panic ends the supervision
+func WithMaxRestarts(n int) SupervisorOption {
+	return func(s *Supervisor) {
+		s.maxRestarts = n
+	}
+}
+
+// WithRestartWindow only counts the restarts happened in the last d against the budget
+// zero means every restart is counted
+func WithRestartWindow(d time.Duration) SupervisorOption {
+	return func(s *Supervisor) {
+		s.window = d
+	}
+}
+
+// WithRestartBackoff sets how long to wait before the given restart attempt
+func WithRestartBackoff(fn func(attempt int) time.Duration) SupervisorOption {
+	return func(s *Supervisor) {
+		s.backoff = fn
+	}
+}
+
+// WithOnRestart registers a callback which is called on every restart
+func WithOnRestart(fn func(RestartInfo)) SupervisorOption {
+	return func(s *Supervisor) {
+		s.onRestart = fn
+	}
+}
+
+// WithSupervisorLogger sets the logger for panics and restarts
+func WithSupervisorLogger(logger Logger) SupervisorOption {
+	return func(s *Supervisor) {
+		s.logger = logger
+	}
+}
+
+// WithSupervisorName sets the name shown in logs, e.g. consumer_#1
+func WithSupervisorName(name string) SupervisorOption {
+	return func(s *Supervisor) {
+		s.name = name
+	}
+}
+
+// withInterrupt cuts the restart backoff short once interrupt is closed,
+// the restarted function is expected to return right away then
+func withInterrupt(interrupt <-chan struct{}) SupervisorOption {
+	return func(s *Supervisor) {
+		s.interrupt = interrupt
+	}
+}
+
+// withClock sets the clock of the restart backoff
+func withClock(c Clock) SupervisorOption {
+	return func(s *Supervisor) {
+		s.clock = c
+	}
+}
+
+// withCurrentInput describes the event being handled in panic logs
+func withCurrentInput(fn func() interface{}) SupervisorOption {
+	return func(s *Supervisor) {
+		s.current = fn
+	}
+}
+
+// Supervisor runs a function in a goroutine and restarts it when it panics
+// until it returns, Stop is called or the restart budget is exhausted
+type Supervisor struct {
+	name        string
+	fn          func(ctx context.Context)
+	maxRestarts int
+	window      time.Duration
+	backoff     func(attempt int) time.Duration
+	onRestart   func(RestartInfo)
+	logger      Logger
+	current     func() interface{}
+	clock       Clock
+	interrupt   <-chan struct{}
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	// receives the recovered value when the function panics
+	restartChan chan interface{}
+	// the function returned without panicking
+	exitChan chan struct{}
+	// closed when the supervision has ended
+	done      chan struct{}
+	startOnce sync.Once
+
+	restarts atomic.Int64
+	// the time of the restarts in the window, only touched by monitor
+	// or by the goroutine running a pooled instance
+	history []time.Time
+
+	mu  sync.Mutex
+	err error
+}
+
+// NewSupervisor creates a Supervisor for fn, the context passed to fn is
+// cancelled when the supervisor is stopped
+func NewSupervisor(fn func(ctx context.Context), opts ...SupervisorOption) *Supervisor {
+	s := &Supervisor{
+		name:        "worker",
+		fn:          fn,
+		maxRestarts: DefaultRestart,
+		logger:      &StdLogger{},
+		clock:       realClock{},
+		restartChan: make(chan interface{}),
+		exitChan:    make(chan struct{}),
+		done:        make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// Start runs the function, calling it more than once has no effect
+func (s *Supervisor) Start(ctx context.Context) {
+	s.startOnce.Do(func() {
+		s.ctx, s.cancel = context.WithCancel(ctx)
+		go s.monitor()
+	})
+}
+
+// Stop ends the supervision and waits for the monitor goroutine to return
+// it doesn't wait for the function, which should return once its context is done
+// it's safe to call Stop more than once
+func (s *Supervisor) Stop() {
+	s.startOnce.Do(func() {
+		close(s.done)
+	})
+	if s.cancel != nil {
+		s.cancel()
+	}
+	<-s.done
+}
+
+// Done returns a channel which is closed when the supervision has ended
+func (s *Supervisor) Done() <-chan struct{} {
+	return s.done
+}
+
+// Err returns ErrSupervision if the restart budget was exhausted, nil otherwise
+func (s *Supervisor) Err() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
+
+// RestartCount returns the total number of restarts
+func (s *Supervisor) RestartCount() int {
+	return int(s.restarts.Load())
 }
 
 // Supervise supervises a function which is running in a goroutine
-// automatically restart it when crashes
+// automatically restart it when crashes, the returned channel receives
+// ErrSupervision if it has crashed more than maxRestart times
+//
+// Deprecated: use NewSupervisor, which can be stopped
 func Supervise(workerFunc func(), maxRestart int, logger Logger) chan error {
-	s := &supervisor{
-		maxRestart:  maxRestart,
-		restartChan: make(chan struct{}),
-		errChan:     make(chan error),
-		workerFunc:  workerFunc,
-	}
-	go s.monitor()
-	return s.errChan
+	s := NewSupervisor(func(context.Context) { workerFunc() }, WithMaxRestarts(maxRestart), WithSupervisorLogger(logger))
+	s.Start(context.Background())
+
+	errChan := make(chan error)
+	go func() {
+		<-s.done
+		if err := s.Err(); err != nil {
+			errChan <- err
+		}
+		close(errChan)
+	}()
+	return errChan
 }
 
-func (s *supervisor) monitor() {
+func (s *Supervisor) monitor() {
+	defer close(s.done)
+	defer s.cancel()
+
 	go s.work()
 
-	for range s.restartChan {
-		s.restartCount++
-		if s.restartCount > s.maxRestart {
-			s.errChan <- ErrSupervision
+	for {
+		var recovered interface{}
+		select {
+		case recovered = <-s.restartChan:
+		case <-s.exitChan:
 			return
+		case <-s.ctx.Done():
+			return
+		}
+
+		backoff, ok := s.crashed(recovered)
+		if !ok {
+			return
+		}
+		if backoff > 0 {
+			select {
+			case <-s.clock.After(backoff):
+			case <-s.interrupt:
+			case <-s.ctx.Done():
+				return
+			}
 		}
 		go s.work()
 	}
 }
 
-func (s *supervisor) work() {
+// crashed counts a restart after the function panicked with recovered
+// and returns how long to wait before restarting it
+// returns false once the restart budget is exhausted, Err returns ErrSupervision then
+func (s *Supervisor) crashed(recovered interface{}) (time.Duration, bool) {
+	attempt := s.count(s.clock.Now())
+	if attempt > s.maxRestarts {
+		s.logger.Error("%s out of restarts: %d/%d", s.name, s.maxRestarts, s.maxRestarts)
+		s.mu.Lock()
+		s.err = ErrSupervision
+		s.mu.Unlock()
+		return 0, false
+	}
+	s.restarts.Add(1)
+
+	var backoff time.Duration
+	if s.backoff != nil {
+		backoff = s.backoff(attempt)
+	}
+	s.logger.Error("%s restart %d/%d", s.name, attempt, s.maxRestarts)
+	if s.onRestart != nil {
+		s.onRestart(RestartInfo{Name: s.name, Attempt: attempt, Recovered: recovered, Backoff: backoff})
+	}
+	return backoff, true
+}
+
+// count records a restart at now and returns the number of restarts in the window
+func (s *Supervisor) count(now time.Time) int {
+	s.history = append(s.history, now)
+	if s.window > 0 {
+		k := 0
+		for k < len(s.history) && now.Sub(s.history[k]) > s.window {
+			k++
+		}
+		s.history = s.history[k:]
+	}
+	return len(s.history)
+}
+
+func (s *Supervisor) work() {
 	defer func() {
 		if err := recover(); err != nil {
-			s.logger.Error("got recover error: %v\n%s\n", err, string(debug.Stack()))
-			s.restartChan <- struct{}{}
+			s.logPanic(err)
+			select {
+			case s.restartChan <- err:
+			case <-s.ctx.Done():
+			}
+			return
+		}
+		select {
+		case s.exitChan <- struct{}{}:
+		case <-s.ctx.Done():
 		}
 	}()
-	s.workerFunc()
+	s.fn(s.ctx)
+}
+
+func (s *Supervisor) logPanic(recovered interface{}) {
+	s.logger.Error("%s panic: %v%s\n%s\n", s.name, recovered, s.input(), string(debug.Stack()))
+}
+
+// input describes the event being handled, capped at maxInputLog bytes
+func (s *Supervisor) input() string {
+	if s.current == nil {
+		return ""
+	}
+	in := s.current()
+	if in == nil {
+		return ""
+	}
+	str := fmt.Sprintf("%+v", in)
+	if len(str) > maxInputLog {
+		str = str[:maxInputLog] + "..."
+	}
+	return ", input = " + str
 }
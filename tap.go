@@ -0,0 +1,123 @@
+package gostage
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// TapEvent is an output of a stage passed to a Tap
+type TapEvent struct {
+	Stage   string
+	Payload interface{}
+	// the output was emitted before the tap was attached, it comes from the replay buffer
+	Replayed bool
+}
+
+// WithReplayBuffer keeps the last n outputs of the named stage, the taps attached later
+// get them first, flagged as Replayed, the oldest output is evicted once n are kept
+// the outputs are kept by reference, they mustn't be changed downstream
+func WithReplayBuffer(stage string, n int) Option {
+	return func(gs *GoStage) {
+		gs.taps.of(stage).setReplay(n)
+	}
+}
+
+// Tap returns the outputs of the named stage from now on, stage is the name of the Config,
+// it can be called before or during a run and the tap is kept across runs until cancel is called,
+// which closes the channel, the outputs are dropped for the tap while buffer of them are
+// waiting in the channel, a tap never slows the stage down
+func (s *GoStage) Tap(stage string, buffer int) (<-chan TapEvent, func()) {
+	return s.taps.of(stage).attach(buffer)
+}
+
+// stageTaps are the taps of the stages, by name
+type stageTaps struct {
+	mu     sync.Mutex
+	stages map[string]*stageTap
+}
+
+// of returns the taps of the named stage
+func (st *stageTaps) of(stage string) *stageTap {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if st.stages == nil {
+		st.stages = make(map[string]*stageTap)
+	}
+	t := st.stages[stage]
+	if t == nil {
+		t = &stageTap{name: stage, taps: make(map[chan TapEvent]struct{})}
+		st.stages[stage] = t
+	}
+	return t
+}
+
+// stageTap passes the outputs of a stage to its taps and keeps its replay buffer
+type stageTap struct {
+	name string
+	// set while the stage has a tap or a replay buffer, cheaper to check on every output
+	active atomic.Bool
+
+	mu   sync.Mutex
+	taps map[chan TapEvent]struct{}
+	// the replay buffer, a ring of size outputs starting at first
+	replay []interface{}
+	size   int
+	first  int
+}
+
+func (t *stageTap) setReplay(n int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.size, t.replay, t.first = n, nil, 0
+	t.active.Store(n > 0 || len(t.taps) > 0)
+}
+
+func (t *stageTap) attach(buffer int) (<-chan TapEvent, func()) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	c := make(chan TapEvent, buffer+len(t.replay))
+	for k := range t.replay {
+		c <- TapEvent{Stage: t.name, Payload: t.replay[(t.first+k)%len(t.replay)], Replayed: true}
+	}
+	t.taps[c] = struct{}{}
+	t.active.Store(true)
+	var once sync.Once
+	return c, func() {
+		once.Do(func() { t.detach(c) })
+	}
+}
+
+func (t *stageTap) detach(c chan TapEvent) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.taps, c)
+	close(c)
+	t.active.Store(t.size > 0 || len(t.taps) > 0)
+}
+
+// publish passes an output of the stage to its taps and keeps it in the replay buffer
+func (t *stageTap) publish(v interface{}) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.size > 0 {
+		if len(t.replay) < t.size {
+			t.replay = append(t.replay, v)
+		} else {
+			t.replay[t.first] = v
+			t.first = (t.first + 1) % t.size
+		}
+	}
+	for c := range t.taps {
+		select {
+		case c <- TapEvent{Stage: t.name, Payload: v}:
+		default:
+		}
+	}
+}
+
+// tapped passes an output of lw to its taps, if it has any
+func (lw *linkedWorker) tapped(v interface{}) {
+	if lw.tap.active.Load() {
+		lw.tap.publish(v)
+	}
+}
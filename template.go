@@ -0,0 +1,217 @@
+package gostage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"sort"
+	"sync"
+	"syscall"
+)
+
+// ErrDuplicateInstance if a Manager already has an instance with the given name
+var ErrDuplicateInstance = errors.New("duplicate instance")
+
+// ErrUnknownInstance if a Manager has no instance with the given name
+var ErrUnknownInstance = errors.New("unknown instance")
+
+// Template describes a pipeline run many times with different parameters
+type Template struct {
+	// Configs returns new configs and workers for the given parameters,
+	// it's called once per instance so that the instances share nothing
+	Configs func(params interface{}) []*Config
+	// Options returns the options of an instance, optional
+	Options func(params interface{}) []Option
+}
+
+// Instantiate creates an independent pipeline from the template
+func Instantiate(ctx context.Context, t Template, logger Logger, params interface{}) *GoStage {
+	var opts []Option
+	if t.Options != nil {
+		opts = t.Options(params)
+	}
+	return New(ctx, t.Configs(params), logger, opts...)
+}
+
+// Manager runs many pipelines, usually instances of templates
+// only the Manager traps the os signals, the pipelines are run with RunAsync
+type Manager struct {
+	ctx    context.Context
+	logger Logger
+
+	mu        sync.Mutex
+	instances map[string]*managed
+}
+
+type managed struct {
+	gs *GoStage
+	// closed once the current run has stopped, nil if it isn't running
+	done chan struct{}
+}
+
+// NewManager creates a Manager, the pipelines' logs are prefixed with their names
+func NewManager(ctx context.Context, logger Logger) *Manager {
+	return &Manager{ctx: ctx, logger: logger, instances: make(map[string]*managed)}
+}
+
+// Add instantiates the template as the pipeline name, it isn't started
+func (m *Manager) Add(name string, t Template, params interface{}) (*GoStage, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.instances[name]; ok {
+		return nil, fmt.Errorf("%w: %s", ErrDuplicateInstance, name)
+	}
+	gs := Instantiate(m.ctx, t, prefixLogger{prefix: name + ": ", logger: m.logger}, params)
+	m.instances[name] = &managed{gs: gs}
+	return gs, nil
+}
+
+func (m *Manager) instance(name string) (*managed, error) {
+	in, ok := m.instances[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownInstance, name)
+	}
+	return in, nil
+}
+
+// Start runs the pipeline name
+func (m *Manager) Start(name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	in, err := m.instance(name)
+	if err != nil {
+		return err
+	}
+	return m.start(in)
+}
+
+// start runs in unless it's running, m.mu must be held
+func (m *Manager) start(in *managed) error {
+	if in.done != nil {
+		select {
+		case <-in.done:
+		default:
+			return nil
+		}
+	}
+	done := make(chan struct{})
+	if err := in.gs.RunAsync(func() { close(done) }); err != nil {
+		return err
+	}
+	in.done = done
+	return nil
+}
+
+// Stop stops the pipeline name and waits for it
+func (m *Manager) Stop(name string) error {
+	m.mu.Lock()
+	in, err := m.instance(name)
+	m.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	m.stop(in)
+	return nil
+}
+
+// stop stops in if it's running and waits for it
+func (m *Manager) stop(in *managed) {
+	m.mu.Lock()
+	done := in.done
+	m.mu.Unlock()
+	if done == nil {
+		return
+	}
+	in.gs.Stop()
+	<-done
+}
+
+// StopAll stops all pipelines together and waits for them
+func (m *Manager) StopAll() {
+	m.mu.Lock()
+	instances := make([]*managed, 0, len(m.instances))
+	for _, in := range m.instances {
+		instances = append(instances, in)
+	}
+	m.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for _, in := range instances {
+		wg.Add(1)
+		go func(in *managed) {
+			defer wg.Done()
+			m.stop(in)
+		}(in)
+	}
+	wg.Wait()
+}
+
+// Run starts the pipelines which aren't running and blocks until the Manager's
+// context is done or an os signal is received, then stops all of them
+func (m *Manager) Run() error {
+	m.mu.Lock()
+	for _, name := range m.names() {
+		if err := m.start(m.instances[name]); err != nil {
+			m.mu.Unlock()
+			m.StopAll()
+			return fmt.Errorf("%s: %w", name, err)
+		}
+	}
+	m.mu.Unlock()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(signals)
+	select {
+	case <-m.ctx.Done():
+	case sig := <-signals:
+		m.logger.Info("manager received %v, stopping all pipelines", sig)
+	}
+	m.StopAll()
+	return nil
+}
+
+// names returns the names of the instances in order, m.mu must be held
+func (m *Manager) names() []string {
+	names := make([]string, 0, len(m.instances))
+	for name := range m.instances {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Stats returns the stats of every pipeline by name
+func (m *Manager) Stats() map[string]Stats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	stats := make(map[string]Stats, len(m.instances))
+	for name, in := range m.instances {
+		stats[name] = in.gs.Stats()
+	}
+	return stats
+}
+
+// prefixLogger tells the pipelines of a Manager apart in the logs
+type prefixLogger struct {
+	prefix string
+	logger Logger
+}
+
+func (l prefixLogger) Fatal(format string, args ...interface{}) {
+	l.logger.Fatal(l.prefix+format, args...)
+}
+
+func (l prefixLogger) Error(format string, args ...interface{}) {
+	l.logger.Error(l.prefix+format, args...)
+}
+
+func (l prefixLogger) Info(format string, args ...interface{}) {
+	l.logger.Info(l.prefix+format, args...)
+}
+
+func (l prefixLogger) Debug(format string, args ...interface{}) {
+	l.logger.Debug(l.prefix+format, args...)
+}
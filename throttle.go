@@ -0,0 +1,137 @@
+package gostage
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultErrorLogWindow is how long identical worker errors are collapsed unless WithErrorLogThrottle is given
+	DefaultErrorLogWindow = time.Minute
+	// DefaultErrorLogBurst is how many identical worker errors are logged in a window unless WithErrorLogThrottle is given
+	DefaultErrorLogBurst = 10
+	// the most distinct errors tracked, the others are logged unthrottled
+	maxThrottledErrors = 1024
+)
+
+// WithErrorLogThrottle logs the first burst of the identical errors of a stage within window only,
+// those suppressed are logged as one "repeated N times" line once the window is over or the pipeline stops
+// only the log is throttled, Stats, WithOnError and the Observer see every error
+// a window of zero turns throttling off, default is DefaultErrorLogWindow and DefaultErrorLogBurst
+func WithErrorLogThrottle(window time.Duration, burst int) Option {
+	return func(gs *GoStage) {
+		gs.errorLogWindow, gs.errorLogBurst = window, burst
+	}
+}
+
+type throttleKey struct {
+	stage, err string
+}
+
+type throttleEntry struct {
+	start      time.Time
+	logged     int
+	suppressed int
+}
+
+// errorThrottle counts the identical errors of every stage within the current window
+type errorThrottle struct {
+	window time.Duration
+	burst  int
+
+	mu      sync.Mutex
+	entries map[throttleKey]*throttleEntry
+}
+
+func newErrorThrottle(window time.Duration, burst int) *errorThrottle {
+	if burst < 1 {
+		burst = 1
+	}
+	return &errorThrottle{window: window, burst: burst, entries: make(map[throttleKey]*throttleEntry)}
+}
+
+// repeat is a count of suppressed errors to log
+type repeat struct {
+	key   throttleKey
+	count int
+	since time.Duration
+}
+
+// allow returns whether the error may be logged now, and the errors suppressed
+// in the windows which are over
+func (t *errorThrottle) allow(key throttleKey, now time.Time) (bool, []repeat) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var over []repeat
+	e := t.entries[key]
+	if e != nil && now.Sub(e.start) >= t.window {
+		if e.suppressed > 0 {
+			over = append(over, repeat{key: key, count: e.suppressed, since: now.Sub(e.start)})
+		}
+		delete(t.entries, key)
+		e = nil
+	}
+	if e == nil {
+		if len(t.entries) >= maxThrottledErrors {
+			over = append(over, t.expire(now, false)...)
+			if len(t.entries) >= maxThrottledErrors {
+				return true, over
+			}
+		}
+		t.entries[key] = &throttleEntry{start: now, logged: 1}
+		return true, over
+	}
+	if e.logged < t.burst {
+		e.logged++
+		return true, over
+	}
+	e.suppressed++
+	return false, over
+}
+
+// expire forgets the windows which are over by now, all of them if all is set,
+// and returns their suppressed errors
+func (t *errorThrottle) expire(now time.Time, all bool) []repeat {
+	var over []repeat
+	for key, e := range t.entries {
+		if !all && now.Sub(e.start) < t.window {
+			continue
+		}
+		if e.suppressed > 0 {
+			over = append(over, repeat{key: key, count: e.suppressed, since: now.Sub(e.start)})
+		}
+		delete(t.entries, key)
+	}
+	return over
+}
+
+// logWorkerError logs an error of a stage unless the same error has been logged too often lately
+func (s *GoStage) logWorkerError(lw *linkedWorker, err error, format string, args ...interface{}) {
+	if s.errorThrottle == nil {
+		s.logger.Error(format, args...)
+		return
+	}
+	ok, over := s.errorThrottle.allow(throttleKey{stage: lw.Name, err: err.Error()}, s.clock.Now())
+	s.logRepeats(over)
+	if ok {
+		s.logger.Error(format, args...)
+	}
+}
+
+// flushErrorLog logs the errors suppressed in the current windows
+func (s *GoStage) flushErrorLog() {
+	if s.errorThrottle == nil {
+		return
+	}
+	s.errorThrottle.mu.Lock()
+	over := s.errorThrottle.expire(s.clock.Now(), true)
+	s.errorThrottle.mu.Unlock()
+	s.logRepeats(over)
+}
+
+func (s *GoStage) logRepeats(over []repeat) {
+	for _, r := range over {
+		s.logger.Error("%s error repeated %d times in %v: %s", r.key.stage, r.count, r.since.Round(time.Millisecond), r.key.err)
+	}
+}
@@ -0,0 +1,265 @@
+package gostage
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// ErrDuplicateStage if a SubscribeToName matches more than one config
+var ErrDuplicateStage = errors.New("duplicate stage name")
+
+// ErrCycle if configs subscribe to each other in a loop
+var ErrCycle = errors.New("cyclic subscription")
+
+// ErrOrphanStage if a config subscribes to a worker which isn't in the configs
+var ErrOrphanStage = errors.New("orphan stage")
+
+// ErrMultipleRoots if the producers feed different stages
+var ErrMultipleRoots = errors.New("producers feed different stages")
+
+// ErrEmptyPipeline if there are no configs to run
+var ErrEmptyPipeline = errors.New("empty pipeline")
+
+// ErrNoConsumer if the producers don't feed any stage, or at runtime if a send
+// is blocked on a stage no instance is left to take from, see WithNoConsumerTimeout
+var ErrNoConsumer = errors.New("no consumer")
+
+// ErrFanOut if several configs subscribe to the same stage, a stage feeds one stage only
+var ErrFanOut = errors.New("stage with several subscribers")
+
+// ErrMisplacedStage if a worker is placed where its role doesn't allow
+var ErrMisplacedStage = errors.New("misplaced stage")
+
+// ErrInvalidSubscription if a config sets both SubscribeTo and SubscribeToName
+var ErrInvalidSubscription = errors.New("invalid subscription")
+
+// ErrSharedWorker if the same Worker, held by pointer, is run by several configs
+var ErrSharedWorker = errors.New("worker used by several stages")
+
+// WithAllowSharedWorker lets several configs run the same Worker held by pointer,
+// they're separate stages with their own names and stats, the worker is initialized
+// once and closed once the last of them has stopped, it must be safe for concurrent use
+// the configs must have different names and the stages after them subscribe by name
+func WithAllowSharedWorker() Option {
+	return func(gs *GoStage) {
+		gs.allowSharedWorker = true
+	}
+}
+
+// validateSharedWorkers checks the configs running the same worker held by pointer
+func (s *GoStage) validateSharedWorkers() error {
+	owners := make(map[uintptr]*Config, len(s.configs))
+	for _, config := range s.configs {
+		v := reflect.ValueOf(config.Worker)
+		if v.Kind() != reflect.Ptr {
+			continue
+		}
+		first, ok := owners[v.Pointer()]
+		if !ok {
+			owners[v.Pointer()] = config
+			continue
+		}
+		if !s.allowSharedWorker {
+			return fmt.Errorf("%w: %s and %s run the same worker, see WithAllowSharedWorker", ErrSharedWorker, first.Name, config.Name)
+		}
+		if first.Name == config.Name {
+			return fmt.Errorf("%w: %s is the name of two stages running the same worker", ErrDuplicateStage, config.Name)
+		}
+	}
+	if !s.allowSharedWorker {
+		return nil
+	}
+	for _, config := range s.configs {
+		if config.SubscribeTo == nil {
+			continue
+		}
+		v := reflect.ValueOf(config.SubscribeTo)
+		if v.Kind() != reflect.Ptr {
+			continue
+		}
+		if s.runBy(v.Pointer()) > 1 {
+			return fmt.Errorf("%w: %s subscribes to a worker run by several stages, use SubscribeToName", ErrInvalidSubscription, config.Name)
+		}
+	}
+	return nil
+}
+
+// runBy returns the number of configs running the worker at ptr
+func (s *GoStage) runBy(ptr uintptr) int {
+	n := 0
+	for _, config := range s.configs {
+		if v := reflect.ValueOf(config.Worker); v.Kind() == reflect.Ptr && v.Pointer() == ptr {
+			n++
+		}
+	}
+	return n
+}
+
+// validate checks the subscriptions of the configs before they are linked
+func (s *GoStage) validate() error {
+	if len(s.configs) == 0 {
+		return ErrEmptyPipeline
+	}
+	names := make(map[string]int, len(s.configs))
+	var unnamed []*Config
+	for _, config := range s.configs {
+		if config.Name == "" {
+			unnamed = append(unnamed, config)
+			continue
+		}
+		names[config.Name]++
+	}
+	// a default name only describes the worker type, so stages that share
+	// it are numbered instead of rejected
+	for _, config := range unnamed {
+		s.setWorkerName(config)
+		name := config.Name
+		for k := 2; names[name] > 0; k++ {
+			name = fmt.Sprintf("%s-%d", config.Name, k)
+		}
+		config.Name = name
+		names[name]++
+	}
+
+	if err := s.validateSharedWorkers(); err != nil {
+		return err
+	}
+
+	for _, config := range s.configs {
+		if config.SubscribeToName == "" {
+			continue
+		}
+		if config.SubscribeTo != nil {
+			return fmt.Errorf("%w: %s sets both SubscribeTo and SubscribeToName", ErrInvalidSubscription, config.Name)
+		}
+		switch names[config.SubscribeToName] {
+		case 0:
+			return fmt.Errorf("%w: %s subscribes to %s", ErrUnknownStage, config.Name, config.SubscribeToName)
+		case 1:
+		default:
+			return fmt.Errorf("%w: %s subscribes to %s which is used by %d configs", ErrDuplicateStage, config.Name, config.SubscribeToName, names[config.SubscribeToName])
+		}
+	}
+	for _, config := range s.configs {
+		if names[config.Name] > 1 {
+			return fmt.Errorf("%w: %s is the name of %d stages", ErrDuplicateStage, config.Name, names[config.Name])
+		}
+	}
+
+	if err := s.validateRoles(); err != nil {
+		return err
+	}
+	if err := s.validateCreators(); err != nil {
+		return err
+	}
+	if err := s.validateOverflow(); err != nil {
+		return err
+	}
+	if err := s.validateAutoScale(); err != nil {
+		return err
+	}
+	if err := s.validateDispatch(); err != nil {
+		return err
+	}
+	if err := s.validateJoins(); err != nil {
+		return err
+	}
+	if err := s.validateSideOutputs(); err != nil {
+		return err
+	}
+	return s.validateLinks()
+}
+
+// validateLinks walks the configs from the roots like buildLinkedWorkers
+// and rejects every config not reached by the walk
+func (s *GoStage) validateLinks() error {
+	visited := make(map[*Config]bool, len(s.configs))
+	roots := s.findRoots()
+	for _, root := range roots {
+		visited[root] = true
+	}
+
+	first := s.findFirst(roots)
+	for _, config := range s.configs {
+		parent := s.findParent(config)
+		if visited[config] || config == first || !visited[parent] || parent == s.findParent(first) {
+			continue
+		}
+		return fmt.Errorf("%w: %s subscribes to %s but %s subscribes to %s", ErrMultipleRoots, config.Name, parent.Name, first.Name, s.findParent(first).Name)
+	}
+
+	var chain []*Config
+	for config := first; config != nil; config = s.findNext(config) {
+		if visited[config] {
+			for k := range chain {
+				if chain[k] == config {
+					return cycleError(append(chain[k:], config))
+				}
+			}
+		}
+		visited[config] = true
+		chain = append(chain, config)
+	}
+
+	for _, config := range s.configs {
+		if visited[config] {
+			continue
+		}
+		// follow the subscriptions upstream until a known config is met
+		var path []*Config
+		seen := make(map[*Config]bool)
+		for c := config; c != nil && !visited[c]; c = s.findParent(c) {
+			if seen[c] {
+				for k := range path {
+					if path[k] == c {
+						return cycleError(append(path[k:], c))
+					}
+				}
+			}
+			seen[c] = true
+			path = append(path, c)
+			if c.SubscribeTo != nil && s.findParent(c) == nil {
+				return fmt.Errorf("%w: %s subscribes to a worker which isn't in the configs", ErrOrphanStage, c.Name)
+			}
+		}
+		// the walk met a linked stage which already feeds another one
+		last := path[len(path)-1]
+		parent := s.findParent(last)
+		return fmt.Errorf("%w: %s subscribes to %s which already feeds %s", ErrFanOut, last.Name, parent.Name, s.findNext(parent).Name)
+	}
+	if first == nil {
+		names := make([]string, 0, len(roots))
+		for _, root := range roots {
+			names = append(names, root.Name)
+		}
+		return fmt.Errorf("%w: nothing subscribes to %s", ErrNoConsumer, strings.Join(names, ", "))
+	}
+	return nil
+}
+
+// findParent returns the config which c subscribes to, nil for a producer
+func (s *GoStage) findParent(c *Config) *Config {
+	for _, other := range s.configs {
+		if c.SubscribeToName != "" {
+			if other.Name == c.SubscribeToName {
+				return other
+			}
+			continue
+		}
+		if c.SubscribeTo != nil && reflect.DeepEqual(reflect.ValueOf(c.SubscribeTo), reflect.ValueOf(other.Worker)) {
+			return other
+		}
+	}
+	return nil
+}
+
+// cycleError names the configs of a cycle, the first one is repeated at the end
+func cycleError(cycle []*Config) error {
+	names := make([]string, 0, len(cycle))
+	for _, c := range cycle {
+		names = append(names, c.Name)
+	}
+	return fmt.Errorf("%w: %s", ErrCycle, strings.Join(names, " -> "))
+}
@@ -0,0 +1,142 @@
+package gostage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"time"
+)
+
+// ErrWarmup if the Warmup of a worker failed with WithFatalWarmup
+var ErrWarmup = errors.New("warmup failed")
+
+// WarmupWorker is implemented by workers which need time to be useful, e.g. to load
+// reference data, Warmup is called after Init on the workers the run starts with, concurrently,
+// and the stages start once all of them have returned, ctx is done with the pipeline's
+// context or once WithWarmupTimeout has passed
+// a failure is logged and the stage starts cold, see StageStats.Cold and WithFatalWarmup
+type WarmupWorker interface {
+	Warmup(ctx context.Context) error
+}
+
+// WithWarmupTimeout starts the stages after d even if some Warmup hasn't returned,
+// their stages start cold, default is to wait for all of them
+func WithWarmupTimeout(d time.Duration) Option {
+	return func(gs *GoStage) {
+		gs.warmupTimeout = d
+	}
+}
+
+// WithFatalWarmup makes a run fail with ErrWarmup if a Warmup fails, as with Init
+// a Warmup which hasn't returned once WithWarmupTimeout has passed still isn't fatal
+func WithFatalWarmup() Option {
+	return func(gs *GoStage) {
+		gs.fatalWarmup = true
+	}
+}
+
+type warmupTarget struct {
+	lw *linkedWorker
+	n  int
+	w  WarmupWorker
+}
+
+type warmupResult struct {
+	k   int
+	err error
+}
+
+// warmupTargets returns the workers made by prepare which have a Warmup
+func (s *GoStage) warmupTargets() []warmupTarget {
+	var targets []warmupTarget
+	seen := make(map[uintptr]bool)
+	for _, lw := range s.linkedWorkers {
+		for n, w := range lw.prepared {
+			if n != 0 && lw.ShareInstance {
+				continue
+			}
+			ww, ok := w.(WarmupWorker)
+			if !ok {
+				continue
+			}
+			if v := reflect.ValueOf(w); v.Kind() == reflect.Ptr {
+				if seen[v.Pointer()] {
+					continue
+				}
+				seen[v.Pointer()] = true
+			}
+			targets = append(targets, warmupTarget{lw: lw, n: n, w: ww})
+		}
+	}
+	return targets
+}
+
+// warmup calls the Warmup of the prepared workers and waits for them or the timeout
+// s.mu is released meanwhile, the pipeline is still starting
+// returns the failures if WithFatalWarmup is set
+func (s *GoStage) warmup() error {
+	targets := s.warmupTargets()
+	if len(targets) == 0 {
+		return nil
+	}
+	s.mu.Unlock()
+	defer s.mu.Lock()
+
+	ctx, cancel := context.WithCancel(s.ctx)
+	defer cancel()
+	var timeout <-chan time.Time
+	if s.warmupTimeout > 0 {
+		timeout = s.clock.After(s.warmupTimeout)
+	}
+	start := s.clock.Now()
+	results := make(chan warmupResult, len(targets))
+	for k, t := range targets {
+		go func(k int, t warmupTarget) {
+			results <- warmupResult{k: k, err: callWarmup(ctx, t)}
+		}(k, t)
+	}
+
+	var errs []error
+	done := make([]bool, len(targets))
+	for left := len(targets); left > 0; left-- {
+		var r warmupResult
+		select {
+		case r = <-results:
+		case <-timeout:
+			for k, t := range targets {
+				if !done[k] {
+					t.lw.cold.Store(true)
+					s.logger.Error("%s_#%d isn't warm after %v, its stage starts cold", t.lw.Name, t.n, s.warmupTimeout)
+				}
+			}
+			return errors.Join(errs...)
+		}
+		done[r.k] = true
+		if r.err == nil {
+			continue
+		}
+		t := targets[r.k]
+		if s.fatalWarmup {
+			errs = append(errs, r.err)
+			continue
+		}
+		t.lw.cold.Store(true)
+		s.logger.Error("%v, the stage starts cold", r.err)
+	}
+	s.logger.Info("gostage warmed up %d workers in %v", len(targets), s.clock.Now().Sub(start))
+	return errors.Join(errs...)
+}
+
+// callWarmup calls the Warmup of t, a panic is returned as an error
+func callWarmup(ctx context.Context, t warmupTarget) (err error) {
+	defer func() {
+		if v := recover(); v != nil {
+			err = fmt.Errorf("%w: %s_#%d Warmup panicked: %v", ErrWarmup, t.lw.Name, t.n, v)
+		}
+	}()
+	if err := t.w.Warmup(ctx); err != nil {
+		return fmt.Errorf("%w: %s_#%d: %w", ErrWarmup, t.lw.Name, t.n, err)
+	}
+	return nil
+}